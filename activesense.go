@@ -0,0 +1,163 @@
+package mid
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/channel"
+	"github.com/gomidi/midi/midimessage/realtime"
+)
+
+// ActivesenseInterval is the maximal time between two messages of a sender that uses active sensing.
+// Receivers consider the connection as lost, if no message arrived within this time.
+const ActivesenseInterval = 300 * time.Millisecond
+
+// activesenseWriter synchronizes the writes and sends an active sense message,
+// if nothing has been written for ActivesenseInterval
+type activesenseWriter struct {
+	mx      sync.Mutex
+	wr      midi.Writer
+	timer   *time.Timer
+	stopped bool
+}
+
+func (a *activesenseWriter) Write(msg midi.Message) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if !a.stopped {
+		a.timer.Reset(ActivesenseInterval)
+	}
+	return a.wr.Write(msg)
+}
+
+func (a *activesenseWriter) sense() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.stopped {
+		return
+	}
+	a.wr.Write(realtime.Activesense)
+	a.timer.Reset(ActivesenseInterval)
+}
+
+func (a *activesenseWriter) stop() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.stopped = true
+	a.timer.Stop()
+}
+
+// StartActivesense lets the Writer send an active sense message whenever nothing has been written
+// for ActivesenseInterval (300 milliseconds), until StopActivesense is called.
+// Until then, the writes of the Writer are synchronized with the active sense messages, but the
+// methods of the Writer still must not be called concurrently.
+// Calling it while the active sense messages are already sent has no effect.
+func (w *Writer) StartActivesense() {
+	if w.activesense != nil {
+		return
+	}
+	a := &activesenseWriter{wr: w.midiWriter.wr}
+	a.timer = time.AfterFunc(ActivesenseInterval, a.sense)
+	w.midiWriter.wr = a
+	w.activesense = a
+}
+
+// StopActivesense stops sending the active sense messages started by StartActivesense
+func (w *Writer) StopActivesense() {
+	if w.activesense == nil {
+		return
+	}
+	w.activesense.stop()
+	w.midiWriter.wr = w.activesense.wr
+	w.activesense = nil
+}
+
+// StopNotesOnConnectionLost lets the Reader write an all notes off message for every channel to out,
+// when the connection is lost (see Msg.Realtime.ConnectionLost).
+// The messages are written from another goroutine, so out must not be used concurrently
+// (a Writer that called StartActivesense may be used).
+func StopNotesOnConnectionLost(out midi.Writer) ReaderOption {
+	return func(r *Reader) {
+		r.notesOffOut = out
+	}
+}
+
+// activesenseWatchdog detects the loss of a connection that uses active sensing
+type activesenseWatchdog struct {
+	mx      sync.Mutex
+	timer   *time.Timer
+	armed   bool
+	stopped bool
+	lost    func()
+}
+
+// feed is called for every received message
+func (d *activesenseWatchdog) feed(activesense bool) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.stopped || !(d.armed || activesense) {
+		return
+	}
+
+	d.armed = true
+	if d.timer == nil {
+		d.timer = time.AfterFunc(ActivesenseInterval, d.fire)
+		return
+	}
+	d.timer.Reset(ActivesenseInterval)
+}
+
+func (d *activesenseWatchdog) fire() {
+	d.mx.Lock()
+	if d.stopped || !d.armed {
+		d.mx.Unlock()
+		return
+	}
+	// wait for the next active sense message
+	d.armed = false
+	d.mx.Unlock()
+
+	d.lost()
+}
+
+func (d *activesenseWatchdog) stop() {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.stopped = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// watchActivesense passes the received message to the watchdog. The watchdog is started
+// with the first active sense message, if there is a ConnectionLost callback or an output for stopping notes.
+func (r *Reader) watchActivesense(activesense bool) {
+	if r.watchdog == nil {
+		if !activesense || (r.Msg.Realtime.ConnectionLost == nil && r.notesOffOut == nil) {
+			return
+		}
+		r.watchdog = &activesenseWatchdog{lost: r.connectionLost}
+	}
+	r.watchdog.feed(activesense)
+}
+
+func (r *Reader) stopWatchdog() {
+	if r.watchdog != nil {
+		r.watchdog.stop()
+		r.watchdog = nil
+	}
+}
+
+func (r *Reader) connectionLost() {
+	if r.Msg.Realtime.ConnectionLost != nil {
+		r.Msg.Realtime.ConnectionLost()
+	}
+
+	if r.notesOffOut != nil {
+		for ch := uint8(0); ch < 16; ch++ {
+			r.notesOffOut.Write(channel.Channel(ch).ControlChange(123, 0))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that may be used concurrently
+type syncBuffer struct {
+	mx sync.Mutex
+	bf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(b []byte) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.bf.Write(b)
+}
+
+func (s *syncBuffer) String() string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return fmt.Sprintf("% X", s.bf.Bytes())
+}
+
+func TestStartActivesense(t *testing.T) {
+	var bf syncBuffer
+	wr := NewWriter(&bf)
+	wr.StartActivesense()
+	wr.StartActivesense()
+
+	wr.NoteOn(60, 100)
+	time.Sleep(ActivesenseInterval / 2)
+	wr.NoteOff(60)
+
+	if got, want := bf.String(), "90 3C 64 90 3C 00"; got != want {
+		t.Errorf("while writing got %v; want %v", got, want)
+	}
+
+	time.Sleep(ActivesenseInterval + ActivesenseInterval/2)
+
+	if got, want := bf.String(), "90 3C 64 90 3C 00 FE"; got != want {
+		t.Errorf("while idle got %v; want %v", got, want)
+	}
+
+	wr.StopActivesense()
+	wr.StopActivesense()
+	time.Sleep(ActivesenseInterval * 2)
+	wr.NoteOn(61, 100)
+
+	if got, want := bf.String(), "90 3C 64 90 3C 00 FE 90 3D 64"; got != want {
+		t.Errorf("after stop got %v; want %v", got, want)
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	var notesOff syncBuffer
+	rd := NewReader(NoLogger(), StopNotesOnConnectionLost(NewWriter(&notesOff)))
+
+	lost := make(chan bool, 2)
+	rd.Msg.Realtime.ConnectionLost = func() {
+		lost <- true
+	}
+
+	src, in := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- rd.Read(src)
+	}()
+
+	wr := NewWriter(in)
+
+	// no watching before the first active sense message
+	wr.NoteOn(60, 100)
+	time.Sleep(ActivesenseInterval * 2)
+
+	select {
+	case <-lost:
+		t.Fatalf("connection lost without active sensing")
+	default:
+	}
+
+	wr.Activesense()
+	for i := 0; i < 4; i++ {
+		time.Sleep(ActivesenseInterval / 2)
+		wr.NoteOff(60)
+		wr.NoteOn(60, 100)
+	}
+
+	select {
+	case <-lost:
+		t.Fatalf("connection lost although messages arrived")
+	default:
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(ActivesenseInterval * 2):
+		t.Fatalf("connection lost not detected")
+	}
+
+	want := ""
+	for ch := 0; ch < 16; ch++ {
+		want += fmt.Sprintf(" B%X 7B 00", ch)
+	}
+	if got := notesOff.String(); got != want[1:] {
+		t.Errorf("got %v; want %v", got, want[1:])
+	}
+
+	in.Close()
+	<-done
+}
+
+func TestStopReading(t *testing.T) {
+	rd := NewReader(NoLogger())
+	lost := make(chan bool, 1)
+	rd.Msg.Realtime.ConnectionLost = func() {
+		lost <- true
+	}
+
+	in := newTestInReader(rd)
+	in.handleMessage([]byte{0xFE}, 0)
+	rd.StopReading()
+
+	select {
+	case <-lost:
+		t.Errorf("connection lost after StopReading")
+	case <-time.After(ActivesenseInterval * 2):
+	}
+}
@@ -0,0 +1,417 @@
+package mid
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ArpMode is the pattern of an Arpeggiator
+type ArpMode uint8
+
+const (
+	// ArpUp plays the held notes from the lowest to the highest
+	ArpUp ArpMode = iota
+
+	// ArpDown plays the held notes from the highest to the lowest
+	ArpDown
+
+	// ArpUpDown plays the held notes up and down again (without repeating the highest and lowest note)
+	ArpUpDown
+
+	// ArpRandom plays the held notes in random order
+	ArpRandom
+
+	// ArpAsPlayed plays the held notes in the order they were pressed
+	ArpAsPlayed
+
+	// ArpChord plays all held notes at once
+	ArpChord
+)
+
+// clocksPerQuarter is the number of MIDI clocks per quarter note
+const clocksPerQuarter = 24
+
+// ArpConfig configures an Arpeggiator
+type ArpConfig struct {
+	// InChannel is the channel of the notes that are arpeggiated
+	InChannel uint8
+
+	// OutChannel is the channel of the written notes
+	OutChannel uint8
+
+	// Mode is the pattern
+	Mode ArpMode
+
+	// Rate is the number of steps per quarter note. It must divide 24. If it is 0, 4 (16th notes) is used.
+	Rate uint8
+
+	// Gate is the part of a step the notes sound (0-1). If it is 0, 0.5 is used.
+	Gate float64
+
+	// Octaves is the number of octaves the pattern spans. If it is 0, 1 is used.
+	Octaves uint8
+
+	// Latch keeps playing the notes after they have been released, until new notes are pressed
+	Latch bool
+}
+
+func (c *ArpConfig) validate() error {
+	if c.InChannel > 15 || c.OutChannel > 15 {
+		return fmt.Errorf("invalid channel")
+	}
+	if c.Mode > ArpChord {
+		return fmt.Errorf("unknown arpeggiator mode %v", c.Mode)
+	}
+	if c.Rate == 0 {
+		c.Rate = 4
+	}
+	if clocksPerQuarter%int(c.Rate) != 0 {
+		return fmt.Errorf("rate %v does not divide %v", c.Rate, clocksPerQuarter)
+	}
+	if c.Gate == 0 {
+		c.Gate = 0.5
+	}
+	if c.Gate < 0 || c.Gate > 1 {
+		return fmt.Errorf("invalid gate %v", c.Gate)
+	}
+	if c.Octaves == 0 {
+		c.Octaves = 1
+	}
+	if c.Octaves > 10 {
+		return fmt.Errorf("invalid octave range %v", c.Octaves)
+	}
+	return nil
+}
+
+type arpNote struct {
+	key, velocity uint8
+}
+
+// Arpeggiator is a layer over a Reader that plays the held notes of a channel as patterns on a ChannelWriter.
+//
+// The steps follow MIDI clock messages received by the Reader (Realtime.Start restarts the pattern,
+// Realtime.Stop stops the notes), unless the internal clock has been started with Start.
+//
+// Messages on other channels, and the clock messages while the internal clock runs, are passed to the callbacks
+// that had been attached to the Reader before NewArpeggiator was called.
+type Arpeggiator struct {
+	wr ChannelWriter
+
+	mx       sync.Mutex
+	cfg      ArpConfig
+	held     []arpNote // the pressed keys
+	notes    []arpNote // the arpeggiated notes in the order they were played
+	sounding []arpNote
+	clock    int // position in MIDI clocks
+	step     int
+	stop     chan struct{} // stops the internal clock
+	rnd      *rand.Rand
+
+	// the callbacks of the Reader before the Arpeggiator took over
+	prev struct {
+		noteOn  func(p *Position, channel, key, velocity uint8)
+		noteOff func(p *Position, channel, key, velocity uint8)
+		clock   func()
+		start   func()
+		stop    func()
+	}
+
+	// Error is called for errors of the writer, if it is not nil
+	Error func(err error)
+}
+
+// NewArpeggiator attaches an Arpeggiator to rd that writes to wr.
+func NewArpeggiator(rd *Reader, wr ChannelWriter, cfg ArpConfig) (*Arpeggiator, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	a := &Arpeggiator{wr: wr, cfg: cfg, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	a.prev.noteOn = rd.Msg.Channel.NoteOn
+	a.prev.noteOff = rd.Msg.Channel.NoteOff
+	a.prev.clock = rd.Msg.Realtime.Clock
+	a.prev.start = rd.Msg.Realtime.Start
+	a.prev.stop = rd.Msg.Realtime.Stop
+
+	rd.Msg.Channel.NoteOn = a.noteOn
+	rd.Msg.Channel.NoteOff = a.noteOff
+	rd.Msg.Realtime.Clock = a.midiClock
+	rd.Msg.Realtime.Start = a.midiStart
+	rd.Msg.Realtime.Stop = a.midiStop
+
+	return a, nil
+}
+
+// SetConfig changes the configuration. It may be called while playing.
+func (a *Arpeggiator) SetConfig(cfg ArpConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if cfg.InChannel != a.cfg.InChannel {
+		a.held, a.notes = nil, nil
+	}
+	if cfg.OutChannel != a.cfg.OutChannel {
+		a.release()
+	}
+	a.cfg = cfg
+	return nil
+}
+
+// Start starts the internal clock with the given tempo. While it runs, received MIDI clock messages are ignored.
+// It returns an error if bpm is not a positive, finite number.
+func (a *Arpeggiator) Start(bpm float64) error {
+	if !(bpm > 0) || math.IsInf(bpm, 1) {
+		return fmt.Errorf("invalid tempo %v", bpm)
+	}
+
+	interval := time.Duration(float64(time.Minute) / (bpm * clocksPerQuarter))
+	if interval <= 0 {
+		return fmt.Errorf("tempo %v is too fast", bpm)
+	}
+
+	a.Stop()
+
+	stop := make(chan struct{})
+	a.mx.Lock()
+	a.stop = stop
+	a.clock, a.step = 0, 0
+	a.mx.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				a.mx.Lock()
+				// Stop or a new Start may have happened while waiting for the lock
+				if a.stop != stop {
+					a.mx.Unlock()
+					return
+				}
+				a.tick()
+				a.mx.Unlock()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops the internal clock and the sounding notes
+func (a *Arpeggiator) Stop() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.stop != nil {
+		close(a.stop)
+		a.stop = nil
+	}
+	a.release()
+}
+
+func (a *Arpeggiator) error(err error) {
+	if err != nil && a.Error != nil {
+		a.Error(err)
+	}
+}
+
+func (a *Arpeggiator) midiClock() {
+	a.mx.Lock()
+	internal := a.stop != nil
+	if !internal {
+		a.tick()
+	}
+	a.mx.Unlock()
+
+	if internal && a.prev.clock != nil {
+		a.prev.clock()
+	}
+}
+
+func (a *Arpeggiator) midiStart() {
+	a.mx.Lock()
+	internal := a.stop != nil
+	if !internal {
+		a.release()
+		a.clock, a.step = 0, 0
+	}
+	a.mx.Unlock()
+
+	if internal && a.prev.start != nil {
+		a.prev.start()
+	}
+}
+
+func (a *Arpeggiator) midiStop() {
+	a.mx.Lock()
+	internal := a.stop != nil
+	if !internal {
+		a.release()
+	}
+	a.mx.Unlock()
+
+	if internal && a.prev.stop != nil {
+		a.prev.stop()
+	}
+}
+
+func (a *Arpeggiator) noteOn(p *Position, ch, key, vel uint8) {
+	a.mx.Lock()
+	if ch != a.cfg.InChannel {
+		a.mx.Unlock()
+		if a.prev.noteOn != nil {
+			a.prev.noteOn(p, ch, key, vel)
+		}
+		return
+	}
+	defer a.mx.Unlock()
+
+	// with latch, a new chord replaces the latched notes
+	if a.cfg.Latch && len(a.held) == 0 {
+		a.notes = nil
+	}
+
+	n := arpNote{key, vel}
+	a.held = append(removeArpNote(a.held, key), n)
+	a.notes = append(removeArpNote(a.notes, key), n)
+}
+
+func (a *Arpeggiator) noteOff(p *Position, ch, key, vel uint8) {
+	a.mx.Lock()
+	if ch != a.cfg.InChannel {
+		a.mx.Unlock()
+		if a.prev.noteOff != nil {
+			a.prev.noteOff(p, ch, key, vel)
+		}
+		return
+	}
+	defer a.mx.Unlock()
+
+	a.held = removeArpNote(a.held, key)
+	if !a.cfg.Latch {
+		a.notes = removeArpNote(a.notes, key)
+	}
+}
+
+func removeArpNote(notes []arpNote, key uint8) []arpNote {
+	res := notes[:0]
+	for _, n := range notes {
+		if n.key != key {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// tick advances by one MIDI clock. a.mx must be locked.
+func (a *Arpeggiator) tick() {
+	clocksPerStep := clocksPerQuarter / int(a.cfg.Rate)
+	gate := int(a.cfg.Gate*float64(clocksPerStep) + 0.5)
+	if gate < 1 {
+		gate = 1
+	}
+
+	switch pos := a.clock % clocksPerStep; {
+	case pos == 0:
+		a.release()
+		a.play()
+	case pos == gate:
+		a.release()
+	}
+
+	a.clock++
+}
+
+// release stops the sounding notes. a.mx must be locked.
+func (a *Arpeggiator) release() {
+	if len(a.sounding) == 0 {
+		return
+	}
+	a.wr.SetChannel(a.cfg.OutChannel)
+	for _, n := range a.sounding {
+		a.error(a.wr.NoteOff(n.key))
+	}
+	a.sounding = a.sounding[:0]
+}
+
+// play starts the notes of the next step. a.mx must be locked.
+func (a *Arpeggiator) play() {
+	seq := a.sequence()
+	if len(seq) == 0 {
+		a.step = 0
+		return
+	}
+
+	var notes []arpNote
+	switch a.cfg.Mode {
+	case ArpChord:
+		notes = seq
+	case ArpRandom:
+		notes = []arpNote{seq[a.rnd.Intn(len(seq))]}
+	default:
+		notes = []arpNote{seq[a.step%len(seq)]}
+	}
+	a.step++
+
+	a.wr.SetChannel(a.cfg.OutChannel)
+	for _, n := range notes {
+		if err := a.wr.NoteOn(n.key, n.velocity); err != nil {
+			a.error(err)
+			continue
+		}
+		a.sounding = append(a.sounding, n)
+	}
+}
+
+// sequence returns the notes of a pattern cycle. a.mx must be locked.
+func (a *Arpeggiator) sequence() []arpNote {
+	if len(a.notes) == 0 {
+		return nil
+	}
+
+	base := make([]arpNote, len(a.notes))
+	copy(base, a.notes)
+
+	if a.cfg.Mode != ArpAsPlayed {
+		sort.Slice(base, func(i, j int) bool { return base[i].key < base[j].key })
+	}
+
+	var seq []arpNote
+	for oct := 0; oct < int(a.cfg.Octaves); oct++ {
+		for _, n := range base {
+			key := int(n.key) + oct*12
+			if key > 127 {
+				continue
+			}
+			seq = append(seq, arpNote{uint8(key), n.velocity})
+		}
+	}
+
+	switch a.cfg.Mode {
+	case ArpDown:
+		reverseArpNotes(seq)
+	case ArpUpDown:
+		for i := len(seq) - 2; i > 0; i-- {
+			seq = append(seq, seq[i])
+		}
+	}
+	return seq
+}
+
+func reverseArpNotes(notes []arpNote) {
+	for i, j := 0, len(notes)-1; i < j; i, j = i+1, j-1 {
+		notes[i], notes[j] = notes[j], notes[i]
+	}
+}
@@ -0,0 +1,150 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// arpKeys returns the keys of the note on messages (velocity > 0) in the raw MIDI data
+func arpKeys(b []byte) (keys []uint8) {
+	for i := 0; i+2 < len(b); i += 3 {
+		if b[i]&0xF0 == 0x90 && b[i+2] > 0 {
+			keys = append(keys, b[i+1])
+		}
+	}
+	return
+}
+
+func clocks(n int) []byte {
+	return bytes.Repeat([]byte{0xF8}, n)
+}
+
+func TestArpeggiatorModes(t *testing.T) {
+	tests := []struct {
+		cfg      ArpConfig
+		expected string
+	}{
+		{ArpConfig{Mode: ArpUp}, "[60 64 67 60 64]"},
+		{ArpConfig{Mode: ArpDown}, "[67 64 60 67 64]"},
+		{ArpConfig{Mode: ArpUpDown}, "[60 64 67 64 60]"},
+		{ArpConfig{Mode: ArpAsPlayed}, "[64 60 67 64 60]"},
+		{ArpConfig{Mode: ArpUp, Octaves: 2}, "[60 64 67 72 76]"},
+		{ArpConfig{Mode: ArpUpDown, Octaves: 2}, "[60 64 67 72 76 79 76 72 67 64]"},
+		{ArpConfig{Mode: ArpChord}, "[60 64 67 60 64 67]"},
+		{ArpConfig{Mode: ArpUp, Rate: 2}, "[60 64 67]"},
+	}
+
+	for _, test := range tests {
+		var bf bytes.Buffer
+		rd := NewReader(NoLogger())
+		if _, err := NewArpeggiator(rd, NewWriter(&bf), test.cfg); err != nil {
+			t.Fatalf("NewArpeggiator returned error: %v", err)
+		}
+
+		in := []byte{0x90, 64, 100, 0x90, 60, 100, 0x90, 67, 100}
+		steps := 5
+		if test.cfg.Octaves == 2 && test.cfg.Mode == ArpUpDown {
+			steps = 10
+		}
+		if test.cfg.Mode == ArpChord {
+			steps = 2
+		}
+		in = append(in, clocks(steps*6)...)
+		rd.Read(bytes.NewReader(in))
+
+		if got := fmt.Sprint(arpKeys(bf.Bytes())); got != test.expected {
+			t.Errorf("%+v: got %v; want %v", test.cfg, got, test.expected)
+		}
+	}
+}
+
+func TestArpeggiatorGate(t *testing.T) {
+	var bf bytes.Buffer
+	rd := NewReader(NoLogger())
+
+	var passed []uint8
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		passed = append(passed, ch)
+	}
+
+	NewArpeggiator(rd, NewWriter(&bf), ArpConfig{InChannel: 1, OutChannel: 2, Gate: 0.25})
+
+	// 16th notes: 6 clocks per step, gate after 2 clocks
+	in := []byte{0x91, 60, 100, 0xF8, 0xF8, 0x90, 50, 100, 0xF8, 0xF8, 0xF8, 0xF8, 0xF8, 0x81, 60, 0, 0xF8, 0xF8, 0xF8}
+	rd.Read(bytes.NewReader(in))
+
+	if got, want := fmt.Sprintf("% X", bf.Bytes()), "92 3C 64 92 3C 00 92 3C 64 92 3C 00"; got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if fmt.Sprint(passed) != "[0]" {
+		t.Errorf("the note on channel 0 must be passed to the previous callback, got %v", passed)
+	}
+}
+
+func TestArpeggiatorLatch(t *testing.T) {
+	var bf bytes.Buffer
+	rd := NewReader(NoLogger())
+	arp, _ := NewArpeggiator(rd, NewWriter(&bf), ArpConfig{Latch: true})
+
+	var in []byte
+	in = append(in, 0x90, 60, 100, 0x90, 64, 100, 0x80, 60, 0, 0x80, 64, 0)
+	in = append(in, clocks(12)...)
+	// a new chord replaces the latched one
+	in = append(in, 0x90, 62, 100, 0x80, 62, 0)
+	in = append(in, clocks(12)...)
+	// stop
+	in = append(in, 0xFC)
+	rd.Read(bytes.NewReader(in))
+
+	if got, want := fmt.Sprint(arpKeys(bf.Bytes())), "[60 64 62 62]"; got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	if n := len(bf.Bytes()); bf.Bytes()[n-1] != 0 {
+		t.Errorf("the last note must be stopped: % X", bf.Bytes())
+	}
+
+	if err := arp.SetConfig(ArpConfig{Rate: 5}); err == nil {
+		t.Errorf("SetConfig must reject a rate that does not divide 24")
+	}
+}
+
+func TestArpeggiatorInternalClock(t *testing.T) {
+	var bf syncBuffer
+	rd := NewReader(NoLogger())
+	arp, _ := NewArpeggiator(rd, NewWriter(&bf), ArpConfig{Rate: 24})
+
+	rd.Read(bytes.NewReader([]byte{0x90, 60, 100}))
+
+	// 24 steps per quarter note at 600 BPM: a note every 4ms
+	if err := arp.Start(600); err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	arp.Stop()
+
+	got := bf.String()
+	if len(got) < 5*len("90 3C 64 90 3C 00 ") {
+		t.Errorf("got only %v", got)
+	}
+	if got[len(got)-2:] != "00" {
+		t.Errorf("the last note must be stopped: %v", got)
+	}
+
+	// no ticks after Stop
+	time.Sleep(20 * time.Millisecond)
+	if bf.String() != got {
+		t.Errorf("the clock must not tick after Stop")
+	}
+
+	for _, bpm := range []float64{0, -120, math.NaN(), math.Inf(1), 1e300} {
+		if err := arp.Start(bpm); err == nil {
+			arp.Stop()
+			t.Errorf("Start(%v) must return an error", bpm)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package blemidi encodes and decodes the packet format of MIDI over Bluetooth Low Energy (BLE-MIDI).
+//
+// A BLE-MIDI packet starts with a header byte that carries the upper 6 bits of a 13bit
+// millisecond timestamp. Each message is preceded by a timestamp byte with the lower 7 bits,
+// running status may be used and system exclusive messages may continue over several packets.
+//
+// The Decoder reconstructs the timestamps and passes the messages with the delta time
+// in microseconds, exactly like a connect.In listener gets them. In wraps the Decoder
+// to provide a connect.In that can be passed to mid.Reader.ReadFrom.
+//
+// The Writer packetizes a MIDI byte stream (e.g. written by mid.Writer) under a given MTU.
+package blemidi
+
+import (
+	"fmt"
+
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// timestampMask masks the 13bit millisecond timestamp
+const timestampMask = 0x1FFF
+
+// Decoder decodes BLE-MIDI packets into complete MIDI messages
+type Decoder struct {
+	fn      func(msg []byte, deltaMicroseconds int64)
+	started bool
+	last    uint16 // the last 13bit timestamp
+	status  byte   // running status
+	inSysEx bool
+	sysex   []byte
+}
+
+// NewDecoder returns a Decoder that calls fn for every complete message.
+// The delta is the time since the previous message, reconstructed from the timestamps.
+// Since the timestamps wrap every 8.192 seconds, longer pauses can't be reconstructed.
+// The passed slice must not be retained after fn returns.
+func NewDecoder(fn func(msg []byte, deltaMicroseconds int64)) *Decoder {
+	return &Decoder{fn: fn}
+}
+
+func (d *Decoder) emit(msg []byte, ts uint16) {
+	var delta int64
+	if d.started {
+		delta = int64((ts-d.last)&timestampMask) * 1000
+	}
+	d.started = true
+	d.last = ts
+	d.fn(msg, delta)
+}
+
+// Decode decodes the given packet. Messages before an error are passed to the callback.
+func (d *Decoder) Decode(packet []byte) error {
+	if len(packet) < 2 || packet[0]&0xC0 != 0x80 {
+		return fmt.Errorf("invalid BLE-MIDI packet header")
+	}
+
+	var (
+		high    = uint16(packet[0] & 0x3F)
+		prevLow = -1
+		ts      = d.last
+		i       = 1
+	)
+
+	for i < len(packet) {
+		b := packet[i]
+
+		if b < 0x80 {
+			// data bytes: sysex continuation or running status
+			if d.inSysEx {
+				d.sysex = append(d.sysex, b)
+				i++
+				continue
+			}
+
+			if d.status == 0 {
+				return fmt.Errorf("data byte % X without running status", b)
+			}
+
+			n := midistream.DataLen(d.status)
+			if i+n > len(packet) {
+				return fmt.Errorf("incomplete message at the end of the packet")
+			}
+
+			d.emit(append([]byte{d.status}, packet[i:i+n]...), ts)
+			i += n
+			continue
+		}
+
+		// timestamp byte
+		low := int(b & 0x7F)
+		if prevLow >= 0 && low < prevLow {
+			// the lower bits overflowed
+			high = (high + 1) & 0x3F
+		}
+		prevLow = low
+		ts = high<<7 | uint16(low)
+		i++
+
+		if i >= len(packet) {
+			return fmt.Errorf("timestamp without message at the end of the packet")
+		}
+
+		b = packet[i]
+
+		switch {
+		case b < 0x80:
+			// running status after timestamp, handled above
+		case midistream.IsRealtime(b):
+			d.emit([]byte{b}, ts)
+			i++
+		case b == 0xF0:
+			d.inSysEx = true
+			d.status = 0
+			d.sysex = append(d.sysex[:0], b)
+			i++
+		case b == 0xF7:
+			if d.inSysEx {
+				d.inSysEx = false
+				d.emit(append(d.sysex, b), ts)
+			}
+			i++
+		default:
+			// any other status byte aborts a system exclusive message
+			d.inSysEx = false
+			d.status = 0
+			if midistream.IsChannelStatus(b) {
+				d.status = b
+			}
+
+			n := midistream.DataLen(b)
+			if i+1+n > len(packet) {
+				return fmt.Errorf("incomplete message at the end of the packet")
+			}
+
+			d.emit(packet[i:i+1+n], ts)
+			i += 1 + n
+		}
+	}
+
+	return nil
+}
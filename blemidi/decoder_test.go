@@ -0,0 +1,79 @@
+package blemidi
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+type recorder struct {
+	bytes.Buffer
+}
+
+func (r *recorder) record(msg []byte, deltaMicroseconds int64) {
+	fmt.Fprintf(r, "+%v:% X|", deltaMicroseconds/1000, msg)
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		descr    string
+		packets  [][]byte
+		expected string
+	}{
+		{
+			"single message",
+			[][]byte{{0x80, 0x81, 0x90, 60, 100}},
+			"+0:90 3C 64|",
+		},
+		{
+			"running status without and with timestamp",
+			[][]byte{{0x80, 0x81, 0x90, 60, 100, 62, 100, 0x85, 64, 100}},
+			"+0:90 3C 64|+0:90 3E 64|+4:90 40 64|",
+		},
+		{
+			"timestamp overflow within packet",
+			[][]byte{{0x80, 0xFE, 0xB0, 7, 100, 0x82, 0xC0, 5}},
+			"+0:B0 07 64|+4:C0 05|",
+		},
+		{
+			"delta across packets and wrap of the 13bit timestamp",
+			[][]byte{{0xBF, 0xFF, 0xF8}, {0x80, 0x83, 0xF8}},
+			"+0:F8|+4:F8|",
+		},
+		{
+			"system exclusive across packets with realtime in between",
+			[][]byte{{0x80, 0x81, 0xF0, 0x7E, 0x7F}, {0x80, 0x06, 0x01, 0x82, 0xF8, 0x02, 0x83, 0xF7}},
+			"+0:F8|+1:F0 7E 7F 06 01 02 F7|",
+		},
+	}
+
+	for _, test := range tests {
+		var res recorder
+		dec := NewDecoder(res.record)
+		for _, p := range test.packets {
+			if err := dec.Decode(p); err != nil {
+				t.Errorf("[%s] Decode(% X) returned error: %v", test.descr, p, err)
+			}
+		}
+
+		if got := res.String(); got != test.expected {
+			t.Errorf("[%s]\ngot:      %q\nexpected: %q", test.descr, got, test.expected)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := [][]byte{
+		{0x00, 0x80, 0xF8},
+		{0x80, 60, 100},
+		{0x80, 0x80},
+		{0x80, 0x80, 0x90, 60},
+	}
+
+	for _, p := range tests {
+		dec := NewDecoder(func([]byte, int64) {})
+		if err := dec.Decode(p); err == nil {
+			t.Errorf("Decode(% X) must return an error", p)
+		}
+	}
+}
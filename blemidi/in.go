@@ -0,0 +1,134 @@
+package blemidi
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gomidi/connect"
+)
+
+// maxPacketSize is the largest possible BLE-MIDI packet (the largest BLE ATT MTU minus 3)
+const maxPacketSize = 512
+
+// In is a connect.In that reads BLE-MIDI packets from an io.Reader,
+// e.g. the local socket of a BLE gateway process.
+// Every call of Read on the io.Reader must return exactly one packet (datagram semantics).
+type In struct {
+	src       io.Reader
+	name      string
+	mx        sync.Mutex
+	open      bool
+	listening bool
+	listener  func(data []byte, deltaMicroseconds int64)
+}
+
+var _ connect.In = &In{}
+
+// NewIn returns a new In that reads the packets from src. The name is returned by String.
+func NewIn(src io.Reader, name string) *In {
+	return &In{src: src, name: name}
+}
+
+// Open opens the port
+func (i *In) Open() error {
+	i.mx.Lock()
+	i.open = true
+	i.mx.Unlock()
+	return nil
+}
+
+// Close stops listening and closes the underlying io.Reader, if it is an io.Closer
+func (i *In) Close() error {
+	i.StopListening()
+
+	i.mx.Lock()
+	wasOpen := i.open
+	i.open = false
+	i.mx.Unlock()
+
+	if c, ok := i.src.(io.Closer); ok && wasOpen {
+		return c.Close()
+	}
+	return nil
+}
+
+// IsOpen returns wether the port is open
+func (i *In) IsOpen() bool {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	return i.open
+}
+
+// Number returns 0
+func (i *In) Number() int {
+	return 0
+}
+
+// String returns the name of the port
+func (i *In) String() string {
+	return i.name
+}
+
+// Underlying returns the io.Reader
+func (i *In) Underlying() interface{} {
+	return i.src
+}
+
+// SetListener opens the port, if needed, and starts reading the packets in a goroutine.
+// The listener is called for every complete message. Reading stops with the first error of the io.Reader.
+func (i *In) SetListener(listener func(data []byte, deltaMicroseconds int64)) error {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	if i.listening {
+		return fmt.Errorf("already listening")
+	}
+
+	i.open = true
+	i.listening = true
+	i.listener = listener
+	go i.read()
+	return nil
+}
+
+// StopListening stops passing messages to the listener
+func (i *In) StopListening() error {
+	i.mx.Lock()
+	i.listening = false
+	i.listener = nil
+	i.mx.Unlock()
+	return nil
+}
+
+func (i *In) read() {
+	dec := NewDecoder(func(msg []byte, deltaMicroseconds int64) {
+		i.mx.Lock()
+		listener := i.listener
+		i.mx.Unlock()
+		if listener != nil {
+			listener(msg, deltaMicroseconds)
+		}
+	})
+
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, err := i.src.Read(buf)
+		if n > 0 {
+			// invalid packets are skipped
+			dec.Decode(buf[:n])
+		}
+
+		i.mx.Lock()
+		listening := i.listening
+		if err != nil {
+			i.listening = false
+		}
+		i.mx.Unlock()
+
+		if err != nil || !listening {
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package blemidi_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/blemidi"
+)
+
+// packetSource returns one packet per Read and closes done at the end
+type packetSource struct {
+	packets [][]byte
+	done    chan bool
+}
+
+func (s *packetSource) Read(b []byte) (int, error) {
+	if len(s.packets) == 0 {
+		close(s.done)
+		return 0, io.EOF
+	}
+	n := copy(b, s.packets[0])
+	s.packets = s.packets[1:]
+	return n, nil
+}
+
+func TestReadFromIn(t *testing.T) {
+	src := &packetSource{
+		packets: [][]byte{
+			{0x80, 0x81, 0x90, 60, 100},
+			{0x81, 0x81, 0x80, 60, 0},
+		},
+		done: make(chan bool),
+	}
+
+	var res bytes.Buffer
+	rd := mid.NewReader(mid.NoLogger())
+	rd.Msg.Channel.NoteOn = func(p *mid.Position, ch, key, vel uint8) {
+		fmt.Fprintf(&res, "+%v ON%v:%v ", p.DeltaTicks, ch, key)
+	}
+	rd.Msg.Channel.NoteOff = func(p *mid.Position, ch, key, vel uint8) {
+		fmt.Fprintf(&res, "+%v OFF%v:%v ", p.DeltaTicks, ch, key)
+	}
+
+	in := blemidi.NewIn(src, "gateway")
+	if err := rd.ReadFrom(in); err != nil {
+		t.Fatalf("ReadFrom returned error: %v", err)
+	}
+	<-src.done
+	in.Close()
+
+	// 128ms at 120 BPM with a resolution of 1920 ticks per quarter note
+	expected := "+0 ON0:60 +492 OFF0:60 "
+	if got := res.String(); got != expected {
+		t.Errorf("\ngot:      %q\nexpected: %q", got, expected)
+	}
+}
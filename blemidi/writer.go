@@ -0,0 +1,144 @@
+package blemidi
+
+import (
+	"io"
+	"time"
+
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// DefaultMTU is the packet size for the default BLE ATT MTU of 23 bytes
+const DefaultMTU = 20
+
+// minMTU is the smallest packet that can carry any non sysex message
+const minMTU = 5
+
+// Writer packetizes a MIDI byte stream into BLE-MIDI packets.
+// All messages that are passed with a single call of Write get the same timestamp and are
+// put into as few packets as possible. Running status is used within a packet.
+// System exclusive messages that don't fit are continued in the following packets.
+type Writer struct {
+	dest     io.Writer
+	mtu      int
+	splitter *midistream.Splitter
+	start    time.Time
+	now      func() time.Time
+	packet   []byte
+	ts       uint16
+	status   byte
+	err      error
+}
+
+// NewWriter returns a Writer that writes packets of at most mtu bytes to dest.
+// Each packet is passed with a single call of dest.Write.
+// If mtu is 0, DefaultMTU is used.
+// It can be passed to mid.NewWriter to use the Writer methods for BLE-MIDI.
+func NewWriter(dest io.Writer, mtu int) *Writer {
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	if mtu < minMTU {
+		mtu = minMTU
+	}
+	w := &Writer{dest: dest, mtu: mtu, now: time.Now}
+	w.start = w.now()
+	w.splitter = midistream.New(w.writeMessage)
+	return w
+}
+
+// Write writes the given MIDI bytes as packets. Incomplete messages are buffered until they are complete.
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.ts = uint16(w.now().Sub(w.start)/time.Millisecond) & timestampMask
+	w.splitter.Write(b)
+	w.flush()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(b), nil
+}
+
+func (w *Writer) header() byte {
+	return 0x80 | byte(w.ts>>7)&0x3F
+}
+
+func (w *Writer) timestamp() byte {
+	return 0x80 | byte(w.ts)&0x7F
+}
+
+func (w *Writer) flush() {
+	if len(w.packet) == 0 || w.err != nil {
+		return
+	}
+	_, w.err = w.dest.Write(w.packet)
+	w.packet = w.packet[:0]
+	w.status = 0
+}
+
+// ensure flushes the packet, if there is no room for n more bytes and starts a new packet if needed
+func (w *Writer) ensure(n int) {
+	if len(w.packet)+n > w.mtu {
+		w.flush()
+	}
+	if len(w.packet) == 0 {
+		w.packet = append(w.packet, w.header())
+	}
+}
+
+func (w *Writer) writeMessage(msg []byte) {
+	if w.err != nil {
+		return
+	}
+
+	if msg[0] == 0xF0 {
+		w.writeSysEx(msg)
+		return
+	}
+
+	if midistream.IsChannelStatus(msg[0]) && msg[0] == w.status && len(w.packet)+len(msg) <= w.mtu {
+		// running status
+		w.packet = append(w.packet, w.timestamp())
+		w.packet = append(w.packet, msg[1:]...)
+		return
+	}
+
+	w.ensure(1 + len(msg))
+	w.packet = append(w.packet, w.timestamp())
+	w.packet = append(w.packet, msg...)
+
+	switch {
+	case midistream.IsChannelStatus(msg[0]):
+		w.status = msg[0]
+	case !midistream.IsRealtime(msg[0]):
+		w.status = 0
+	}
+}
+
+func (w *Writer) writeSysEx(msg []byte) {
+	// F0 and the data, the terminating F7 is written with its own timestamp
+	data := msg[:len(msg)-1]
+
+	w.ensure(2)
+	w.packet = append(w.packet, w.timestamp())
+
+	for len(data) > 0 {
+		if len(w.packet) == w.mtu {
+			w.flush()
+			w.packet = append(w.packet, w.header())
+		}
+		n := w.mtu - len(w.packet)
+		if n > len(data) {
+			n = len(data)
+		}
+		w.packet = append(w.packet, data[:n]...)
+		data = data[n:]
+	}
+
+	w.ensure(2)
+	w.packet = append(w.packet, w.timestamp(), 0xF7)
+	w.status = 0
+}
@@ -0,0 +1,65 @@
+package blemidi
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type packetRecorder struct {
+	packets [][]byte
+}
+
+func (r *packetRecorder) Write(b []byte) (int, error) {
+	r.packets = append(r.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestWriter(t *testing.T) {
+	var rec packetRecorder
+	w := NewWriter(&rec, 10)
+
+	now := time.Now()
+	w.start = now
+	w.now = func() time.Time { return now }
+
+	// two messages with the same timestamp and running status
+	w.Write([]byte{0x90, 60, 100, 0x90, 64, 100})
+
+	now = now.Add(130 * time.Millisecond)
+	w.Write([]byte{0xF0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 0xF7})
+
+	now = now.Add(5 * time.Millisecond)
+	w.Write([]byte{0xF8})
+
+	var got bytes.Buffer
+	for _, p := range rec.packets {
+		if len(p) > 10 {
+			t.Errorf("packet % X larger than MTU", p)
+		}
+		fmt.Fprintf(&got, "% X|", p)
+	}
+
+	expected := "80 80 90 3C 64 80 40 64|" +
+		"81 82 F0 01 02 03 04 05 06 07|" +
+		"81 08 09 0A 82 F7|" +
+		"81 87 F8|"
+
+	if got.String() != expected {
+		t.Errorf("\ngot:      %q\nexpected: %q", got.String(), expected)
+	}
+
+	var res recorder
+	dec := NewDecoder(res.record)
+	for _, p := range rec.packets {
+		if err := dec.Decode(p); err != nil {
+			t.Errorf("Decode(% X) returned error: %v", p, err)
+		}
+	}
+
+	expected = "+0:90 3C 64|+0:90 40 64|+130:F0 01 02 03 04 05 06 07 08 09 0A F7|+5:F8|"
+	if got := res.String(); got != expected {
+		t.Errorf("\ngot:      %q\nexpected: %q", got, expected)
+	}
+}
@@ -0,0 +1,106 @@
+package mid
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/meta"
+	"github.com/gomidi/midi/smf"
+	"github.com/gomidi/midi/smf/smfwriter"
+)
+
+// ClipToSMF converts the clip file read from src to a single track SMF that is written to dest.
+// The resolution of the clip becomes the resolution of the SMF.
+func ClipToSMF(src io.Reader, dest io.Writer) (err error) {
+	var wr *SMFWriter
+	var lastTick uint64
+
+	rd := NewReader(NoLogger())
+	rd.SMFHeader = func(h smf.Header) {
+		wr = NewSMF(dest, 1, smfwriter.TimeFormat(h.TimeFormat))
+	}
+	rd.Msg.Each = func(p *Position, msg midi.Message) {
+		if err != nil {
+			return
+		}
+		wr.SetDelta(uint32(p.AbsoluteTicks - lastTick))
+		lastTick = p.AbsoluteTicks
+		if msg == meta.EndOfTrack {
+			err = wr.EndOfTrack()
+			if err == smf.ErrFinished {
+				err = nil
+			}
+			return
+		}
+		// don't check the note consolidation here, since we just copy
+		err = wr.wr.Write(msg)
+	}
+
+	if e := rd.ReadClip(src); e != nil {
+		return e
+	}
+
+	return
+}
+
+// SMFToClip converts the SMF read from src to a clip file that is written to dest.
+// The tracks of the SMF are merged into the single sequence of the clip.
+// Meta messages other than tempo and time signature changes are dropped.
+// See NewClipWriter for group and midi2.
+func SMFToClip(src io.Reader, dest io.Writer, group uint8, midi2 bool) error {
+	type event struct {
+		absTicks uint64
+		msg      midi.Message
+	}
+
+	var (
+		events []event
+		end    uint64
+		res    smf.MetricTicks
+	)
+
+	rd := NewReader(NoLogger())
+	rd.SMFHeader = func(h smf.Header) {
+		res, _ = h.TimeFormat.(smf.MetricTicks)
+	}
+	rd.Msg.Each = func(p *Position, msg midi.Message) {
+		if msg == meta.EndOfTrack {
+			if p.AbsoluteTicks > end {
+				end = p.AbsoluteTicks
+			}
+			return
+		}
+		events = append(events, event{p.AbsoluteTicks, msg})
+	}
+
+	err := rd.ReadSMF(src)
+	if err != nil {
+		return err
+	}
+
+	if res == 0 {
+		return fmt.Errorf("can't convert SMF to clip: time format must be metric ticks")
+	}
+
+	// the tracks come one after another, so sort them by time, keeping the order within a time
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].absTicks < events[b].absTicks
+	})
+
+	wr := NewClipWriter(dest, uint16(res.Ticks4th()), group, midi2)
+	var lastTick uint64
+
+	for _, ev := range events {
+		wr.SetDelta(uint32(ev.absTicks - lastTick))
+		lastTick = ev.absTicks
+		err = wr.wr.Write(ev.msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	wr.SetDelta(uint32(end - lastTick))
+	return wr.EndOfClip()
+}
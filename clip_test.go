@@ -0,0 +1,157 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gomidi/mid/ump"
+	"github.com/gomidi/midi/smf"
+)
+
+func writeTestClip(bf *bytes.Buffer, midi2 bool) {
+	wr := NewClipWriter(bf, 960, 0, midi2)
+	wr.TempoBPM(100)
+	wr.Meter(3, 4)
+	wr.SetChannel(1)
+	wr.NoteOn(60, 100)
+	wr.SetDelta(960)
+	wr.NoteOff(60)
+	wr.SetDelta(2000000) // larger than a single delta clockstamp
+	wr.ControlChange(7, 90)
+	wr.SetDelta(480)
+	wr.EndOfClip()
+}
+
+func clipTestReader(res *bytes.Buffer) *Reader {
+	rd := NewReader(NoLogger())
+	rd.SMFHeader = func(h smf.Header) {
+		fmt.Fprintf(res, "[%v] ", h.TimeFormat)
+	}
+	rd.Msg.Meta.TempoBPM = func(p Position, bpm float64) {
+		fmt.Fprintf(res, "%v/%v TEMPO%.0f ", p.Track, p.AbsoluteTicks, bpm)
+	}
+	rd.Msg.Meta.TimeSig = func(p Position, num, denom uint8) {
+		fmt.Fprintf(res, "%v/%v METER%v/%v ", p.Track, p.AbsoluteTicks, num, denom)
+	}
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		fmt.Fprintf(res, "%v/%v ON%v:%v/%v ", p.Track, p.AbsoluteTicks, ch, key, vel)
+	}
+	rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) {
+		fmt.Fprintf(res, "%v/%v OFF%v:%v ", p.Track, p.AbsoluteTicks, ch, key)
+	}
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) {
+		fmt.Fprintf(res, "%v/%v+%v CC%v/%v:%v ", p.Track, p.AbsoluteTicks, p.DeltaTicks, ch, cc, val)
+	}
+	rd.Msg.Meta.EndOfTrack = func(p Position) {
+		fmt.Fprintf(res, "%v/+%v END", p.Track, p.DeltaTicks)
+	}
+	return rd
+}
+
+func TestClipReadWrite(t *testing.T) {
+	expected := "[960 MetricTicks] 0/0 TEMPO100 0/0 METER3/4 0/0 ON1:60/100 0/960 OFF1:60 0/2000960+2000000 CC1/7:90 0/+0 END"
+
+	for _, midi2 := range []bool{false, true} {
+		var bf bytes.Buffer
+		writeTestClip(&bf, midi2)
+
+		if got := string(bf.Bytes()[:8]); got != "SMF2CLIP" {
+			t.Fatalf("clip starts with %q", got)
+		}
+
+		var res bytes.Buffer
+		err := clipTestReader(&res).ReadClip(&bf)
+		if err != nil {
+			t.Fatalf("midi2: %v: ReadClip returned error: %v", midi2, err)
+		}
+
+		if got := res.String(); got != expected {
+			t.Errorf("midi2: %v\ngot:      %q\nexpected: %q", midi2, got, expected)
+		}
+	}
+}
+
+func TestClipInvalidTimeSignature(t *testing.T) {
+	var bf bytes.Buffer
+	wr := NewClipWriter(&bf, 960, 0, false)
+	wr.TempoBPM(100)
+	// a denominator of 2^8 does not fit into a byte
+	wr.wr.writePackets(ump.SetTimeSignature(0, 4, 8, 0))
+	wr.Meter(3, 4)
+	wr.EndOfClip()
+
+	var res bytes.Buffer
+	if err := clipTestReader(&res).ReadClip(&bf); err != nil {
+		t.Fatalf("ReadClip returned error: %v", err)
+	}
+
+	expected := "[960 MetricTicks] 0/0 TEMPO100 0/0 METER3/4 0/+0 END"
+	if got := res.String(); got != expected {
+		t.Errorf("got:      %q\nexpected: %q", got, expected)
+	}
+}
+
+func TestClipSMFConversion(t *testing.T) {
+	var clip bytes.Buffer
+	writeTestClip(&clip, true)
+
+	var file bytes.Buffer
+	if err := ClipToSMF(&clip, &file); err != nil {
+		t.Fatalf("ClipToSMF returned error: %v", err)
+	}
+
+	var res bytes.Buffer
+	if err := clipTestReader(&res).ReadSMF(bytes.NewReader(file.Bytes())); err != nil {
+		t.Fatalf("ReadSMF returned error: %v", err)
+	}
+
+	expected := "[960 MetricTicks] 0/0 TEMPO100 0/0 METER3/4 0/0 ON1:60/100 0/960 OFF1:60 0/2000960+2000000 CC1/7:90 0/+0 END"
+	if got := res.String(); got != expected {
+		t.Errorf("ClipToSMF\ngot:      %q\nexpected: %q", got, expected)
+	}
+
+	// now back again
+	var clip2 bytes.Buffer
+	if err := SMFToClip(&file, &clip2, 0, true); err != nil {
+		t.Fatalf("SMFToClip returned error: %v", err)
+	}
+
+	res.Reset()
+	if err := clipTestReader(&res).ReadClip(&clip2); err != nil {
+		t.Fatalf("ReadClip returned error: %v", err)
+	}
+
+	if got := res.String(); got != expected {
+		t.Errorf("SMFToClip\ngot:      %q\nexpected: %q", got, expected)
+	}
+}
+
+func TestSMFToClipMergesTracks(t *testing.T) {
+	var file bytes.Buffer
+	wr := NewSMF(&file, 2)
+	wr.TempoBPM(120)
+	wr.SetDelta(960)
+	wr.TempoBPM(60)
+	wr.EndOfTrack()
+	wr.SetDelta(480)
+	wr.NoteOn(60, 100)
+	wr.SetDelta(960)
+	wr.NoteOff(60)
+	wr.EndOfTrack()
+
+	var clip bytes.Buffer
+	if err := SMFToClip(&file, &clip, 0, false); err != nil {
+		t.Fatalf("SMFToClip returned error: %v", err)
+	}
+
+	var res bytes.Buffer
+	if err := clipTestReader(&res).ReadClip(&clip); err != nil {
+		t.Fatalf("ReadClip returned error: %v", err)
+	}
+
+	expected := "[960 MetricTicks] 0/0 TEMPO120 0/480 ON0:60/100 0/960 TEMPO60 0/1440 OFF0:60 0/+0 END"
+	if got := res.String(); got != expected {
+		t.Errorf("got:      %q\nexpected: %q", got, expected)
+	}
+}
@@ -0,0 +1,142 @@
+// Command midilatency measures the round trip latency, jitter, loss and reordering of a MIDI path.
+//
+// Probes are sent to an output and must come back on an input, e.g. through a loopback cable
+// from MIDI out to MIDI in of an interface, or through a device that echoes its input.
+//
+// Usage:
+//
+//	midilatency -serial /dev/ttyUSB0 [-n 1000] [-interval 10ms] [-probe sysex|notes]
+//	midilatency -rtp 192.168.1.10:5004
+//	midilatency -mock
+//
+// A serial device must already be configured for MIDI (raw mode, 31250 baud), e.g. with stty.
+// An RTP-MIDI peer must echo the received messages. -mock measures a virtual loopback, which shows the
+// overhead of the measurement itself.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/mockdriver"
+	"github.com/gomidi/mid/rtpmidi"
+	"github.com/gomidi/mid/serial"
+)
+
+var (
+	argSerial    = flag.String("serial", "", "serial device (tty of a UART MIDI adapter)")
+	argRTP       = flag.String("rtp", "", "control address (host:port) of an RTP-MIDI peer")
+	argMock      = flag.Bool("mock", false, "measure a virtual loopback")
+	argN         = flag.Int("n", 1000, "number of probes")
+	argInterval  = flag.Duration("interval", 10*time.Millisecond, "interval between probes")
+	argTimeout   = flag.Duration("timeout", time.Second, "time to wait for outstanding probes")
+	argProbe     = flag.String("probe", "sysex", "kind of probes: sysex or notes")
+	argChannel   = flag.Uint("channel", 16, "channel of note probes (1-16)")
+	argHistogram = flag.Duration("histogram", 0, "print a histogram with the given bucket width")
+)
+
+func main() {
+	flag.Parse()
+
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg := mid.LatencyConfig{
+		N:        *argN,
+		Interval: *argInterval,
+		Timeout:  *argTimeout,
+	}
+
+	switch *argProbe {
+	case "sysex":
+		cfg.Probe = mid.SysExProbe
+	case "notes":
+		cfg.Probe = mid.NoteProbe
+	default:
+		return fmt.Errorf("unknown probe %q", *argProbe)
+	}
+
+	if *argChannel < 1 || *argChannel > 16 {
+		return fmt.Errorf("invalid channel %v", *argChannel)
+	}
+	cfg.Channel = uint8(*argChannel - 1)
+
+	out, in, close, err := openPorts()
+	if err != nil {
+		return err
+	}
+	defer close()
+
+	fmt.Printf("measuring %v -> %v with %v probes...\n", out, in, cfg.N)
+
+	r, err := mid.MeasureLatencyWith(out, in, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(r)
+
+	if *argHistogram > 0 {
+		printHistogram(r, *argHistogram)
+	}
+	return nil
+}
+
+func openPorts() (out connect.Out, in connect.In, close func(), err error) {
+	switch {
+	case *argSerial != "":
+		f, err := os.OpenFile(*argSerial, os.O_RDWR, 0)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		p := serial.New(f, *argSerial)
+		if err := p.Open(); err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+		return p, p, func() { p.Close() }, nil
+
+	case *argRTP != "":
+		s, err := rtpmidi.Dial("midilatency", *argRTP, 5*time.Second)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("can't connect to %v: %v", *argRTP, err)
+		}
+		return s, s, func() { s.Close() }, nil
+
+	case *argMock:
+		drv := mockdriver.New("mock")
+		o, i := drv.Loopback("loopback")
+		return o, i, func() { drv.Close() }, nil
+	}
+
+	return nil, nil, nil, fmt.Errorf("one of -serial, -rtp or -mock is needed")
+}
+
+func printHistogram(r *mid.LatencyReport, width time.Duration) {
+	start, counts := r.Histogram(width)
+
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+
+	for i, c := range counts {
+		bar := 0
+		if max > 0 {
+			bar = c * 50 / max
+		}
+		fmt.Printf("%10v %6d %s\n", start+time.Duration(i)*width, c, strings.Repeat("#", bar))
+	}
+}
@@ -0,0 +1,351 @@
+package mid
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/channel"
+	"github.com/gomidi/midi/midimessage/meta"
+	"github.com/gomidi/midi/smf"
+	"github.com/gomidi/midi/smf/smfreader"
+	"github.com/gomidi/midi/smf/smfwriter"
+)
+
+// Curve is a response curve. It maps an input value in the range 0-1 to an output value in the range 0-1.
+type Curve func(x float64) float64
+
+// LinearCurve returns the curve that does not change the values
+func LinearCurve() Curve {
+	return func(x float64) float64 { return x }
+}
+
+// ExponentialCurve returns the curve x^exponent. Exponents > 1 make the response softer, exponents < 1 harder.
+// It returns an error if exponent is not > 0.
+func ExponentialCurve(exponent float64) (Curve, error) {
+	if !(exponent > 0) {
+		return nil, fmt.Errorf("exponent must be > 0, got %v", exponent)
+	}
+	return func(x float64) float64 { return math.Pow(x, exponent) }, nil
+}
+
+// LogarithmicCurve returns the curve log(1 + k*x) / log(1 + k). The bigger k, the harder the response.
+// It returns an error if k is not > 0.
+func LogarithmicCurve(k float64) (Curve, error) {
+	if !(k > 0) {
+		return nil, fmt.Errorf("k must be > 0, got %v", k)
+	}
+	return func(x float64) float64 { return math.Log1p(k*x) / math.Log1p(k) }, nil
+}
+
+// SCurve returns a logistic curve that is soft at the ends and steep in the middle.
+// The bigger steepness, the steeper the middle (e.g. 10).
+// It returns an error if steepness is not > 0.
+func SCurve(steepness float64) (Curve, error) {
+	if !(steepness > 0) {
+		return nil, fmt.Errorf("steepness must be > 0, got %v", steepness)
+	}
+	sigmoid := func(x float64) float64 { return 1 / (1 + math.Exp(-steepness*(x-0.5))) }
+	lo, hi := sigmoid(0), sigmoid(1)
+	return func(x float64) float64 { return (sigmoid(x) - lo) / (hi - lo) }, nil
+}
+
+// TableCurve returns a curve that is defined by a table of 7-bit output values that are evenly spread over the input range.
+// Between the entries it is interpolated. A table of 128 entries maps every 7-bit value directly.
+// It returns an error if the table has less than 2 entries.
+func TableCurve(table []uint8) (Curve, error) {
+	if len(table) < 2 {
+		return nil, fmt.Errorf("table must have at least 2 entries, got %v", len(table))
+	}
+	points := make([]CurvePoint, len(table))
+	for i, v := range table {
+		points[i] = CurvePoint{X: float64(i) / float64(len(table)-1), Y: float64(v) / 127}
+	}
+	return PiecewiseCurve(points...)
+}
+
+// CurvePoint is a point of a PiecewiseCurve
+type CurvePoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// PiecewiseCurve returns a curve that connects the given points by straight lines.
+// Before the first and after the last point, the curve is flat.
+// It returns an error if less than 2 points are given.
+func PiecewiseCurve(points ...CurvePoint) (Curve, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("at least 2 points are needed, got %v", len(points))
+	}
+	pts := make([]CurvePoint, len(points))
+	copy(pts, points)
+	sort.SliceStable(pts, func(a, b int) bool { return pts[a].X < pts[b].X })
+
+	return func(x float64) float64 {
+		i := sort.Search(len(pts), func(i int) bool { return pts[i].X >= x })
+		switch {
+		case i == 0:
+			return pts[0].Y
+		case i == len(pts):
+			return pts[len(pts)-1].Y
+		}
+		a, b := pts[i-1], pts[i]
+		return a.Y + (b.Y-a.Y)*(x-a.X)/(b.X-a.X)
+	}, nil
+}
+
+// CurveSpec describes a Curve in a form that can be saved, e.g. as JSON
+type CurveSpec struct {
+	// Type is linear (the default), exponential, logarithmic, s-curve, table or piecewise
+	Type string `json:"type,omitempty"`
+
+	// Param is the exponent of exponential, k of logarithmic and the steepness of s-curve
+	Param float64 `json:"param,omitempty"`
+
+	// Table is the table of table
+	Table []uint8 `json:"table,omitempty"`
+
+	// Points are the points of piecewise
+	Points []CurvePoint `json:"points,omitempty"`
+}
+
+// Curve returns the described curve
+func (s CurveSpec) Curve() (c Curve, err error) {
+	switch s.Type {
+	case "", "linear":
+		return LinearCurve(), nil
+	case "exponential":
+		c, err = ExponentialCurve(s.Param)
+	case "logarithmic":
+		c, err = LogarithmicCurve(s.Param)
+	case "s-curve":
+		c, err = SCurve(s.Param)
+	case "table":
+		c, err = TableCurve(s.Table)
+	case "piecewise":
+		c, err = PiecewiseCurve(s.Points...)
+	default:
+		return nil, fmt.Errorf("unknown curve type %q", s.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s curve: %v", s.Type, err)
+	}
+	return c, nil
+}
+
+// Map maps a 7-bit value
+func (c Curve) Map(value uint8) uint8 {
+	y := math.Round(c(float64(value)/127) * 127)
+	switch {
+	case y < 0 || math.IsNaN(y):
+		return 0
+	case y > 127:
+		return 127
+	}
+	return uint8(y)
+}
+
+// MapVelocity maps a note on velocity. Since a velocity of 0 would stop the note, the result is at least 1.
+func (c Curve) MapVelocity(velocity uint8) uint8 {
+	if v := c.Map(velocity); v > 0 {
+		return v
+	}
+	return 1
+}
+
+// MapPitchbend maps a pitch bend value. The curve is applied to the distance from the center
+// in both directions.
+func (c Curve) MapPitchbend(value int16) int16 {
+	max := 8191.0
+	if value < 0 {
+		max = 8192
+	}
+	x := math.Abs(float64(value)) / max
+	y := math.Round(c(x) * max)
+	switch {
+	case y < 0 || math.IsNaN(y):
+		y = 0
+	case y > max:
+		y = max
+	}
+	if value < 0 {
+		return -int16(y)
+	}
+	return int16(y)
+}
+
+// CurveSet is a set of curves per channel. Values without a curve (nil) are not changed.
+type CurveSet struct {
+	// Velocity is the curve for the note on velocity
+	Velocity [16]Curve
+
+	// Aftertouch is the curve for the channel pressure
+	Aftertouch [16]Curve
+
+	// PolyAftertouch is the curve for the key pressure
+	PolyAftertouch [16]Curve
+
+	// Pitchbend is the curve for the pitch bend
+	Pitchbend [16]Curve
+
+	// Controllers are the curves of the controllers (index is channel, controller)
+	Controllers [16][128]Curve
+}
+
+// Apply returns the message with the curves applied. Messages without a curve are returned unchanged.
+func (cs *CurveSet) Apply(msg midi.Message) midi.Message {
+	switch m := msg.(type) {
+	case channel.NoteOn:
+		if c := cs.Velocity[m.Channel()]; c != nil && m.Velocity() > 0 {
+			return channel.Channel(m.Channel()).NoteOn(m.Key(), c.MapVelocity(m.Velocity()))
+		}
+	case channel.Aftertouch:
+		if c := cs.Aftertouch[m.Channel()]; c != nil {
+			return channel.Channel(m.Channel()).Aftertouch(c.Map(m.Pressure()))
+		}
+	case channel.PolyAftertouch:
+		if c := cs.PolyAftertouch[m.Channel()]; c != nil {
+			return channel.Channel(m.Channel()).PolyAftertouch(m.Key(), c.Map(m.Pressure()))
+		}
+	case channel.Pitchbend:
+		if c := cs.Pitchbend[m.Channel()]; c != nil {
+			return channel.Channel(m.Channel()).Pitchbend(c.MapPitchbend(m.Value()))
+		}
+	case channel.ControlChange:
+		if c := cs.Controllers[m.Channel()][m.Controller()]; c != nil {
+			return channel.Channel(m.Channel()).ControlChange(m.Controller(), c.Map(m.Value()))
+		}
+	}
+	return msg
+}
+
+// Curves is a layer over a Reader that applies a CurveSet to the note on velocity, aftertouch,
+// pitch bend and control change callbacks that had been attached to the Reader before NewCurves was called.
+// Place it in front of callbacks that write to a Writer (or in front of other layers like Zones)
+// to write the values with the curves applied.
+//
+// Msg.Each still receives the unchanged messages.
+type Curves struct {
+	mx  sync.RWMutex
+	set *CurveSet
+
+	// the callbacks of the Reader before Curves took over
+	prev struct {
+		noteOn         func(p *Position, channel, key, velocity uint8)
+		pitchbend      func(p *Position, channel uint8, value int16)
+		aftertouch     func(p *Position, channel, pressure uint8)
+		polyAftertouch func(p *Position, channel, key, pressure uint8)
+		cc             func(p *Position, channel, controller, value uint8)
+	}
+}
+
+// NewCurves attaches Curves with the given set to rd.
+func NewCurves(rd *Reader, set CurveSet) *Curves {
+	c := &Curves{}
+	c.SetCurves(set)
+
+	c.prev.noteOn = rd.Msg.Channel.NoteOn
+	c.prev.pitchbend = rd.Msg.Channel.Pitchbend
+	c.prev.aftertouch = rd.Msg.Channel.Aftertouch
+	c.prev.polyAftertouch = rd.Msg.Channel.PolyAftertouch
+	c.prev.cc = rd.Msg.Channel.ControlChange.Each
+
+	if c.prev.noteOn != nil {
+		rd.Msg.Channel.NoteOn = c.noteOn
+	}
+	if c.prev.pitchbend != nil {
+		rd.Msg.Channel.Pitchbend = c.pitchbend
+	}
+	if c.prev.aftertouch != nil {
+		rd.Msg.Channel.Aftertouch = c.aftertouch
+	}
+	if c.prev.polyAftertouch != nil {
+		rd.Msg.Channel.PolyAftertouch = c.polyAftertouch
+	}
+	if c.prev.cc != nil {
+		rd.Msg.Channel.ControlChange.Each = c.controlChange
+	}
+	return c
+}
+
+// SetCurves replaces the curves. It may be called while reading.
+func (c *Curves) SetCurves(set CurveSet) {
+	c.mx.Lock()
+	c.set = &set
+	c.mx.Unlock()
+}
+
+func (c *Curves) curves() *CurveSet {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.set
+}
+
+func (c *Curves) noteOn(p *Position, ch, key, vel uint8) {
+	if curve := c.curves().Velocity[ch]; curve != nil {
+		vel = curve.MapVelocity(vel)
+	}
+	c.prev.noteOn(p, ch, key, vel)
+}
+
+func (c *Curves) pitchbend(p *Position, ch uint8, value int16) {
+	if curve := c.curves().Pitchbend[ch]; curve != nil {
+		value = curve.MapPitchbend(value)
+	}
+	c.prev.pitchbend(p, ch, value)
+}
+
+func (c *Curves) aftertouch(p *Position, ch, pressure uint8) {
+	if curve := c.curves().Aftertouch[ch]; curve != nil {
+		pressure = curve.Map(pressure)
+	}
+	c.prev.aftertouch(p, ch, pressure)
+}
+
+func (c *Curves) polyAftertouch(p *Position, ch, key, pressure uint8) {
+	if curve := c.curves().PolyAftertouch[ch]; curve != nil {
+		pressure = curve.Map(pressure)
+	}
+	c.prev.polyAftertouch(p, ch, key, pressure)
+}
+
+func (c *Curves) controlChange(p *Position, ch, cc, val uint8) {
+	if curve := c.curves().Controllers[ch][cc]; curve != nil {
+		val = curve.Map(val)
+	}
+	c.prev.cc(p, ch, cc, val)
+}
+
+// ApplyCurvesToSMF applies the curves to the SMF read from src and writes the result to dest.
+// Everything else (tracks, timing and other messages) is copied unchanged.
+func ApplyCurvesToSMF(src io.Reader, dest io.Writer, set CurveSet) (err error) {
+	var wr *SMFWriter
+
+	rd := NewReader(NoLogger())
+	rd.SMFHeader = func(h smf.Header) {
+		wr = NewSMF(dest, h.NumTracks, smfwriter.TimeFormat(h.TimeFormat), smfwriter.Format(h.Format))
+	}
+	rd.Msg.Each = func(p *Position, msg midi.Message) {
+		if err != nil {
+			return
+		}
+		wr.SetDelta(p.DeltaTicks)
+		if msg == meta.EndOfTrack {
+			err = wr.EndOfTrack()
+			if err == smf.ErrFinished {
+				err = nil
+			}
+			return
+		}
+		// don't check the note consolidation here, since we just copy
+		err = wr.wr.Write(set.Apply(msg))
+	}
+
+	if e := rd.ReadSMF(src, smfreader.NoteOffVelocity()); e != nil {
+		return fmt.Errorf("can't read SMF: %v", e)
+	}
+	return
+}
@@ -0,0 +1,193 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/smf"
+	"github.com/gomidi/midi/smf/smfreader"
+	"github.com/gomidi/midi/smf/smfwriter"
+)
+
+func mustCurve(c Curve, err error) Curve {
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestCurveMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		curve    Curve
+		in       []uint8
+		expected string
+	}{
+		{"linear", LinearCurve(), []uint8{0, 1, 64, 127}, "[0 1 64 127]"},
+		{"exponential", mustCurve(ExponentialCurve(2)), []uint8{0, 64, 127}, "[0 32 127]"},
+		{"logarithmic", mustCurve(LogarithmicCurve(10)), []uint8{0, 64, 127}, "[0 95 127]"},
+		{"s-curve", mustCurve(SCurve(10)), []uint8{0, 32, 64, 96, 127}, "[0 9 65 119 127]"},
+		{"table", mustCurve(TableCurve([]uint8{0, 100, 127})), []uint8{0, 32, 127}, "[0 50 127]"},
+		{"piecewise", mustCurve(PiecewiseCurve(CurvePoint{0.5, 0.5}, CurvePoint{0, 0.2}, CurvePoint{1, 0.5})), []uint8{0, 32, 64, 127}, "[25 45 64 64]"},
+		{"flat ends", mustCurve(PiecewiseCurve(CurvePoint{0.2, 0.1}, CurvePoint{0.8, 0.9})), []uint8{0, 127}, "[13 114]"},
+		{"clipped", func(x float64) float64 { return x * 2 }, []uint8{0, 100}, "[0 127]"},
+	}
+
+	for _, test := range tests {
+		var got []uint8
+		for _, v := range test.in {
+			got = append(got, test.curve.Map(v))
+		}
+		if fmt.Sprint(got) != test.expected {
+			t.Errorf("%s: got %v; want %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestCurveErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve func() (Curve, error)
+	}{
+		{"exponential", func() (Curve, error) { return ExponentialCurve(0) }},
+		{"logarithmic", func() (Curve, error) { return LogarithmicCurve(-1) }},
+		{"s-curve", func() (Curve, error) { return SCurve(math.NaN()) }},
+		{"table", func() (Curve, error) { return TableCurve([]uint8{127}) }},
+		{"piecewise", func() (Curve, error) { return PiecewiseCurve(CurvePoint{0, 0}) }},
+	}
+
+	for _, test := range tests {
+		if c, err := test.curve(); err == nil || c != nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestCurveMapPitchbend(t *testing.T) {
+	curve := mustCurve(ExponentialCurve(2))
+
+	tests := []struct {
+		in, expected int16
+	}{
+		{0, 0},
+		{8191, 8191},
+		{-8192, -8192},
+		{4096, 2048},
+		{-4096, -2048},
+	}
+
+	for _, test := range tests {
+		if got := curve.MapPitchbend(test.in); got != test.expected {
+			t.Errorf("MapPitchbend(%v) = %v; want %v", test.in, got, test.expected)
+		}
+	}
+
+	if got := mustCurve(ExponentialCurve(3)).MapVelocity(5); got != 1 {
+		t.Errorf("MapVelocity must not return 0, got %v", got)
+	}
+}
+
+func TestCurves(t *testing.T) {
+	var bf bytes.Buffer
+	wr := NewWriter(&bf)
+	rd := NewReader(NoLogger())
+
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		wr.SetChannel(ch)
+		wr.NoteOn(key, vel)
+	}
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) {
+		wr.SetChannel(ch)
+		wr.ControlChange(cc, val)
+	}
+	rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, value int16) {
+		wr.SetChannel(ch)
+		wr.Pitchbend(value)
+	}
+
+	var set CurveSet
+	set.Velocity[0] = mustCurve(ExponentialCurve(2))
+	set.Controllers[1][7] = mustCurve(TableCurve([]uint8{127, 0}))
+	set.Pitchbend[0] = func(float64) float64 { return 0 }
+	curves := NewCurves(rd, set)
+
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		{[]byte{0x90, 60, 64}, "90 3C 20"},
+		{[]byte{0x91, 60, 64}, "91 3C 40"},
+		{[]byte{0xB1, 7, 100}, "B1 07 1B"},
+		{[]byte{0xB1, 1, 100}, "B1 01 64"},
+		{[]byte{0xE0, 0, 0x60}, "E0 00 40"},
+	}
+
+	for _, test := range tests {
+		bf.Reset()
+		rd.Read(bytes.NewReader(test.in))
+		if got := fmt.Sprintf("% X", bf.Bytes()); got != test.expected {
+			t.Errorf("% X: got %v; want %v", test.in, got, test.expected)
+		}
+	}
+
+	curves.SetCurves(CurveSet{})
+	bf.Reset()
+	rd.Read(bytes.NewReader([]byte{0x90, 62, 64}))
+	if got, want := fmt.Sprintf("% X", bf.Bytes()), "90 3E 40"; got != want {
+		t.Errorf("after SetCurves: got %v; want %v", got, want)
+	}
+}
+
+func TestApplyCurvesToSMF(t *testing.T) {
+	var src bytes.Buffer
+	wr := NewSMF(&src, 2, smfwriter.TimeFormat(smf.MetricTicks(960)))
+	wr.TempoBPM(120)
+	wr.EndOfTrack()
+	wr.SetChannel(2)
+	wr.NoteOn(60, 64)
+	wr.SetDelta(960)
+	wr.NoteOffVelocity(60, 64)
+	wr.SetDelta(10)
+	wr.Aftertouch(64)
+	wr.EndOfTrack()
+
+	var set CurveSet
+	set.Velocity[2] = mustCurve(ExponentialCurve(2))
+	set.Aftertouch[2] = LinearCurve()
+
+	var dest bytes.Buffer
+	if err := ApplyCurvesToSMF(bytes.NewReader(src.Bytes()), &dest, set); err != nil {
+		t.Fatalf("ApplyCurvesToSMF returned error: %v", err)
+	}
+
+	var got []string
+	var header smf.Header
+	rd := NewReader(NoLogger())
+	rd.SMFHeader = func(h smf.Header) { header = h }
+	rd.Msg.Each = func(p *Position, msg midi.Message) {
+		got = append(got, fmt.Sprintf("%v %v %v", p.Track, p.AbsoluteTicks, msg))
+	}
+	if err := rd.ReadSMF(bytes.NewReader(dest.Bytes()), smfreader.NoteOffVelocity()); err != nil {
+		t.Fatalf("can't read result: %v", err)
+	}
+
+	if header.NumTracks != 2 || header.TimeFormat != smf.MetricTicks(960) {
+		t.Errorf("wrong header: %v", header)
+	}
+
+	expected := []string{
+		"0 0 meta.Tempo BPM: 120.00",
+		"0 0 meta.EndOfTrack",
+		"1 0 channel.NoteOn channel 2 key 60 velocity 32",
+		"1 960 channel.NoteOffVelocity channel 2 key 60 velocity 64",
+		"1 970 channel.Aftertouch channel 2 pressure 64",
+		"1 970 meta.EndOfTrack",
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("got\n%v\nwant\n%v", got, expected)
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/gomidi/mid
 
+go 1.27.1
+
 require (
 	github.com/gomidi/connect v0.10.0
 	github.com/gomidi/midi v1.6.0
@@ -0,0 +1,310 @@
+package mid
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Scale is a musical scale given by its pitch classes in semitones above the root (0-11, ascending)
+type Scale []uint8
+
+// common scales
+var (
+	ScaleMajor           = Scale{0, 2, 4, 5, 7, 9, 11}
+	ScaleMinor           = Scale{0, 2, 3, 5, 7, 8, 10}
+	ScaleHarmonicMinor   = Scale{0, 2, 3, 5, 7, 8, 11}
+	ScaleDorian          = Scale{0, 2, 3, 5, 7, 9, 10}
+	ScaleMixolydian      = Scale{0, 2, 4, 5, 7, 9, 10}
+	ScalePentatonicMajor = Scale{0, 2, 4, 7, 9}
+	ScalePentatonicMinor = Scale{0, 3, 5, 7, 10}
+	ScaleBlues           = Scale{0, 3, 5, 6, 7, 10}
+)
+
+func (s Scale) validate() error {
+	for i, pc := range s {
+		if pc > 11 || (i > 0 && pc <= s[i-1]) {
+			return fmt.Errorf("invalid scale %v", s)
+		}
+	}
+	return nil
+}
+
+// degree returns the index of the highest scale degree that is not above the given key
+// and the octave of the key relative to the root
+func (s Scale) degree(root uint8, key int) (degree, octave int, exact bool) {
+	rel := key - int(root)
+	octave = floorDiv(rel, 12)
+	pc := rel - octave*12
+
+	degree = -1
+	for i, p := range s {
+		if int(p) <= pc {
+			degree = i
+			exact = int(p) == pc
+		}
+	}
+	if degree < 0 {
+		// below the first pitch class: the last degree of the octave below
+		return len(s) - 1, octave - 1, false
+	}
+	return degree, octave, exact
+}
+
+// key returns the key of the given scale degree (may be negative or beyond the scale length)
+func (s Scale) key(root uint8, degree, octave int) int {
+	octave += floorDiv(degree, len(s))
+	degree -= floorDiv(degree, len(s)) * len(s)
+	return int(root) + octave*12 + int(s[degree])
+}
+
+// Snap returns the scale key next to the given key. If two keys are equally near, the lower one is returned.
+func (s Scale) Snap(root uint8, key uint8) uint8 {
+	if len(s) == 0 {
+		return key
+	}
+	degree, octave, exact := s.degree(root, int(key))
+	if exact {
+		return key
+	}
+	lower := s.key(root, degree, octave)
+	upper := s.key(root, degree+1, octave)
+	if upper-int(key) < int(key)-lower && upper <= 127 {
+		return uint8(upper)
+	}
+	if lower < 0 {
+		return uint8(upper)
+	}
+	return uint8(lower)
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// HarmonizerConfig configures a Harmonizer
+type HarmonizerConfig struct {
+	// InChannel is the channel of the played notes
+	InChannel uint8
+
+	// OutChannel is the channel of the written notes
+	OutChannel uint8
+
+	// Root is the pitch class of the root of the key (0 = C, 1 = C#, ..., 11 = B)
+	Root uint8
+
+	// Scale is the scale of the key. It is needed for Snap and Harmonies.
+	Scale Scale
+
+	// Snap moves the played notes to the next note of the scale
+	Snap bool
+
+	// Chord is a stored chord shape in semitones relative to the played note, e.g. {0, 4, 7} for a major triad.
+	// Every played note is expanded to the chord. If it is empty, the played note is kept.
+	Chord []int8
+
+	// Harmonies are diatonic intervals in scale steps that are added to every note, e.g. {2, 4} adds
+	// a third and a fifth within the scale and -7 adds the octave below (for a heptatonic scale).
+	Harmonies []int
+}
+
+func (c *HarmonizerConfig) validate() error {
+	if c.InChannel > 15 || c.OutChannel > 15 {
+		return fmt.Errorf("invalid channel")
+	}
+	if c.Root > 11 {
+		return fmt.Errorf("invalid root %v", c.Root)
+	}
+	if err := c.Scale.validate(); err != nil {
+		return err
+	}
+	if len(c.Scale) == 0 && (c.Snap || len(c.Harmonies) > 0) {
+		return fmt.Errorf("snap and harmonies need a scale")
+	}
+	return nil
+}
+
+// keys returns the keys that are played for the given key
+func (c *HarmonizerConfig) keys(key uint8) (keys []uint8) {
+	if c.Snap {
+		key = c.Scale.Snap(c.Root, key)
+	}
+
+	chord := c.Chord
+	if len(chord) == 0 {
+		chord = []int8{0}
+	}
+
+	var seen [128]bool
+	add := func(k int) {
+		if k >= 0 && k <= 127 && !seen[k] {
+			seen[k] = true
+			keys = append(keys, uint8(k))
+		}
+	}
+
+	for _, interval := range chord {
+		k := int(key) + int(interval)
+		add(k)
+
+		if len(c.Harmonies) == 0 {
+			continue
+		}
+
+		degree, octave, _ := c.Scale.degree(c.Root, k)
+		for _, h := range c.Harmonies {
+			add(c.Scale.key(c.Root, degree+h, octave) + k - c.Scale.key(c.Root, degree, octave))
+		}
+	}
+	return
+}
+
+// Harmonizer is a layer over a Reader that expands the notes of a channel into chords, adds diatonic harmonies
+// and snaps them to a scale before writing them to a ChannelWriter.
+//
+// Every generated note on gets a matching note off, even if the configuration changes in between.
+// If different played notes generate the same key, the key is started once and stopped when the last of
+// them is released, so the note consolidation of the writer (see ConsolidateNotes) is never violated.
+//
+// Messages on other channels and messages other than notes and polyphonic aftertouch are passed to the callbacks
+// that had been attached to the Reader before NewHarmonizer was called.
+type Harmonizer struct {
+	wr ChannelWriter
+
+	mx        sync.Mutex
+	cfg       HarmonizerConfig
+	generated [16][128][]uint8 // input channel -> played key -> generated keys
+	outChan   [16][128]uint8   // input channel -> played key -> output channel
+	sounding  [16][128]int     // output channel -> key -> number of played keys that generated it
+
+	// the callbacks of the Reader before the Harmonizer took over
+	prev struct {
+		noteOn         func(p *Position, channel, key, velocity uint8)
+		noteOff        func(p *Position, channel, key, velocity uint8)
+		polyAftertouch func(p *Position, channel, key, pressure uint8)
+	}
+
+	// Error is called for errors of the writer, if it is not nil
+	Error func(err error)
+}
+
+// NewHarmonizer attaches a Harmonizer to rd that writes to wr.
+func NewHarmonizer(rd *Reader, wr ChannelWriter, cfg HarmonizerConfig) (*Harmonizer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	h := &Harmonizer{wr: wr, cfg: cfg}
+
+	h.prev.noteOn = rd.Msg.Channel.NoteOn
+	h.prev.noteOff = rd.Msg.Channel.NoteOff
+	h.prev.polyAftertouch = rd.Msg.Channel.PolyAftertouch
+
+	rd.Msg.Channel.NoteOn = h.noteOn
+	rd.Msg.Channel.NoteOff = h.noteOff
+	rd.Msg.Channel.PolyAftertouch = h.polyAftertouch
+
+	return h, nil
+}
+
+// SetConfig changes the configuration. It may be called while reading.
+// Running notes are stopped as they were started.
+func (h *Harmonizer) SetConfig(cfg HarmonizerConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	h.mx.Lock()
+	h.cfg = cfg
+	h.mx.Unlock()
+	return nil
+}
+
+func (h *Harmonizer) error(err error) {
+	if err != nil && h.Error != nil {
+		h.Error(err)
+	}
+}
+
+func (h *Harmonizer) noteOn(p *Position, ch, key, vel uint8) {
+	h.mx.Lock()
+	if ch != h.cfg.InChannel {
+		h.mx.Unlock()
+		if h.prev.noteOn != nil {
+			h.prev.noteOn(p, ch, key, vel)
+		}
+		return
+	}
+	defer h.mx.Unlock()
+
+	// a retriggered key stops its previous notes
+	h.stop(ch, key, 0)
+
+	out := h.cfg.OutChannel
+	keys := h.cfg.keys(key)
+	h.generated[ch][key] = keys
+	h.outChan[ch][key] = out
+
+	h.wr.SetChannel(out)
+	for _, k := range keys {
+		h.sounding[out][k]++
+		if h.sounding[out][k] == 1 {
+			h.error(h.wr.NoteOn(k, vel))
+		}
+	}
+}
+
+func (h *Harmonizer) noteOff(p *Position, ch, key, vel uint8) {
+	h.mx.Lock()
+	if h.generated[ch][key] == nil && ch != h.cfg.InChannel {
+		h.mx.Unlock()
+		if h.prev.noteOff != nil {
+			h.prev.noteOff(p, ch, key, vel)
+		}
+		return
+	}
+	defer h.mx.Unlock()
+
+	h.stop(ch, key, vel)
+}
+
+// stop stops the notes generated by the played key. h.mx must be locked.
+func (h *Harmonizer) stop(ch, key, vel uint8) {
+	keys := h.generated[ch][key]
+	if keys == nil {
+		return
+	}
+	h.generated[ch][key] = nil
+
+	out := h.outChan[ch][key]
+	h.wr.SetChannel(out)
+	for _, k := range keys {
+		h.sounding[out][k]--
+		if h.sounding[out][k] > 0 {
+			continue
+		}
+		if vel > 0 {
+			h.error(h.wr.NoteOffVelocity(k, vel))
+		} else {
+			h.error(h.wr.NoteOff(k))
+		}
+	}
+}
+
+func (h *Harmonizer) polyAftertouch(p *Position, ch, key, pressure uint8) {
+	h.mx.Lock()
+	if h.generated[ch][key] == nil && ch != h.cfg.InChannel {
+		h.mx.Unlock()
+		if h.prev.polyAftertouch != nil {
+			h.prev.polyAftertouch(p, ch, key, pressure)
+		}
+		return
+	}
+	defer h.mx.Unlock()
+
+	h.wr.SetChannel(h.outChan[ch][key])
+	for _, k := range h.generated[ch][key] {
+		h.error(h.wr.PolyAftertouch(k, pressure))
+	}
+}
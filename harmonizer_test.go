@@ -0,0 +1,146 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestScaleSnap(t *testing.T) {
+	tests := []struct {
+		scale    Scale
+		root     uint8
+		key      uint8
+		expected uint8
+	}{
+		{ScaleMajor, 0, 60, 60},
+		{ScaleMajor, 0, 61, 60},
+		{ScaleMajor, 0, 66, 65},
+		{ScaleMajor, 2, 60, 59},
+		{ScalePentatonicMinor, 0, 61, 60},
+		{ScalePentatonicMinor, 0, 62, 63},
+		{ScalePentatonicMinor, 0, 71, 70},
+		{ScalePentatonicMinor, 0, 68, 67},
+		{ScaleMinor, 9, 0, 0},
+		{ScaleMinor, 9, 1, 0},
+		{ScaleMajor, 0, 127, 127},
+	}
+
+	for _, test := range tests {
+		if got := test.scale.Snap(test.root, test.key); got != test.expected {
+			t.Errorf("%v root %v Snap(%v) = %v; want %v", test.scale, test.root, test.key, got, test.expected)
+		}
+	}
+}
+
+func TestHarmonizerKeys(t *testing.T) {
+	tests := []struct {
+		cfg      HarmonizerConfig
+		key      uint8
+		expected string
+	}{
+		{HarmonizerConfig{}, 60, "[60]"},
+		{HarmonizerConfig{Chord: []int8{0, 4, 7}}, 62, "[62 66 69]"},
+		// diatonic thirds and fifths in C major
+		{HarmonizerConfig{Scale: ScaleMajor, Harmonies: []int{2, 4}}, 60, "[60 64 67]"},
+		{HarmonizerConfig{Scale: ScaleMajor, Harmonies: []int{2, 4}}, 62, "[62 65 69]"},
+		{HarmonizerConfig{Scale: ScaleMajor, Harmonies: []int{2, 4}}, 71, "[71 74 77]"},
+		{HarmonizerConfig{Scale: ScaleMajor, Harmonies: []int{-7}}, 64, "[64 52]"},
+		// in G major
+		{HarmonizerConfig{Root: 7, Scale: ScaleMajor, Harmonies: []int{2}}, 62, "[62 66]"},
+		// a chromatic note keeps its offset to the scale
+		{HarmonizerConfig{Scale: ScaleMajor, Harmonies: []int{2}}, 61, "[61 65]"},
+		{HarmonizerConfig{Scale: ScaleMajor, Snap: true, Harmonies: []int{2}}, 61, "[60 64]"},
+		{HarmonizerConfig{Scale: ScaleMajor, Snap: true, Chord: []int8{0, 12}, Harmonies: []int{2}}, 66, "[65 69 77 81]"},
+		// generated keys are unique and in range
+		{HarmonizerConfig{Chord: []int8{0, 12, 12}}, 120, "[120]"},
+	}
+
+	for _, test := range tests {
+		if got := fmt.Sprint(test.cfg.keys(test.key)); got != test.expected {
+			t.Errorf("%+v keys(%v) = %v; want %v", test.cfg, test.key, got, test.expected)
+		}
+	}
+}
+
+func TestHarmonizer(t *testing.T) {
+	var bf bytes.Buffer
+	rd := NewReader(NoLogger())
+
+	var passed []string
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		passed = append(passed, fmt.Sprintf("on %v %v", ch, key))
+	}
+
+	wr := NewWriter(&bf)
+	wr.ConsolidateNotes(true)
+
+	var errs []error
+	h, err := NewHarmonizer(rd, wr, HarmonizerConfig{OutChannel: 1, Scale: ScaleMajor, Harmonies: []int{2}})
+	if err != nil {
+		t.Fatalf("NewHarmonizer returned error: %v", err)
+	}
+	h.Error = func(err error) { errs = append(errs, err) }
+
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		{[]byte{0x90, 60, 100}, "91 3C 64 91 40 64"},
+		// E is shared with the harmony of C: it is started once
+		{[]byte{0x90, 64, 100}, "91 43 64"},
+		{[]byte{0xA0, 64, 20}, "A1 40 14 A1 43 14"},
+		// E still sounds for the played E
+		{[]byte{0x80, 60, 0}, "91 3C 00"},
+		{[]byte{0x80, 64, 0}, "91 40 00 91 43 00"},
+		// other channels are passed
+		{[]byte{0x92, 60, 100}, ""},
+	}
+
+	for _, test := range tests {
+		bf.Reset()
+		rd.Read(bytes.NewReader(test.in))
+
+		if got := fmt.Sprintf("% X", bf.Bytes()); got != test.expected {
+			t.Errorf("% X: got %v; want %v", test.in, got, test.expected)
+		}
+	}
+
+	if fmt.Sprint(passed) != "[on 2 60]" {
+		t.Errorf("passed %v; want [on 2 60]", passed)
+	}
+
+	// change the configuration while a note is down
+	bf.Reset()
+	rd.Read(bytes.NewReader([]byte{0x90, 62, 100}))
+	if err := h.SetConfig(HarmonizerConfig{OutChannel: 3, Chord: []int8{0, 3, 7}}); err != nil {
+		t.Fatalf("SetConfig returned error: %v", err)
+	}
+	rd.Read(bytes.NewReader([]byte{0x90, 60, 100, 0x80, 62, 0, 0x80, 60, 0}))
+
+	if got, want := fmt.Sprintf("% X", bf.Bytes()),
+		"91 3E 64 91 41 64 93 3C 64 93 3F 64 93 43 64 91 3E 00 91 41 00 93 3C 00 93 3F 00 93 43 00"; got != want {
+		t.Errorf("after SetConfig: got %v; want %v", got, want)
+	}
+
+	if len(errs) > 0 {
+		t.Errorf("the note consolidation of the writer must not complain: %v", errs)
+	}
+}
+
+func TestHarmonizerInvalid(t *testing.T) {
+	configs := []HarmonizerConfig{
+		{InChannel: 16},
+		{Root: 12},
+		{Snap: true},
+		{Harmonies: []int{2}},
+		{Scale: Scale{0, 4, 2}},
+		{Scale: Scale{0, 12}},
+	}
+
+	for _, cfg := range configs {
+		if _, err := NewHarmonizer(NewReader(), NewWriter(&bytes.Buffer{}), cfg); err == nil {
+			t.Errorf("NewHarmonizer(%+v) must return an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package mid
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomidi/connect"
+)
+
+// sub IDs of the general information messages
+const (
+	generalInformation = 0x06
+	identityRequest    = 0x01
+	identityReply      = 0x02
+)
+
+// ErrTimeout is returned, if an expected answer did not arrive in time
+var ErrTimeout = fmt.Errorf("timeout")
+
+// Identity is the information a device sends back on an identity request
+type Identity struct {
+
+	// Manufacturer is the system exclusive ID of the manufacturer
+	Manufacturer ManufacturerID
+
+	// Family is the device family code (LSB first in the message)
+	Family uint16
+
+	// Model is the device family member code (LSB first in the message)
+	Model uint16
+
+	// Version is the software revision level
+	Version [4]byte
+}
+
+// String represents the identity as a string (for debugging)
+func (i Identity) String() string {
+	return fmt.Sprintf("manufacturer: %s family: %04X model: %04X version: % X", i.Manufacturer, i.Family, i.Model, i.Version[:])
+}
+
+// bytes returns the identity as it appears inside an identity reply
+func (i Identity) bytes() []byte {
+	b := i.Manufacturer.Bytes()
+	return append(b,
+		byte(i.Family), byte(i.Family>>8),
+		byte(i.Model), byte(i.Model>>8),
+		i.Version[0], i.Version[1], i.Version[2], i.Version[3])
+}
+
+// IdentityReplyData returns the system exclusive data (without 0xF0 and 0xF7) of an identity reply
+func IdentityReplyData(deviceID uint8, id Identity) []byte {
+	return universalSysEx(false, deviceID, generalInformation, identityReply, id.bytes()...)
+}
+
+// ParseIdentityReply parses the given system exclusive data (without 0xF0 and 0xF7).
+// ok is false, if data is no valid identity reply.
+func ParseIdentityReply(data []byte) (deviceID uint8, id Identity, ok bool) {
+	if !isUniversalSysEx(data, generalInformation, identityReply) || data[0] != universalNonRealtime {
+		return
+	}
+
+	deviceID = data[1]
+	man, n := ParseManufacturerID(data[4:])
+	if n == 0 {
+		return
+	}
+
+	rest := data[4+n:]
+	if len(rest) < 8 {
+		return
+	}
+
+	id.Manufacturer = man
+	id.Family = uint16(rest[0]) | uint16(rest[1])<<8
+	id.Model = uint16(rest[2]) | uint16(rest[3])<<8
+	copy(id.Version[:], rest[4:8])
+	ok = true
+	return
+}
+
+// IdentityRequest writes an identity request for the given device.
+// Use AllDevices to ask every connected device.
+func (w *midiWriter) IdentityRequest(deviceID uint8) error {
+	return w.SysEx(universalSysEx(false, deviceID, generalInformation, identityRequest))
+}
+
+// IdentityReply writes an identity reply for the given device.
+func (w *midiWriter) IdentityReply(deviceID uint8, id Identity) error {
+	return w.SysEx(IdentityReplyData(deviceID, id))
+}
+
+// QueryIdentity sends an identity request to the device with the given ID via out and waits for the
+// reply on in. If no reply arrives within timeout, ErrTimeout is returned.
+// QueryIdentity takes over the listener of in and stops listening before returning.
+func QueryIdentity(out connect.Out, in connect.In, deviceID uint8, timeout time.Duration) (Identity, error) {
+	type reply struct {
+		deviceID uint8
+		id       Identity
+	}
+
+	replies := make(chan reply, 1)
+
+	rd := NewReader(NoLogger())
+	rd.Msg.SysEx.IdentityReply = func(p *Position, dev uint8, id Identity) {
+		if deviceID != AllDevices && dev != deviceID {
+			return
+		}
+		select {
+		case replies <- reply{dev, id}:
+		default:
+		}
+	}
+
+	err := rd.ReadFrom(in)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	defer in.StopListening()
+
+	err = WriteTo(out).IdentityRequest(deviceID)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	select {
+	case r := <-replies:
+		return r.id, nil
+	case <-time.After(timeout):
+		return Identity{}, ErrTimeout
+	}
+}
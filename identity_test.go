@@ -0,0 +1,107 @@
+package mid
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIdentityReply(t *testing.T) {
+	tests := []struct {
+		deviceID uint8
+		id       Identity
+	}{
+		{0x10, Identity{Manufacturer: ManufacturerID{0x41, 0, 0}, Family: 0x011E, Model: 0x0003, Version: [4]byte{0, 1, 0, 0}}},
+		{0x7F, Identity{Manufacturer: ManufacturerID{0, 0x20, 0x29}, Family: 0x0001, Model: 0x7F7F, Version: [4]byte{1, 2, 3, 4}}},
+	}
+
+	for _, test := range tests {
+		var bf bytes.Buffer
+		wr := NewWriter(&bf)
+		wr.IdentityReply(test.deviceID, test.id)
+
+		var gotDev uint8
+		var gotID Identity
+		var complete bool
+
+		rd := NewReader(NoLogger())
+		rd.Msg.SysEx.Complete = func(p *Position, data []byte) {
+			complete = true
+		}
+		rd.Msg.SysEx.IdentityReply = func(p *Position, dev uint8, id Identity) {
+			gotDev, gotID = dev, id
+		}
+		rd.Read(&bf)
+
+		if complete {
+			t.Errorf("Complete must not be called for identity replies")
+		}
+
+		if got, want := gotDev, test.deviceID; got != want {
+			t.Errorf("deviceID = %v; want %v", got, want)
+		}
+
+		if got, want := gotID, test.id; !reflect.DeepEqual(got, want) {
+			t.Errorf("identity = %v; want %v", got, want)
+		}
+	}
+}
+
+func TestIdentityRequest(t *testing.T) {
+	var bf bytes.Buffer
+	NewWriter(&bf).IdentityRequest(AllDevices)
+
+	if got, want := bf.Bytes(), []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IdentityRequest(AllDevices) = % X; want % X", got, want)
+	}
+}
+
+type identityDevice struct {
+	id       Identity
+	listener func([]byte, int64)
+}
+
+func (d *identityDevice) Open() error             { return nil }
+func (d *identityDevice) Close() error            { return nil }
+func (d *identityDevice) IsOpen() bool            { return true }
+func (d *identityDevice) Number() int             { return 0 }
+func (d *identityDevice) String() string          { return "identity device" }
+func (d *identityDevice) Underlying() interface{} { return nil }
+func (d *identityDevice) StopListening() error    { d.listener = nil; return nil }
+
+func (d *identityDevice) SetListener(fn func([]byte, int64)) error {
+	d.listener = fn
+	return nil
+}
+
+func (d *identityDevice) Send(b []byte) error {
+	if bytes.Equal(b, []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}) && d.listener != nil {
+		var bf bytes.Buffer
+		NewWriter(&bf).IdentityReply(0x05, d.id)
+		go d.listener(bf.Bytes(), 0)
+	}
+	return nil
+}
+
+func TestQueryIdentity(t *testing.T) {
+	dev := &identityDevice{id: Identity{Manufacturer: ManufacturerID{0x43, 0, 0}, Family: 0x0041, Model: 0x0200}}
+
+	id, err := QueryIdentity(dev, dev, AllDevices, time.Second)
+	if err != nil {
+		t.Fatalf("QueryIdentity returned error: %v", err)
+	}
+
+	if got, want := id, dev.id; !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryIdentity() = %v; want %v", got, want)
+	}
+
+	if got, want := id.Manufacturer.String(), "Yamaha"; got != want {
+		t.Errorf("Manufacturer.String() = %q; want %q", got, want)
+	}
+
+	_, err = QueryIdentity(dev, dev, 0x01, time.Millisecond*50)
+	if err != ErrTimeout {
+		t.Errorf("QueryIdentity for missing device returned %v; want ErrTimeout", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package bridge contains the message types and fields that are shared by the oscbridge and wsbridge packages.
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/gomidi/mid"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/channel"
+)
+
+// message types
+const (
+	NoteOn = iota
+	NoteOff
+	ControlChange
+	ProgramChange
+	Pitchbend
+	Aftertouch
+	PolyAftertouch
+	NumTypes
+)
+
+// TypeNames are the names of the message types
+var TypeNames = [NumTypes]string{
+	NoteOn:         "noteon",
+	NoteOff:        "noteoff",
+	ControlChange:  "cc",
+	ProgramChange:  "program",
+	Pitchbend:      "pitchbend",
+	Aftertouch:     "aftertouch",
+	PolyAftertouch: "polyaftertouch",
+}
+
+// fields of the messages
+const (
+	Ch = iota
+	Key
+	Vel
+	CC
+	Val
+	Prog
+	NumFields
+)
+
+// FieldNames are the names of the fields
+var FieldNames = [NumFields]string{
+	Ch:   "ch",
+	Key:  "key",
+	Vel:  "vel",
+	CC:   "cc",
+	Val:  "val",
+	Prog: "prog",
+}
+
+// Fields are the fields of each message type, besides the channel
+var Fields = [NumTypes][]int{
+	NoteOn:         {Key, Vel},
+	NoteOff:        {Key, Vel},
+	ControlChange:  {CC, Val},
+	ProgramChange:  {Prog},
+	Pitchbend:      {Val},
+	Aftertouch:     {Val},
+	PolyAftertouch: {Key, Val},
+}
+
+// Values are the field values of a message
+type Values [NumFields]int
+
+// Type returns the message type of the given name
+func Type(name string) (int, bool) {
+	for typ, n := range TypeNames {
+		if n == name {
+			return typ, true
+		}
+	}
+	return 0, false
+}
+
+// Field returns the field of the given name
+func Field(name string) (int, bool) {
+	for f, n := range FieldNames {
+		if n == name {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// Decode returns the type and the values of a channel message.
+// Note on messages with velocity 0 are of type NoteOff. For other messages ok is false.
+func Decode(msg midi.Message) (typ int, v Values, ok bool) {
+	switch m := msg.(type) {
+	case channel.NoteOn:
+		typ, v[Ch], v[Key], v[Vel] = NoteOn, int(m.Channel()), int(m.Key()), int(m.Velocity())
+		if m.Velocity() == 0 {
+			typ = NoteOff
+		}
+	case channel.NoteOff:
+		typ, v[Ch], v[Key] = NoteOff, int(m.Channel()), int(m.Key())
+	case channel.NoteOffVelocity:
+		typ, v[Ch], v[Key], v[Vel] = NoteOff, int(m.Channel()), int(m.Key()), int(m.Velocity())
+	case channel.ControlChange:
+		typ, v[Ch], v[CC], v[Val] = ControlChange, int(m.Channel()), int(m.Controller()), int(m.Value())
+	case channel.ProgramChange:
+		typ, v[Ch], v[Prog] = ProgramChange, int(m.Channel()), int(m.Program())
+	case channel.Pitchbend:
+		typ, v[Ch], v[Val] = Pitchbend, int(m.Channel()), int(m.Value())
+	case channel.Aftertouch:
+		typ, v[Ch], v[Val] = Aftertouch, int(m.Channel()), int(m.Pressure())
+	case channel.PolyAftertouch:
+		typ, v[Ch], v[Key], v[Val] = PolyAftertouch, int(m.Channel()), int(m.Key()), int(m.Pressure())
+	default:
+		return 0, v, false
+	}
+	return typ, v, true
+}
+
+// Write calls the Writer method for the message type. The values are clamped to their ranges,
+// but an invalid channel is an error.
+func Write(wr mid.ChannelWriter, typ int, v Values) error {
+	if v[Ch] < 0 || v[Ch] > 15 {
+		return fmt.Errorf("invalid channel %v", v[Ch])
+	}
+
+	b7 := func(f int) uint8 {
+		switch {
+		case v[f] < 0:
+			return 0
+		case v[f] > 127:
+			return 127
+		}
+		return uint8(v[f])
+	}
+
+	wr.SetChannel(uint8(v[Ch]))
+
+	switch typ {
+	case NoteOn:
+		return wr.NoteOn(b7(Key), b7(Vel))
+	case NoteOff:
+		if v[Vel] > 0 {
+			return wr.NoteOffVelocity(b7(Key), b7(Vel))
+		}
+		return wr.NoteOff(b7(Key))
+	case ControlChange:
+		return wr.ControlChange(b7(CC), b7(Val))
+	case ProgramChange:
+		return wr.ProgramChange(b7(Prog))
+	case Pitchbend:
+		val := v[Val]
+		if val < -8192 {
+			val = -8192
+		}
+		if val > 8191 {
+			val = 8191
+		}
+		return wr.Pitchbend(int16(val))
+	case Aftertouch:
+		return wr.Aftertouch(b7(Val))
+	case PolyAftertouch:
+		return wr.PolyAftertouch(b7(Key), b7(Val))
+	}
+	return nil
+}
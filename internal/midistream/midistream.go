@@ -0,0 +1,144 @@
+// Package midistream splits a MIDI 1.0 byte stream into complete messages.
+package midistream
+
+// DataLen returns the number of data bytes that follow the given status byte.
+// For system exclusive messages it returns -1.
+func DataLen(status byte) int {
+	switch status & 0xF0 {
+	case 0x80, 0x90, 0xA0, 0xB0, 0xE0:
+		return 2
+	case 0xC0, 0xD0:
+		return 1
+	}
+
+	switch status {
+	case 0xF0:
+		return -1
+	case 0xF1, 0xF3:
+		return 1
+	case 0xF2:
+		return 2
+	}
+
+	return 0
+}
+
+// IsRealtime returns true for system realtime status bytes
+func IsRealtime(b byte) bool {
+	return b >= 0xF8
+}
+
+// IsChannelStatus returns true for status bytes of channel messages
+func IsChannelStatus(b byte) bool {
+	return b >= 0x80 && b < 0xF0
+}
+
+// Splitter splits a MIDI 1.0 byte stream into complete messages.
+// Running status is resolved, so every message passed to the callback starts with its status byte.
+// Realtime messages inside other messages are passed immediately.
+// System exclusive messages are passed including 0xF0 and 0xF7. If a system exclusive message
+// is aborted by another status byte, it is passed with a terminating 0xF7.
+type Splitter struct {
+	fn      func(msg []byte)
+	status  byte
+	buf     []byte
+	need    int
+	inSysEx bool
+}
+
+// New returns a Splitter that calls fn for every complete message.
+// The passed slice must not be retained after fn returns.
+func New(fn func(msg []byte)) *Splitter {
+	return &Splitter{fn: fn}
+}
+
+// Write feeds the bytes of the stream into the splitter. It never returns an error.
+func (s *Splitter) Write(b []byte) (int, error) {
+	for _, c := range b {
+		s.writeByte(c)
+	}
+	return len(b), nil
+}
+
+// Reset clears the running status and any incomplete message
+func (s *Splitter) Reset() {
+	s.status = 0
+	s.buf = s.buf[:0]
+	s.need = 0
+	s.inSysEx = false
+}
+
+// InSysEx returns true, if the splitter is inside a system exclusive message
+func (s *Splitter) InSysEx() bool {
+	return s.inSysEx
+}
+
+func (s *Splitter) writeByte(c byte) {
+	if IsRealtime(c) {
+		s.fn([]byte{c})
+		return
+	}
+
+	if s.inSysEx {
+		if c == 0xF7 {
+			s.buf = append(s.buf, c)
+			s.inSysEx = false
+			s.fn(s.buf)
+			s.buf = s.buf[:0]
+			return
+		}
+		if c < 0x80 {
+			s.buf = append(s.buf, c)
+			return
+		}
+		// aborted by another status byte
+		s.buf = append(s.buf, 0xF7)
+		s.inSysEx = false
+		s.fn(s.buf)
+		s.buf = s.buf[:0]
+	}
+
+	if c >= 0x80 {
+		s.buf = append(s.buf[:0], c)
+
+		switch {
+		case c == 0xF0:
+			s.status = 0
+			s.inSysEx = true
+			return
+		case c == 0xF7:
+			// stray end of system exclusive
+			s.buf = s.buf[:0]
+			return
+		case IsChannelStatus(c):
+			s.status = c
+		default:
+			// system common messages cancel the running status
+			s.status = 0
+		}
+
+		s.need = DataLen(c)
+		if s.need == 0 {
+			s.fn(s.buf)
+			s.buf = s.buf[:0]
+		}
+		return
+	}
+
+	// data byte
+	if len(s.buf) == 0 {
+		if s.status == 0 {
+			// no running status: ignore
+			return
+		}
+		s.buf = append(s.buf, s.status)
+		s.need = DataLen(s.status)
+	}
+
+	s.buf = append(s.buf, c)
+	s.need--
+	if s.need == 0 {
+		s.fn(s.buf)
+		s.buf = s.buf[:0]
+	}
+}
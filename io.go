@@ -2,10 +2,14 @@ package mid
 
 import (
 	"bytes"
+	"math"
 	"time"
 
 	"github.com/gomidi/connect"
+	"github.com/gomidi/mid/internal/midistream"
 	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/channel"
+	"github.com/gomidi/midi/midimessage/realtime"
 	"github.com/gomidi/midi/midireader"
 	"github.com/gomidi/midi/smf"
 )
@@ -30,17 +34,150 @@ type inReader struct {
 	in         connect.In
 	midiReader midi.Reader
 	bf         bytes.Buffer
+
+	// pos is reused for every message
+	pos Position
+
+	// status is the running status of channel messages
+	status byte
+
+	// noteOffVelocity tells, if the midiReaderOptions keep the velocity of note off messages
+	noteOffVelocity bool
+}
+
+func newInReader(r *Reader, in connect.In) *inReader {
+	rd := &inReader{rd: r, in: in}
+	rd.midiReader = midireader.New(&rd.bf, r.dispatchRealTime, r.midiReaderOptions...)
+
+	probe, _ := midireader.New(bytes.NewReader([]byte{0x80, 0x3C, 0x40}), nil, r.midiReaderOptions...).Read()
+	_, rd.noteOffVelocity = probe.(channel.NoteOffVelocity)
+	return rd
 }
 
 func (r *inReader) handleMessage(b []byte, deltaMicroseconds int64) {
 	// use the fake position to get the ticks for the current tempo
-	r.rd.pos = &Position{}
-	r.rd.pos.DeltaTicks = r.rd.Ticks(time.Duration(deltaMicroseconds * 1000)) // deltaticks
-	r.rd.pos.AbsoluteTicks += uint64(r.rd.pos.DeltaTicks)
+	// (the position is read by tempo changes from other goroutines, see TempoDetector.Tap)
+	r.rd.tempoMx.Lock()
+	r.pos.DeltaTicks = r.rd.ticks(time.Duration(deltaMicroseconds * 1000)) // deltaticks
+	r.pos.AbsoluteTicks += uint64(r.pos.DeltaTicks)
+	r.rd.pos = &r.pos
+	r.rd.tempoMx.Unlock()
+
+	if r.dispatchDirect(b) {
+		return
+	}
+
+	// keep track of the running status that the midiReader sees
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] >= 0x80 && !midistream.IsRealtime(b[i]) {
+			r.status = 0
+			if midistream.IsChannelStatus(b[i]) {
+				r.status = b[i]
+			}
+			break
+		}
+	}
 	r.bf.Write(b)
 	r.rd.dispatchMessage(r.midiReader)
 }
 
+// dispatchDirect decodes complete channel and realtime messages directly from the bytes and dispatches them
+// without allocating (unless logging). It returns false, if the message must go through the midiReader.
+func (r *inReader) dispatchDirect(b []byte) bool {
+	if len(b) == 0 || r.bf.Len() > 0 || r.rd.Msg.Each != nil {
+		return false
+	}
+
+	if len(b) == 1 && midistream.IsRealtime(b[0]) {
+		if m := realtimeMessage(b[0]); m != nil {
+			r.rd.dispatchRealTime(m)
+			return true
+		}
+		return false
+	}
+
+	status, data := b[0], b[1:]
+	switch {
+	case midistream.IsChannelStatus(status):
+	case status < 0x80 && r.status != 0:
+		// running status
+		status, data = r.status, b
+	default:
+		return false
+	}
+
+	var d1, d2 byte
+	switch midistream.DataLen(status) {
+	case 1:
+		if len(data) != 1 {
+			return false
+		}
+		d1 = data[0]
+	case 2:
+		if len(data) != 2 {
+			return false
+		}
+		d1, d2 = data[0], data[1]
+	}
+
+	r.status = status
+	if r.rd.logger != nil {
+		r.rd.log(channelMessage(status, d1, d2, r.noteOffVelocity))
+	}
+	r.rd.dispatchChannel(status, d1, d2, r.noteOffVelocity)
+	return true
+}
+
+// channelMessage returns the channel message like the midiReader does
+func channelMessage(status, d1, d2 uint8, noteOffVelocity bool) midi.Message {
+	ch := channel.Channel(status & 0x0F)
+
+	switch status & 0xF0 {
+	case 0x90:
+		if d2 == 0 {
+			return ch.NoteOff(d1)
+		}
+		return ch.NoteOn(d1, d2)
+	case 0x80:
+		if noteOffVelocity {
+			return ch.NoteOffVelocity(d1, d2)
+		}
+		return ch.NoteOff(d1)
+	case 0xA0:
+		return ch.PolyAftertouch(d1, d2)
+	case 0xB0:
+		return ch.ControlChange(d1, d2)
+	case 0xC0:
+		return ch.ProgramChange(d1)
+	case 0xD0:
+		return ch.Aftertouch(d1)
+	default:
+		// Channel.Pitchbend does not set the absolute value
+		m, _ := channel.NewReader(bytes.NewReader([]byte{d2})).Read(status, d1)
+		return m
+	}
+}
+
+func realtimeMessage(b byte) realtime.Message {
+	switch b {
+	case 0xF8:
+		return realtime.TimingClock
+	case 0xF9:
+		return realtime.Tick
+	case 0xFA:
+		return realtime.Start
+	case 0xFB:
+		return realtime.Continue
+	case 0xFC:
+		return realtime.Stop
+	case 0xFE:
+		return realtime.Activesense
+	case 0xFF:
+		return realtime.Reset
+	}
+	return nil
+}
+
 // Duration returns the duration for the given delta ticks, respecting the current tempo
 func (r *Reader) Duration(deltaticks uint32) time.Duration {
 	return r.resolution.FractionalDuration(r.TempoBPM(), deltaticks)
@@ -58,10 +195,19 @@ func (r *Reader) Resolution() uint32 {
 // Ticks returns the ticks that correspond to a duration while respecting the current tempo
 // If it can't be determined, 0 is returned
 func (r *Reader) Ticks(d time.Duration) uint32 {
+	r.tempoMx.Lock()
+	defer r.tempoMx.Unlock()
+	return r.ticks(d)
+}
+
+// ticks is Ticks for the caller that holds the tempoMx
+func (r *Reader) ticks(d time.Duration) uint32 {
 	if r.resolution == 0 {
 		return 0
 	}
-	return r.resolution.FractionalTicks(r.TempoBPM(), d)
+	// same as r.resolution.FractionalTicks, but without allocating (it is called for every live message)
+	bpm := r.tempoChanges[len(r.tempoChanges)-1].bpm
+	return uint32(math.RoundToEven(float64(d.Nanoseconds()) / 1000000 * float64(uint16(r.resolution)) * bpm / 60000))
 }
 
 /*
@@ -74,6 +220,8 @@ func (r *Reader) Tempo() uint32 {
 
 // BPM returns the current tempo in BPM (beats per minute)
 func (r *Reader) TempoBPM() float64 {
+	r.tempoMx.Lock()
+	defer r.tempoMx.Unlock()
 	tempochange := r.tempoChanges[len(r.tempoChanges)-1]
 	return tempochange.bpm
 }
@@ -81,14 +229,25 @@ func (r *Reader) TempoBPM() float64 {
 // ReadFrom configures the Reader to read from to the given MIDI in connection.
 // The gomidi/connect package provides adapters to rtmidi and portaudio
 // that fullfill the InConnection interface.
+//
+// The Position that is passed to the callbacks is reused for every message, so it must not be retained.
+// Channel and realtime messages are decoded without allocation, unless Msg.Each is set.
+// If a logger is used, only the logging allocates (use NoLogger to avoid it).
+//
+// When done, call in.StopListening() and then StopReading.
 func (r *Reader) ReadFrom(in connect.In) error {
 	r.resolution = LiveResolution
 	r.reset()
-	rd := &inReader{rd: r, in: in}
-	rd.midiReader = midireader.New(&rd.bf, r.dispatchRealTime, r.midiReaderOptions...)
+	rd := newInReader(r, in)
 	return rd.in.SetListener(rd.handleMessage)
 }
 
+// StopReading stops the watching of active sense messages of a Reader that reads with ReadFrom, so that
+// Msg.Realtime.ConnectionLost is not called anymore. It must be called after in.StopListening().
+func (r *Reader) StopReading() {
+	r.stopWatchdog()
+}
+
 // LiveResolution is the resolution used for live over the wire reading with Reader.ReadFrom
 const LiveResolution = smf.MetricTicks(1920)
 
@@ -0,0 +1,215 @@
+package mid
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midireader"
+)
+
+// inReaderRecorder records the callbacks of a Reader that reads from an inReader
+type inReaderRecorder []string
+
+func (rec *inReaderRecorder) attach(rd *Reader) {
+	add := func(p *Position, format string, args ...interface{}) {
+		*rec = append(*rec, fmt.Sprintf("%v:", p.AbsoluteTicks)+fmt.Sprintf(format, args...))
+	}
+
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) { add(p, "on %v %v %v", ch, key, vel) }
+	rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) { add(p, "off %v %v %v", ch, key, vel) }
+	rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, val int16) { add(p, "pb %v %v", ch, val) }
+	rd.Msg.Channel.PolyAftertouch = func(p *Position, ch, key, val uint8) { add(p, "pat %v %v %v", ch, key, val) }
+	rd.Msg.Channel.Aftertouch = func(p *Position, ch, val uint8) { add(p, "at %v %v", ch, val) }
+	rd.Msg.Channel.ProgramChange = func(p *Position, ch, prog uint8) { add(p, "pc %v %v", ch, prog) }
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) { add(p, "cc %v %v %v", ch, cc, val) }
+	rd.Msg.Channel.ControlChange.RPN.MSB = func(p *Position, ch, typ1, typ2, val uint8) {
+		add(p, "rpn msb %v %v %v %v", ch, typ1, typ2, val)
+	}
+	rd.Msg.Channel.ControlChange.RPN.LSB = func(p *Position, ch, typ1, typ2, val uint8) {
+		add(p, "rpn lsb %v %v %v %v", ch, typ1, typ2, val)
+	}
+	rd.Msg.Realtime.Start = func() { *rec = append(*rec, "start") }
+	rd.Msg.SysEx.Complete = func(p *Position, data []byte) { add(p, "sysex % X", data) }
+}
+
+func newTestInReader(rd *Reader) *inReader {
+	rd.resolution = LiveResolution
+	rd.reset()
+	return newInReader(rd, nil)
+}
+
+func TestInReaderDirectDispatch(t *testing.T) {
+	input := [][]byte{
+		{0x90, 60, 100},
+		{61, 90}, // running status
+		{0x90, 60, 0},
+		{0x81, 61, 64},
+		{0xE2, 0x00, 0x40},
+		{0xE2, 0x7F, 0x7F},
+		{0xE2, 0x00, 0x00},
+		{0xA3, 60, 20},
+		{0xD4, 30},
+		{0xC5, 7},
+		{0x92, 0xF8, 60, 100}, // goes through the midireader
+		{61, 90},              // running status of the previous message
+		{0xB0, 7, 100},
+		{0xB0, 101, 0},
+		{0xB0, 100, 1},
+		{0xB0, 6, 64},
+		{0xB0, 38, 2},
+		{0xFA},
+		{0xF0, 0x7D, 0x01, 0xF7},
+		{62, 1}, // running status is cancelled by system messages
+		{0xB1, 1, 10},
+	}
+
+	tests := []struct {
+		options []ReaderOption
+		noteOff string
+	}{
+		{nil, "off 1 61 0"},
+		{[]ReaderOption{ReadingOptions(midireader.NoteOffVelocity())}, "off 1 61 64"},
+	}
+
+	for _, test := range tests {
+		var direct, generic inReaderRecorder
+
+		rd := NewReader(append(test.options, NoLogger())...)
+		direct.attach(rd)
+		in := newTestInReader(rd)
+		for _, b := range input {
+			in.handleMessage(b, 1000)
+		}
+
+		rd = NewReader(append(test.options, NoLogger())...)
+		generic.attach(rd)
+		rd.Msg.Each = func(*Position, midi.Message) {}
+		in = newTestInReader(rd)
+		for _, b := range input {
+			in.handleMessage(b, 1000)
+		}
+
+		if fmt.Sprint(direct) != fmt.Sprint(generic) {
+			t.Errorf("direct dispatch differs from generic dispatch:\n%q\n%q", direct, generic)
+		}
+
+		if len(direct) != 18 || direct[3] != "16:"+test.noteOff || direct[11] != "48:on 2 61 90" || direct[17] != "84:cc 1 1 10" {
+			t.Errorf("unexpected callbacks %q", direct)
+		}
+	}
+}
+
+// logRecorder is a Logger that records the log lines
+type logRecorder []string
+
+func (l *logRecorder) Printf(format string, vals ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, vals...))
+}
+
+func TestInReaderDirectDispatchLogger(t *testing.T) {
+	input := [][]byte{{0x90, 60, 100}, {60, 0}, {0x80, 60, 10}, {0xA1, 60, 3}, {0xB2, 7, 1}, {0xC3, 4}, {0xD4, 5}, {0xE5, 1, 0x40}}
+
+	var direct, generic logRecorder
+
+	in := newTestInReader(NewReader(SetLogger(&direct)))
+	for _, b := range input {
+		in.handleMessage(b, 1000)
+	}
+
+	rd := NewReader(SetLogger(&generic))
+	rd.Msg.Each = func(*Position, midi.Message) {}
+	in = newTestInReader(rd)
+	for _, b := range input {
+		in.handleMessage(b, 1000)
+	}
+
+	if len(direct) != len(input) || fmt.Sprint(direct) != fmt.Sprint(generic) {
+		t.Errorf("direct dispatch logs differently:\n%q\n%q", direct, generic)
+	}
+}
+
+func TestInReaderDirectDispatchDefaultReader(t *testing.T) {
+	defer discardStdout()()
+
+	var got []string
+	rd := NewReader()
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) { got = append(got, fmt.Sprint(ch, cc, val)) }
+	in := newTestInReader(rd)
+
+	if !in.dispatchDirect([]byte{0xB1, 74, 10}) || fmt.Sprint(got) != "[1 74 10]" {
+		t.Errorf("a default reader must use the direct dispatch, got %v", got)
+	}
+}
+
+// discardStdout redirects the standard output (of the default logger) to the null device until the returned function is called
+func discardStdout() func() {
+	stdout := os.Stdout
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return func() {}
+	}
+	os.Stdout = null
+	return func() {
+		os.Stdout = stdout
+		null.Close()
+	}
+}
+
+func TestInReaderDirectDispatchAllocs(t *testing.T) {
+	rd := NewReader(NoLogger())
+	var sum int
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) { sum += int(vel) }
+	rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, val int16) { sum += int(val) }
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) { sum += int(val) }
+	in := newTestInReader(rd)
+
+	msgs := [][]byte{{0x90, 60, 100}, {0xE0, 0, 0x40}, {0xB0, 74, 10}, {0xF8}}
+	allocs := testing.AllocsPerRun(1000, func() {
+		for _, b := range msgs {
+			in.handleMessage(b, 1000)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("direct dispatch allocates %v times per run", allocs)
+	}
+}
+
+func benchmarkHandleMessage(b *testing.B, rd *Reader, generic bool) {
+	var sum int
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) { sum += int(val) }
+	rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, val int16) { sum += int(val) }
+	if generic {
+		rd.Msg.Each = func(*Position, midi.Message) {}
+	}
+	in := newTestInReader(rd)
+
+	// a dense MPE like controller stream
+	msgs := [][]byte{{0xB1, 74, 10}, {0xE1, 0x10, 0x40}, {0xD1, 20}, {0xB2, 74, 90}, {0xE2, 0x7F, 0x3F}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in.handleMessage(msgs[i%len(msgs)], 1000)
+	}
+}
+
+func BenchmarkHandleMessageDirect(b *testing.B) {
+	benchmarkHandleMessage(b, NewReader(NoLogger()), false)
+}
+
+func BenchmarkHandleMessageGeneric(b *testing.B) {
+	benchmarkHandleMessage(b, NewReader(NoLogger()), true)
+}
+
+// the default reader logs every message
+func BenchmarkHandleMessageDirectDefaultReader(b *testing.B) {
+	defer discardStdout()()
+	benchmarkHandleMessage(b, NewReader(), false)
+}
+
+func BenchmarkHandleMessageGenericDefaultReader(b *testing.B) {
+	defer discardStdout()()
+	benchmarkHandleMessage(b, NewReader(), true)
+}
@@ -0,0 +1,361 @@
+package mid
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// LatencyProbe is the kind of messages that are sent by MeasureLatencyWith
+type LatencyProbe uint8
+
+const (
+	// SysExProbe sends system exclusive messages (non-commercial manufacturer ID 7D) that carry
+	// a sequence number and the time of sending
+	SysExProbe LatencyProbe = iota
+
+	// NoteProbe sends note on/note off pairs, for paths that filter system exclusive messages.
+	// The sequence number is encoded in key and velocity, so it wraps after 16256 probes.
+	NoteProbe
+)
+
+// LatencyConfig configures MeasureLatencyWith
+type LatencyConfig struct {
+	// N is the number of probes (at most 16256 note probes or 2097152 sysex probes)
+	N int
+
+	// Probe is the kind of the probes
+	Probe LatencyProbe
+
+	// Channel is the channel of the note probes
+	Channel uint8
+
+	// Interval is the time between two probes. If it is 0, 10ms are used.
+	Interval time.Duration
+
+	// Timeout is the time to wait for outstanding probes after the last one has been sent.
+	// Probes that did not arrive until then are lost. If it is 0, 1s is used.
+	Timeout time.Duration
+}
+
+// LatencyReport is the result of a latency measurement
+type LatencyReport struct {
+	// Sent is the number of sent probes
+	Sent int
+
+	// Lost is the number of probes that did not come back
+	Lost int
+
+	// Reordered is the number of probes that came back after a later probe
+	Reordered int
+
+	// Duplicated is the number of probes that came back more than once
+	Duplicated int
+
+	// RoundTrips are the round trip times of the returned probes in the order of sending
+	RoundTrips []time.Duration
+
+	sorted []time.Duration
+}
+
+// Min returns the shortest round trip time
+func (r *LatencyReport) Min() time.Duration {
+	return r.Percentile(0)
+}
+
+// Max returns the longest round trip time
+func (r *LatencyReport) Max() time.Duration {
+	return r.Percentile(100)
+}
+
+// Mean returns the mean round trip time
+func (r *LatencyReport) Mean() time.Duration {
+	if len(r.RoundTrips) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range r.RoundTrips {
+		sum += d
+	}
+	return sum / time.Duration(len(r.RoundTrips))
+}
+
+// Percentile returns the round trip time that p percent (0-100) of the probes did not exceed
+func (r *LatencyReport) Percentile(p float64) time.Duration {
+	if len(r.RoundTrips) == 0 {
+		return 0
+	}
+	if len(r.sorted) != len(r.RoundTrips) {
+		r.sorted = make([]time.Duration, len(r.RoundTrips))
+		copy(r.sorted, r.RoundTrips)
+		sort.Slice(r.sorted, func(a, b int) bool { return r.sorted[a] < r.sorted[b] })
+	}
+	i := int(math.Ceil(p/100*float64(len(r.sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.sorted) {
+		i = len(r.sorted) - 1
+	}
+	return r.sorted[i]
+}
+
+// StdDev returns the standard deviation of the round trip times
+func (r *LatencyReport) StdDev() time.Duration {
+	if len(r.RoundTrips) < 2 {
+		return 0
+	}
+	mean := float64(r.Mean())
+	var sum float64
+	for _, d := range r.RoundTrips {
+		sum += (float64(d) - mean) * (float64(d) - mean)
+	}
+	return time.Duration(math.Sqrt(sum / float64(len(r.RoundTrips)-1)))
+}
+
+// Jitter returns the mean difference between the round trip times of consecutive probes
+func (r *LatencyReport) Jitter() time.Duration {
+	if len(r.RoundTrips) < 2 {
+		return 0
+	}
+	var sum time.Duration
+	for i := 1; i < len(r.RoundTrips); i++ {
+		d := r.RoundTrips[i] - r.RoundTrips[i-1]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum / time.Duration(len(r.RoundTrips)-1)
+}
+
+// Histogram returns the number of round trips per bucket of the given width, starting with the bucket of Min
+// (so the first bucket starts at Min truncated to the width)
+func (r *LatencyReport) Histogram(width time.Duration) (start time.Duration, counts []int) {
+	if len(r.RoundTrips) == 0 || width <= 0 {
+		return 0, nil
+	}
+	start = r.Min().Truncate(width)
+	counts = make([]int, int((r.Max()-start)/width)+1)
+	for _, d := range r.RoundTrips {
+		counts[(d-start)/width]++
+	}
+	return
+}
+
+// String returns a summary of the report
+func (r *LatencyReport) String() string {
+	var bf strings.Builder
+	fmt.Fprintf(&bf, "probes: %v sent, %v received, %v lost (%.1f%%), %v reordered, %v duplicated\n",
+		r.Sent, len(r.RoundTrips), r.Lost, r.lossPercent(), r.Reordered, r.Duplicated)
+	if len(r.RoundTrips) == 0 {
+		return bf.String()
+	}
+	fmt.Fprintf(&bf, "round trip: min %v, mean %v, median %v, p95 %v, p99 %v, max %v\n",
+		r.Min(), r.Mean(), r.Percentile(50), r.Percentile(95), r.Percentile(99), r.Max())
+	fmt.Fprintf(&bf, "jitter: %v, stddev %v\n", r.Jitter(), r.StdDev())
+	return bf.String()
+}
+
+func (r *LatencyReport) lossPercent() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Lost) * 100 / float64(r.Sent)
+}
+
+// MeasureLatency sends n probes to out, 10ms apart, and measures the round trip times of the probes
+// that come back via in (e.g. through a loopback cable or a device that echoes). Both ports must be open.
+// See MeasureLatencyWith for more options.
+func MeasureLatency(out connect.Out, in connect.In, n int) (*LatencyReport, error) {
+	return MeasureLatencyWith(out, in, LatencyConfig{N: n})
+}
+
+// MeasureLatencyWith measures the latency like MeasureLatency, configured by cfg.
+// Messages that arrive on in and are no probes are ignored.
+func MeasureLatencyWith(out connect.Out, in connect.In, cfg LatencyConfig) (*LatencyReport, error) {
+	if cfg.N <= 0 {
+		return nil, fmt.Errorf("invalid number of probes %v", cfg.N)
+	}
+	if cfg.Probe == NoteProbe && cfg.N > maxNoteProbes {
+		return nil, fmt.Errorf("too many note probes: %v (max %v)", cfg.N, maxNoteProbes)
+	}
+	if cfg.Probe == SysExProbe && cfg.N > maxSysExProbes {
+		return nil, fmt.Errorf("too many sysex probes: %v (max %v)", cfg.N, maxSysExProbes)
+	}
+	if cfg.Channel > 15 {
+		return nil, fmt.Errorf("invalid channel %v", cfg.Channel)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Millisecond
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+
+	m := &latencyMeasurement{
+		cfg:      cfg,
+		start:    time.Now(),
+		sentAt:   make([]time.Duration, cfg.N),
+		received: make([]time.Duration, cfg.N),
+		count:    make([]int, cfg.N),
+		done:     make(chan struct{}),
+		last:     -1,
+	}
+	m.splitter = midistream.New(m.receive)
+
+	if err := in.SetListener(m.listen); err != nil {
+		return nil, fmt.Errorf("can't listen to %v: %v", in, err)
+	}
+	defer in.StopListening()
+
+	for i := 0; i < cfg.N; i++ {
+		if i > 0 {
+			time.Sleep(cfg.Interval)
+		}
+		if err := m.send(out, i); err != nil {
+			return nil, fmt.Errorf("can't send probe %v to %v: %v", i, out, err)
+		}
+	}
+
+	select {
+	case <-m.done:
+	case <-time.After(cfg.Timeout):
+	}
+
+	return m.report(), nil
+}
+
+const maxNoteProbes = 128 * 127
+
+// maxSysExProbes is the number of sequence numbers that fit into the three 7bit bytes of a sysex probe
+const maxSysExProbes = 1 << 21
+
+// latencyMeasurement is the state of a running measurement
+type latencyMeasurement struct {
+	cfg      LatencyConfig
+	start    time.Time
+	splitter *midistream.Splitter
+
+	mx        sync.Mutex
+	sentAt    []time.Duration // since start
+	received  []time.Duration // round trip times
+	count     []int           // number of receptions
+	sent      int
+	returned  int
+	last      int // the highest received probe
+	reordered int
+	done      chan struct{}
+}
+
+func (m *latencyMeasurement) send(out connect.Out, seq int) error {
+	var msg []byte
+	switch m.cfg.Probe {
+	case NoteProbe:
+		key, vel := uint8(seq%128), uint8(seq/128+1)
+		msg = []byte{0x90 | m.cfg.Channel, key, vel}
+	default:
+		msg = make([]byte, 0, 12)
+		msg = append(msg, 0xF0, 0x7D, 'L')
+		msg = appendUint7(msg, uint64(seq), 3)
+		msg = appendUint7(msg, uint64(time.Since(m.start)/time.Microsecond), 5)
+		msg = append(msg, 0xF7)
+	}
+
+	m.mx.Lock()
+	m.sentAt[seq] = time.Since(m.start)
+	m.sent = seq + 1
+	m.mx.Unlock()
+
+	if err := out.Send(msg); err != nil {
+		return err
+	}
+	if m.cfg.Probe == NoteProbe {
+		return out.Send([]byte{0x80 | m.cfg.Channel, msg[1], 0})
+	}
+	return nil
+}
+
+// appendUint7 appends v as n bytes of 7 bit (most significant first)
+func appendUint7(b []byte, v uint64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(7*uint(i)))&0x7F)
+	}
+	return b
+}
+
+func readUint7(b []byte) (v uint64) {
+	for _, c := range b {
+		v = v<<7 | uint64(c&0x7F)
+	}
+	return
+}
+
+func (m *latencyMeasurement) listen(data []byte, deltaMicroseconds int64) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.splitter.Write(data)
+}
+
+// receive handles a complete message. m.mx is locked.
+func (m *latencyMeasurement) receive(msg []byte) {
+	now := time.Since(m.start)
+
+	seq := -1
+	var rtt time.Duration
+
+	switch {
+	case m.cfg.Probe == NoteProbe && len(msg) == 3 && msg[0] == 0x90|m.cfg.Channel && msg[2] > 0:
+		seq = int(msg[2]-1)*128 + int(msg[1])
+		if seq < m.sent {
+			rtt = now - m.sentAt[seq]
+		}
+	case m.cfg.Probe == SysExProbe && len(msg) == 12 && msg[1] == 0x7D && msg[2] == 'L':
+		seq = int(readUint7(msg[3:6]))
+		rtt = now - time.Duration(readUint7(msg[6:11]))*time.Microsecond
+	}
+
+	if seq < 0 || seq >= m.sent {
+		return
+	}
+
+	m.count[seq]++
+	if m.count[seq] > 1 {
+		return
+	}
+
+	m.received[seq] = rtt
+	if seq < m.last {
+		m.reordered++
+	} else {
+		m.last = seq
+	}
+
+	m.returned++
+	if m.returned == m.cfg.N {
+		close(m.done)
+	}
+}
+
+func (m *latencyMeasurement) report() *LatencyReport {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	r := &LatencyReport{Sent: m.sent, Reordered: m.reordered}
+	for i := 0; i < m.sent; i++ {
+		switch c := m.count[i]; {
+		case c == 0:
+			r.Lost++
+		default:
+			r.Duplicated += c - 1
+			r.RoundTrips = append(r.RoundTrips, m.received[i])
+		}
+	}
+	return r
+}
@@ -0,0 +1,126 @@
+package mid
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomidi/mid/mockdriver"
+)
+
+// delayPort is an out port that delivers the sent messages to its listener after a delay.
+// The delay function may return a negative delay to drop the message.
+type delayPort struct {
+	mockdriver.Out
+	delay func(n int) time.Duration
+
+	mx       sync.Mutex
+	n        int
+	listener func([]byte, int64)
+}
+
+func (p *delayPort) Send(msg []byte) error {
+	p.mx.Lock()
+	d := p.delay(p.n)
+	p.n++
+	listener := p.listener
+	p.mx.Unlock()
+
+	if d < 0 || listener == nil {
+		return nil
+	}
+	b := append([]byte(nil), msg...)
+	time.AfterFunc(d, func() { listener(b, 0) })
+	return nil
+}
+
+func (p *delayPort) SetListener(fn func([]byte, int64)) error {
+	p.mx.Lock()
+	p.listener = fn
+	p.mx.Unlock()
+	return nil
+}
+
+func (p *delayPort) StopListening() error {
+	return p.SetListener(nil)
+}
+
+func TestMeasureLatencyLoopback(t *testing.T) {
+	drv := mockdriver.New("latency")
+	out, in := drv.Loopback("loop")
+
+	for _, probe := range []LatencyProbe{SysExProbe, NoteProbe} {
+		r, err := MeasureLatencyWith(out, in, LatencyConfig{N: 20, Probe: probe, Channel: 3, Interval: time.Millisecond})
+		if err != nil {
+			t.Fatalf("probe %v: MeasureLatencyWith returned error: %v", probe, err)
+		}
+		if r.Sent != 20 || len(r.RoundTrips) != 20 || r.Lost != 0 || r.Reordered != 0 || r.Duplicated != 0 {
+			t.Errorf("probe %v: unexpected report\n%v", probe, r)
+		}
+		if r.Max() > 50*time.Millisecond {
+			t.Errorf("probe %v: a direct loopback must be fast, got max %v", probe, r.Max())
+		}
+	}
+
+	if sent := out.Sent(); len(sent) != 20+40 || sent[20][0] != 0x93 || sent[21][0] != 0x83 {
+		t.Errorf("wrong probes sent: %v", out.SentString())
+	}
+}
+
+func TestMeasureLatencyLossAndReordering(t *testing.T) {
+	// message 3 is lost, message 5 is overtaken by message 6 (it arrives 10ms after message 6 and 10ms before message 7)
+	p := &delayPort{delay: func(n int) time.Duration {
+		switch n {
+		case 3:
+			return -1
+		case 5:
+			return 35 * time.Millisecond
+		}
+		return 5 * time.Millisecond
+	}}
+
+	r, err := MeasureLatencyWith(p, p, LatencyConfig{N: 10, Interval: 20 * time.Millisecond, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("MeasureLatencyWith returned error: %v", err)
+	}
+
+	if r.Sent != 10 || r.Lost != 1 || r.Reordered != 1 || len(r.RoundTrips) != 9 {
+		t.Errorf("unexpected report\n%v", r)
+	}
+	if r.Min() < 5*time.Millisecond || r.Percentile(50) > 20*time.Millisecond || r.Max() < 35*time.Millisecond {
+		t.Errorf("unexpected round trips %v", r.RoundTrips)
+	}
+	if r.Jitter() == 0 {
+		t.Errorf("the delayed probe must cause jitter")
+	}
+
+	start, counts := r.Histogram(10 * time.Millisecond)
+	var total int
+	for _, c := range counts {
+		total += c
+	}
+	if start != 0 || total != 9 {
+		t.Errorf("wrong histogram %v %v", start, counts)
+	}
+
+	if s := r.String(); !strings.Contains(s, "1 lost (10.0%)") || !strings.Contains(s, "1 reordered") {
+		t.Errorf("wrong summary:\n%s", s)
+	}
+}
+
+func TestMeasureLatencyInvalid(t *testing.T) {
+	p := &delayPort{delay: func(int) time.Duration { return 0 }}
+
+	configs := []LatencyConfig{
+		{N: 0},
+		{N: 1, Channel: 16},
+		{N: maxNoteProbes + 1, Probe: NoteProbe},
+		{N: maxSysExProbes + 1, Probe: SysExProbe},
+	}
+	for _, cfg := range configs {
+		if _, err := MeasureLatencyWith(p, p, cfg); err == nil {
+			t.Errorf("MeasureLatencyWith(%+v) must return an error", cfg)
+		}
+	}
+}
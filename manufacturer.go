@@ -0,0 +1,84 @@
+package mid
+
+import (
+	"fmt"
+)
+
+// ManufacturerID is the ID of a manufacturer as used inside system exclusive messages.
+// One byte IDs are stored as {id, 0, 0}, three byte IDs (beginning with 0x00) are stored as they are.
+type ManufacturerID [3]byte
+
+// ParseManufacturerID reads the manufacturer ID at the start of b and returns it together
+// with the number of bytes it occupies (1 or 3). If b is too short, n is 0.
+func ParseManufacturerID(b []byte) (id ManufacturerID, n int) {
+	if len(b) == 0 {
+		return
+	}
+	if b[0] != 0 {
+		return ManufacturerID{b[0], 0, 0}, 1
+	}
+	if len(b) < 3 {
+		return
+	}
+	return ManufacturerID{0, b[1], b[2]}, 3
+}
+
+// IsExtended returns true for three byte manufacturer IDs
+func (m ManufacturerID) IsExtended() bool {
+	return m[0] == 0
+}
+
+// Bytes returns the bytes of the ID as they appear in a system exclusive message
+func (m ManufacturerID) Bytes() []byte {
+	if m.IsExtended() {
+		return []byte{0, m[1], m[2]}
+	}
+	return []byte{m[0]}
+}
+
+// Name returns the name of the manufacturer or an empty string, if it is unknown
+func (m ManufacturerID) Name() string {
+	return Manufacturers[m]
+}
+
+// String returns the name of the manufacturer, if it is known, or the bytes of the ID otherwise
+func (m ManufacturerID) String() string {
+	if name := m.Name(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("% X", m.Bytes())
+}
+
+// Manufacturers maps manufacturer IDs to the names of the manufacturers.
+// It only contains a selection of common manufacturers and may be extended by the user.
+var Manufacturers = map[ManufacturerID]string{
+	{0x01, 0, 0}: "Sequential Circuits",
+	{0x04, 0, 0}: "Moog",
+	{0x06, 0, 0}: "Lexicon",
+	{0x07, 0, 0}: "Kurzweil",
+	{0x0F, 0, 0}: "Ensoniq",
+	{0x10, 0, 0}: "Oberheim",
+	{0x11, 0, 0}: "Apple",
+	{0x18, 0, 0}: "E-mu",
+	{0x1C, 0, 0}: "Eventide",
+	{0x40, 0, 0}: "Kawai",
+	{0x41, 0, 0}: "Roland",
+	{0x42, 0, 0}: "Korg",
+	{0x43, 0, 0}: "Yamaha",
+	{0x44, 0, 0}: "Casio",
+	{0x47, 0, 0}: "Akai",
+	{0x4C, 0, 0}: "Sony",
+	{0x52, 0, 0}: "Zoom",
+	{0x7D, 0, 0}: "Non-Commercial",
+
+	{0, 0x00, 0x0E}: "Alesis",
+	{0, 0x00, 0x3B}: "Mark of the Unicorn",
+	{0, 0x00, 0x66}: "Mackie",
+	{0, 0x01, 0x05}: "M-Audio",
+	{0, 0x20, 0x29}: "Novation",
+	{0, 0x20, 0x32}: "Behringer",
+	{0, 0x20, 0x33}: "Access Music",
+	{0, 0x20, 0x3C}: "Elektron",
+	{0, 0x20, 0x6B}: "Arturia",
+	{0, 0x21, 0x09}: "Native Instruments",
+}
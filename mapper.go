@@ -0,0 +1,426 @@
+package mid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Control identifies a physical control of a MIDI controller
+type Control struct {
+	// Type is cc, rpn, nrpn, note or pitchbend
+	Type string `json:"type"`
+
+	// Channel is the MIDI channel (0-15)
+	Channel uint8 `json:"channel"`
+
+	// Number is the controller number (cc), the key (note) or the parameter number (rpn and nrpn: MSB*128 + LSB).
+	// It is not used for pitchbend.
+	Number uint16 `json:"number,omitempty"`
+}
+
+func (c Control) validate() error {
+	if c.Channel > 15 {
+		return fmt.Errorf("invalid channel %v", c.Channel)
+	}
+	switch c.Type {
+	case "cc", "note":
+		if c.Number > 127 {
+			return fmt.Errorf("invalid %s number %v", c.Type, c.Number)
+		}
+	case "rpn", "nrpn":
+		if c.Number > 16383 {
+			return fmt.Errorf("invalid %s number %v", c.Type, c.Number)
+		}
+	case "pitchbend":
+		if c.Number != 0 {
+			return fmt.Errorf("pitchbend has no number")
+		}
+	default:
+		return fmt.Errorf("unknown control type %q", c.Type)
+	}
+	return nil
+}
+
+// String returns a readable description of the control
+func (c Control) String() string {
+	if c.Type == "pitchbend" {
+		return fmt.Sprintf("pitchbend channel %v", c.Channel)
+	}
+	return fmt.Sprintf("%s %v channel %v", c.Type, c.Number, c.Channel)
+}
+
+// Mapping binds a control to a named parameter
+type Mapping struct {
+	// Parameter is the name of the parameter
+	Parameter string `json:"parameter"`
+
+	// Control is the bound control
+	Control Control `json:"control"`
+
+	// Min and Max are the range of the parameter values. If both are 0, the range is 0-1.
+	// Max may be less than Min to invert the control.
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+
+	// Curve is the response curve that is applied to the normalized control value
+	Curve CurveSpec `json:"curve,omitempty"`
+
+	// SoftTakeover ignores the control after the parameter has been changed by SetValue,
+	// until the control reaches the value of the parameter. This avoids jumps of the parameter.
+	SoftTakeover bool `json:"soft_takeover,omitempty"`
+}
+
+// softTakeoverTolerance is the distance (relative to the parameter range) where the control picks up the parameter
+const softTakeoverTolerance = 0.02
+
+// binding is a mapping with its state
+type binding struct {
+	Mapping
+	curve Curve
+
+	value    float64 // the current value of the parameter
+	pickedUp bool    // the control has picked up the parameter (soft takeover)
+	last     float64 // the last value of the control, if not picked up
+	hasLast  bool
+}
+
+func newBinding(m Mapping) (*binding, error) {
+	if m.Parameter == "" {
+		return nil, fmt.Errorf("mapping without parameter")
+	}
+	if err := m.Control.validate(); err != nil {
+		return nil, err
+	}
+	if m.Min == 0 && m.Max == 0 {
+		m.Max = 1
+	}
+	curve, err := m.Curve.Curve()
+	if err != nil {
+		return nil, err
+	}
+	return &binding{Mapping: m, curve: curve, pickedUp: true}, nil
+}
+
+// scale returns the parameter value for the normalized control value (0-1)
+func (b *binding) scale(x float64) float64 {
+	return b.Min + b.curve(math.Max(0, math.Min(1, x)))*(b.Max-b.Min)
+}
+
+// takeover returns true, if the control value v is passed to the parameter
+func (b *binding) takeover(v float64) bool {
+	if !b.SoftTakeover || b.pickedUp {
+		return true
+	}
+	tolerance := softTakeoverTolerance * math.Abs(b.Max-b.Min)
+	crossed := b.hasLast && (b.last-b.value)*(v-b.value) <= 0
+	if crossed || math.Abs(v-b.value) <= tolerance {
+		b.pickedUp = true
+		return true
+	}
+	b.last, b.hasLast = v, true
+	return false
+}
+
+// Mapper is a layer over a Reader that maps controls to named parameters ("MIDI learn").
+// Mappings can be made in code with Bind, be learned from the next moved control with Learn and be saved and
+// loaded as JSON.
+//
+// The normalized control values (0-1) are shaped by the curve of the mapping, scaled to the range of the mapping
+// and passed to the Callback. The normalized value of a cc is value/127, of a note velocity/127 (0 for the note off),
+// of pitch bend (value+8192)/16383 and of a rpn or nrpn the 14-bit value/16383 (the MSB resets the LSB).
+//
+// Messages of unmapped controls are passed to the callbacks that had been attached to the Reader before NewMapper
+// was called. Since the Mapper handles RPN and NRPN messages, their control changes are no longer passed to
+// Msg.Channel.ControlChange.Each.
+type Mapper struct {
+	mx       sync.Mutex
+	bindings map[Control]*binding
+	learn    *Mapping
+	rpn      map[Control]uint16 // the last 14-bit values of rpn and nrpn
+
+	// the callbacks of the Reader before the Mapper took over
+	prev struct {
+		noteOn    func(p *Position, channel, key, velocity uint8)
+		noteOff   func(p *Position, channel, key, velocity uint8)
+		pitchbend func(p *Position, channel uint8, value int16)
+		cc        func(p *Position, channel, controller, value uint8)
+		rpnMSB    func(p *Position, channel, typ1, typ2, msbVal uint8)
+		rpnLSB    func(p *Position, channel, typ1, typ2, lsbVal uint8)
+		nrpnMSB   func(p *Position, channel, typ1, typ2, msbVal uint8)
+		nrpnLSB   func(p *Position, channel, typ1, typ2, lsbVal uint8)
+	}
+
+	// Callback is called with the name and the new value of a parameter, when a mapped control is moved
+	Callback func(parameter string, value float64)
+
+	// Learned is called, when a mapping has been learned, if it is not nil
+	Learned func(m Mapping)
+}
+
+// NewMapper attaches a Mapper to rd.
+func NewMapper(rd *Reader) *Mapper {
+	m := &Mapper{
+		bindings: map[Control]*binding{},
+		rpn:      map[Control]uint16{},
+	}
+
+	m.prev.noteOn = rd.Msg.Channel.NoteOn
+	m.prev.noteOff = rd.Msg.Channel.NoteOff
+	m.prev.pitchbend = rd.Msg.Channel.Pitchbend
+	m.prev.cc = rd.Msg.Channel.ControlChange.Each
+	m.prev.rpnMSB = rd.Msg.Channel.ControlChange.RPN.MSB
+	m.prev.rpnLSB = rd.Msg.Channel.ControlChange.RPN.LSB
+	m.prev.nrpnMSB = rd.Msg.Channel.ControlChange.NRPN.MSB
+	m.prev.nrpnLSB = rd.Msg.Channel.ControlChange.NRPN.LSB
+
+	rd.Msg.Channel.NoteOn = m.noteOn
+	rd.Msg.Channel.NoteOff = m.noteOff
+	rd.Msg.Channel.Pitchbend = m.pitchbend
+	rd.Msg.Channel.ControlChange.Each = m.controlChange
+	rd.Msg.Channel.ControlChange.RPN.MSB = m.rpnMSB
+	rd.Msg.Channel.ControlChange.RPN.LSB = m.rpnLSB
+	rd.Msg.Channel.ControlChange.NRPN.MSB = m.nrpnMSB
+	rd.Msg.Channel.ControlChange.NRPN.LSB = m.nrpnLSB
+
+	return m
+}
+
+// Bind adds a mapping. An existing mapping of the same parameter or the same control is replaced.
+func (m *Mapper) Bind(mapping Mapping) error {
+	b, err := newBinding(mapping)
+	if err != nil {
+		return err
+	}
+	m.mx.Lock()
+	m.bind(b)
+	m.mx.Unlock()
+	return nil
+}
+
+// bind adds the binding. m.mx must be locked.
+func (m *Mapper) bind(b *binding) {
+	addBinding(m.bindings, b)
+}
+
+// unbind removes the binding of the parameter. m.mx must be locked.
+func (m *Mapper) unbind(parameter string) {
+	removeBinding(m.bindings, parameter)
+}
+
+// addBinding adds the binding to bindings, replacing a previous binding of the parameter
+func addBinding(bindings map[Control]*binding, b *binding) {
+	removeBinding(bindings, b.Parameter)
+	bindings[b.Control] = b
+}
+
+// removeBinding removes the binding of the parameter from bindings
+func removeBinding(bindings map[Control]*binding, parameter string) {
+	for c, b := range bindings {
+		if b.Parameter == parameter {
+			delete(bindings, c)
+		}
+	}
+}
+
+// Unbind removes the mapping of the parameter
+func (m *Mapper) Unbind(parameter string) {
+	m.mx.Lock()
+	m.unbind(parameter)
+	m.mx.Unlock()
+}
+
+// Mappings returns the mappings, sorted by parameter
+func (m *Mapper) Mappings() []Mapping {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	res := make([]Mapping, 0, len(m.bindings))
+	for _, b := range m.bindings {
+		res = append(res, b.Mapping)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Parameter < res[b].Parameter })
+	return res
+}
+
+// Learn enters the learn mode: the next moved control is bound to the parameter of the mapping
+// (its Control is ignored). The message of the learned control is not passed to the Callback.
+func (m *Mapper) Learn(mapping Mapping) error {
+	mapping.Control = Control{Type: "cc"}
+	if _, err := newBinding(mapping); err != nil {
+		return err
+	}
+	m.mx.Lock()
+	m.learn = &mapping
+	m.mx.Unlock()
+	return nil
+}
+
+// CancelLearn leaves the learn mode
+func (m *Mapper) CancelLearn() {
+	m.mx.Lock()
+	m.learn = nil
+	m.mx.Unlock()
+}
+
+// Learning returns true, if the Mapper is in learn mode
+func (m *Mapper) Learning() bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.learn != nil
+}
+
+// SetValue informs the Mapper about a change of the parameter value that did not come from the control
+// (e.g. by the user interface or by loading a preset). Controls with soft takeover are ignored until they
+// reach the new value.
+func (m *Mapper) SetValue(parameter string, value float64) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for _, b := range m.bindings {
+		if b.Parameter == parameter {
+			b.value = value
+			b.pickedUp = false
+			b.hasLast = false
+		}
+	}
+}
+
+// SaveMappings writes the mappings as JSON to w
+func (m *Mapper) SaveMappings(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m.Mappings())
+}
+
+// LoadMappings replaces the mappings by the ones read as JSON from r.
+// Like with Bind, a later mapping of the same parameter replaces an earlier one.
+func (m *Mapper) LoadMappings(r io.Reader) error {
+	var mappings []Mapping
+	if err := json.NewDecoder(r).Decode(&mappings); err != nil {
+		return fmt.Errorf("can't read mappings: %v", err)
+	}
+
+	bindings := map[Control]*binding{}
+	for _, mapping := range mappings {
+		b, err := newBinding(mapping)
+		if err != nil {
+			return fmt.Errorf("invalid mapping of %q: %v", mapping.Parameter, err)
+		}
+		addBinding(bindings, b)
+	}
+
+	m.mx.Lock()
+	m.bindings = bindings
+	m.mx.Unlock()
+	return nil
+}
+
+// handle handles the normalized value x of the control and returns false, if the control is not mapped
+func (m *Mapper) handle(c Control, x float64) bool {
+	m.mx.Lock()
+
+	if m.learn != nil {
+		mapping := *m.learn
+		mapping.Control = c
+		m.learn = nil
+		b, _ := newBinding(mapping)
+		m.bind(b)
+		m.mx.Unlock()
+
+		if m.Learned != nil {
+			m.Learned(b.Mapping)
+		}
+		return true
+	}
+
+	b, ok := m.bindings[c]
+	if !ok {
+		m.mx.Unlock()
+		return false
+	}
+
+	v := b.scale(x)
+	pass := b.takeover(v)
+	if pass {
+		b.value = v
+	}
+	param := b.Parameter
+	m.mx.Unlock()
+
+	if pass && m.Callback != nil {
+		m.Callback(param, v)
+	}
+	return true
+}
+
+func (m *Mapper) noteOn(p *Position, ch, key, vel uint8) {
+	if !m.handle(Control{"note", ch, uint16(key)}, float64(vel)/127) && m.prev.noteOn != nil {
+		m.prev.noteOn(p, ch, key, vel)
+	}
+}
+
+func (m *Mapper) noteOff(p *Position, ch, key, vel uint8) {
+	// a note off must not be learned
+	if m.Learning() || !m.handle(Control{"note", ch, uint16(key)}, 0) {
+		if m.prev.noteOff != nil {
+			m.prev.noteOff(p, ch, key, vel)
+		}
+	}
+}
+
+func (m *Mapper) pitchbend(p *Position, ch uint8, value int16) {
+	if !m.handle(Control{"pitchbend", ch, 0}, float64(int(value)+8192)/16383) && m.prev.pitchbend != nil {
+		m.prev.pitchbend(p, ch, value)
+	}
+}
+
+func (m *Mapper) controlChange(p *Position, ch, cc, val uint8) {
+	if !m.handle(Control{"cc", ch, uint16(cc)}, float64(val)/127) && m.prev.cc != nil {
+		m.prev.cc(p, ch, cc, val)
+	}
+}
+
+// parameterValue returns the normalized 14-bit value after a data entry of a rpn or nrpn
+func (m *Mapper) parameterValue(c Control, val uint8, msb bool) float64 {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	v := m.rpn[c]
+	if msb {
+		v = uint16(val) << 7
+	} else {
+		v = v&^0x7F | uint16(val)
+	}
+	m.rpn[c] = v
+	return float64(v) / 16383
+}
+
+func (m *Mapper) rpnMSB(p *Position, ch, typ1, typ2, val uint8) {
+	c := Control{"rpn", ch, uint16(typ1)<<7 | uint16(typ2)}
+	if !m.handle(c, m.parameterValue(c, val, true)) && m.prev.rpnMSB != nil {
+		m.prev.rpnMSB(p, ch, typ1, typ2, val)
+	}
+}
+
+func (m *Mapper) rpnLSB(p *Position, ch, typ1, typ2, val uint8) {
+	c := Control{"rpn", ch, uint16(typ1)<<7 | uint16(typ2)}
+	if !m.handle(c, m.parameterValue(c, val, false)) && m.prev.rpnLSB != nil {
+		m.prev.rpnLSB(p, ch, typ1, typ2, val)
+	}
+}
+
+func (m *Mapper) nrpnMSB(p *Position, ch, typ1, typ2, val uint8) {
+	c := Control{"nrpn", ch, uint16(typ1)<<7 | uint16(typ2)}
+	if !m.handle(c, m.parameterValue(c, val, true)) && m.prev.nrpnMSB != nil {
+		m.prev.nrpnMSB(p, ch, typ1, typ2, val)
+	}
+}
+
+func (m *Mapper) nrpnLSB(p *Position, ch, typ1, typ2, val uint8) {
+	c := Control{"nrpn", ch, uint16(typ1)<<7 | uint16(typ2)}
+	if !m.handle(c, m.parameterValue(c, val, false)) && m.prev.nrpnLSB != nil {
+		m.prev.nrpnLSB(p, ch, typ1, typ2, val)
+	}
+}
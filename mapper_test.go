@@ -0,0 +1,202 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type mapperRecorder []string
+
+func (r *mapperRecorder) callback(parameter string, value float64) {
+	*r = append(*r, fmt.Sprintf("%s=%.3f", parameter, value))
+}
+
+func TestMapperBind(t *testing.T) {
+	rd := NewReader(NoLogger())
+
+	var passed []string
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) {
+		passed = append(passed, fmt.Sprintf("cc %v %v", cc, val))
+	}
+
+	var got mapperRecorder
+	m := NewMapper(rd)
+	m.Callback = got.callback
+
+	mappings := []Mapping{
+		{Parameter: "volume", Control: Control{Type: "cc", Channel: 0, Number: 7}},
+		{Parameter: "cutoff", Control: Control{Type: "cc", Channel: 1, Number: 74}, Min: 20, Max: 20000, Curve: CurveSpec{Type: "exponential", Param: 2}},
+		{Parameter: "pan", Control: Control{Type: "pitchbend", Channel: 0}, Min: -1, Max: 1},
+		{Parameter: "mute", Control: Control{Type: "note", Channel: 9, Number: 36}},
+		{Parameter: "tune", Control: Control{Type: "rpn", Channel: 0, Number: 1}},
+		{Parameter: "attack", Control: Control{Type: "nrpn", Channel: 2, Number: 3<<7 | 5}, Max: 100},
+	}
+	for _, mapping := range mappings {
+		if err := m.Bind(mapping); err != nil {
+			t.Fatalf("Bind(%v) returned error: %v", mapping.Parameter, err)
+		}
+	}
+
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		{[]byte{0xB0, 7, 127}, "[volume=1.000]"},
+		{[]byte{0xB0, 7, 0}, "[volume=0.000]"},
+		{[]byte{0xB1, 74, 127}, "[cutoff=20000.000]"},
+		{[]byte{0xE0, 0, 0x40}, "[pan=0.000]"},
+		{[]byte{0xE0, 0, 0}, "[pan=-1.000]"},
+		{[]byte{0x99, 36, 127, 0x89, 36, 0}, "[mute=1.000 mute=0.000]"},
+		// RPN 0/1 (fine tuning): the MSB resets the LSB
+		{[]byte{0xB0, 101, 0, 0xB0, 100, 1, 0xB0, 6, 0x40, 0xB0, 38, 0x7F, 0xB0, 6, 0x7F}, "[tune=0.500 tune=0.508 tune=0.992]"},
+		{[]byte{0xB2, 99, 3, 0xB2, 98, 5, 0xB2, 6, 0x7F, 0xB2, 38, 0x7F}, "[attack=99.225 attack=100.000]"},
+		// unmapped
+		{[]byte{0xB0, 8, 1, 0xB1, 7, 1}, "[]"},
+	}
+
+	for _, test := range tests {
+		got = nil
+		rd.Read(bytes.NewReader(test.in))
+		if fmt.Sprint(got) != test.expected {
+			t.Errorf("% X: got %v; want %v", test.in, got, test.expected)
+		}
+	}
+
+	if fmt.Sprint(passed) != "[cc 8 1 cc 7 1]" {
+		t.Errorf("unmapped controls must be passed, got %v", passed)
+	}
+
+	m.Unbind("volume")
+	got = nil
+	rd.Read(bytes.NewReader([]byte{0xB0, 7, 127}))
+	if len(got) != 0 {
+		t.Errorf("unbound control must not call back, got %v", got)
+	}
+}
+
+func TestMapperLearn(t *testing.T) {
+	rd := NewReader(NoLogger())
+	var got mapperRecorder
+	m := NewMapper(rd)
+	m.Callback = got.callback
+
+	var learned []Mapping
+	m.Learned = func(mapping Mapping) { learned = append(learned, mapping) }
+
+	if err := m.Learn(Mapping{Parameter: "reverb", Max: 10}); err != nil {
+		t.Fatalf("Learn returned error: %v", err)
+	}
+	if !m.Learning() {
+		t.Fatalf("must be learning")
+	}
+
+	// the note off of a previously pressed key must not be learned
+	rd.Read(bytes.NewReader([]byte{0x80, 60, 0, 0xB3, 91, 64, 0xB3, 91, 127}))
+
+	if m.Learning() {
+		t.Errorf("must have left the learn mode")
+	}
+	if len(learned) != 1 || learned[0].Control != (Control{Type: "cc", Channel: 3, Number: 91}) || learned[0].Max != 10 {
+		t.Errorf("wrong learned mapping: %+v", learned)
+	}
+	if fmt.Sprint(got) != "[reverb=10.000]" {
+		t.Errorf("got %v; want [reverb=10.000]", got)
+	}
+
+	// learning another control for the parameter replaces the mapping
+	m.Learn(Mapping{Parameter: "reverb"})
+	rd.Read(bytes.NewReader([]byte{0xE5, 0, 0x40}))
+	if mappings := m.Mappings(); len(mappings) != 1 || mappings[0].Control.String() != "pitchbend channel 5" {
+		t.Errorf("wrong mappings after relearning: %+v", mappings)
+	}
+
+	m.Learn(Mapping{Parameter: "other"})
+	m.CancelLearn()
+	if m.Learning() {
+		t.Errorf("CancelLearn must leave the learn mode")
+	}
+
+	if err := m.Learn(Mapping{}); err == nil {
+		t.Errorf("Learn must reject a mapping without parameter")
+	}
+}
+
+func TestMapperSoftTakeover(t *testing.T) {
+	rd := NewReader(NoLogger())
+	var got mapperRecorder
+	m := NewMapper(rd)
+	m.Callback = got.callback
+
+	m.Bind(Mapping{Parameter: "gain", Control: Control{Type: "cc", Number: 1}, Max: 127, SoftTakeover: true})
+
+	rd.Read(bytes.NewReader([]byte{0xB0, 1, 10}))
+	m.SetValue("gain", 100)
+
+	// the control is below the value: ignored until it crosses it
+	rd.Read(bytes.NewReader([]byte{0xB0, 1, 11, 0xB0, 1, 50, 0xB0, 1, 95, 0xB0, 1, 102, 0xB0, 1, 90}))
+
+	if fmt.Sprint(got) != "[gain=10.000 gain=102.000 gain=90.000]" {
+		t.Errorf("got %v", got)
+	}
+
+	// a control near the value picks it up at once
+	got = nil
+	m.SetValue("gain", 50)
+	rd.Read(bytes.NewReader([]byte{0xB0, 1, 120, 0xB0, 1, 51}))
+	if fmt.Sprint(got) != "[gain=51.000]" {
+		t.Errorf("got %v; want [gain=51.000]", got)
+	}
+}
+
+func TestMapperSaveLoad(t *testing.T) {
+	m := NewMapper(NewReader(NoLogger()))
+	m.Bind(Mapping{Parameter: "b", Control: Control{Type: "nrpn", Channel: 1, Number: 300}, Min: 1, Max: 2, SoftTakeover: true})
+	m.Bind(Mapping{Parameter: "a", Control: Control{Type: "cc", Number: 7}, Curve: CurveSpec{Type: "piecewise", Points: []CurvePoint{{0, 0}, {1, 0.5}}}})
+
+	var bf bytes.Buffer
+	if err := m.SaveMappings(&bf); err != nil {
+		t.Fatalf("SaveMappings returned error: %v", err)
+	}
+
+	rd := NewReader(NoLogger())
+	var got mapperRecorder
+	m2 := NewMapper(rd)
+	m2.Callback = got.callback
+	if err := m2.LoadMappings(strings.NewReader(bf.String())); err != nil {
+		t.Fatalf("LoadMappings returned error: %v", err)
+	}
+
+	if fmt.Sprintf("%+v", m2.Mappings()) != fmt.Sprintf("%+v", m.Mappings()) {
+		t.Errorf("got\n%+v\nwant\n%+v", m2.Mappings(), m.Mappings())
+	}
+
+	rd.Read(bytes.NewReader([]byte{0xB0, 7, 127}))
+	if fmt.Sprint(got) != "[a=0.500]" {
+		t.Errorf("got %v; want [a=0.500]", got)
+	}
+
+	// the later mapping of a parameter wins
+	dup := `[{"parameter": "a", "control": {"type": "cc", "number": 7}}, {"parameter": "a", "control": {"type": "cc", "number": 8}}]`
+	if err := m2.LoadMappings(strings.NewReader(dup)); err != nil {
+		t.Fatalf("LoadMappings returned error: %v", err)
+	}
+	if mappings := m2.Mappings(); len(mappings) != 1 || mappings[0].Control.Number != 8 {
+		t.Errorf("got %+v; want a single mapping of cc 8", mappings)
+	}
+
+	invalid := []string{
+		`[{"parameter": "x", "control": {"type": "knob"}}]`,
+		`[{"parameter": "x", "control": {"type": "cc", "number": 128}}]`,
+		`[{"parameter": "x", "control": {"type": "cc", "channel": 16}}]`,
+		`[{"parameter": "x", "control": {"type": "cc"}, "curve": {"type": "exponential"}}]`,
+		`[{"control": {"type": "cc"}}]`,
+		`{`,
+	}
+	for _, in := range invalid {
+		if err := m2.LoadMappings(strings.NewReader(in)); err == nil {
+			t.Errorf("LoadMappings(%s) must return an error", in)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package mid
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrNoFreeChannel is returned, if a note can't be played, because every channel is in use
+var ErrNoFreeChannel = fmt.Errorf("no free channel")
+
+// MicrotonalWriter plays microtonal notes on synths without MTS support by spreading the notes
+// across a pool of channels and sending a pitch bend before each note on.
+// A channel is only retuned, if no note is sounding on it. Notes with the same pitch bend share a channel.
+// The MicrotonalWriter changes the channel of the underlying writer. Its methods must not be called concurrently.
+type MicrotonalWriter struct {
+	wr        ChannelWriter
+	channels  []uint8
+	bendRange uint8
+
+	sounding [16]int             // number of sounding notes per channel
+	keys     [16][128]bool       // sounding keys per channel
+	bend     [16]int16           // the current pitch bend per channel
+	tuned    [16]bool            // pitch bend has been written to the channel
+	lastUsed [16]uint64          // for the least recently used allocation
+	counter  uint64              // counts the allocations
+	notes    map[microNote]uint8 // sounding notes and their channels
+}
+
+// microNote is a microtonal pitch split into a key and a pitch bend
+type microNote struct {
+	key  uint8
+	bend int16
+}
+
+// NewMicrotonalWriter returns a MicrotonalWriter that writes to wr and uses the given channels.
+// bendRange is the pitch bend range in semitones. It is set up on every channel via PitchBendSensitivityRPN.
+func NewMicrotonalWriter(wr ChannelWriter, bendRange uint8, channels ...uint8) (*MicrotonalWriter, error) {
+	if bendRange == 0 {
+		return nil, fmt.Errorf("bendRange must be > 0")
+	}
+
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels given")
+	}
+
+	m := &MicrotonalWriter{
+		wr:        wr,
+		channels:  channels,
+		bendRange: bendRange,
+		notes:     map[microNote]uint8{},
+	}
+
+	for _, ch := range channels {
+		if ch > 15 {
+			return nil, fmt.Errorf("invalid channel %v", ch)
+		}
+		wr.SetChannel(ch)
+		err := wr.PitchBendSensitivityRPN(bendRange, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// split splits the pitch into the nearest key and the pitch bend for the deviation
+func (m *MicrotonalWriter) split(pitch float64) (n microNote, err error) {
+	key := math.Round(pitch)
+	if key < 0 || key > 127 {
+		return n, fmt.Errorf("pitch %v out of range", pitch)
+	}
+
+	bend := math.Round((pitch - key) / float64(m.bendRange) * 8192)
+	n.key = uint8(key)
+	n.bend = int16(clamp(bend, -8192, 8191))
+	return
+}
+
+// allocate finds a channel for the given note. Channels that are already tuned to the pitch bend
+// are preferred, otherwise the least recently used channel without sounding notes is taken.
+func (m *MicrotonalWriter) allocate(n microNote) (ch uint8, err error) {
+	var free = -1
+	for _, c := range m.channels {
+		if m.tuned[c] && m.bend[c] == n.bend && !m.keys[c][n.key] {
+			return c, nil
+		}
+		if m.sounding[c] == 0 && (free < 0 || m.lastUsed[c] < m.lastUsed[free]) {
+			free = int(c)
+		}
+	}
+
+	if free < 0 {
+		return 0, ErrNoFreeChannel
+	}
+	return uint8(free), nil
+}
+
+// NoteOn plays a note with the given pitch in fractional semitones (MIDI key + cents/100)
+func (m *MicrotonalWriter) NoteOn(pitch float64, velocity uint8) error {
+	n, err := m.split(pitch)
+	if err != nil {
+		return err
+	}
+
+	if _, has := m.notes[n]; has {
+		return fmt.Errorf("can't play pitch %v: note already running", pitch)
+	}
+
+	ch, err := m.allocate(n)
+	if err != nil {
+		return err
+	}
+
+	m.wr.SetChannel(ch)
+
+	if !m.tuned[ch] || m.bend[ch] != n.bend {
+		err = m.wr.Pitchbend(n.bend)
+		if err != nil {
+			return err
+		}
+		m.bend[ch] = n.bend
+		m.tuned[ch] = true
+	}
+
+	err = m.wr.NoteOn(n.key, velocity)
+	if err != nil {
+		return err
+	}
+
+	m.counter++
+	m.lastUsed[ch] = m.counter
+	m.sounding[ch]++
+	m.keys[ch][n.key] = true
+	m.notes[n] = ch
+	return nil
+}
+
+// NoteOff stops the note with the given pitch
+func (m *MicrotonalWriter) NoteOff(pitch float64) error {
+	n, err := m.split(pitch)
+	if err != nil {
+		return err
+	}
+
+	ch, has := m.notes[n]
+	if !has {
+		return fmt.Errorf("can't stop pitch %v: note is not running", pitch)
+	}
+
+	delete(m.notes, n)
+	m.sounding[ch]--
+	m.keys[ch][n.key] = false
+
+	m.wr.SetChannel(ch)
+	return m.wr.NoteOff(n.key)
+}
+
+// AllNotesOff stops all sounding notes, ordered by channel and key
+func (m *MicrotonalWriter) AllNotesOff() error {
+	sounding := make([]microNote, 0, len(m.notes))
+	for n := range m.notes {
+		sounding = append(sounding, n)
+	}
+	sort.Slice(sounding, func(a, b int) bool {
+		chA, chB := m.notes[sounding[a]], m.notes[sounding[b]]
+		if chA != chB {
+			return chA < chB
+		}
+		return sounding[a].key < sounding[b].key
+	})
+
+	for _, n := range sounding {
+		ch := m.notes[n]
+		delete(m.notes, n)
+		m.sounding[ch]--
+		m.keys[ch][n.key] = false
+		m.wr.SetChannel(ch)
+		err := m.wr.NoteOff(n.key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
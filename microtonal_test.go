@@ -0,0 +1,71 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMicrotonalWriter(t *testing.T) {
+	var bf bytes.Buffer
+	wr := NewWriter(&bf)
+
+	m, err := NewMicrotonalWriter(wr, 2, 1, 2)
+	if err != nil {
+		t.Fatalf("NewMicrotonalWriter returned error: %v", err)
+	}
+
+	// setup of the pitch bend range is not of interest here
+	bf.Reset()
+
+	steps := []struct {
+		on    bool
+		pitch float64
+		err   error
+	}{
+		{true, 60, nil},
+		{true, 60.5, nil},
+		{true, 64, nil},                 // shares channel 1 with 60
+		{true, 62.25, ErrNoFreeChannel}, // both channels have sounding notes
+		{false, 60.5, nil},
+		{true, 62.25, nil}, // retunes channel 2
+		{true, 63.25, nil}, // has the same pitch bend
+	}
+
+	for _, s := range steps {
+		if s.on {
+			err = m.NoteOn(s.pitch, 100)
+		} else {
+			err = m.NoteOff(s.pitch)
+		}
+		if err != s.err {
+			t.Errorf("on: %v pitch: %v returned %v; want %v", s.on, s.pitch, err, s.err)
+		}
+	}
+
+	m.AllNotesOff()
+
+	var res bytes.Buffer
+	rd := NewReader(NoLogger())
+	rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, val int16) {
+		fmt.Fprintf(&res, "PB%v:%v ", ch, val)
+	}
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		fmt.Fprintf(&res, "ON%v:%v ", ch, key)
+	}
+	rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) {
+		fmt.Fprintf(&res, "OFF%v:%v ", ch, key)
+	}
+	rd.Read(&bf)
+
+	expected := "PB1:0 ON1:60 PB2:-2048 ON2:61 ON1:64 OFF2:61 PB2:1024 ON2:62 ON2:63 " +
+		"OFF1:60 OFF1:64 OFF2:62 OFF2:63 "
+
+	if got := res.String(); got != expected {
+		t.Errorf("got %q; want %q", got, expected)
+	}
+
+	if m.NoteOn(62.25, 100) != nil || m.NoteOn(61, 100) != nil {
+		t.Errorf("after AllNotesOff all channels must be free")
+	}
+}
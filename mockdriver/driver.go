@@ -0,0 +1,153 @@
+// Package mockdriver is an in-process connect.Driver for testing code that uses connect.In and connect.Out,
+// e.g. mid.Reader.ReadFrom and mid.WriteTo.
+//
+// The driver has virtual in and out ports. Out ports record everything that is sent and can be wired to
+// in ports (loopback). In ports deliver messages synchronously to their listener, so tests are deterministic.
+// The delta timestamps that are passed to the listeners come from a virtual clock that only moves on
+// when Advance is called, or are passed explicitly with In.Receive.
+//
+//	drv := mockdriver.New("mock")
+//	out, in := drv.NewOut("out"), drv.NewIn("in")
+//	drv.Connect(out, in)
+//	rd.ReadFrom(in)
+//	wr := mid.WriteTo(out)
+//	wr.NoteOn(60, 100)          // received with delta 0
+//	drv.Advance(time.Second)
+//	wr.NoteOff(60)              // received with delta 1000000 microseconds
+//	out.AssertSent(t, []byte{0x90, 60, 100}, []byte{0x90, 60, 0})
+package mockdriver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+)
+
+// Driver is a connect.Driver with virtual ports
+type Driver struct {
+	name string
+
+	mx    sync.Mutex
+	ins   []*In
+	outs  []*Out
+	now   time.Duration // the virtual clock
+	wires map[*Out][]*In
+}
+
+var _ connect.Driver = &Driver{}
+
+// New returns a new Driver without ports
+func New(name string) *Driver {
+	return &Driver{name: name, wires: map[*Out][]*In{}}
+}
+
+// NewIn adds an open virtual in port with the given name. The ports are numbered in the order of creation.
+func (d *Driver) NewIn(name string) *In {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	in := &In{drv: d, name: name, number: len(d.ins), open: true}
+	d.ins = append(d.ins, in)
+	return in
+}
+
+// NewOut adds an open virtual out port with the given name. The ports are numbered in the order of creation.
+func (d *Driver) NewOut(name string) *Out {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	out := &Out{drv: d, name: name, number: len(d.outs), open: true}
+	d.outs = append(d.outs, out)
+	return out
+}
+
+// Connect wires the out port to the in ports: every message sent to out is received by the ins.
+func (d *Driver) Connect(out *Out, ins ...*In) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.wires[out] = append(d.wires[out], ins...)
+}
+
+// Disconnect removes all wires from the out port
+func (d *Driver) Disconnect(out *Out) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	delete(d.wires, out)
+}
+
+// Loopback returns a new pair of connected ports with the given name
+func (d *Driver) Loopback(name string) (*Out, *In) {
+	out, in := d.NewOut(name), d.NewIn(name)
+	d.Connect(out, in)
+	return out, in
+}
+
+// Advance moves the virtual clock forward
+func (d *Driver) Advance(dur time.Duration) {
+	d.mx.Lock()
+	d.now += dur
+	d.mx.Unlock()
+}
+
+// Now returns the time of the virtual clock since the creation of the driver
+func (d *Driver) Now() time.Duration {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.now
+}
+
+// Ins returns the in ports
+func (d *Driver) Ins() ([]connect.In, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	ins := make([]connect.In, len(d.ins))
+	for i, in := range d.ins {
+		ins[i] = in
+	}
+	return ins, nil
+}
+
+// Outs returns the out ports
+func (d *Driver) Outs() ([]connect.Out, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	outs := make([]connect.Out, len(d.outs))
+	for i, out := range d.outs {
+		outs[i] = out
+	}
+	return outs, nil
+}
+
+// String returns the name of the driver
+func (d *Driver) String() string {
+	return d.name
+}
+
+// Close closes all ports
+func (d *Driver) Close() error {
+	d.mx.Lock()
+	ins, outs := d.ins, d.outs
+	d.mx.Unlock()
+
+	for _, in := range ins {
+		in.Close()
+	}
+	for _, out := range outs {
+		out.Close()
+	}
+	return nil
+}
+
+// deliver passes the message sent to out to the connected ins
+func (d *Driver) deliver(out *Out, msg []byte) error {
+	d.mx.Lock()
+	ins := d.wires[out]
+	now := d.now
+	d.mx.Unlock()
+
+	for _, in := range ins {
+		if err := in.receiveAt(msg, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package mockdriver_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/mockdriver"
+)
+
+func TestLoopback(t *testing.T) {
+	drv := mockdriver.New("mock")
+	defer drv.Close()
+
+	out, in := drv.Loopback("loop")
+
+	var got []string
+	rd := mid.NewReader(mid.NoLogger())
+	rd.Msg.Channel.NoteOn = func(p *mid.Position, channel, key, vel uint8) {
+		got = append(got, fmt.Sprintf("on %v +%v", key, p.DeltaTicks))
+	}
+	rd.Msg.Channel.NoteOff = func(p *mid.Position, channel, key, vel uint8) {
+		got = append(got, fmt.Sprintf("off %v +%v", key, p.DeltaTicks))
+	}
+
+	if err := rd.ReadFrom(in); err != nil {
+		t.Fatalf("ReadFrom returned error: %v", err)
+	}
+
+	wr := mid.WriteTo(out)
+	wr.NoteOn(60, 100)
+	drv.Advance(time.Second)
+	wr.NoteOff(60)
+	drv.Advance(250 * time.Millisecond)
+	wr.NoteOn(62, 100)
+
+	// explicit delta of 125ms
+	in.Receive([]byte{0x90, 62, 0}, 125000)
+
+	// at 120 BPM and 1920 ticks per quarter note: 1s = 3840 ticks
+	want := "[on 60 +0 off 60 +3840 on 62 +960 off 62 +480]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	out.AssertSent(t, []byte{0x90, 60, 100}, []byte{0x90, 60, 0}, []byte{0x90, 62, 100})
+	out.Reset()
+	out.AssertNothingSent(t)
+}
+
+func TestPorts(t *testing.T) {
+	drv := mockdriver.New("mock")
+	drv.NewIn("a")
+	in := drv.NewIn("b")
+	out := drv.NewOut("c")
+
+	found, err := connect.OpenIn(drv, -1, "b")
+	if err != nil || found != in || found.Number() != 1 {
+		t.Errorf("OpenIn returned %v, %v; want port b", found, err)
+	}
+
+	outs, _ := drv.Outs()
+	if len(outs) != 1 || outs[0] != out {
+		t.Errorf("Outs() = %v; want [c]", outs)
+	}
+
+	drv.Close()
+
+	if err := out.Send([]byte{0xF8}); err != connect.ErrClosed {
+		t.Errorf("Send on closed port returned %v; want connect.ErrClosed", err)
+	}
+
+	if err := in.Receive([]byte{0xF8}, 0); err != connect.ErrClosed {
+		t.Errorf("Receive on closed port returned %v; want connect.ErrClosed", err)
+	}
+
+	out.Open()
+	out.Err = fmt.Errorf("broken cable")
+	if err := out.Send([]byte{0xF8}); err != out.Err {
+		t.Errorf("Send returned %v; want %v", err, out.Err)
+	}
+	out.AssertNothingSent(t)
+}
+
+// errorRecorder is a mockdriver.TestingT that records the errors
+type errorRecorder []string
+
+func (e *errorRecorder) Helper() {}
+
+func (e *errorRecorder) Errorf(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	out := mockdriver.New("mock").NewOut("c")
+	out.Open()
+	out.Send([]byte{0x90, 60, 100})
+
+	var errs errorRecorder
+	out.AssertSent(&errs, []byte{0x90, 60, 100})
+	out.AssertSent(&errs, []byte{0x90, 61, 100})
+	out.AssertNothingSent(&errs)
+
+	if len(errs) != 2 || errs[0] != `c: sent "90 3C 64"; want "90 3D 64"` {
+		t.Errorf("wrong assertion errors %q", errs)
+	}
+}
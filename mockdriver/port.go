@@ -0,0 +1,255 @@
+package mockdriver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+)
+
+// In is a virtual in port
+type In struct {
+	drv    *Driver
+	name   string
+	number int
+
+	mx       sync.Mutex
+	open     bool
+	listener func(data []byte, deltaMicroseconds int64)
+	last     time.Duration // virtual time of the last received message
+	received bool
+}
+
+var _ connect.In = &In{}
+
+// Open opens the port
+func (i *In) Open() error {
+	i.mx.Lock()
+	i.open = true
+	i.mx.Unlock()
+	return nil
+}
+
+// Close stops listening and closes the port
+func (i *In) Close() error {
+	i.mx.Lock()
+	i.open = false
+	i.listener = nil
+	i.mx.Unlock()
+	return nil
+}
+
+// IsOpen returns wether the port is open
+func (i *In) IsOpen() bool {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	return i.open
+}
+
+// Number returns the number of the port
+func (i *In) Number() int {
+	return i.number
+}
+
+// String returns the name of the port
+func (i *In) String() string {
+	return i.name
+}
+
+// Underlying returns the Driver
+func (i *In) Underlying() interface{} {
+	return i.drv
+}
+
+// SetListener opens the port, if needed, and sets the listener
+func (i *In) SetListener(listener func(data []byte, deltaMicroseconds int64)) error {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	if i.listener != nil {
+		return fmt.Errorf("already listening")
+	}
+
+	i.open = true
+	i.listener = listener
+	return nil
+}
+
+// StopListening removes the listener
+func (i *In) StopListening() error {
+	i.mx.Lock()
+	i.listener = nil
+	i.mx.Unlock()
+	return nil
+}
+
+// IsListening returns wether a listener is set
+func (i *In) IsListening() bool {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	return i.listener != nil
+}
+
+// Receive passes the message with the given delta to the listener, as if it arrived on the port.
+// The virtual clock is not affected. Receive returns connect.ErrClosed, if the port is closed.
+// Messages received without listener are discarded.
+func (i *In) Receive(msg []byte, deltaMicroseconds int64) error {
+	i.mx.Lock()
+	if !i.open {
+		i.mx.Unlock()
+		return connect.ErrClosed
+	}
+	listener := i.listener
+	i.mx.Unlock()
+
+	if listener != nil {
+		listener(copyBytes(msg), deltaMicroseconds)
+	}
+	return nil
+}
+
+// receiveAt receives the message at the given virtual time. The delta is the virtual time
+// since the previous message that arrived via a wire.
+func (i *In) receiveAt(msg []byte, now time.Duration) error {
+	i.mx.Lock()
+	var delta time.Duration
+	if i.received {
+		delta = now - i.last
+	}
+	i.last, i.received = now, true
+	i.mx.Unlock()
+
+	return i.Receive(msg, delta.Nanoseconds()/1000)
+}
+
+// Out is a virtual out port that records the sent messages
+type Out struct {
+	drv    *Driver
+	name   string
+	number int
+
+	mx   sync.Mutex
+	open bool
+	sent [][]byte
+
+	// Err is returned by Send (and the message is not recorded), if it is not nil
+	Err error
+}
+
+var _ connect.Out = &Out{}
+
+// Open opens the port
+func (o *Out) Open() error {
+	o.mx.Lock()
+	o.open = true
+	o.mx.Unlock()
+	return nil
+}
+
+// Close closes the port
+func (o *Out) Close() error {
+	o.mx.Lock()
+	o.open = false
+	o.mx.Unlock()
+	return nil
+}
+
+// IsOpen returns wether the port is open
+func (o *Out) IsOpen() bool {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	return o.open
+}
+
+// Number returns the number of the port
+func (o *Out) Number() int {
+	return o.number
+}
+
+// String returns the name of the port
+func (o *Out) String() string {
+	return o.name
+}
+
+// Underlying returns the Driver
+func (o *Out) Underlying() interface{} {
+	return o.drv
+}
+
+// Send records the message and passes it to the connected in ports.
+// Like a real driver, it returns connect.ErrClosed, if the port is not open.
+func (o *Out) Send(msg []byte) error {
+	o.mx.Lock()
+	if !o.open {
+		o.mx.Unlock()
+		return connect.ErrClosed
+	}
+	if o.Err != nil {
+		o.mx.Unlock()
+		return o.Err
+	}
+	o.sent = append(o.sent, copyBytes(msg))
+	o.mx.Unlock()
+
+	return o.drv.deliver(o, msg)
+}
+
+// Sent returns the recorded messages
+func (o *Out) Sent() [][]byte {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	sent := make([][]byte, len(o.sent))
+	copy(sent, o.sent)
+	return sent
+}
+
+// SentString returns the recorded messages as hex string, separated by commas, e.g. "90 3C 64, 80 3C 00"
+func (o *Out) SentString() string {
+	return formatMessages(o.Sent())
+}
+
+// Reset clears the recorded messages
+func (o *Out) Reset() {
+	o.mx.Lock()
+	o.sent = nil
+	o.mx.Unlock()
+}
+
+// TestingT is the part of testing.TB that is used by the assertions.
+// It keeps the testing package out of programs that use the mockdriver.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertSent reports an error to t, if the recorded messages differ from want
+func (o *Out) AssertSent(t TestingT, want ...[]byte) {
+	t.Helper()
+	if got, expected := o.SentString(), formatMessages(want); got != expected {
+		t.Errorf("%s: sent %q; want %q", o.name, got, expected)
+	}
+}
+
+// AssertNothingSent reports an error to t, if any message has been recorded
+func (o *Out) AssertNothingSent(t TestingT) {
+	t.Helper()
+	if got := o.SentString(); got != "" {
+		t.Errorf("%s: sent %q; want nothing", o.name, got)
+	}
+}
+
+func formatMessages(msgs [][]byte) string {
+	s := make([]string, len(msgs))
+	for i, msg := range msgs {
+		s[i] = fmt.Sprintf("% X", msg)
+	}
+	return strings.Join(s, ", ")
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,101 @@
+package mid
+
+import (
+	"fmt"
+)
+
+// MPEZone is a zone of MIDI Polyphonic Expression (MPE).
+// The lower zone is managed via channel 0 and uses the member channels 1 upwards,
+// the upper zone is managed via channel 15 and uses the member channels 14 downwards.
+type MPEZone struct {
+
+	// Upper is true for the upper zone and false for the lower zone
+	Upper bool
+
+	// MemberChannels is the number of member channels (0-15). 0 disables the zone.
+	MemberChannels uint8
+}
+
+// MPENoteID identifies a note of a MPE zone, independent of its channel and key
+type MPENoteID uint32
+
+// MPEExpression holds the per note expression values of a MPE note
+type MPEExpression struct {
+
+	// Pitchbend is the per note pitch bend
+	Pitchbend int16
+
+	// Pressure is the per note pressure (channel pressure of the member channel)
+	Pressure uint8
+
+	// Timbre is the per note timbre (CC74 of the member channel)
+	Timbre uint8
+}
+
+// mpeTimbreCC is the controller for the third dimension of MPE
+const mpeTimbreCC = 74
+
+// mpeDefaultExpression is the expression a MPE note starts with
+var mpeDefaultExpression = MPEExpression{Pitchbend: 0, Pressure: 0, Timbre: 64}
+
+// ManagerChannel returns the manager channel of the zone (0 or 15)
+func (z MPEZone) ManagerChannel() uint8 {
+	if z.Upper {
+		return 15
+	}
+	return 0
+}
+
+// IsMember returns true, if ch is a member channel of the zone
+func (z MPEZone) IsMember(ch uint8) bool {
+	if z.MemberChannels == 0 || ch > 15 {
+		return false
+	}
+	if z.Upper {
+		return ch < 15 && ch >= 15-z.MemberChannels
+	}
+	return ch > 0 && ch <= z.MemberChannels
+}
+
+// Members returns the member channels of the zone, starting with the one next to the manager channel
+func (z MPEZone) Members() []uint8 {
+	var chs []uint8
+	for i := uint8(1); i <= z.MemberChannels && i < 16; i++ {
+		if z.Upper {
+			chs = append(chs, 15-i)
+		} else {
+			chs = append(chs, i)
+		}
+	}
+	return chs
+}
+
+// String represents the zone as a string (for debugging)
+func (z MPEZone) String() string {
+	name := "lower"
+	if z.Upper {
+		name = "upper"
+	}
+	return fmt.Sprintf("%s MPE zone with %v member channels", name, z.MemberChannels)
+}
+
+// ParseMPEConfiguration checks, if the given RPN (as passed to the RPN.MSB callback of the Reader)
+// is a MPE Configuration Message and returns the configured zone.
+func ParseMPEConfiguration(channel, typ1, typ2, msbVal uint8) (z MPEZone, ok bool) {
+	if typ1 != 0 || typ2 != 6 || (channel != 0 && channel != 15) {
+		return
+	}
+	z.Upper = channel == 15
+	z.MemberChannels = msbVal
+	if z.MemberChannels > 15 {
+		z.MemberChannels = 15
+	}
+	return z, true
+}
+
+// MPEConfigurationRPN writes the MPE Configuration Message (RPN 6) with the number of member channels.
+// The current channel must be the manager channel of the zone (0 for the lower and 15 for the upper zone).
+// A value of 0 disables the zone.
+func (w *midiWriter) MPEConfigurationRPN(memberChannels uint8) error {
+	return w.RPN(0, 6, memberChannels, 0)
+}
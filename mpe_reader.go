@@ -0,0 +1,276 @@
+package mid
+
+// MPEReader is a layer over a Reader that reassembles the per note expression of MPE zones
+// into note centric callbacks. Every note gets an ID that is passed to all callbacks concerning the note.
+//
+// Messages on member channels are consumed by the MPEReader, all other messages are passed to the
+// callbacks that had been attached to the Reader before NewMPEReader was called.
+// The callbacks of the MPEReader must be set before reading starts.
+type MPEReader struct {
+	zones        [2]MPEZone // lower and upper zone
+	nextID       MPENoteID
+	channelNotes [16][]mpeReaderNote
+	expression   [16]MPEExpression
+
+	// the callbacks of the Reader before the MPEReader took over
+	prev struct {
+		noteOn         func(p *Position, channel, key, velocity uint8)
+		noteOff        func(p *Position, channel, key, velocity uint8)
+		pitchbend      func(p *Position, channel uint8, value int16)
+		aftertouch     func(p *Position, channel, pressure uint8)
+		polyAftertouch func(p *Position, channel, key, pressure uint8)
+		cc             func(p *Position, channel, controller, value uint8)
+		rpnMSB         func(p *Position, channel, typ1, typ2, msbVal uint8)
+		rpnLSB         func(p *Position, channel, typ1, typ2, lsbVal uint8)
+		rpnIncrement   func(p *Position, channel, typ1, typ2 uint8)
+		rpnDecrement   func(p *Position, channel, typ1, typ2 uint8)
+	}
+
+	// ZoneChange is called, when a MPE Configuration Message changed a zone
+	ZoneChange func(p *Position, zone MPEZone)
+
+	// Note provides the note centric callbacks
+	Note struct {
+
+		// On is called when a note starts on a member channel. initial is the expression
+		// of the member channel at that time.
+		On func(p *Position, id MPENoteID, channel, key, velocity uint8, initial MPEExpression)
+
+		// Off is called when a note stops
+		Off func(p *Position, id MPENoteID, velocity uint8)
+
+		// Pitchbend is called for the per note pitch bend
+		Pitchbend func(p *Position, id MPENoteID, value int16)
+
+		// Pressure is called for the per note pressure (channel or polyphonic pressure of the member channel)
+		Pressure func(p *Position, id MPENoteID, pressure uint8)
+
+		// Timbre is called for the per note timbre (CC74 of the member channel)
+		Timbre func(p *Position, id MPENoteID, value uint8)
+	}
+}
+
+type mpeReaderNote struct {
+	id  MPENoteID
+	key uint8
+}
+
+// NewMPEReader attaches a MPEReader to rd. The given zones are active from the start,
+// further zones are configured by MPE Configuration Messages.
+// Since the MPEReader tracks RPNs, CC 100 and 101 and the data entry, increment and decrement controllers
+// of RPNs (CC 6, 38, 96 and 97) are not passed to ControlChange.Each anymore.
+// RPNs other than the MPE Configuration Message are passed to the RPN callbacks that were attached before.
+func NewMPEReader(rd *Reader, zones ...MPEZone) *MPEReader {
+	m := &MPEReader{}
+	for i := range m.expression {
+		m.expression[i] = mpeDefaultExpression
+	}
+
+	for _, z := range zones {
+		m.setZone(z)
+	}
+
+	m.prev.noteOn = rd.Msg.Channel.NoteOn
+	m.prev.noteOff = rd.Msg.Channel.NoteOff
+	m.prev.pitchbend = rd.Msg.Channel.Pitchbend
+	m.prev.aftertouch = rd.Msg.Channel.Aftertouch
+	m.prev.polyAftertouch = rd.Msg.Channel.PolyAftertouch
+	m.prev.cc = rd.Msg.Channel.ControlChange.Each
+	m.prev.rpnMSB = rd.Msg.Channel.ControlChange.RPN.MSB
+	m.prev.rpnLSB = rd.Msg.Channel.ControlChange.RPN.LSB
+	m.prev.rpnIncrement = rd.Msg.Channel.ControlChange.RPN.Increment
+	m.prev.rpnDecrement = rd.Msg.Channel.ControlChange.RPN.Decrement
+
+	rd.Msg.Channel.NoteOn = m.noteOn
+	rd.Msg.Channel.NoteOff = m.noteOff
+	rd.Msg.Channel.Pitchbend = m.pitchbend
+	rd.Msg.Channel.Aftertouch = m.aftertouch
+	rd.Msg.Channel.PolyAftertouch = m.polyAftertouch
+	rd.Msg.Channel.ControlChange.Each = m.controlChange
+	rd.Msg.Channel.ControlChange.RPN.MSB = m.rpnMSB
+	rd.Msg.Channel.ControlChange.RPN.LSB = m.rpnLSB
+	rd.Msg.Channel.ControlChange.RPN.Increment = m.rpnIncrement
+	rd.Msg.Channel.ControlChange.RPN.Decrement = m.rpnDecrement
+
+	return m
+}
+
+// Zones returns the lower and the upper zone
+func (m *MPEReader) Zones() (lower, upper MPEZone) {
+	return m.zones[0], m.zones[1]
+}
+
+// setZone configures a zone and shrinks the other zone, if they overlap
+func (m *MPEReader) setZone(z MPEZone) {
+	this, other := 0, 1
+	if z.Upper {
+		this, other = 1, 0
+	}
+
+	if z.MemberChannels > 15 {
+		z.MemberChannels = 15
+	}
+
+	m.zones[this] = z
+
+	if z.MemberChannels >= 15 {
+		m.zones[other].MemberChannels = 0
+		return
+	}
+
+	if max := 14 - z.MemberChannels; m.zones[other].MemberChannels > max {
+		m.zones[other].MemberChannels = max
+	}
+}
+
+// isMember returns true, if ch is a member channel of any zone
+func (m *MPEReader) isMember(ch uint8) bool {
+	return m.zones[0].IsMember(ch) || m.zones[1].IsMember(ch)
+}
+
+func (m *MPEReader) noteOn(p *Position, ch, key, vel uint8) {
+	if !m.isMember(ch) {
+		if m.prev.noteOn != nil {
+			m.prev.noteOn(p, ch, key, vel)
+		}
+		return
+	}
+
+	m.nextID++
+	id := m.nextID
+	m.channelNotes[ch] = append(m.channelNotes[ch], mpeReaderNote{id: id, key: key})
+
+	if m.Note.On != nil {
+		m.Note.On(p, id, ch, key, vel, m.expression[ch])
+	}
+}
+
+func (m *MPEReader) noteOff(p *Position, ch, key, vel uint8) {
+	if !m.isMember(ch) {
+		if m.prev.noteOff != nil {
+			m.prev.noteOff(p, ch, key, vel)
+		}
+		return
+	}
+
+	notes := m.channelNotes[ch]
+	for i, n := range notes {
+		if n.key != key {
+			continue
+		}
+		m.channelNotes[ch] = append(notes[:i], notes[i+1:]...)
+		if m.Note.Off != nil {
+			m.Note.Off(p, n.id, vel)
+		}
+		return
+	}
+}
+
+func (m *MPEReader) pitchbend(p *Position, ch uint8, value int16) {
+	if !m.isMember(ch) {
+		if m.prev.pitchbend != nil {
+			m.prev.pitchbend(p, ch, value)
+		}
+		return
+	}
+
+	m.expression[ch].Pitchbend = value
+	if m.Note.Pitchbend == nil {
+		return
+	}
+	for _, n := range m.channelNotes[ch] {
+		m.Note.Pitchbend(p, n.id, value)
+	}
+}
+
+func (m *MPEReader) aftertouch(p *Position, ch, pressure uint8) {
+	if !m.isMember(ch) {
+		if m.prev.aftertouch != nil {
+			m.prev.aftertouch(p, ch, pressure)
+		}
+		return
+	}
+
+	m.expression[ch].Pressure = pressure
+	if m.Note.Pressure == nil {
+		return
+	}
+	for _, n := range m.channelNotes[ch] {
+		m.Note.Pressure(p, n.id, pressure)
+	}
+}
+
+func (m *MPEReader) polyAftertouch(p *Position, ch, key, pressure uint8) {
+	if !m.isMember(ch) {
+		if m.prev.polyAftertouch != nil {
+			m.prev.polyAftertouch(p, ch, key, pressure)
+		}
+		return
+	}
+
+	if m.Note.Pressure == nil {
+		return
+	}
+	for _, n := range m.channelNotes[ch] {
+		if n.key == key {
+			m.Note.Pressure(p, n.id, pressure)
+		}
+	}
+}
+
+func (m *MPEReader) controlChange(p *Position, ch, cc, val uint8) {
+	if !m.isMember(ch) || cc != mpeTimbreCC {
+		if m.prev.cc != nil {
+			m.prev.cc(p, ch, cc, val)
+		}
+		return
+	}
+
+	m.expression[ch].Timbre = val
+	if m.Note.Timbre == nil {
+		return
+	}
+	for _, n := range m.channelNotes[ch] {
+		m.Note.Timbre(p, n.id, val)
+	}
+}
+
+func (m *MPEReader) rpnMSB(p *Position, ch, typ1, typ2, val uint8) {
+	z, ok := ParseMPEConfiguration(ch, typ1, typ2, val)
+	if !ok {
+		if m.prev.rpnMSB != nil {
+			m.prev.rpnMSB(p, ch, typ1, typ2, val)
+		}
+		return
+	}
+
+	m.setZone(z)
+	if m.ZoneChange != nil {
+		m.ZoneChange(p, z)
+	}
+}
+
+// isMPEConfiguration returns true for the RPN of the MPE Configuration Message
+func isMPEConfiguration(ch, typ1, typ2 uint8) bool {
+	_, ok := ParseMPEConfiguration(ch, typ1, typ2, 0)
+	return ok
+}
+
+// rpnLSB ignores the LSB of the MPE Configuration Message, like rpnIncrement and rpnDecrement
+func (m *MPEReader) rpnLSB(p *Position, ch, typ1, typ2, val uint8) {
+	if !isMPEConfiguration(ch, typ1, typ2) && m.prev.rpnLSB != nil {
+		m.prev.rpnLSB(p, ch, typ1, typ2, val)
+	}
+}
+
+func (m *MPEReader) rpnIncrement(p *Position, ch, typ1, typ2 uint8) {
+	if !isMPEConfiguration(ch, typ1, typ2) && m.prev.rpnIncrement != nil {
+		m.prev.rpnIncrement(p, ch, typ1, typ2)
+	}
+}
+
+func (m *MPEReader) rpnDecrement(p *Position, ch, typ1, typ2 uint8) {
+	if !isMPEConfiguration(ch, typ1, typ2) && m.prev.rpnDecrement != nil {
+		m.prev.rpnDecrement(p, ch, typ1, typ2)
+	}
+}
@@ -0,0 +1,130 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMPEZone(t *testing.T) {
+	tests := []struct {
+		zone    MPEZone
+		members []uint8
+	}{
+		{MPEZone{Upper: false, MemberChannels: 3}, []uint8{1, 2, 3}},
+		{MPEZone{Upper: true, MemberChannels: 2}, []uint8{14, 13}},
+		{MPEZone{Upper: false, MemberChannels: 0}, nil},
+	}
+
+	for _, test := range tests {
+		if got, want := test.zone.Members(), test.members; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v.Members() = %v; want %v", test.zone, got, want)
+		}
+		for _, ch := range test.members {
+			if !test.zone.IsMember(ch) {
+				t.Errorf("%v.IsMember(%v) = false; want true", test.zone, ch)
+			}
+		}
+		if test.zone.IsMember(test.zone.ManagerChannel()) {
+			t.Errorf("manager channel must not be a member channel of %v", test.zone)
+		}
+	}
+}
+
+func TestMPEWriterReader(t *testing.T) {
+	var bf bytes.Buffer
+	wr := NewWriter(&bf)
+
+	m, err := NewMPEWriter(wr, MPEZone{MemberChannels: 2}, 48)
+	if err != nil {
+		t.Fatalf("NewMPEWriter returned error: %v", err)
+	}
+
+	a, _ := m.NoteOn(60, 100)
+	b, _ := m.NoteOn(64, 90)
+	m.Pitchbend(a, 100)
+	m.Timbre(b, 20)
+	m.Pressure(a, 55)
+	m.NoteOff(a, 0)
+	c, _ := m.NoteOn(67, 80) // reuses the channel of a
+	m.Manager().ControlChange(7, 100)
+	m.NoteOff(b, 0)
+	m.NoteOff(c, 0)
+
+	var res bytes.Buffer
+	rd := NewReader(NoLogger())
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) {
+		fmt.Fprintf(&res, "CC%v/%v:%v ", ch, cc, val)
+	}
+
+	mr := NewMPEReader(rd)
+	mr.ZoneChange = func(p *Position, z MPEZone) {
+		fmt.Fprintf(&res, "zone:%v ", z.MemberChannels)
+	}
+	mr.Note.On = func(p *Position, id MPENoteID, ch, key, vel uint8, initial MPEExpression) {
+		fmt.Fprintf(&res, "on#%v(ch%v key%v) ", id, ch, key)
+	}
+	mr.Note.Off = func(p *Position, id MPENoteID, vel uint8) {
+		fmt.Fprintf(&res, "off#%v ", id)
+	}
+	mr.Note.Pitchbend = func(p *Position, id MPENoteID, val int16) {
+		fmt.Fprintf(&res, "pb#%v:%v ", id, val)
+	}
+	mr.Note.Pressure = func(p *Position, id MPENoteID, val uint8) {
+		fmt.Fprintf(&res, "pr#%v:%v ", id, val)
+	}
+	mr.Note.Timbre = func(p *Position, id MPENoteID, val uint8) {
+		fmt.Fprintf(&res, "tb#%v:%v ", id, val)
+	}
+	rd.Read(&bf)
+
+	expected := "zone:2 on#1(ch1 key60) on#2(ch2 key64) pb#1:100 tb#2:20 pr#1:55 off#1 on#3(ch1 key67) CC0/7:100 off#2 off#3 "
+
+	if got := res.String(); got != expected {
+		t.Errorf("got\n%q\nwant\n%q", got, expected)
+	}
+
+	if lower, _ := mr.Zones(); lower.MemberChannels != 2 {
+		t.Errorf("lower zone has %v member channels; want 2", lower.MemberChannels)
+	}
+}
+
+func TestMPEReaderChainsRPN(t *testing.T) {
+	var bf bytes.Buffer
+	wr := NewWriter(&bf)
+	wr.SetChannel(1)
+	wr.PitchBendSensitivityRPN(48, 0)
+	wr.RPNIncrement(0, 0)
+	wr.RPNDecrement(0, 0)
+	wr.SetChannel(0)
+	wr.MPEConfigurationRPN(3)
+	wr.RPNIncrement(0, 6)
+
+	var res bytes.Buffer
+	rd := NewReader(NoLogger())
+	rd.Msg.Channel.ControlChange.RPN.MSB = func(p *Position, ch, typ1, typ2, val uint8) {
+		fmt.Fprintf(&res, "msb%v/%v-%v:%v ", ch, typ1, typ2, val)
+	}
+	rd.Msg.Channel.ControlChange.RPN.LSB = func(p *Position, ch, typ1, typ2, val uint8) {
+		fmt.Fprintf(&res, "lsb%v/%v-%v:%v ", ch, typ1, typ2, val)
+	}
+	rd.Msg.Channel.ControlChange.RPN.Increment = func(p *Position, ch, typ1, typ2 uint8) {
+		fmt.Fprintf(&res, "inc%v/%v-%v ", ch, typ1, typ2)
+	}
+	rd.Msg.Channel.ControlChange.RPN.Decrement = func(p *Position, ch, typ1, typ2 uint8) {
+		fmt.Fprintf(&res, "dec%v/%v-%v ", ch, typ1, typ2)
+	}
+
+	mr := NewMPEReader(rd)
+	mr.ZoneChange = func(p *Position, z MPEZone) {
+		fmt.Fprintf(&res, "zone:%v ", z.MemberChannels)
+	}
+	rd.Read(&bf)
+
+	// the LSB and the increment of the MPE Configuration Message are not passed
+	expected := "msb1/0-0:48 lsb1/0-0:0 msb1/127-127:0 inc1/0-0 msb1/127-127:0 dec1/0-0 msb1/127-127:0 zone:3 msb0/127-127:0 msb0/127-127:0 "
+	if got := res.String(); got != expected {
+		t.Errorf("got\n%q\nwant\n%q", got, expected)
+	}
+}
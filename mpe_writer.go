@@ -0,0 +1,188 @@
+package mid
+
+import (
+	"fmt"
+)
+
+// MPEWriter writes notes with per note expression to a MPE zone.
+// Every note gets its own member channel, as long as there are enough channels.
+// The MPEWriter changes the channel of the underlying writer. Its methods must not be called concurrently.
+type MPEWriter struct {
+	wr   ChannelWriter
+	zone MPEZone
+
+	notes    map[MPENoteID]mpeNote
+	nextID   MPENoteID
+	sounding [16]int
+	keys     [16][128]bool
+	lastUsed [16]uint64
+	counter  uint64
+}
+
+type mpeNote struct {
+	channel uint8
+	key     uint8
+}
+
+// NewMPEWriter returns a MPEWriter for the given zone that writes to wr.
+// It writes the MPE Configuration Message to the manager channel and, if pitchBendRange is > 0,
+// sets the pitch bend range of the member channels (in semitones, the MPE default is 48).
+func NewMPEWriter(wr ChannelWriter, zone MPEZone, pitchBendRange uint8) (*MPEWriter, error) {
+	if zone.MemberChannels == 0 || zone.MemberChannels > 15 {
+		return nil, fmt.Errorf("invalid number of member channels: %v", zone.MemberChannels)
+	}
+
+	m := &MPEWriter{
+		wr:    wr,
+		zone:  zone,
+		notes: map[MPENoteID]mpeNote{},
+	}
+
+	wr.SetChannel(zone.ManagerChannel())
+	err := wr.RPN(0, 6, zone.MemberChannels, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if pitchBendRange == 0 {
+		return m, nil
+	}
+
+	for _, ch := range zone.Members() {
+		wr.SetChannel(ch)
+		err = wr.PitchBendSensitivityRPN(pitchBendRange, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Zone returns the zone of the writer
+func (m *MPEWriter) Zone() MPEZone {
+	return m.zone
+}
+
+// allocate returns the least recently used member channel without sounding notes.
+// If every member channel is in use, the channel with the fewest notes is shared.
+func (m *MPEWriter) allocate(key uint8) (ch uint8, err error) {
+	var best = -1
+	for _, c := range m.zone.Members() {
+		if m.keys[c][key] {
+			continue
+		}
+		if best < 0 ||
+			m.sounding[c] < m.sounding[best] ||
+			(m.sounding[c] == m.sounding[best] && m.lastUsed[c] < m.lastUsed[best]) {
+			best = int(c)
+		}
+	}
+	if best < 0 {
+		return 0, ErrNoFreeChannel
+	}
+	return uint8(best), nil
+}
+
+// NoteOn starts a note on a member channel and returns its ID.
+// Before the note on, the expression of the channel is reset (pitch bend to center, pressure to 0, timbre to 64).
+func (m *MPEWriter) NoteOn(key, velocity uint8) (id MPENoteID, err error) {
+	return m.NoteOnExpression(key, velocity, mpeDefaultExpression)
+}
+
+// NoteOnExpression starts a note with the given initial expression on a member channel and returns its ID.
+func (m *MPEWriter) NoteOnExpression(key, velocity uint8, initial MPEExpression) (id MPENoteID, err error) {
+	ch, err := m.allocate(key)
+	if err != nil {
+		return 0, err
+	}
+
+	m.wr.SetChannel(ch)
+
+	err = m.wr.Pitchbend(initial.Pitchbend)
+	if err != nil {
+		return
+	}
+
+	err = m.wr.ControlChange(mpeTimbreCC, initial.Timbre)
+	if err != nil {
+		return
+	}
+
+	err = m.wr.Aftertouch(initial.Pressure)
+	if err != nil {
+		return
+	}
+
+	err = m.wr.NoteOn(key, velocity)
+	if err != nil {
+		return
+	}
+
+	m.nextID++
+	id = m.nextID
+	m.notes[id] = mpeNote{channel: ch, key: key}
+	m.counter++
+	m.lastUsed[ch] = m.counter
+	m.sounding[ch]++
+	m.keys[ch][key] = true
+	return id, nil
+}
+
+// note selects the channel of the note with the given id
+func (m *MPEWriter) note(id MPENoteID) (n mpeNote, err error) {
+	n, has := m.notes[id]
+	if !has {
+		return n, fmt.Errorf("unknown MPE note %v", id)
+	}
+	m.wr.SetChannel(n.channel)
+	return n, nil
+}
+
+// NoteOff stops the note with the given id. If velocity is > 0, it is sent as release velocity.
+func (m *MPEWriter) NoteOff(id MPENoteID, velocity uint8) error {
+	n, err := m.note(id)
+	if err != nil {
+		return err
+	}
+
+	delete(m.notes, id)
+	m.sounding[n.channel]--
+	m.keys[n.channel][n.key] = false
+
+	if velocity > 0 {
+		return m.wr.NoteOffVelocity(n.key, velocity)
+	}
+	return m.wr.NoteOff(n.key)
+}
+
+// Pitchbend writes the per note pitch bend of the note with the given id
+func (m *MPEWriter) Pitchbend(id MPENoteID, value int16) error {
+	if _, err := m.note(id); err != nil {
+		return err
+	}
+	return m.wr.Pitchbend(value)
+}
+
+// Pressure writes the per note pressure of the note with the given id
+func (m *MPEWriter) Pressure(id MPENoteID, pressure uint8) error {
+	if _, err := m.note(id); err != nil {
+		return err
+	}
+	return m.wr.Aftertouch(pressure)
+}
+
+// Timbre writes the per note timbre (CC74) of the note with the given id
+func (m *MPEWriter) Timbre(id MPENoteID, value uint8) error {
+	if _, err := m.note(id); err != nil {
+		return err
+	}
+	return m.wr.ControlChange(mpeTimbreCC, value)
+}
+
+// Manager sets the channel of the underlying writer to the manager channel and returns the writer.
+// Messages written to it affect the whole zone.
+func (m *MPEWriter) Manager() ChannelWriter {
+	m.wr.SetChannel(m.zone.ManagerChannel())
+	return m.wr
+}
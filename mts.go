@@ -0,0 +1,363 @@
+package mid
+
+import (
+	"fmt"
+	"math"
+)
+
+// sub IDs of the MIDI Tuning Standard (MTS) messages
+const (
+	mtsSubID              = 0x08
+	mtsBulkDumpRequest    = 0x00
+	mtsBulkDump           = 0x01
+	mtsNoteChange         = 0x02
+	mtsNoteChangeBank     = 0x07
+	mtsScaleOctave1Byte   = 0x08
+	mtsScaleOctave2Byte   = 0x09
+	mtsNameLength         = 16
+	mtsMaxNotesPerMessage = 127
+)
+
+// MTSPitch is a pitch in the frequency data format of the MIDI Tuning Standard:
+// a semitone (equal tempered MIDI key) and a 14bit fraction of a semitone (in 1/16384 steps).
+type MTSPitch struct {
+	Semitone uint8
+	Fraction uint16
+}
+
+// MTSNoChange is the pitch that tells the receiver to keep the current tuning of a key
+var MTSNoChange = MTSPitch{Semitone: 0x7F, Fraction: 0x3FFF}
+
+// MTSPitchFromFloat returns the MTSPitch for a pitch given in fractional semitones
+// (e.g. 60.5 is a quarter tone above the middle C). The pitch is clamped to the valid range.
+func MTSPitchFromFloat(pitch float64) MTSPitch {
+	if pitch <= 0 {
+		return MTSPitch{}
+	}
+	semitone := math.Floor(pitch)
+	fraction := math.Round((pitch - semitone) * 16384)
+	if fraction >= 16384 {
+		semitone++
+		fraction = 0
+	}
+	if semitone > 127 || (semitone == 127 && fraction >= 0x3FFF) {
+		// 7F 7F 7F is reserved for MTSNoChange
+		return MTSPitch{Semitone: 127, Fraction: 0x3FFE}
+	}
+	return MTSPitch{Semitone: uint8(semitone), Fraction: uint16(fraction)}
+}
+
+// MTSPitchFromFrequency returns the MTSPitch for the given frequency in Hz (A4 = 440 Hz)
+func MTSPitchFromFrequency(hz float64) MTSPitch {
+	if hz <= 0 {
+		return MTSPitch{}
+	}
+	return MTSPitchFromFloat(69 + 12*math.Log2(hz/440))
+}
+
+// Float returns the pitch in fractional semitones
+func (p MTSPitch) Float() float64 {
+	return float64(p.Semitone) + float64(p.Fraction)/16384
+}
+
+// Frequency returns the frequency of the pitch in Hz (A4 = 440 Hz)
+func (p MTSPitch) Frequency() float64 {
+	return 440 * math.Pow(2, (p.Float()-69)/12)
+}
+
+func (p MTSPitch) bytes() []byte {
+	return []byte{p.Semitone & 0x7F, byte(p.Fraction>>7) & 0x7F, byte(p.Fraction) & 0x7F}
+}
+
+func parseMTSPitch(b []byte) MTSPitch {
+	return MTSPitch{Semitone: b[0], Fraction: uint16(b[1])<<7 | uint16(b[2])}
+}
+
+// MTSBulkDump is a bulk tuning dump of all 128 keys
+type MTSBulkDump struct {
+
+	// Program is the tuning program number
+	Program uint8
+
+	// Name is the name of the tuning (at most 16 ASCII characters)
+	Name string
+
+	// Pitches are the pitches of the keys
+	Pitches [128]MTSPitch
+}
+
+func (d MTSBulkDump) data(deviceID uint8) []byte {
+	var name [mtsNameLength]byte
+	for i := range name {
+		name[i] = ' '
+		if i < len(d.Name) {
+			name[i] = d.Name[i] & 0x7F
+		}
+	}
+
+	b := universalSysEx(false, deviceID, mtsSubID, mtsBulkDump, d.Program&0x7F)
+	b = append(b, name[:]...)
+	for _, p := range d.Pitches {
+		b = append(b, p.bytes()...)
+	}
+	return append(b, mtsChecksum(b))
+}
+
+// mtsChecksum is the XOR of all bytes from the sub ID 0x7E up to the last data byte
+func mtsChecksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum ^= c
+	}
+	return sum & 0x7F
+}
+
+// ParseMTSBulkDump parses the given system exclusive data (without 0xF0 and 0xF7) as bulk tuning dump.
+func ParseMTSBulkDump(data []byte) (deviceID uint8, d MTSBulkDump, err error) {
+	if !isUniversalSysEx(data, mtsSubID, mtsBulkDump) {
+		err = fmt.Errorf("no MTS bulk dump")
+		return
+	}
+	if got, want := len(data), 5+mtsNameLength+128*3+1; got != want {
+		err = fmt.Errorf("invalid length of MTS bulk dump: %v, expected %v", got, want)
+		return
+	}
+	if sum := mtsChecksum(data[:len(data)-1]); sum != data[len(data)-1] {
+		err = fmt.Errorf("invalid checksum of MTS bulk dump: %X, expected %X", data[len(data)-1], sum)
+		return
+	}
+
+	deviceID = data[1]
+	d.Program = data[4]
+	d.Name = string(data[5 : 5+mtsNameLength])
+	pitches := data[5+mtsNameLength:]
+	for i := range d.Pitches {
+		d.Pitches[i] = parseMTSPitch(pitches[i*3:])
+	}
+	return
+}
+
+// MTSNoteTuning is the tuning of a single key
+type MTSNoteTuning struct {
+	Key   uint8
+	Pitch MTSPitch
+}
+
+// MTSNoteChange is a single note tuning change for some keys.
+// Realtime changes without a bank are written as the original real-time message (without bank),
+// all others as the bank variant.
+type MTSNoteChange struct {
+
+	// Realtime is true, if the change should affect sounding notes immediately
+	Realtime bool
+
+	// Bank is the tuning bank
+	Bank uint8
+
+	// Program is the tuning program number
+	Program uint8
+
+	// Notes are the changed keys
+	Notes []MTSNoteTuning
+}
+
+func (c MTSNoteChange) data(deviceID uint8) []byte {
+	var b []byte
+	if c.Realtime && c.Bank == 0 {
+		b = universalSysEx(true, deviceID, mtsSubID, mtsNoteChange, c.Program&0x7F)
+	} else {
+		b = universalSysEx(c.Realtime, deviceID, mtsSubID, mtsNoteChangeBank, c.Bank&0x7F, c.Program&0x7F)
+	}
+	b = append(b, byte(len(c.Notes)))
+	for _, n := range c.Notes {
+		b = append(b, n.Key&0x7F)
+		b = append(b, n.Pitch.bytes()...)
+	}
+	return b
+}
+
+// ParseMTSNoteChange parses the given system exclusive data (without 0xF0 and 0xF7) as single note tuning change.
+func ParseMTSNoteChange(data []byte) (deviceID uint8, c MTSNoteChange, err error) {
+	var rest []byte
+	switch {
+	case isUniversalSysEx(data, mtsSubID, mtsNoteChange) && data[0] == universalRealtime && len(data) >= 6:
+		c.Realtime = true
+		c.Program = data[4]
+		rest = data[5:]
+	case isUniversalSysEx(data, mtsSubID, mtsNoteChangeBank) && len(data) >= 7:
+		c.Realtime = data[0] == universalRealtime
+		c.Bank = data[4]
+		c.Program = data[5]
+		rest = data[6:]
+	default:
+		err = fmt.Errorf("no MTS single note tuning change")
+		return
+	}
+
+	deviceID = data[1]
+	num := int(rest[0])
+	rest = rest[1:]
+	if len(rest) < num*4 {
+		err = fmt.Errorf("MTS single note tuning change too short for %v notes", num)
+		return
+	}
+
+	for i := 0; i < num; i++ {
+		c.Notes = append(c.Notes, MTSNoteTuning{Key: rest[i*4], Pitch: parseMTSPitch(rest[i*4+1:])})
+	}
+	return
+}
+
+// MTSScaleOctave is a scale/octave tuning that applies the same deviation to every
+// octave of a pitch class on the given channels.
+type MTSScaleOctave struct {
+
+	// Realtime is true, if the change should affect sounding notes immediately
+	Realtime bool
+
+	// HighResolution selects the 2 byte format (resolution of 0.012 cents, range -100 to +100 cents)
+	// instead of the 1 byte format (resolution of 1 cent, range -64 to +63 cents)
+	HighResolution bool
+
+	// Channels is a bitmask of the affected channels (bit 0 is channel 0)
+	Channels uint16
+
+	// Cents are the deviations from equal temperament in cents, starting with C
+	Cents [12]float64
+}
+
+// MTSAllChannels is a channel mask for MTSScaleOctave that selects every channel
+const MTSAllChannels = 0xFFFF
+
+func (s MTSScaleOctave) data(deviceID uint8) []byte {
+	subID := byte(mtsScaleOctave1Byte)
+	if s.HighResolution {
+		subID = mtsScaleOctave2Byte
+	}
+
+	b := universalSysEx(s.Realtime, deviceID, mtsSubID, subID,
+		byte(s.Channels>>14)&0x03,
+		byte(s.Channels>>7)&0x7F,
+		byte(s.Channels)&0x7F,
+	)
+
+	for _, c := range s.Cents {
+		if s.HighResolution {
+			v := uint16(clamp(math.Round(8192+c*8192/100), 0, 16383))
+			b = append(b, byte(v>>7), byte(v)&0x7F)
+			continue
+		}
+		b = append(b, byte(clamp(math.Round(64+c), 0, 127)))
+	}
+	return b
+}
+
+// ParseMTSScaleOctave parses the given system exclusive data (without 0xF0 and 0xF7) as scale/octave tuning.
+func ParseMTSScaleOctave(data []byte) (deviceID uint8, s MTSScaleOctave, err error) {
+	switch {
+	case isUniversalSysEx(data, mtsSubID, mtsScaleOctave1Byte) && len(data) >= 7+12:
+	case isUniversalSysEx(data, mtsSubID, mtsScaleOctave2Byte) && len(data) >= 7+24:
+		s.HighResolution = true
+	default:
+		err = fmt.Errorf("no MTS scale/octave tuning")
+		return
+	}
+
+	deviceID = data[1]
+	s.Realtime = data[0] == universalRealtime
+	s.Channels = uint16(data[4]&0x03)<<14 | uint16(data[5]&0x7F)<<7 | uint16(data[6]&0x7F)
+
+	vals := data[7:]
+	for i := range s.Cents {
+		if s.HighResolution {
+			v := uint16(vals[i*2])<<7 | uint16(vals[i*2+1])
+			s.Cents[i] = (float64(v) - 8192) * 100 / 8192
+			continue
+		}
+		s.Cents[i] = float64(vals[i]) - 64
+	}
+	return
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// MTSBulkDumpRequest writes a request for the bulk tuning dump of the given tuning program
+func (w *midiWriter) MTSBulkDumpRequest(deviceID, program uint8) error {
+	return w.SysEx(universalSysEx(false, deviceID, mtsSubID, mtsBulkDumpRequest, program&0x7F))
+}
+
+// MTSBulkDump writes a bulk tuning dump
+func (w *midiWriter) MTSBulkDump(deviceID uint8, d MTSBulkDump) error {
+	return w.SysEx(d.data(deviceID))
+}
+
+// MTSNoteChange writes a single note tuning change. If there are more than 127 notes,
+// multiple messages are written.
+func (w *midiWriter) MTSNoteChange(deviceID uint8, c MTSNoteChange) error {
+	notes := c.Notes
+	for {
+		c.Notes = notes
+		if len(notes) > mtsMaxNotesPerMessage {
+			c.Notes = notes[:mtsMaxNotesPerMessage]
+		}
+		err := w.SysEx(c.data(deviceID))
+		if err != nil {
+			return err
+		}
+		notes = notes[len(c.Notes):]
+		if len(notes) == 0 {
+			return nil
+		}
+	}
+}
+
+// MTSScaleOctave writes a scale/octave tuning
+func (w *midiWriter) MTSScaleOctave(deviceID uint8, s MTSScaleOctave) error {
+	return w.SysEx(s.data(deviceID))
+}
+
+// dispatchTuning dispatches MTS messages to the Tuning callbacks.
+// It returns false, if data is no MTS message or no callback is defined for it.
+func (r *Reader) dispatchTuning(data []byte) bool {
+	if len(data) < 4 || !isUniversalSysEx(data, mtsSubID, data[3]) {
+		return false
+	}
+
+	cb := &r.Msg.SysEx.Tuning
+
+	switch data[3] {
+	case mtsBulkDump:
+		if cb.BulkDump == nil {
+			return false
+		}
+		if dev, d, err := ParseMTSBulkDump(data); err == nil {
+			cb.BulkDump(r.pos, dev, d)
+			return true
+		}
+	case mtsNoteChange, mtsNoteChangeBank:
+		if cb.NoteChange == nil {
+			return false
+		}
+		if dev, c, err := ParseMTSNoteChange(data); err == nil {
+			cb.NoteChange(r.pos, dev, c)
+			return true
+		}
+	case mtsScaleOctave1Byte, mtsScaleOctave2Byte:
+		if cb.ScaleOctave == nil {
+			return false
+		}
+		if dev, s, err := ParseMTSScaleOctave(data); err == nil {
+			cb.ScaleOctave(r.pos, dev, s)
+			return true
+		}
+	}
+	return false
+}
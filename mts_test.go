@@ -0,0 +1,137 @@
+package mid
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMTSPitch(t *testing.T) {
+	tests := []struct {
+		pitch    float64
+		expected MTSPitch
+	}{
+		{60, MTSPitch{60, 0}},
+		{60.5, MTSPitch{60, 8192}},
+		{69.999999999, MTSPitch{70, 0}},
+		{-3, MTSPitch{0, 0}},
+		{127, MTSPitch{127, 0}},
+		{127.25, MTSPitch{127, 4096}},
+		{127.99999, MTSPitch{127, 0x3FFE}},
+		{130, MTSPitch{127, 0x3FFE}},
+	}
+
+	for _, test := range tests {
+		if got, want := MTSPitchFromFloat(test.pitch), test.expected; got != want {
+			t.Errorf("MTSPitchFromFloat(%v) = %v; want %v", test.pitch, got, want)
+		}
+	}
+
+	if got, want := MTSPitchFromFrequency(440), (MTSPitch{69, 0}); got != want {
+		t.Errorf("MTSPitchFromFrequency(440) = %v; want %v", got, want)
+	}
+}
+
+func TestMTSMessages(t *testing.T) {
+	var dump MTSBulkDump
+	dump.Program = 3
+	dump.Name = "just intonation"
+	for i := range dump.Pitches {
+		dump.Pitches[i] = MTSPitchFromFloat(float64(i) + 0.25)
+	}
+
+	change := MTSNoteChange{
+		Realtime: true,
+		Program:  5,
+		Notes:    []MTSNoteTuning{{60, MTSPitch{60, 100}}, {61, MTSPitch{60, 8000}}},
+	}
+
+	bankChange := MTSNoteChange{
+		Bank:    2,
+		Program: 5,
+		Notes:   []MTSNoteTuning{{0, MTSNoChange}},
+	}
+
+	octave := MTSScaleOctave{
+		Realtime: true,
+		Channels: 0x8001,
+		Cents:    [12]float64{0, -10, 4, 16, -14, -2, -17, 2, -8, 16, 18, -12},
+	}
+
+	octaveHigh := octave
+	octaveHigh.HighResolution = true
+	octaveHigh.Cents[1] = -50
+
+	var bf bytes.Buffer
+	wr := NewWriter(&bf)
+	wr.MTSBulkDump(0x10, dump)
+	wr.MTSNoteChange(0x11, change)
+	wr.MTSNoteChange(0x12, bankChange)
+	wr.MTSScaleOctave(AllDevices, octave)
+	wr.MTSScaleOctave(AllDevices, octaveHigh)
+
+	var dumps []MTSBulkDump
+	var changes []MTSNoteChange
+	var octaves []MTSScaleOctave
+	var devices []uint8
+
+	rd := NewReader(NoLogger())
+	rd.Msg.SysEx.Tuning.BulkDump = func(p *Position, dev uint8, d MTSBulkDump) {
+		devices = append(devices, dev)
+		dumps = append(dumps, d)
+	}
+	rd.Msg.SysEx.Tuning.NoteChange = func(p *Position, dev uint8, c MTSNoteChange) {
+		devices = append(devices, dev)
+		changes = append(changes, c)
+	}
+	rd.Msg.SysEx.Tuning.ScaleOctave = func(p *Position, dev uint8, s MTSScaleOctave) {
+		devices = append(devices, dev)
+		octaves = append(octaves, s)
+	}
+	rd.Read(&bf)
+
+	if got, want := devices, []uint8{0x10, 0x11, 0x12, 0x7F, 0x7F}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("device IDs = %v; want %v", got, want)
+	}
+
+	dump.Name = "just intonation "
+	if got, want := dumps[0], dump; !reflect.DeepEqual(got, want) {
+		t.Errorf("bulk dump = %v; want %v", got, want)
+	}
+
+	if got, want := changes, []MTSNoteChange{change, bankChange}; !reflect.DeepEqual(got, want) {
+		t.Errorf("note changes = %v; want %v", got, want)
+	}
+
+	if got, want := octaves[0], octave; !reflect.DeepEqual(got, want) {
+		t.Errorf("scale/octave = %v; want %v", got, want)
+	}
+
+	for i, c := range octaves[1].Cents {
+		if math.Abs(c-octaveHigh.Cents[i]) > 0.02 {
+			t.Errorf("high resolution scale/octave cents[%v] = %v; want %v", i, c, octaveHigh.Cents[i])
+		}
+	}
+}
+
+func TestMTSNoteChangeSplit(t *testing.T) {
+	var c MTSNoteChange
+	for i := 0; i < 128; i++ {
+		c.Notes = append(c.Notes, MTSNoteTuning{uint8(i), MTSPitch{uint8(i), 0}})
+	}
+
+	var bf bytes.Buffer
+	NewWriter(&bf).MTSNoteChange(AllDevices, c)
+
+	var got []int
+	rd := NewReader(NoLogger())
+	rd.Msg.SysEx.Tuning.NoteChange = func(p *Position, dev uint8, c MTSNoteChange) {
+		got = append(got, len(c.Notes))
+	}
+	rd.Read(&bf)
+
+	if want := []int{127, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("notes per message = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,328 @@
+// Package oscbridge bridges MIDI and Open Sound Control (OSC) over UDP.
+//
+// MIDI messages that are read by a mid.Reader are sent as OSC messages and received OSC
+// messages are written to a mid.ChannelWriter. The mapping is defined declaratively by a Config.
+package oscbridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/internal/bridge"
+	"github.com/gomidi/midi"
+)
+
+// maxPacketSize is the size of the receive buffer
+const maxPacketSize = 65536
+
+type outRoute struct {
+	typ       int
+	address   []templatePart
+	args      []int
+	channels  [16]bool
+	normalize bool
+}
+
+type inRoute struct {
+	typ       int
+	segments  []string // literal segments, empty for fields
+	fields    []int    // field of each segment, -1 for literal segments
+	args      []int
+	defaults  bridge.Values
+	normalize bool
+}
+
+// Bridge sends and receives OSC messages according to a Config
+type Bridge struct {
+	conn *net.UDPConn
+	dest *net.UDPAddr
+	out  [bridge.NumTypes][]outRoute
+	in   []inRoute
+	prev func(*mid.Position, midi.Message)
+
+	// Error is called for errors while sending OSC or writing MIDI, if it is not nil
+	Error func(err error)
+}
+
+// New returns a Bridge for the given config that receives OSC on the listen address
+// and sends OSC to the dest address (both host:port). If dest is empty, no OSC is sent.
+func New(cfg *Config, listen, dest string) (*Bridge, error) {
+	b := &Bridge{}
+
+	for _, o := range cfg.Out {
+		r, err := newOutRoute(o)
+		if err != nil {
+			return nil, err
+		}
+		b.out[r.typ] = append(b.out[r.typ], r)
+	}
+
+	for _, i := range cfg.In {
+		r, err := newInRoute(i)
+		if err != nil {
+			return nil, err
+		}
+		b.in = append(b.in, r)
+	}
+
+	if dest != "" {
+		var err error
+		b.dest, err = net.ResolveUDPAddr("udp", dest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	b.conn, err = net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func newOutRoute(o OutMapping) (r outRoute, err error) {
+	r.typ, err = messageType(o.Message)
+	if err != nil {
+		return
+	}
+	r.address, err = parseTemplate(o.Address)
+	if err != nil {
+		return
+	}
+	r.args, err = fields(o.Args)
+	if err != nil {
+		return
+	}
+	for i := range r.channels {
+		r.channels[i] = len(o.Channels) == 0
+	}
+	for _, ch := range o.Channels {
+		if ch > 15 {
+			return r, fmt.Errorf("invalid channel %v", ch)
+		}
+		r.channels[ch] = true
+	}
+	r.normalize = o.Normalize
+	return
+}
+
+func newInRoute(i InMapping) (r inRoute, err error) {
+	r.typ, err = messageType(i.Message)
+	if err != nil {
+		return
+	}
+	r.args, err = fields(i.Args)
+	if err != nil {
+		return
+	}
+	for name, v := range i.Defaults {
+		f, ok := bridge.Field(name)
+		if !ok {
+			return r, fmt.Errorf("unknown field %q", name)
+		}
+		r.defaults[f] = v
+	}
+	for _, seg := range strings.Split(i.Address, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			f, ok := bridge.Field(seg[1 : len(seg)-1])
+			if !ok {
+				return r, fmt.Errorf("unknown field %q in address", seg)
+			}
+			r.segments = append(r.segments, "")
+			r.fields = append(r.fields, f)
+			continue
+		}
+		if strings.ContainsAny(seg, "{}") {
+			return r, fmt.Errorf("fields must fill a complete path segment in address %q", i.Address)
+		}
+		r.segments = append(r.segments, seg)
+		r.fields = append(r.fields, -1)
+	}
+	r.normalize = i.Normalize
+	return
+}
+
+// LocalAddr returns the address on which OSC is received
+func (b *Bridge) LocalAddr() *net.UDPAddr {
+	return b.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close stops receiving and sending
+func (b *Bridge) Close() error {
+	return b.conn.Close()
+}
+
+func (b *Bridge) error(err error) {
+	if b.Error != nil {
+		b.Error(err)
+	}
+}
+
+// Attach subscribes the bridge to the messages of the Reader. A Msg.Each callback that
+// had been attached to the Reader before is still called.
+func (b *Bridge) Attach(rd *mid.Reader) {
+	b.prev = rd.Msg.Each
+	rd.Msg.Each = b.each
+}
+
+func (b *Bridge) each(p *mid.Position, msg midi.Message) {
+	if b.prev != nil {
+		b.prev(p, msg)
+	}
+
+	typ, v, ok := bridge.Decode(msg)
+	if !ok {
+		return
+	}
+
+	for _, r := range b.out[typ] {
+		if r.channels[v[bridge.Ch]] {
+			b.send(r, v)
+		}
+	}
+}
+
+func (b *Bridge) send(r outRoute, v bridge.Values) {
+	if b.dest == nil {
+		return
+	}
+
+	var addr strings.Builder
+	for _, part := range r.address {
+		if part.field < 0 {
+			addr.WriteString(part.literal)
+		} else {
+			addr.WriteString(strconv.Itoa(v[part.field]))
+		}
+	}
+
+	m := Message{Address: addr.String()}
+	for _, f := range r.args {
+		if r.normalize {
+			m.Args = append(m.Args, normalize(r.typ, f, v[f]))
+		} else {
+			m.Args = append(m.Args, int32(v[f]))
+		}
+	}
+
+	bt, err := m.MarshalBinary()
+	if err == nil {
+		_, err = b.conn.WriteToUDP(bt, b.dest)
+	}
+	if err != nil {
+		b.error(err)
+	}
+}
+
+// normalize returns the value as float between 0 and 1 (pitch bend between -1 and 1)
+func normalize(typ, field, v int) float32 {
+	if field == bridge.Ch {
+		return float32(v)
+	}
+	if typ == bridge.Pitchbend && field == bridge.Val {
+		return float32(v) / 8192
+	}
+	return float32(v) / 127
+}
+
+// denormalize is the inverse of normalize
+func denormalize(typ, field int, f float32) int {
+	if field == bridge.Ch {
+		return int(f)
+	}
+	if typ == bridge.Pitchbend && field == bridge.Val {
+		return int(f*8192 + 0.5*sign(f))
+	}
+	return int(f*127 + 0.5)
+}
+
+func sign(f float32) float32 {
+	if f < 0 {
+		return -1
+	}
+	return 1
+}
+
+// Serve receives OSC messages and writes the mapped MIDI messages to wr until the bridge is closed.
+// Messages that match no mapping are ignored.
+func (b *Bridge) Serve(wr mid.ChannelWriter) error {
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, _, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := parsePacket(buf[:n])
+		if err != nil {
+			b.error(err)
+		}
+
+		for _, m := range msgs {
+			b.receive(wr, m)
+		}
+	}
+}
+
+func (r inRoute) match(segments []string) (v bridge.Values, ok bool) {
+	if len(segments) != len(r.segments) {
+		return v, false
+	}
+
+	v = r.defaults
+	for i, seg := range segments {
+		if r.fields[i] < 0 {
+			if seg != r.segments[i] {
+				return v, false
+			}
+			continue
+		}
+		n, err := strconv.Atoi(seg)
+		if err != nil {
+			return v, false
+		}
+		v[r.fields[i]] = n
+	}
+	return v, true
+}
+
+func (b *Bridge) receive(wr mid.ChannelWriter, m Message) {
+	segments := strings.Split(m.Address, "/")
+
+	for _, r := range b.in {
+		v, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+
+		for i, f := range r.args {
+			if i >= len(m.Args) {
+				break
+			}
+			switch a := m.Args[i].(type) {
+			case int32:
+				v[f] = int(a)
+			case float32:
+				if r.normalize {
+					v[f] = denormalize(r.typ, f, a)
+				} else {
+					v[f] = int(a)
+				}
+			}
+		}
+
+		if err := bridge.Write(wr, r.typ, v); err != nil {
+			b.error(err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package oscbridge
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomidi/mid"
+)
+
+const testConfig = `{
+  "out": [
+    {"message": "noteon", "address": "/midi/{ch}/note", "args": ["key", "vel"]},
+    {"message": "noteoff", "address": "/midi/{ch}/note", "args": ["key", "vel"]},
+    {"message": "cc", "address": "/midi/{ch}/cc/{cc}", "args": ["val"], "normalize": true, "channels": [2]}
+  ],
+  "in": [
+    {"address": "/light/{ch}/dimmer", "message": "cc", "args": ["val"], "defaults": {"cc": 7}, "normalize": true},
+    {"address": "/pad/{key}", "message": "noteon", "args": ["vel"], "defaults": {"ch": 9}}
+  ]
+}`
+
+func TestConfigErrors(t *testing.T) {
+	tests := []string{
+		`{"out": [{"message": "foo", "address": "/x"}]}`,
+		`{"out": [{"message": "cc", "address": "/x/{nope}"}]}`,
+		`{"in": [{"message": "cc", "address": "/x/a{ch}"}]}`,
+		`{"in": [{"message": "cc", "address": "/x", "args": ["bar"]}]}`,
+		`{"unknown": []}`,
+	}
+
+	for _, test := range tests {
+		cfg, err := ReadConfig(strings.NewReader(test))
+		if err == nil {
+			_, err = New(cfg, "127.0.0.1:0", "")
+		}
+		if err == nil {
+			t.Errorf("config %s must return an error", test)
+		}
+	}
+}
+
+func TestBridgeOut(t *testing.T) {
+	cfg, err := ReadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	b, err := New(cfg, "127.0.0.1:0", receiver.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer b.Close()
+
+	var bf bytes.Buffer
+	wr := mid.NewWriter(&bf)
+	wr.SetChannel(2)
+	wr.NoteOn(60, 100)
+	wr.ControlChange(7, 127)
+	wr.SetChannel(3)
+	wr.ControlChange(7, 64) // not mapped for channel 3
+	wr.SetChannel(2)
+	wr.NoteOff(60)
+
+	rd := mid.NewReader(mid.NoLogger())
+	b.Attach(rd)
+	rd.Read(&bf)
+
+	var got []string
+	buf := make([]byte, 1024)
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 3; i++ {
+		n, _, err := receiver.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("can't receive OSC message %v: %v", i+1, err)
+		}
+		var m Message
+		if err := m.UnmarshalBinary(buf[:n]); err != nil {
+			t.Fatalf("invalid OSC message: %v", err)
+		}
+		got = append(got, m.String())
+	}
+
+	want := "[/midi/2/note [60 100] /midi/2/cc/7 [1] /midi/2/note [60 0]]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+type syncBuffer struct {
+	bytes.Buffer
+	written chan bool
+}
+
+func (s *syncBuffer) Write(b []byte) (int, error) {
+	n, err := s.Buffer.Write(b)
+	s.written <- true
+	return n, err
+}
+
+func TestBridgeIn(t *testing.T) {
+	cfg, err := ReadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(cfg, "127.0.0.1:0", "")
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer b.Close()
+
+	out := &syncBuffer{written: make(chan bool, 10)}
+	go b.Serve(mid.NewWriter(out))
+
+	sender, err := net.DialUDP("udp", nil, b.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	for _, m := range []Message{
+		{Address: "/light/4/dimmer", Args: []interface{}{float32(0.5)}},
+		{Address: "/unmapped", Args: []interface{}{int32(1)}},
+		{Address: "/pad/36", Args: []interface{}{int32(120)}},
+	} {
+		bt, _ := m.MarshalBinary()
+		sender.Write(bt)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-out.written:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for MIDI message %v", i+1)
+		}
+	}
+
+	if got, want := fmt.Sprintf("% X", out.Bytes()), "B4 07 40 99 24 78"; got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package oscbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gomidi/mid/internal/bridge"
+)
+
+// Config defines the mapping between MIDI messages and OSC messages.
+// It is meant to be loaded from a JSON file, e.g.
+//
+//	{
+//	  "out": [
+//	    {"message": "noteon", "address": "/midi/{ch}/note", "args": ["key", "vel"]},
+//	    {"message": "cc", "address": "/midi/{ch}/cc/{cc}", "args": ["val"], "normalize": true}
+//	  ],
+//	  "in": [
+//	    {"address": "/light/{ch}/dimmer", "message": "cc", "args": ["val"], "defaults": {"cc": 7}, "normalize": true}
+//	  ]
+//	}
+//
+// The message types are noteon, noteoff, cc, program, pitchbend, aftertouch and polyaftertouch.
+// The fields are ch (channel 0-15), key, vel, cc (controller), val (controller value, pressure or pitch bend value)
+// and prog (program). Fields in curly braces within an address are replaced by (out)
+// or taken from (in) the corresponding path segment.
+type Config struct {
+	// Out maps MIDI messages read by the Reader to OSC messages
+	Out []OutMapping `json:"out"`
+
+	// In maps received OSC messages to Writer method calls
+	In []InMapping `json:"in"`
+}
+
+// OutMapping maps a MIDI message type to an OSC message
+type OutMapping struct {
+	// Message is the MIDI message type
+	Message string `json:"message"`
+
+	// Address is the OSC address template
+	Address string `json:"address"`
+
+	// Args are the fields that are passed as OSC arguments
+	Args []string `json:"args"`
+
+	// Channels restricts the mapping to the given channels. If empty, all channels are mapped.
+	Channels []uint8 `json:"channels,omitempty"`
+
+	// Normalize sends the arguments as floats between 0 and 1 (pitch bend between -1 and 1)
+	// instead of integers
+	Normalize bool `json:"normalize,omitempty"`
+}
+
+// InMapping maps an OSC address pattern to a MIDI message
+type InMapping struct {
+	// Address is the OSC address pattern. Fields in curly braces must fill a complete path segment.
+	Address string `json:"address"`
+
+	// Message is the MIDI message type
+	Message string `json:"message"`
+
+	// Args are the fields that are set by the OSC arguments (in order)
+	Args []string `json:"args"`
+
+	// Defaults are the values of fields that are neither in the address nor in the arguments
+	Defaults map[string]int `json:"defaults,omitempty"`
+
+	// Normalize expects float arguments between 0 and 1 (pitch bend between -1 and 1)
+	Normalize bool `json:"normalize,omitempty"`
+}
+
+// ReadConfig reads a JSON config from r
+func ReadConfig(r io.Reader) (*Config, error) {
+	var cfg Config
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("can't read OSC bridge config: %v", err)
+	}
+	return &cfg, nil
+}
+
+// ReadConfigFile reads a JSON config file
+func ReadConfigFile(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadConfig(f)
+}
+
+func messageType(name string) (int, error) {
+	t, ok := bridge.Type(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown message type %q", name)
+	}
+	return t, nil
+}
+
+func fields(names []string) ([]int, error) {
+	res := make([]int, len(names))
+	for i, n := range names {
+		f, ok := bridge.Field(n)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", n)
+		}
+		res[i] = f
+	}
+	return res, nil
+}
+
+// templatePart is either a literal or a field of an address template
+type templatePart struct {
+	literal string
+	field   int
+}
+
+func parseTemplate(tmpl string) ([]templatePart, error) {
+	var parts []templatePart
+	for len(tmpl) > 0 {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			parts = append(parts, templatePart{literal: tmpl, field: -1})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, templatePart{literal: tmpl[:i], field: -1})
+		}
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated field in address %q", tmpl)
+		}
+		f, ok := bridge.Field(tmpl[i+1 : i+j])
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q in address", tmpl[i+1:i+j])
+		}
+		parts = append(parts, templatePart{field: f})
+		tmpl = tmpl[i+j+1:]
+	}
+	return parts, nil
+}
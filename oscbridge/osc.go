@@ -0,0 +1,180 @@
+package oscbridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Message is an OSC message. The arguments may be of type int32, float32, string or []byte.
+type Message struct {
+	Address string
+	Args    []interface{}
+}
+
+// String represents the message as a string (for debugging)
+func (m Message) String() string {
+	return fmt.Sprintf("%s %v", m.Address, m.Args)
+}
+
+// pad returns the number of zero bytes needed to align n to 4 bytes
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+func writeString(bf *bytes.Buffer, s string) {
+	bf.WriteString(s)
+	// at least one terminating zero
+	bf.Write(make([]byte, 1+pad(len(s)+1)))
+}
+
+// MarshalBinary encodes the message as OSC packet
+func (m Message) MarshalBinary() ([]byte, error) {
+	var bf bytes.Buffer
+	writeString(&bf, m.Address)
+
+	tags := []byte{','}
+	var args bytes.Buffer
+
+	for _, a := range m.Args {
+		switch v := a.(type) {
+		case int32:
+			tags = append(tags, 'i')
+			binary.Write(&args, binary.BigEndian, v)
+		case float32:
+			tags = append(tags, 'f')
+			binary.Write(&args, binary.BigEndian, math.Float32bits(v))
+		case string:
+			tags = append(tags, 's')
+			writeString(&args, v)
+		case []byte:
+			tags = append(tags, 'b')
+			binary.Write(&args, binary.BigEndian, int32(len(v)))
+			args.Write(v)
+			args.Write(make([]byte, pad(len(v))))
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument type %T", a)
+		}
+	}
+
+	writeString(&bf, string(tags))
+	bf.Write(args.Bytes())
+	return bf.Bytes(), nil
+}
+
+func readString(b []byte) (s string, rest []byte, err error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, fmt.Errorf("unterminated OSC string")
+	}
+	n := i + 1 + pad(i+1)
+	if n > len(b) {
+		return "", nil, fmt.Errorf("OSC string not padded")
+	}
+	return string(b[:i]), b[n:], nil
+}
+
+// UnmarshalBinary decodes an OSC message
+func (m *Message) UnmarshalBinary(b []byte) (err error) {
+	m.Args = nil
+	m.Address, b, err = readString(b)
+	if err != nil {
+		return err
+	}
+
+	if len(b) == 0 {
+		// no type tags
+		return nil
+	}
+
+	var tags string
+	tags, b, err = readString(b)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 || tags[0] != ',' {
+		return fmt.Errorf("invalid OSC type tags %q", tags)
+	}
+
+	for _, t := range tags[1:] {
+		switch t {
+		case 'i', 'f':
+			if len(b) < 4 {
+				return fmt.Errorf("OSC argument too short")
+			}
+			v := binary.BigEndian.Uint32(b)
+			if t == 'i' {
+				m.Args = append(m.Args, int32(v))
+			} else {
+				m.Args = append(m.Args, math.Float32frombits(v))
+			}
+			b = b[4:]
+		case 's':
+			var s string
+			s, b, err = readString(b)
+			if err != nil {
+				return err
+			}
+			m.Args = append(m.Args, s)
+		case 'b':
+			if len(b) < 4 {
+				return fmt.Errorf("OSC argument too short")
+			}
+			n := int(binary.BigEndian.Uint32(b))
+			if n < 0 || len(b) < 4+n+pad(n) {
+				return fmt.Errorf("OSC blob too short")
+			}
+			m.Args = append(m.Args, append([]byte(nil), b[4:4+n]...))
+			b = b[4+n+pad(n):]
+		case 'T':
+			m.Args = append(m.Args, int32(1))
+		case 'F', 'N':
+			m.Args = append(m.Args, int32(0))
+		default:
+			return fmt.Errorf("unsupported OSC type tag %q", t)
+		}
+	}
+
+	return nil
+}
+
+// bundleTag starts every OSC bundle
+const bundleTag = "#bundle\x00"
+
+// parsePacket returns the messages of an OSC packet, which might be a (nested) bundle
+func parsePacket(b []byte) ([]Message, error) {
+	if !bytes.HasPrefix(b, []byte(bundleTag)) {
+		var m Message
+		if err := m.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		return []Message{m}, nil
+	}
+
+	if len(b) < 16 {
+		return nil, fmt.Errorf("OSC bundle too short")
+	}
+
+	// skip the time tag: the messages are handled immediately
+	b = b[16:]
+
+	var msgs []Message
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return msgs, fmt.Errorf("OSC bundle element too short")
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		if n < 0 || len(b) < 4+n {
+			return msgs, fmt.Errorf("OSC bundle element too short")
+		}
+		ms, err := parsePacket(b[4 : 4+n])
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, ms...)
+		b = b[4+n:]
+	}
+	return msgs, nil
+}
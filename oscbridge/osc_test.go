@@ -0,0 +1,51 @@
+package oscbridge
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundtrip(t *testing.T) {
+	m := Message{Address: "/midi/1/note", Args: []interface{}{int32(60), float32(0.5), "abc", []byte{1, 2, 3, 4, 5}}}
+
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary returned error: %v", err)
+	}
+
+	if len(b)%4 != 0 {
+		t.Errorf("len(packet) = %v is not a multiple of 4", len(b))
+	}
+
+	var got Message
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary returned error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %v; want %v", got, m)
+	}
+}
+
+func TestParseBundle(t *testing.T) {
+	a, _ := Message{Address: "/a", Args: []interface{}{int32(1)}}.MarshalBinary()
+	b, _ := Message{Address: "/b"}.MarshalBinary()
+
+	bundle := append([]byte(bundleTag), 0, 0, 0, 0, 0, 0, 0, 1)
+	for _, el := range [][]byte{a, b} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(el)))
+		bundle = append(bundle, n[:]...)
+		bundle = append(bundle, el...)
+	}
+
+	msgs, err := parsePacket(bundle)
+	if err != nil {
+		t.Fatalf("parsePacket returned error: %v", err)
+	}
+
+	if len(msgs) != 2 || msgs[0].Address != "/a" || msgs[1].Address != "/b" {
+		t.Errorf("parsePacket(bundle) = %v", msgs)
+	}
+}
@@ -30,15 +30,20 @@ import (
 // so they get no Position.
 type Reader struct {
 	tempoChanges      []tempoChange       // track tempo changes
+	tempoMx           sync.Mutex          // protects the tempoChanges and the position of live input
+	fixedTempoMap     bool                // the tempoChanges are known in advance (ReadSMFParallel)
 	header            smf.Header          // store the SMF header
 	logger            Logger              // optional logger
 	pos               *Position           // the current SMFPosition
 	errSMF            error               // error when reading SMF
 	midiReaderOptions []midireader.Option // options for the midireader
 	liveReader        midi.Reader
-	midiClocks        [3]*time.Time
+	midiClocks        [3]time.Time
 	clockmx           sync.Mutex // protect the midiClocks
 	ignoreMIDIClock   bool
+	port              portReader // reports the port of the current message, may be nil
+	watchdog          *activesenseWatchdog
+	notesOffOut       midi.Writer // receives all notes off messages when the connection is lost
 
 	channelRPN_NRPN [16][4]uint8 // channel -> [cc0,cc1,valcc0,valcc1], initial value [-1,-1,-1,-1]
 
@@ -205,6 +210,10 @@ type Reader struct {
 			// Activesense is called for a active sense message
 			Activesense func()
 
+			// ConnectionLost is called from another goroutine, if no message arrived within ActivesenseInterval
+			// after an active sense message. Watching starts again with the next active sense message.
+			ConnectionLost func()
+
 			// Start is called for a start message
 			Start func()
 
@@ -254,6 +263,24 @@ type Reader struct {
 
 			// Escape is called for an escaping system exclusive message
 			Escape func(p *Position, data []byte)
+
+			// IdentityReply is called for a complete universal system exclusive message
+			// that is an identity reply. If it is set, identity replies are not passed to Complete.
+			IdentityReply func(p *Position, deviceID uint8, id Identity)
+
+			// Tuning provides callbacks for MIDI Tuning Standard (MTS) messages.
+			// If a callback is set, the corresponding messages are not passed to Complete.
+			Tuning struct {
+
+				// BulkDump is called for a bulk tuning dump
+				BulkDump func(p *Position, deviceID uint8, dump MTSBulkDump)
+
+				// NoteChange is called for a single note tuning change (real-time and non-real-time)
+				NoteChange func(p *Position, deviceID uint8, change MTSNoteChange)
+
+				// ScaleOctave is called for a scale/octave tuning (1 byte and 2 byte format)
+				ScaleOctave func(p *Position, deviceID uint8, tuning MTSScaleOctave)
+			}
 		}
 	}
 }
@@ -0,0 +1,234 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gomidi/mid/ump"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/meta"
+	"github.com/gomidi/midi/midimessage/meta/meter"
+	"github.com/gomidi/midi/midireader"
+	"github.com/gomidi/midi/smf"
+)
+
+// ReadClipFile opens, reads and closes a complete MIDI 2.0 clip file (SMF2CLIP).
+// If the read content was a valid clip file, nil is returned.
+//
+// See ReadClip for the details.
+func (r *Reader) ReadClipFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.ReadClip(f)
+}
+
+// ReadClip reads a MIDI 2.0 clip file (SMF2CLIP) from src until the end of the clip is reached.
+//
+// ReadClip does not close the src.
+//
+// The clip is handled like a single track SMF: The delta clockstamp ticks per quarter note
+// are reported as metric ticks via the SMFHeader callback and the delta clockstamps
+// become the DeltaTicks of the Position that is passed to the callbacks.
+// Set tempo and set time signature messages are passed to the corresponding meta callbacks,
+// the end of the clip is reported as end of track.
+// MIDI 2.0 channel voice messages are translated to MIDI 1.0 messages; packets
+// without MIDI 1.0 equivalent are skipped.
+//
+// The callbacks must be attached before Reader.ReadClip is called
+// and they must not be unset or replaced until ReadClip returns.
+func (r *Reader) ReadClip(src io.Reader) error {
+	r.errSMF = nil
+	r.pos = &Position{}
+	r.reset()
+	rd := newClipReader(src, r.midiReaderOptions...)
+
+	err := rd.ReadHeader()
+	if err != nil {
+		return err
+	}
+	r.setHeader(rd.Header())
+	r.readSMF(rd)
+
+	if r.errSMF == smf.ErrFinished {
+		return nil
+	}
+	return r.errSMF
+}
+
+type clipEvent struct {
+	delta uint32
+	msg   midi.Message
+}
+
+// clipReader reads a clip file and fullfills the smf.Reader interface
+type clipReader struct {
+	src        io.Reader
+	rd         *ump.Reader
+	trans      ump.MIDI2To1
+	bf         bytes.Buffer
+	midiReader midi.Reader
+	header     smf.Header
+	headerRead bool
+	queue      []clipEvent
+	delta      uint32
+	lastDelta  uint32
+	track      int16
+	finished   bool
+	err        error
+}
+
+var _ smf.Reader = &clipReader{}
+
+func newClipReader(src io.Reader, options ...midireader.Option) *clipReader {
+	rd := &clipReader{src: src, rd: ump.NewReader(src), track: -1}
+	rd.midiReader = midireader.New(&rd.bf, nil, options...)
+	return rd
+}
+
+// ReadHeader reads the file header and the clip configuration header up to the start of the clip
+func (c *clipReader) ReadHeader() error {
+	if c.headerRead {
+		return c.err
+	}
+	c.headerRead = true
+
+	var magic [len(clipMagic)]byte
+	_, err := io.ReadFull(c.src, magic[:])
+	if err != nil || string(magic[:]) != clipMagic {
+		c.err = fmt.Errorf("not a SMF2CLIP file")
+		return c.err
+	}
+
+	var tpq uint16
+
+	for {
+		var p ump.Packet
+		p, err = c.rd.Read()
+		if err != nil {
+			c.err = fmt.Errorf("can't read clip configuration header: %v", err)
+			return c.err
+		}
+
+		if p.Type() == ump.Utility && p.Status() == ump.UtilityDCTPQ {
+			tpq = p.Time16()
+			continue
+		}
+
+		if p.Type() == ump.Stream && p.StreamStatus() == ump.StreamStartOfClip {
+			break
+		}
+
+		// configuration messages (e.g. the initial tempo) are reported at the start of the clip
+		c.handlePacket(p)
+	}
+
+	if tpq == 0 {
+		c.err = fmt.Errorf("missing delta clockstamp ticks per quarter note in clip configuration header")
+		return c.err
+	}
+
+	c.header = smf.Header{Format: smf.SMF0, NumTracks: 1, TimeFormat: smf.MetricTicks(tpq)}
+	c.delta = 0
+	return nil
+}
+
+// Header returns the header (as single track SMF)
+func (c *clipReader) Header() smf.Header {
+	return c.header
+}
+
+// Delta returns the ticks between the last read message and the message before
+func (c *clipReader) Delta() uint32 {
+	return c.lastDelta
+}
+
+// Track returns 0 once the first message has been read
+func (c *clipReader) Track() int16 {
+	return c.track
+}
+
+func (c *clipReader) Read() (midi.Message, error) {
+	if !c.headerRead {
+		if err := c.ReadHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	for len(c.queue) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		if c.finished {
+			c.err = smf.ErrFinished
+			return nil, c.err
+		}
+
+		p, err := c.rd.Read()
+		if err != nil {
+			c.err = fmt.Errorf("clip ended without end of clip message: %v", err)
+			return nil, c.err
+		}
+		c.handlePacket(p)
+	}
+
+	ev := c.queue[0]
+	c.queue = c.queue[1:]
+	c.lastDelta = ev.delta
+	c.track = 0
+	return ev.msg, nil
+}
+
+// add queues the message, taking the ticks that passed since the last queued message
+func (c *clipReader) add(msg midi.Message) {
+	c.queue = append(c.queue, clipEvent{c.delta, msg})
+	c.delta = 0
+}
+
+func (c *clipReader) handlePacket(p ump.Packet) {
+	switch p.Type() {
+	case ump.Utility:
+		if p.Status() == ump.UtilityDeltaClockstamp {
+			c.delta += p.Ticks()
+		}
+	case ump.Stream:
+		if p.StreamStatus() == ump.StreamEndOfClip {
+			c.add(meta.EndOfTrack)
+			c.finished = true
+		}
+	case ump.FlexData:
+		bank, status := p.FlexStatus()
+		if bank != 0 {
+			return
+		}
+		switch status {
+		case ump.FlexSetTempo:
+			c.add(meta.FractionalBPM(p.Tempo()))
+		case ump.FlexSetTimeSignature:
+			num, denomPower, thirtySeconds := p.TimeSignature()
+			if denomPower > 7 {
+				// the denominator would not fit into a byte
+				return
+			}
+			ts := meter.Meter(num, 1<<denomPower)
+			if thirtySeconds != 0 {
+				ts.DemiSemiQuaverPerQuarter = thirtySeconds
+			}
+			c.add(ts)
+		}
+	default:
+		for _, b := range c.trans.Translate(p) {
+			c.bf.Write(b)
+			msg, err := c.midiReader.Read()
+			if err != nil {
+				c.bf.Reset()
+				continue
+			}
+			c.add(msg)
+		}
+	}
+}
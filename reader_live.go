@@ -23,10 +23,13 @@ func (r *Reader) Read(src io.Reader) (err error) {
 	r.pos = nil
 	r.reset()
 	rd := midireader.New(src, r.dispatchRealTime, r.midiReaderOptions...)
-	return r.dispatch(rd)
+	err = r.dispatch(rd)
+	r.stopWatchdog()
+	return
 }
 
 func (r *Reader) dispatchRealTime(m realtime.Message) {
+	r.watchActivesense(m == realtime.Activesense)
 
 	// ticks (most important, must be sent every 10 milliseconds) comes first
 	if m == realtime.Tick {
@@ -54,35 +57,34 @@ func (r *Reader) dispatchRealTime(m realtime.Message) {
 
 		r.clockmx.Lock()
 
-		if r.midiClocks[0] == nil {
-			r.midiClocks[0] = &gotClock
+		if r.midiClocks[0].IsZero() {
+			r.midiClocks[0] = gotClock
+			r.clockmx.Unlock()
 			return
 		}
 
-		if r.midiClocks[1] == nil {
-			r.midiClocks[1] = &gotClock
+		if r.midiClocks[1].IsZero() {
+			r.midiClocks[1] = gotClock
+			r.clockmx.Unlock()
 			return
 		}
 
-		if r.midiClocks[2] == nil {
-			r.midiClocks[2] = &gotClock
+		if r.midiClocks[2].IsZero() {
+			r.midiClocks[2] = gotClock
+			r.clockmx.Unlock()
 			return
 		}
 
-		bpm := tempoBasedOnMIDIClocks(r.midiClocks[0], r.midiClocks[1], r.midiClocks[2], &gotClock)
+		bpm := tempoBasedOnMIDIClocks(&r.midiClocks[0], &r.midiClocks[1], &r.midiClocks[2], &gotClock)
 
 		// move them over
 		r.midiClocks[0] = r.midiClocks[1]
 		r.midiClocks[1] = r.midiClocks[2]
-		r.midiClocks[2] = &gotClock
+		r.midiClocks[2] = gotClock
 
 		r.clockmx.Unlock()
 
-		r.saveTempoChange(*r.pos, bpm)
-		if r.Msg.Meta.TempoBPM != nil {
-			r.Msg.Meta.TempoBPM(*r.pos, bpm)
-		}
-
+		r.liveTempoChange(bpm)
 		return
 	}
 
@@ -135,3 +137,20 @@ func (r *Reader) dispatchRealTime(m realtime.Message) {
 		return
 	}
 }
+
+// liveTempoChange registers a tempo change that has been detected while reading live MIDI
+// (maybe from another goroutine, see TempoDetector.Tap)
+func (r *Reader) liveTempoChange(bpm float64) {
+	r.tempoMx.Lock()
+	// when reading with Read, there is no position
+	var pos Position
+	if r.pos != nil {
+		pos = *r.pos
+	}
+	r.tempoMx.Unlock()
+
+	r.saveTempoChange(pos, bpm)
+	if r.Msg.Meta.TempoBPM != nil {
+		r.Msg.Meta.TempoBPM(pos, bpm)
+	}
+}
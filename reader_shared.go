@@ -12,7 +12,10 @@ import (
 )
 
 func (r *Reader) reset() {
+	r.stopWatchdog()
+	r.tempoMx.Lock()
 	r.tempoChanges = []tempoChange{tempoChange{0, 120}}
+	r.tempoMx.Unlock()
 
 	for c := 0; c < 16; c++ {
 		r.channelRPN_NRPN[c] = [4]uint8{0, 0, 0, 0}
@@ -20,7 +23,12 @@ func (r *Reader) reset() {
 }
 
 func (r *Reader) saveTempoChange(pos Position, bpm float64) {
+	if r.fixedTempoMap {
+		return
+	}
+	r.tempoMx.Lock()
 	r.tempoChanges = append(r.tempoChanges, tempoChange{pos.AbsoluteTicks, bpm})
+	r.tempoMx.Unlock()
 }
 
 // TimeAt returns the time.Duration at the given absolute position counted
@@ -31,21 +39,28 @@ func (r *Reader) TimeAt(absTicks uint64) *time.Duration {
 		return nil
 	}
 
+	r.tempoMx.Lock()
+	result := timeAt(r.resolution, r.tempoChanges, absTicks)
+	r.tempoMx.Unlock()
+	return &result
+}
+
+// timeAt returns the time.Duration at the given absolute position for the given tempo changes
+func timeAt(resolution smf.MetricTicks, tempoChanges []tempoChange, absTicks uint64) time.Duration {
 	var tc = tempoChange{0, 120}
 	var lastTick uint64
 	var lastDur time.Duration
-	for _, t := range r.tempoChanges {
+	for _, t := range tempoChanges {
 		if t.absTicks >= absTicks {
 			// println("stopping")
 			break
 		}
 		// println("pre", "lastDur", lastDur, "lastTick", lastTick, "bpm", tc.bpm)
-		lastDur += calcDeltaTime(r.resolution, uint32(t.absTicks-lastTick), tc.bpm)
+		lastDur += calcDeltaTime(resolution, uint32(t.absTicks-lastTick), tc.bpm)
 		tc = t
 		lastTick = t.absTicks
 	}
-	result := lastDur + calcDeltaTime(r.resolution, uint32(absTicks-lastTick), tc.bpm)
-	return &result
+	return lastDur + calcDeltaTime(resolution, uint32(absTicks-lastTick), tc.bpm)
 }
 
 // log does the logging
@@ -94,11 +109,10 @@ func (r *Reader) _RPN_NRPN_Reset(ch uint8, isRPN bool) {
 
 }
 
-func (r *Reader) sendAsCC(ch, cc, val uint8) error {
+func (r *Reader) sendAsCC(ch, cc, val uint8) {
 	if r.Msg.Channel.ControlChange.Each != nil {
 		r.Msg.Channel.ControlChange.Each(r.pos, ch, cc, val)
 	}
-	return nil
 }
 
 func (r *Reader) hasRPNCallback() bool {
@@ -122,6 +136,8 @@ func (r *Reader) dispatchMessage(rd midi.Reader) (err error) {
 		return
 	}
 
+	r.watchActivesense(false)
+
 	if frd, ok := rd.(smf.Reader); ok && r.pos != nil {
 		r.pos.DeltaTicks = frd.Delta()
 		r.pos.AbsoluteTicks += uint64(r.pos.DeltaTicks)
@@ -172,210 +188,7 @@ func (r *Reader) dispatchMessage(rd midi.Reader) (err error) {
 		}
 
 	case channel.ControlChange:
-		var (
-			ch  = msg.Channel()
-			val = msg.Value()
-			cc  = msg.Controller()
-		)
-
-		switch cc {
-
-		/*
-			Ok, lets explain the reasoning behind this confusing RPN/NRPN handling a bit.
-			There are the following observations:
-				- a channel can either have a RPN message or a NRPN message at a point in time
-				- the identifiers are sent via CC101 + CC100 for RPN and CC99 + CC98 for NRPN
-			    - the order of the identifier CC messages may vary in reality
-				- the identifiers are sent before the value
-				- the MSB is sent via CC6
-				- the LSB is sent via CC38
-
-			RPN and NRPN are never mixed at the same time on the same channel.
-			We want to always send complete valid RPN/NRPN messages to the callbacks.
-			For this to happen, each identifier is cached and when the MSB arrives and both identifiers are there,
-			the callback is called. If any of the conditions are not met, the callback is not called.
-		*/
-
-		// first identifier of a RPN/NRPN message
-		case 101, 99:
-			if (cc == 101 && !r.hasRPNCallback()) ||
-				(cc == 99 && !r.hasNRPNCallback()) {
-				return r.sendAsCC(ch, cc, val)
-			}
-
-			// RPN reset (127,127)
-			if val+r.channelRPN_NRPN[ch][3] == 2*127 {
-				r._RPN_NRPN_Reset(ch, cc == 101)
-			} else {
-				// register first ident cc
-				r.channelRPN_NRPN[ch][0] = cc
-				// track the first ident value
-				r.channelRPN_NRPN[ch][2] = val
-			}
-
-		// second identifier of a RPN/NRPN message
-		case 100, 98:
-			if (cc == 100 && !r.hasRPNCallback()) ||
-				(cc == 98 && !r.hasNRPNCallback()) {
-				return r.sendAsCC(ch, cc, val)
-			}
-
-			// RPN reset (127,127)
-			if val+r.channelRPN_NRPN[ch][2] == 2*127 {
-				r._RPN_NRPN_Reset(ch, cc == 100)
-			} else {
-				// register second ident cc
-				r.channelRPN_NRPN[ch][1] = cc
-				// track the second ident value
-				r.channelRPN_NRPN[ch][3] = val
-			}
-
-		// the data entry controller
-		case 6:
-			if r.hasNoRPNorNRPNCallback() {
-				println("early return on cc6")
-				return r.sendAsCC(ch, cc, val)
-			}
-			switch {
-
-			// is a valid RPN
-			case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
-				if r.Msg.Channel.ControlChange.RPN.MSB != nil {
-					r.Msg.Channel.ControlChange.RPN.MSB(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3],
-						val)
-				}
-				return
-
-			// is a valid NRPN
-			case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
-				if r.Msg.Channel.ControlChange.NRPN.MSB != nil {
-					r.Msg.Channel.ControlChange.NRPN.MSB(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3],
-						val)
-				}
-				return
-
-			// is no valid RPN/NRPN, send as controller change
-			default:
-				//				println("invalid RPN/NRPN on cc6")
-				return r.sendAsCC(ch, cc, val)
-			}
-
-		// the lsb
-		case 38:
-			if r.hasNoRPNorNRPNCallback() {
-				return r.sendAsCC(ch, cc, val)
-			}
-
-			switch {
-
-			// is a valid RPN
-			case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
-				if r.Msg.Channel.ControlChange.RPN.LSB != nil {
-					r.Msg.Channel.ControlChange.RPN.LSB(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3],
-						val)
-				}
-				return
-
-			// is a valid NRPN
-			case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
-				if r.Msg.Channel.ControlChange.NRPN.LSB != nil {
-					r.Msg.Channel.ControlChange.NRPN.LSB(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3],
-						val)
-				}
-				return
-
-			// is no valid RPN/NRPN, send as controller change
-			default:
-				return r.sendAsCC(ch, cc, val)
-			}
-
-		// the increment
-		case 96:
-			if r.Msg.Channel.ControlChange.RPN.Increment == nil && r.Msg.Channel.ControlChange.NRPN.Increment == nil {
-				return r.sendAsCC(ch, cc, val)
-			}
-			switch {
-
-			// is a valid RPN
-			case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
-				if r.Msg.Channel.ControlChange.RPN.Increment != nil {
-					r.Msg.Channel.ControlChange.RPN.Increment(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3])
-				}
-				return
-
-			// is a valid NRPN
-			case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
-				if r.Msg.Channel.ControlChange.NRPN.Increment != nil {
-					r.Msg.Channel.ControlChange.NRPN.Increment(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3])
-				}
-				return
-
-			// is no valid RPN/NRPN, send as controller change
-			default:
-				return r.sendAsCC(ch, cc, val)
-			}
-
-		// the decrement
-		case 97:
-			if r.Msg.Channel.ControlChange.RPN.Decrement == nil && r.Msg.Channel.ControlChange.NRPN.Decrement == nil {
-				return r.sendAsCC(ch, cc, val)
-			}
-			switch {
-
-			// is a valid RPN
-			case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
-				if r.Msg.Channel.ControlChange.RPN.Decrement != nil {
-					r.Msg.Channel.ControlChange.RPN.Decrement(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3])
-				}
-				return
-
-			// is a valid NRPN
-			case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
-				if r.Msg.Channel.ControlChange.NRPN.Decrement != nil {
-					r.Msg.Channel.ControlChange.NRPN.Decrement(
-						r.pos,
-						ch,
-						r.channelRPN_NRPN[ch][2],
-						r.channelRPN_NRPN[ch][3])
-				}
-				return
-
-			// is no valid RPN/NRPN, send as controller change
-			default:
-				return r.sendAsCC(ch, cc, val)
-			}
-
-		default:
-			return r.sendAsCC(ch, cc, val)
-		}
+		r.dispatchCC(msg.Channel(), msg.Controller(), msg.Value())
 
 	case meta.SMPTE:
 		if r.Msg.Meta.SMPTE != nil {
@@ -426,6 +239,9 @@ func (r *Reader) dispatchMessage(rd midi.Reader) (err error) {
 		}
 
 	case sysex.SysEx:
+		if r.dispatchUniversalSysEx(msg.Data()) {
+			return
+		}
 		if r.Msg.SysEx.Complete != nil {
 			r.Msg.SysEx.Complete(r.pos, msg.Data())
 		}
@@ -553,3 +369,267 @@ type tempoChange struct {
 func calcDeltaTime(mt smf.MetricTicks, deltaTicks uint32, bpm float64) time.Duration {
 	return mt.FractionalDuration(bpm, deltaTicks)
 }
+
+// dispatchCC dispatches a control change message, handling RPN and NRPN messages
+func (r *Reader) dispatchCC(ch, cc, val uint8) {
+	switch cc {
+
+	/*
+		Ok, lets explain the reasoning behind this confusing RPN/NRPN handling a bit.
+		There are the following observations:
+			- a channel can either have a RPN message or a NRPN message at a point in time
+			- the identifiers are sent via CC101 + CC100 for RPN and CC99 + CC98 for NRPN
+		    - the order of the identifier CC messages may vary in reality
+			- the identifiers are sent before the value
+			- the MSB is sent via CC6
+			- the LSB is sent via CC38
+
+		RPN and NRPN are never mixed at the same time on the same channel.
+		We want to always send complete valid RPN/NRPN messages to the callbacks.
+		For this to happen, each identifier is cached and when the MSB arrives and both identifiers are there,
+		the callback is called. If any of the conditions are not met, the callback is not called.
+	*/
+
+	// first identifier of a RPN/NRPN message
+	case 101, 99:
+		if (cc == 101 && !r.hasRPNCallback()) ||
+			(cc == 99 && !r.hasNRPNCallback()) {
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+		// RPN reset (127,127)
+		if val+r.channelRPN_NRPN[ch][3] == 2*127 {
+			r._RPN_NRPN_Reset(ch, cc == 101)
+		} else {
+			// register first ident cc
+			r.channelRPN_NRPN[ch][0] = cc
+			// track the first ident value
+			r.channelRPN_NRPN[ch][2] = val
+		}
+
+	// second identifier of a RPN/NRPN message
+	case 100, 98:
+		if (cc == 100 && !r.hasRPNCallback()) ||
+			(cc == 98 && !r.hasNRPNCallback()) {
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+		// RPN reset (127,127)
+		if val+r.channelRPN_NRPN[ch][2] == 2*127 {
+			r._RPN_NRPN_Reset(ch, cc == 100)
+		} else {
+			// register second ident cc
+			r.channelRPN_NRPN[ch][1] = cc
+			// track the second ident value
+			r.channelRPN_NRPN[ch][3] = val
+		}
+
+	// the data entry controller
+	case 6:
+		if r.hasNoRPNorNRPNCallback() {
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+		switch {
+
+		// is a valid RPN
+		case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
+			if r.Msg.Channel.ControlChange.RPN.MSB != nil {
+				r.Msg.Channel.ControlChange.RPN.MSB(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3],
+					val)
+			}
+			return
+
+		// is a valid NRPN
+		case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
+			if r.Msg.Channel.ControlChange.NRPN.MSB != nil {
+				r.Msg.Channel.ControlChange.NRPN.MSB(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3],
+					val)
+			}
+			return
+
+		// is no valid RPN/NRPN, send as controller change
+		default:
+			//				println("invalid RPN/NRPN on cc6")
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+	// the lsb
+	case 38:
+		if r.hasNoRPNorNRPNCallback() {
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+		switch {
+
+		// is a valid RPN
+		case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
+			if r.Msg.Channel.ControlChange.RPN.LSB != nil {
+				r.Msg.Channel.ControlChange.RPN.LSB(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3],
+					val)
+			}
+			return
+
+		// is a valid NRPN
+		case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
+			if r.Msg.Channel.ControlChange.NRPN.LSB != nil {
+				r.Msg.Channel.ControlChange.NRPN.LSB(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3],
+					val)
+			}
+			return
+
+		// is no valid RPN/NRPN, send as controller change
+		default:
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+	// the increment
+	case 96:
+		if r.Msg.Channel.ControlChange.RPN.Increment == nil && r.Msg.Channel.ControlChange.NRPN.Increment == nil {
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+		switch {
+
+		// is a valid RPN
+		case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
+			if r.Msg.Channel.ControlChange.RPN.Increment != nil {
+				r.Msg.Channel.ControlChange.RPN.Increment(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3])
+			}
+			return
+
+		// is a valid NRPN
+		case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
+			if r.Msg.Channel.ControlChange.NRPN.Increment != nil {
+				r.Msg.Channel.ControlChange.NRPN.Increment(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3])
+			}
+			return
+
+		// is no valid RPN/NRPN, send as controller change
+		default:
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+	// the decrement
+	case 97:
+		if r.Msg.Channel.ControlChange.RPN.Decrement == nil && r.Msg.Channel.ControlChange.NRPN.Decrement == nil {
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+		switch {
+
+		// is a valid RPN
+		case r.channelRPN_NRPN[ch][0] == 101 && r.channelRPN_NRPN[ch][1] == 100:
+			if r.Msg.Channel.ControlChange.RPN.Decrement != nil {
+				r.Msg.Channel.ControlChange.RPN.Decrement(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3])
+			}
+			return
+
+		// is a valid NRPN
+		case r.channelRPN_NRPN[ch][0] == 99 && r.channelRPN_NRPN[ch][1] == 98:
+			if r.Msg.Channel.ControlChange.NRPN.Decrement != nil {
+				r.Msg.Channel.ControlChange.NRPN.Decrement(
+					r.pos,
+					ch,
+					r.channelRPN_NRPN[ch][2],
+					r.channelRPN_NRPN[ch][3])
+			}
+			return
+
+		// is no valid RPN/NRPN, send as controller change
+		default:
+			r.sendAsCC(ch, cc, val)
+			return
+		}
+
+	default:
+		r.sendAsCC(ch, cc, val)
+		return
+	}
+}
+
+// dispatchChannel dispatches a channel message from its raw bytes, without going through a midi.Reader.
+// If noteOffVelocity is false, the velocity of note off messages is passed as 0, like the midireader does.
+func (r *Reader) dispatchChannel(status, d1, d2 uint8, noteOffVelocity bool) {
+	r.watchActivesense(false)
+
+	ch := status & 0x0F
+
+	switch status & 0xF0 {
+	case 0x90:
+		if d2 > 0 {
+			if r.Msg.Channel.NoteOn != nil {
+				r.Msg.Channel.NoteOn(r.pos, ch, d1, d2)
+			}
+			return
+		}
+		if r.Msg.Channel.NoteOff != nil {
+			r.Msg.Channel.NoteOff(r.pos, ch, d1, 0)
+		}
+
+	case 0x80:
+		if !noteOffVelocity {
+			d2 = 0
+		}
+		if r.Msg.Channel.NoteOff != nil {
+			r.Msg.Channel.NoteOff(r.pos, ch, d1, d2)
+		}
+
+	case 0xE0:
+		if r.Msg.Channel.Pitchbend != nil {
+			r.Msg.Channel.Pitchbend(r.pos, ch, int16(uint16(d2)<<7|uint16(d1))-8192)
+		}
+
+	case 0xA0:
+		if r.Msg.Channel.PolyAftertouch != nil {
+			r.Msg.Channel.PolyAftertouch(r.pos, ch, d1, d2)
+		}
+
+	case 0xD0:
+		if r.Msg.Channel.Aftertouch != nil {
+			r.Msg.Channel.Aftertouch(r.pos, ch, d1)
+		}
+
+	case 0xB0:
+		r.dispatchCC(ch, d1, d2)
+
+	case 0xC0:
+		if r.Msg.Channel.ProgramChange != nil {
+			r.Msg.Channel.ProgramChange(r.pos, ch, d1)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package mid
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/meta"
+	"github.com/gomidi/midi/smf"
+	"github.com/gomidi/midi/smf/smfreader"
+)
+
+// SMFEvent is a message of a SMF track
+type SMFEvent struct {
+	Position
+
+	// Time is the time since the start of the file, respecting the tempo changes of all tracks.
+	// It is 0 if the time format of the file is not smf.MetricTicks.
+	Time time.Duration
+
+	Message midi.Message
+}
+
+// SMFTracks are the tracks of a SMF file that has been parsed by ParseSMFTracks
+type SMFTracks struct {
+	// Header is the header of the file. NumTracks is the number of the found tracks.
+	Header smf.Header
+
+	// Tracks are the events of the tracks, in the order of the file
+	Tracks [][]SMFEvent
+
+	tempoChanges []tempoChange
+}
+
+// TimeAt returns the time.Duration at the given absolute position, like Reader.TimeAt.
+// If the time format is not of type smf.MetricTicks, nil is returned.
+func (s *SMFTracks) TimeAt(absTicks uint64) *time.Duration {
+	resolution, isMetric := s.Header.TimeFormat.(smf.MetricTicks)
+	if !isMetric {
+		return nil
+	}
+	d := timeAt(resolution, s.tempoChanges, absTicks)
+	return &d
+}
+
+// ParseSMFTracks parses the tracks of the SMF file that can be read from src concurrently into per track event lists
+// (at most runtime.GOMAXPROCS(0) tracks at the same time).
+// Afterwards the tempo map of all tracks is resolved, so that the times of the events are correct for every track.
+// The result does not depend on the order in which the tracks have been parsed.
+//
+// If a track can't be parsed, the error of the first such track is returned.
+func ParseSMFTracks(src io.ReaderAt, options ...smfreader.Option) (*SMFTracks, error) {
+	hd, division, chunks, err := readSMFChunks(src)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMFTracks{Header: hd, Tracks: make([][]SMFEvent, len(chunks))}
+	errs := make([]error, len(chunks))
+
+	parallel(len(chunks), func(i int) {
+		s.Tracks[i], errs[i] = parseSMFTrack(src, division, chunks[i], int16(i), options...)
+	})
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("can't parse track %v: %v", i, err)
+		}
+	}
+
+	// the tempo map; at the same ticks, the tempo changes of later tracks win
+	s.tempoChanges = []tempoChange{{0, 120}}
+	for _, track := range s.Tracks {
+		for _, ev := range track {
+			if tempo, ok := ev.Message.(meta.Tempo); ok {
+				s.tempoChanges = append(s.tempoChanges, tempoChange{ev.AbsoluteTicks, tempo.FractionalBPM()})
+			}
+		}
+	}
+	sort.SliceStable(s.tempoChanges, func(a, b int) bool { return s.tempoChanges[a].absTicks < s.tempoChanges[b].absTicks })
+
+	if resolution, isMetric := hd.TimeFormat.(smf.MetricTicks); isMetric {
+		parallel(len(s.Tracks), func(i int) {
+			resolveTimes(resolution, s.tempoChanges, s.Tracks[i])
+		})
+	}
+
+	return s, nil
+}
+
+// parallel calls fn for 0 <= i < n, with at most runtime.GOMAXPROCS(0) concurrent calls
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+}
+
+func parseSMFTrack(src io.ReaderAt, division uint16, c smfChunk, track int16, options ...smfreader.Option) (events []SMFEvent, err error) {
+	// the smfreader panics on some invalid data, which must not crash the other goroutines
+	defer func() {
+		if p := recover(); p != nil {
+			events, err = nil, fmt.Errorf("invalid track data: %v", p)
+		}
+	}()
+
+	rd, err := newTrackReader(src, division, c.start, c.end, 0, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var absTicks uint64
+	for {
+		m, err := rd.Read()
+		if err == smf.ErrFinished || err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		absTicks += uint64(rd.Delta())
+		events = append(events, SMFEvent{
+			Position: Position{Track: track, DeltaTicks: rd.Delta(), AbsoluteTicks: absTicks},
+			Message:  m,
+		})
+	}
+}
+
+// resolveTimes sets the times of the events of a track, like Reader.TimeAt does.
+// Since the events are ordered by ticks, the tempo map is walked only once.
+func resolveTimes(resolution smf.MetricTicks, tempoChanges []tempoChange, events []SMFEvent) {
+	var i int
+	var start time.Duration // the time of tempoChanges[i]
+	for e := range events {
+		absTicks := events[e].AbsoluteTicks
+		for i+1 < len(tempoChanges) && tempoChanges[i+1].absTicks < absTicks {
+			start += calcDeltaTime(resolution, uint32(tempoChanges[i+1].absTicks-tempoChanges[i].absTicks), tempoChanges[i].bpm)
+			i++
+		}
+		events[e].Time = start + calcDeltaTime(resolution, uint32(absTicks-tempoChanges[i].absTicks), tempoChanges[i].bpm)
+	}
+}
+
+// ReadSMFParallel reads the SMF file that can be read from src like ReadSMF, but the tracks are parsed
+// concurrently by ParseSMFTracks before any message is dispatched.
+// The messages are then dispatched track by track, in the order of the file.
+//
+// Since the tempo map is resolved before, Reader.TimeAt respects the tempo changes of all tracks
+// within the callbacks of every track.
+func (r *Reader) ReadSMFParallel(src io.ReaderAt, options ...smfreader.Option) error {
+	s, err := ParseSMFTracks(src, options...)
+	if err != nil {
+		return err
+	}
+
+	r.errSMF = nil
+	r.pos = &Position{}
+	r.reset()
+	r.setHeader(s.Header)
+	r.tempoMx.Lock()
+	r.tempoChanges = s.tempoChanges
+	r.tempoMx.Unlock()
+	r.fixedTempoMap = true
+	defer func() { r.fixedTempoMap = false }()
+
+	for i, track := range s.Tracks {
+		*r.pos = Position{Track: int16(i)}
+		r.readSMF(&smfEventReader{header: s.Header, events: track, track: int16(i)})
+
+		if r.errSMF != nil && r.errSMF != smf.ErrFinished {
+			return r.errSMF
+		}
+		r.errSMF = nil
+	}
+
+	return nil
+}
+
+// smfEventReader is a smf.Reader for the parsed events of a track
+type smfEventReader struct {
+	header smf.Header
+	events []SMFEvent
+	track  int16
+	next   int
+}
+
+func (e *smfEventReader) ReadHeader() error {
+	return nil
+}
+
+func (e *smfEventReader) Header() smf.Header {
+	return e.header
+}
+
+func (e *smfEventReader) Read() (midi.Message, error) {
+	if e.next >= len(e.events) {
+		return nil, smf.ErrFinished
+	}
+	e.next++
+	return e.events[e.next-1].Message, nil
+}
+
+func (e *smfEventReader) Delta() uint32 {
+	if e.next == 0 {
+		return 0
+	}
+	return e.events[e.next-1].DeltaTicks
+}
+
+func (e *smfEventReader) Track() int16 {
+	return e.track
+}
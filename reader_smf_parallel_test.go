@@ -0,0 +1,145 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/channel"
+)
+
+// writeParallelTestSMF writes a SMF file with three tracks, where the tempo changes are in the last track
+func writeParallelTestSMF() []byte {
+	var bf bytes.Buffer
+	wr := NewSMF(&bf, 3)
+
+	wr.Meter(4, 4)
+	wr.EndOfTrack()
+
+	for i := 0; i < 50; i++ {
+		wr.SetDelta(240)
+		wr.NoteOn(uint8(40+i), 100)
+		wr.SetDelta(240)
+		wr.NoteOff(uint8(40 + i))
+	}
+	wr.EndOfTrack()
+
+	wr.TempoBPM(100)
+	wr.SetDelta(3840)
+	wr.TempoBPM(140)
+	wr.SetDelta(7680)
+	wr.TempoBPM(60)
+	wr.EndOfTrack()
+
+	return bf.Bytes()
+}
+
+func TestParseSMFTracks(t *testing.T) {
+	data := writeParallelTestSMF()
+
+	rd := NewReader(NoLogger())
+	var want [3][]string
+	rd.Msg.Each = func(p *Position, m midi.Message) {
+		want[p.Track] = append(want[p.Track], fmt.Sprintf("%v d:%v %v", p.AbsoluteTicks, p.DeltaTicks, m))
+	}
+	if err := rd.ReadSMF(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadSMF returned error: %v", err)
+	}
+
+	s, err := ParseSMFTracks(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ParseSMFTracks returned error: %v", err)
+	}
+	if s.Header.NumTracks != 3 || len(s.Tracks) != 3 {
+		t.Fatalf("wrong number of tracks: %v", s.Header)
+	}
+
+	for i, track := range s.Tracks {
+		var got []string
+		for _, ev := range track {
+			if ev.Track != int16(i) {
+				t.Errorf("event of track %v has track %v", i, ev.Track)
+			}
+			got = append(got, fmt.Sprintf("%v d:%v %v", ev.AbsoluteTicks, ev.DeltaTicks, ev.Message))
+
+			if want := *rd.TimeAt(ev.AbsoluteTicks); ev.Time != want {
+				t.Errorf("track %v, %v ticks: Time = %v; want %v", i, ev.AbsoluteTicks, ev.Time, want)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want[i]) {
+			t.Errorf("track %v:\ngot  %v\nwant %v", i, got, want[i])
+		}
+	}
+
+	// 4 beats at 100 BPM and 8 beats at 140 BPM
+	if want := 2400*time.Millisecond + 3428571*time.Microsecond; *s.TimeAt(11520)-want > 100*time.Microsecond || want-*s.TimeAt(11520) > 100*time.Microsecond {
+		t.Errorf("TimeAt(11520) = %v; want %v", *s.TimeAt(11520), want)
+	}
+}
+
+func TestReadSMFParallel(t *testing.T) {
+	data := writeParallelTestSMF()
+
+	var sequential, parallel []string
+	record := func(rd *Reader, events *[]string) {
+		rd.Msg.Each = func(p *Position, m midi.Message) {
+			*events = append(*events, fmt.Sprintf("#%v %v d:%v %v", p.Track, p.AbsoluteTicks, p.DeltaTicks, m))
+		}
+	}
+
+	rd := NewReader(NoLogger())
+	record(rd, &sequential)
+	rd.ReadSMF(bytes.NewReader(data))
+	final := *rd.TimeAt(20000)
+
+	rd = NewReader(NoLogger())
+	record(rd, &parallel)
+	var times []time.Duration
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		times = append(times, *rd.TimeAt(p.AbsoluteTicks))
+	}
+	if err := rd.ReadSMFParallel(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadSMFParallel returned error: %v", err)
+	}
+
+	if fmt.Sprint(parallel) != fmt.Sprint(sequential) {
+		t.Errorf("got\n%v\nwant\n%v", parallel, sequential)
+	}
+
+	// the tempo changes of the last track are known in the callbacks of the second track
+	s, _ := ParseSMFTracks(bytes.NewReader(data))
+	var want []time.Duration
+	for _, ev := range s.Tracks[1] {
+		if _, ok := ev.Message.(channel.NoteOn); ok {
+			want = append(want, ev.Time)
+		}
+	}
+	if len(times) != 50 || fmt.Sprint(times) != fmt.Sprint(want) {
+		t.Errorf("wrong times in the callbacks:\ngot  %v\nwant %v", times, want)
+	}
+
+	if got := *rd.TimeAt(20000); got != final {
+		t.Errorf("TimeAt(20000) = %v; want %v", got, final)
+	}
+}
+
+func TestParseSMFTracksInvalid(t *testing.T) {
+	data := writeParallelTestSMF()
+
+	// an undefined status byte within the second track
+	corrupt := append([]byte{}, data...)
+	i := bytes.Index(corrupt, []byte("MTrk")) + 8
+	i = bytes.Index(corrupt[i:], []byte("MTrk")) + i + 8
+	corrupt[i+1] = 0xF4
+
+	for _, test := range [][]byte{nil, data[:10], corrupt} {
+		if _, err := ParseSMFTracks(bytes.NewReader(test)); err == nil {
+			t.Errorf("ParseSMFTracks(% X) must return an error", test)
+		}
+		if err := NewReader(NoLogger()).ReadSMFParallel(bytes.NewReader(test)); err == nil {
+			t.Errorf("ReadSMFParallel(% X) must return an error", test)
+		}
+	}
+}
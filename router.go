@@ -0,0 +1,425 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gomidi/connect"
+	"github.com/gomidi/mid/internal/midistream"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/realtime"
+	"github.com/gomidi/midi/midireader"
+)
+
+// RouteRule routes messages from an input to outputs of a Router.
+// A message is sent to the outputs of every rule that matches it.
+//
+// The message types are noteon, noteoff, cc, program, pitchbend, aftertouch, polyaftertouch
+// (channel messages) and sysex, syscommon and realtime.
+type RouteRule struct {
+	// Input is the name of the input. If empty, the rule matches all inputs.
+	Input string
+
+	// Outputs are the names of the outputs
+	Outputs []string
+
+	// Channels restricts the rule to channel messages of the given channels (0-15).
+	// If empty, the rule matches channel messages of all channels and system messages.
+	Channels []uint8
+
+	// Types restricts the rule to the given message types. If empty, all types match.
+	Types []string
+
+	// MinKey and MaxKey restrict note and polyphonic aftertouch messages to a key range.
+	// If MaxKey is 0, the range is not restricted. Other messages are not affected.
+	MinKey, MaxKey uint8
+
+	// Remap maps the channels of the matched channel messages to other channels
+	Remap map[uint8]uint8
+}
+
+// route message types
+const (
+	routeNoteOn = iota
+	routeNoteOff
+	routeCC
+	routeProgram
+	routePitchbend
+	routeAftertouch
+	routePolyAftertouch
+	routeSysEx
+	routeSysCommon
+	routeRealtime
+	numRouteTypes
+)
+
+var routeTypes = map[string]int{
+	"noteon":         routeNoteOn,
+	"noteoff":        routeNoteOff,
+	"cc":             routeCC,
+	"program":        routeProgram,
+	"pitchbend":      routePitchbend,
+	"aftertouch":     routeAftertouch,
+	"polyaftertouch": routePolyAftertouch,
+	"sysex":          routeSysEx,
+	"syscommon":      routeSysCommon,
+	"realtime":       routeRealtime,
+}
+
+// routeType returns the route message type of a complete raw message
+func routeType(msg []byte) int {
+	switch msg[0] & 0xF0 {
+	case 0x80:
+		return routeNoteOff
+	case 0x90:
+		if len(msg) > 2 && msg[2] == 0 {
+			return routeNoteOff
+		}
+		return routeNoteOn
+	case 0xA0:
+		return routePolyAftertouch
+	case 0xB0:
+		return routeCC
+	case 0xC0:
+		return routeProgram
+	case 0xD0:
+		return routeAftertouch
+	case 0xE0:
+		return routePitchbend
+	}
+
+	switch {
+	case msg[0] == 0xF0:
+		return routeSysEx
+	case midistream.IsRealtime(msg[0]):
+		return routeRealtime
+	}
+	return routeSysCommon
+}
+
+type routeRule struct {
+	input          string
+	outputs        []*routerOutput
+	channels       [16]bool
+	systemMessages bool
+	types          [numRouteTypes]bool
+	minKey, maxKey uint8
+	remap          [16]uint8
+}
+
+func (r *Router) compileRule(rr RouteRule) (c routeRule, err error) {
+	c.input = rr.Input
+
+	for _, name := range rr.Outputs {
+		out, ok := r.outputs[name]
+		if !ok {
+			return c, fmt.Errorf("unknown output %q", name)
+		}
+		c.outputs = append(c.outputs, out)
+	}
+
+	c.systemMessages = len(rr.Channels) == 0
+	for i := range c.channels {
+		c.channels[i] = len(rr.Channels) == 0
+	}
+	for _, ch := range rr.Channels {
+		if ch > 15 {
+			return c, fmt.Errorf("invalid channel %v", ch)
+		}
+		c.channels[ch] = true
+	}
+
+	for i := range c.types {
+		c.types[i] = len(rr.Types) == 0
+	}
+	for _, name := range rr.Types {
+		typ, ok := routeTypes[name]
+		if !ok {
+			return c, fmt.Errorf("unknown message type %q", name)
+		}
+		c.types[typ] = true
+	}
+
+	c.minKey, c.maxKey = rr.MinKey, rr.MaxKey
+	if c.maxKey == 0 {
+		c.maxKey = 127
+	}
+
+	for i := range c.remap {
+		c.remap[i] = uint8(i)
+	}
+	for from, to := range rr.Remap {
+		if from > 15 || to > 15 {
+			return c, fmt.Errorf("invalid channel mapping %v => %v", from, to)
+		}
+		c.remap[from] = to
+	}
+	return
+}
+
+func (c *routeRule) match(input string, typ int, msg []byte) bool {
+	if c.input != "" && c.input != input {
+		return false
+	}
+
+	if !c.types[typ] {
+		return false
+	}
+
+	if !midistream.IsChannelStatus(msg[0]) {
+		return c.systemMessages
+	}
+
+	if !c.channels[msg[0]&0x0F] {
+		return false
+	}
+
+	switch typ {
+	case routeNoteOn, routeNoteOff, routePolyAftertouch:
+		return msg[1] >= c.minKey && msg[1] <= c.maxKey
+	}
+	return true
+}
+
+type routerOutput struct {
+	name string
+	mx   sync.Mutex // messages are parsed and written atomically
+	wr   midi.Writer
+
+	// the parser reads the raw messages from bf, realtime messages are passed to rt
+	parser midi.Reader
+	bf     bytes.Buffer
+	rt     midi.Message
+}
+
+func newRouterOutput(name string, wr midi.Writer) *routerOutput {
+	o := &routerOutput{name: name, wr: wr}
+	o.parser = midireader.New(&o.bf, func(m realtime.Message) {
+		o.rt = m
+	}, midireader.NoteOffVelocity())
+	return o
+}
+
+// write parses the complete raw message and writes it
+func (o *routerOutput) write(msg []byte) error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	o.bf.Reset()
+	o.bf.Write(msg)
+	o.rt = nil
+
+	m, err := o.parser.Read()
+	if o.rt != nil {
+		m, err = o.rt, nil
+	}
+	if err != nil {
+		return err
+	}
+	return o.wr.Write(m)
+}
+
+// routedNote is a destination of a message: an output and the channel
+type routedNote struct {
+	out     *routerOutput
+	channel uint8
+}
+
+// Router routes the messages of named inputs to named outputs according to RouteRules.
+//
+// Messages from different inputs are merged into the outputs as complete messages, so system exclusive
+// messages are never interleaved with other messages. Note off messages are sent to the outputs that received
+// the corresponding note on message, even if the rules have been changed in between, so no notes get stuck.
+type Router struct {
+	mx      sync.Mutex
+	inputs  map[string]connect.In
+	outputs map[string]*routerOutput
+	rules   []routeRule
+
+	// running notes: input -> channel -> key -> destinations
+	notes map[string]*[16][128][]routedNote
+
+	// Error is called for errors of the outputs, if it is not nil
+	Error func(err error)
+}
+
+// NewRouter returns a Router without inputs, outputs and rules
+func NewRouter() *Router {
+	return &Router{
+		inputs:  map[string]connect.In{},
+		outputs: map[string]*routerOutput{},
+		notes:   map[string]*[16][128][]routedNote{},
+	}
+}
+
+// AddOutput adds the output with the given name, e.g. a Writer
+func (r *Router) AddOutput(name string, wr midi.Writer) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.outputs[name] = newRouterOutput(name, wr)
+}
+
+// AddInput starts routing the messages of in as input with the given name
+func (r *Router) AddInput(name string, in connect.In) error {
+	r.mx.Lock()
+	if _, has := r.inputs[name]; has {
+		r.mx.Unlock()
+		return fmt.Errorf("input %q already exists", name)
+	}
+	r.inputs[name] = in
+	r.mx.Unlock()
+
+	split := midistream.New(func(msg []byte) {
+		r.Route(name, msg)
+	})
+
+	return in.SetListener(func(data []byte, deltaMicroseconds int64) {
+		split.Write(data)
+	})
+}
+
+// Read routes the messages read from src as input with the given name until an error happens.
+// io.EOF is the expected error that is returned when reading should stop.
+func (r *Router) Read(name string, src io.Reader) error {
+	split := midistream.New(func(msg []byte) {
+		r.Route(name, msg)
+	})
+
+	buf := make([]byte, 256)
+	for {
+		n, err := src.Read(buf)
+		split.Write(buf[:n])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// SetRules replaces the rules. It may be called while routing is running.
+// The note off messages of running notes are still routed to the outputs that received their note on message,
+// even if the new rules would route them elsewhere.
+func (r *Router) SetRules(rules ...RouteRule) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	compiled := make([]routeRule, len(rules))
+	for i, rr := range rules {
+		c, err := r.compileRule(rr)
+		if err != nil {
+			return fmt.Errorf("invalid rule #%v: %v", i, err)
+		}
+		compiled[i] = c
+	}
+
+	r.rules = compiled
+	return nil
+}
+
+// Close stops listening to the inputs that had been added with AddInput
+func (r *Router) Close() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for name, in := range r.inputs {
+		in.StopListening()
+		delete(r.inputs, name)
+	}
+	return nil
+}
+
+func (r *Router) error(err error) {
+	if r.Error != nil {
+		r.Error(err)
+	}
+}
+
+// Route routes the complete raw message msg as if it arrived at the input with the given name
+func (r *Router) Route(input string, msg []byte) {
+	if len(msg) == 0 || msg[0] < 0x80 {
+		return
+	}
+
+	typ := routeType(msg)
+
+	r.mx.Lock()
+
+	if typ == routeNoteOff {
+		if notes := r.notes[input]; notes != nil && len(msg) > 2 {
+			ch, key := msg[0]&0x0F, msg[1]
+			if dests := notes[ch][key]; dests != nil {
+				notes[ch][key] = nil
+				r.mx.Unlock()
+				for _, d := range dests {
+					r.send(d.out, msg, d.channel)
+				}
+				return
+			}
+		}
+	}
+
+	// an output receives the message only once per channel, even if several rules route it there
+	var deliveries []routedNote
+
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.match(input, typ, msg) {
+			continue
+		}
+		ch := msg[0] & 0x0F
+		if midistream.IsChannelStatus(msg[0]) {
+			ch = rule.remap[ch]
+		}
+	outputs:
+		for _, out := range rule.outputs {
+			d := routedNote{out, ch}
+			for _, has := range deliveries {
+				if has == d {
+					continue outputs
+				}
+			}
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	if typ == routeNoteOn && len(deliveries) > 0 {
+		notes := r.notes[input]
+		if notes == nil {
+			notes = &[16][128][]routedNote{}
+			r.notes[input] = notes
+		}
+		// a retriggered key keeps the outputs of the sounding note, so that all of them receive the note off
+		dests := notes[msg[0]&0x0F][msg[1]]
+	deliveries:
+		for _, d := range deliveries {
+			for _, dest := range dests {
+				if dest == d {
+					continue deliveries
+				}
+			}
+			dests = append(dests, d)
+		}
+		notes[msg[0]&0x0F][msg[1]] = dests
+	}
+
+	r.mx.Unlock()
+
+	for _, d := range deliveries {
+		r.send(d.out, msg, d.channel)
+	}
+}
+
+// send writes the message to the output, setting the channel of channel messages
+func (r *Router) send(out *routerOutput, msg []byte, ch uint8) {
+	if midistream.IsChannelStatus(msg[0]) && msg[0]&0x0F != ch {
+		m := make([]byte, len(msg))
+		copy(m, msg)
+		m[0] = m[0]&0xF0 | ch
+		msg = m
+	}
+
+	if err := out.write(msg); err != nil {
+		r.error(fmt.Errorf("can't write to output %q: %v", out.name, err))
+	}
+}
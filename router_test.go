@@ -0,0 +1,171 @@
+package mid
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/gomidi/mid/mockdriver"
+)
+
+func TestRouterRules(t *testing.T) {
+	drv := mockdriver.New("test")
+	bass, lead := drv.NewOut("bass"), drv.NewOut("lead")
+	keys := drv.NewIn("keys")
+
+	r := NewRouter()
+	r.AddOutput("bass", WriteTo(bass))
+	r.AddOutput("lead", WriteTo(lead))
+
+	err := r.SetRules(
+		RouteRule{Input: "keys", Outputs: []string{"bass"}, Types: []string{"noteon", "noteoff"}, MaxKey: 59, Remap: map[uint8]uint8{0: 1}},
+		RouteRule{Input: "keys", Outputs: []string{"lead"}, Channels: []uint8{0}, MinKey: 60},
+		RouteRule{Input: "other", Outputs: []string{"bass", "lead"}},
+	)
+	if err != nil {
+		t.Fatalf("SetRules returned error: %v", err)
+	}
+
+	if err := r.AddInput("keys", keys); err != nil {
+		t.Fatalf("AddInput returned error: %v", err)
+	}
+
+	for _, msg := range [][]byte{
+		{0x90, 48, 100}, // bass, remapped to channel 1
+		{0x90, 72, 100}, // lead
+		{0xB0, 1, 64},   // lead (no key restriction for cc)
+		{0x91, 72, 100}, // channel 1: nothing
+		{0xF8},          // system messages only match rules without channels: nothing
+		{0x80, 48, 0},   // bass
+		{0x90, 72, 0},   // lead
+	} {
+		keys.Receive(msg, 0)
+	}
+
+	bass.AssertSent(t, []byte{0x91, 48, 100}, []byte{0x81, 48, 0})
+	lead.AssertSent(t, []byte{0x90, 72, 100}, []byte{0xB0, 1, 64}, []byte{0x90, 72, 0})
+
+	bass.Reset()
+	lead.Reset()
+
+	if err := r.Read("other", bytes.NewReader([]byte{0xF8, 0xC2, 5})); err != io.EOF {
+		t.Fatalf("Read returned %v; want io.EOF", err)
+	}
+
+	bass.AssertSent(t, []byte{0xF8}, []byte{0xC2, 5})
+	lead.AssertSent(t, []byte{0xF8}, []byte{0xC2, 5})
+}
+
+func TestRouterInvalidRules(t *testing.T) {
+	r := NewRouter()
+	r.AddOutput("out", NewWriter(io.Discard))
+
+	rules := []RouteRule{
+		{Outputs: []string{"missing"}},
+		{Outputs: []string{"out"}, Channels: []uint8{16}},
+		{Outputs: []string{"out"}, Types: []string{"foo"}},
+		{Outputs: []string{"out"}, Remap: map[uint8]uint8{0: 16}},
+	}
+
+	for _, rule := range rules {
+		if err := r.SetRules(rule); err == nil {
+			t.Errorf("SetRules(%v) must return an error", rule)
+		}
+	}
+}
+
+func TestRouterSysExMerge(t *testing.T) {
+	drv := mockdriver.New("test")
+	synth := drv.NewOut("synth")
+	a, b := drv.NewIn("a"), drv.NewIn("b")
+
+	r := NewRouter()
+	r.AddOutput("synth", WriteTo(synth))
+	r.SetRules(RouteRule{Outputs: []string{"synth"}})
+	r.AddInput("a", a)
+	r.AddInput("b", b)
+
+	// the system exclusive message of a arrives in two parts, b sends a note in between
+	a.Receive([]byte{0xF0, 0x7E, 0x7F}, 0)
+	b.Receive([]byte{0x90, 60, 100}, 0)
+	a.Receive([]byte{0x06, 0x01, 0xF7}, 0)
+
+	synth.AssertSent(t, []byte{0x90, 60, 100}, []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7})
+
+	r.Close()
+	if a.IsListening() || b.IsListening() {
+		t.Errorf("inputs must not be listened to after Close")
+	}
+}
+
+func TestRouterReload(t *testing.T) {
+	drv := mockdriver.New("test")
+	synthA, synthB := drv.NewOut("a"), drv.NewOut("b")
+	keys := drv.NewIn("keys")
+
+	r := NewRouter()
+	r.AddOutput("a", WriteTo(synthA))
+	r.AddOutput("b", WriteTo(synthB))
+	r.SetRules(RouteRule{Outputs: []string{"a"}, Remap: map[uint8]uint8{0: 2}})
+	r.AddInput("keys", keys)
+
+	keys.Receive([]byte{0x90, 60, 100}, 0)
+
+	// switch the sound while the note is held
+	r.SetRules(RouteRule{Outputs: []string{"b"}})
+
+	keys.Receive([]byte{0x90, 62, 100}, 0)
+	keys.Receive([]byte{0x80, 60, 0}, 0)
+	keys.Receive([]byte{0x80, 62, 0}, 0)
+
+	synthA.AssertSent(t, []byte{0x92, 60, 100}, []byte{0x82, 60, 0})
+	synthB.AssertSent(t, []byte{0x90, 62, 100}, []byte{0x80, 62, 0})
+}
+
+func TestRouterRetrigger(t *testing.T) {
+	drv := mockdriver.New("test")
+	synthA, synthB := drv.NewOut("a"), drv.NewOut("b")
+	keys := drv.NewIn("keys")
+
+	r := NewRouter()
+	r.AddOutput("a", WriteTo(synthA))
+	r.AddOutput("b", WriteTo(synthB))
+	r.SetRules(RouteRule{Outputs: []string{"a"}})
+	r.AddInput("keys", keys)
+
+	keys.Receive([]byte{0x90, 60, 100}, 0)
+
+	// the key is played again after the rules changed
+	r.SetRules(RouteRule{Outputs: []string{"b"}})
+	keys.Receive([]byte{0x90, 60, 90}, 0)
+	keys.Receive([]byte{0x80, 60, 0}, 0)
+
+	synthA.AssertSent(t, []byte{0x90, 60, 100}, []byte{0x80, 60, 0})
+	synthB.AssertSent(t, []byte{0x90, 60, 90}, []byte{0x80, 60, 0})
+}
+
+func TestRouterOverlappingRules(t *testing.T) {
+	drv := mockdriver.New("test")
+	synth := drv.NewOut("synth")
+	keys := drv.NewIn("keys")
+
+	r := NewRouter()
+	r.AddOutput("synth", WriteTo(synth))
+	r.SetRules(
+		RouteRule{Outputs: []string{"synth"}},
+		RouteRule{Input: "keys", Outputs: []string{"synth", "synth"}, Types: []string{"noteon", "noteoff", "realtime"}},
+		RouteRule{Outputs: []string{"synth"}, Remap: map[uint8]uint8{0: 2}},
+	)
+	r.AddInput("keys", keys)
+
+	keys.Receive([]byte{0x90, 60, 100}, 0)
+	keys.Receive([]byte{0xF8}, 0)
+	keys.Receive([]byte{0x80, 60, 0}, 0)
+
+	// once per channel
+	synth.AssertSent(t,
+		[]byte{0x90, 60, 100}, []byte{0x92, 60, 100},
+		[]byte{0xF8},
+		[]byte{0x80, 60, 0}, []byte{0x82, 60, 0},
+	)
+}
@@ -0,0 +1,130 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// commands of the AppleMIDI session protocol
+const (
+	cmdInvitation         = "IN"
+	cmdInvitationAccepted = "OK"
+	cmdInvitationRejected = "NO"
+	cmdEndSession         = "BY"
+	cmdClockSync          = "CK"
+	cmdReceiverFeedback   = "RS"
+)
+
+// protocolVersion is the version of the AppleMIDI session protocol
+const protocolVersion = 2
+
+// signature starts every AppleMIDI command packet
+var signature = []byte{0xFF, 0xFF}
+
+// isCommand returns true, if the packet is an AppleMIDI command packet (and not an RTP packet)
+func isCommand(b []byte) bool {
+	return len(b) >= 4 && b[0] == 0xFF && b[1] == 0xFF
+}
+
+// command returns the command of an AppleMIDI command packet
+func command(b []byte) string {
+	return string(b[2:4])
+}
+
+// exchange is an invitation (IN), invitation accepted (OK), invitation rejected (NO) or end session (BY) packet
+type exchange struct {
+	Command string
+	Token   uint32
+	SSRC    uint32
+	Name    string
+}
+
+func (e exchange) MarshalBinary() ([]byte, error) {
+	var bf bytes.Buffer
+	bf.Write(signature)
+	bf.WriteString(e.Command)
+	binary.Write(&bf, binary.BigEndian, uint32(protocolVersion))
+	binary.Write(&bf, binary.BigEndian, e.Token)
+	binary.Write(&bf, binary.BigEndian, e.SSRC)
+	if e.Command != cmdEndSession {
+		bf.WriteString(e.Name)
+		bf.WriteByte(0)
+	}
+	return bf.Bytes(), nil
+}
+
+func (e *exchange) UnmarshalBinary(b []byte) error {
+	if !isCommand(b) || len(b) < 16 {
+		return fmt.Errorf("invalid AppleMIDI exchange packet")
+	}
+
+	e.Command = command(b)
+
+	if v := binary.BigEndian.Uint32(b[4:]); v != protocolVersion {
+		return fmt.Errorf("unsupported AppleMIDI protocol version %v", v)
+	}
+
+	e.Token = binary.BigEndian.Uint32(b[8:])
+	e.SSRC = binary.BigEndian.Uint32(b[12:])
+	e.Name = string(bytes.TrimRight(b[16:], "\x00"))
+	return nil
+}
+
+// clockSync is a clock synchronization (CK) packet. The timestamps are in units of 100 microseconds.
+type clockSync struct {
+	SSRC       uint32
+	Count      uint8
+	Timestamps [3]uint64
+}
+
+func (c clockSync) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 36)
+	copy(b, signature)
+	copy(b[2:], cmdClockSync)
+	binary.BigEndian.PutUint32(b[4:], c.SSRC)
+	b[8] = c.Count
+	for i, ts := range c.Timestamps {
+		binary.BigEndian.PutUint64(b[12+i*8:], ts)
+	}
+	return b, nil
+}
+
+func (c *clockSync) UnmarshalBinary(b []byte) error {
+	if !isCommand(b) || command(b) != cmdClockSync || len(b) < 36 {
+		return fmt.Errorf("invalid AppleMIDI clock synchronization packet")
+	}
+
+	c.SSRC = binary.BigEndian.Uint32(b[4:])
+	c.Count = b[8]
+	for i := range c.Timestamps {
+		c.Timestamps[i] = binary.BigEndian.Uint64(b[12+i*8:])
+	}
+	return nil
+}
+
+// receiverFeedback is a receiver feedback (RS) packet, that acknowledges the received packets
+// up to the given sequence number
+type receiverFeedback struct {
+	SSRC           uint32
+	SequenceNumber uint16
+}
+
+func (r receiverFeedback) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 12)
+	copy(b, signature)
+	copy(b[2:], cmdReceiverFeedback)
+	binary.BigEndian.PutUint32(b[4:], r.SSRC)
+	binary.BigEndian.PutUint16(b[8:], r.SequenceNumber)
+	return b, nil
+}
+
+func (r *receiverFeedback) UnmarshalBinary(b []byte) error {
+	if !isCommand(b) || command(b) != cmdReceiverFeedback || len(b) < 12 {
+		return fmt.Errorf("invalid AppleMIDI receiver feedback packet")
+	}
+
+	r.SSRC = binary.BigEndian.Uint32(b[4:])
+	r.SequenceNumber = binary.BigEndian.Uint16(b[8:])
+	return nil
+}
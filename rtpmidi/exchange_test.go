@@ -0,0 +1,47 @@
+package rtpmidi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExchange(t *testing.T) {
+	tests := []exchange{
+		{Command: cmdInvitation, Token: 0x12345678, SSRC: 0xCAFE, Name: "session"},
+		{Command: cmdInvitationAccepted, Token: 1, SSRC: 2, Name: ""},
+		{Command: cmdEndSession, Token: 0, SSRC: 3},
+	}
+
+	for _, test := range tests {
+		b, _ := test.MarshalBinary()
+		var got exchange
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Errorf("UnmarshalBinary(% X) returned error: %v", b, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test) {
+			t.Errorf("UnmarshalBinary(MarshalBinary(%v)) = %v", test, got)
+		}
+	}
+}
+
+func TestClockSyncAndFeedback(t *testing.T) {
+	c := clockSync{SSRC: 7, Count: 2, Timestamps: [3]uint64{1, 1 << 40, 3}}
+	b, _ := c.MarshalBinary()
+	if len(b) != 36 {
+		t.Errorf("len(clock sync) = %v; want 36", len(b))
+	}
+
+	var gotC clockSync
+	if err := gotC.UnmarshalBinary(b); err != nil || gotC != c {
+		t.Errorf("clock sync roundtrip: %v, %v", gotC, err)
+	}
+
+	r := receiverFeedback{SSRC: 7, SequenceNumber: 0xABCD}
+	b, _ = r.MarshalBinary()
+
+	var gotR receiverFeedback
+	if err := gotR.UnmarshalBinary(b); err != nil || gotR != r {
+		t.Errorf("receiver feedback roundtrip: %v, %v", gotR, err)
+	}
+}
@@ -0,0 +1,363 @@
+package rtpmidi
+
+import (
+	"fmt"
+)
+
+// channelState is the state of a MIDI channel, as far as it is covered by the recovery journal
+// (chapters P, C, W and N)
+type channelState struct {
+	program     int16      // -1 if unknown
+	controllers [128]int16 // -1 if unknown
+	pitchbend   int32      // -1 if unknown, otherwise LSB | MSB<<7
+	notes       [128]uint8 // velocity of the playing notes, 0 if not playing
+	offs        [128]bool  // notes that were released since the checkpoint
+	changed     bool       // changed since the checkpoint
+	seq         uint16     // sequence number of the last change
+}
+
+func newChannelState() *channelState {
+	c := &channelState{program: -1, pitchbend: -1}
+	for i := range c.controllers {
+		c.controllers[i] = -1
+	}
+	return c
+}
+
+// track updates the state for the given channel message
+func (c *channelState) track(msg []byte) {
+	switch msg[0] & 0xF0 {
+	case 0x80:
+		c.notes[msg[1]] = 0
+		c.offs[msg[1]] = true
+	case 0x90:
+		c.notes[msg[1]] = msg[2]
+		if msg[2] == 0 {
+			c.offs[msg[1]] = true
+		} else {
+			c.offs[msg[1]] = false
+		}
+	case 0xB0:
+		c.controllers[msg[1]] = int16(msg[2])
+	case 0xC0:
+		c.program = int16(msg[1])
+	case 0xE0:
+		c.pitchbend = int32(msg[1]) | int32(msg[2])<<7
+	}
+}
+
+// isJournaled returns true for messages that are covered by the journal
+func isJournaled(msg []byte) bool {
+	if len(msg) < 2 {
+		return false
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90, 0xB0, 0xE0:
+		return len(msg) >= 3
+	case 0xC0:
+		return true
+	}
+	return false
+}
+
+// journalWriter keeps the sender state and writes the recovery journal
+type journalWriter struct {
+	checkpoint uint16
+	channels   [16]*channelState
+}
+
+// track records the messages that are sent with the packet of the given sequence number
+func (j *journalWriter) track(seq uint16, msg []byte) {
+	if !isJournaled(msg) {
+		return
+	}
+
+	ch := msg[0] & 0x0F
+	if j.channels[ch] == nil {
+		j.channels[ch] = newChannelState()
+	}
+	c := j.channels[ch]
+
+	if !c.changed {
+		c.changed = true
+		c.offs = [128]bool{}
+	}
+	c.seq = seq
+	c.track(msg)
+}
+
+// acknowledge is called for receiver feedback: the packets up to seq have been received,
+// so the journal can start after them
+func (j *journalWriter) acknowledge(seq uint16) {
+	j.checkpoint = seq
+	for _, c := range j.channels {
+		// serial number arithmetic
+		if c != nil && c.changed && int16(c.seq-seq) <= 0 {
+			c.changed = false
+			c.offs = [128]bool{}
+		}
+	}
+}
+
+// marshal returns the recovery journal or nil, if no channel changed since the checkpoint
+func (j *journalWriter) marshal() []byte {
+	var chans [][]byte
+
+	for ch, c := range j.channels {
+		if c != nil && c.changed {
+			chans = append(chans, marshalChannel(uint8(ch), c))
+		}
+	}
+
+	if len(chans) == 0 {
+		return nil
+	}
+
+	// header: S=0 Y=0 A=1 H=0 TOTCHAN, checkpoint sequence number
+	b := []byte{0x20 | byte(len(chans)-1), byte(j.checkpoint >> 8), byte(j.checkpoint)}
+	for _, cj := range chans {
+		b = append(b, cj...)
+	}
+	return b
+}
+
+// table of contents bits of a channel journal
+const (
+	tocP = 0x80
+	tocC = 0x40
+	tocM = 0x20
+	tocW = 0x10
+	tocN = 0x08
+)
+
+func marshalChannel(ch uint8, c *channelState) []byte {
+	b := []byte{ch << 3, 0, 0}
+	var toc byte
+
+	if c.program >= 0 {
+		toc |= tocP
+		b = append(b, byte(c.program), 0, 0)
+	}
+
+	var ctrls []byte
+	for num, val := range c.controllers {
+		if val >= 0 {
+			ctrls = append(ctrls, byte(num), byte(val))
+		}
+	}
+	if len(ctrls) > 0 {
+		toc |= tocC
+		b = append(b, byte(len(ctrls)/2-1))
+		b = append(b, ctrls...)
+	}
+
+	if c.pitchbend >= 0 {
+		toc |= tocW
+		b = append(b, byte(c.pitchbend&0x7F), byte(c.pitchbend>>7))
+	}
+
+	var logs []byte
+	for key, vel := range c.notes {
+		if vel > 0 && len(logs) < 2*127 {
+			// Y=1: the note should be played
+			logs = append(logs, byte(key), 0x80|vel)
+		}
+	}
+
+	low, high := -1, -1
+	for key, off := range c.offs {
+		if off {
+			if low < 0 {
+				low = key / 8
+			}
+			high = key / 8
+		}
+	}
+
+	if len(logs) > 0 || low >= 0 {
+		toc |= tocN
+		if low < 0 {
+			// LOW > HIGH: no offbits
+			low, high = 1, 0
+		}
+		b = append(b, byte(len(logs)/2), byte(low<<4|high))
+		b = append(b, logs...)
+		for o := low; o <= high; o++ {
+			var bits byte
+			for i := 0; i < 8; i++ {
+				if c.offs[o*8+i] {
+					bits |= 0x80 >> uint(i)
+				}
+			}
+			b = append(b, bits)
+		}
+	}
+
+	length := len(b)
+	b[0] |= byte(length>>8) & 0x03
+	b[1] = byte(length)
+	b[2] = toc
+	return b
+}
+
+// journalReader keeps the receiver state and recovers it from the journal of a packet
+type journalReader struct {
+	channels [16]*channelState
+}
+
+func (j *journalReader) channel(ch uint8) *channelState {
+	if j.channels[ch] == nil {
+		j.channels[ch] = newChannelState()
+	}
+	return j.channels[ch]
+}
+
+// track records a received message
+func (j *journalReader) track(msg []byte) {
+	if isJournaled(msg) {
+		j.channel(msg[0] & 0x0F).track(msg)
+	}
+}
+
+// recover parses the journal and returns the messages that are needed to
+// get the receiver state in sync with the sender state
+func (j *journalReader) recover(journal []byte) (msgs [][]byte, err error) {
+	if len(journal) < 3 {
+		return nil, fmt.Errorf("recovery journal too short")
+	}
+
+	hd := journal[0]
+	b := journal[3:]
+
+	if hd&0x40 != 0 {
+		// skip the system journal
+		if len(b) < 2 {
+			return nil, fmt.Errorf("system journal too short")
+		}
+		l := int(b[0]&0x03)<<8 | int(b[1])
+		if len(b) < l {
+			return nil, fmt.Errorf("system journal too short")
+		}
+		b = b[l:]
+	}
+
+	if hd&0x20 == 0 {
+		return nil, nil
+	}
+
+	for n := int(hd&0x0F) + 1; n > 0; n-- {
+		if len(b) < 3 {
+			return msgs, fmt.Errorf("channel journal too short")
+		}
+		l := int(b[0]&0x03)<<8 | int(b[1])
+		if l < 3 || len(b) < l {
+			return msgs, fmt.Errorf("invalid channel journal length")
+		}
+		ch := (b[0] >> 3) & 0x0F
+		msgs, err = j.recoverChannel(msgs, ch, b[2], b[3:l])
+		if err != nil {
+			return
+		}
+		b = b[l:]
+	}
+
+	for _, msg := range msgs {
+		j.track(msg)
+	}
+	return
+}
+
+func (j *journalReader) recoverChannel(msgs [][]byte, ch uint8, toc byte, b []byte) ([][]byte, error) {
+	c := j.channel(ch)
+	errShort := fmt.Errorf("channel journal chapter too short")
+
+	if toc&tocP != 0 {
+		if len(b) < 3 {
+			return msgs, errShort
+		}
+		if prog := int16(b[0] & 0x7F); prog != c.program {
+			msgs = append(msgs, []byte{0xC0 | ch, byte(prog)})
+		}
+		b = b[3:]
+	}
+
+	if toc&tocC != 0 {
+		if len(b) < 1 {
+			return msgs, errShort
+		}
+		n := int(b[0]&0x7F) + 1
+		if len(b) < 1+2*n {
+			return msgs, errShort
+		}
+		for i := 0; i < n; i++ {
+			num, val := b[1+2*i]&0x7F, b[2+2*i]
+			// A=1 logs (toggle or count tools) are not supported
+			if val&0x80 == 0 && int16(val) != c.controllers[num] {
+				msgs = append(msgs, []byte{0xB0 | ch, num, val})
+			}
+		}
+		b = b[1+2*n:]
+	}
+
+	if toc&tocM != 0 {
+		if len(b) < 2 {
+			return msgs, errShort
+		}
+		l := int(b[0]&0x03)<<8 | int(b[1])
+		if len(b) < l {
+			return msgs, errShort
+		}
+		b = b[l:]
+	}
+
+	if toc&tocW != 0 {
+		if len(b) < 2 {
+			return msgs, errShort
+		}
+		lsb, msb := b[0]&0x7F, b[1]&0x7F
+		if int32(lsb)|int32(msb)<<7 != c.pitchbend {
+			msgs = append(msgs, []byte{0xE0 | ch, lsb, msb})
+		}
+		b = b[2:]
+	}
+
+	if toc&tocN != 0 {
+		if len(b) < 2 {
+			return msgs, errShort
+		}
+		n := int(b[0] & 0x7F)
+		low, high := int(b[1]>>4), int(b[1]&0x0F)
+		if n == 127 && low == 15 && high == 0 {
+			n = 128
+		}
+		b = b[2:]
+		if len(b) < 2*n {
+			return msgs, errShort
+		}
+
+		var logged [128]bool
+		for i := 0; i < n; i++ {
+			key, vel := b[2*i]&0x7F, b[2*i+1]&0x7F
+			logged[key] = true
+			if c.notes[key] == 0 && b[2*i+1]&0x80 != 0 && vel > 0 {
+				msgs = append(msgs, []byte{0x90 | ch, key, vel})
+			}
+		}
+		b = b[2*n:]
+
+		for o := low; o <= high; o++ {
+			if len(b) < 1 {
+				return msgs, errShort
+			}
+			for i := 0; i < 8; i++ {
+				key := o*8 + i
+				if b[0]&(0x80>>uint(i)) != 0 && !logged[key] && c.notes[key] > 0 {
+					msgs = append(msgs, []byte{0x80 | ch, byte(key), 0})
+				}
+			}
+			b = b[1:]
+		}
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,64 @@
+package rtpmidi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestJournalRecovery(t *testing.T) {
+	var (
+		jw journalWriter
+		jr journalReader
+	)
+
+	// sequence number -> messages
+	packets := []struct {
+		msgs [][]byte
+		lost bool
+	}{
+		{[][]byte{{0xC0, 5}, {0x90, 60, 100}, {0x90, 62, 90}}, false},
+		{[][]byte{{0x80, 60, 0}, {0xB0, 7, 80}}, true},
+		{[][]byte{{0x91, 40, 70}, {0xE0, 0, 80}}, true},
+		{[][]byte{{0x90, 64, 100}}, false},
+	}
+
+	var recovered [][]byte
+
+	for i, p := range packets {
+		seq := uint16(i + 1)
+		journal := jw.marshal()
+
+		if !p.lost {
+			if i > 0 && packets[i-1].lost {
+				var err error
+				recovered, err = jr.recover(journal)
+				if err != nil {
+					t.Fatalf("recover returned error: %v", err)
+				}
+			}
+			for _, msg := range p.msgs {
+				jr.track(msg)
+			}
+		}
+
+		for _, msg := range p.msgs {
+			jw.track(seq, msg)
+		}
+	}
+
+	want := "[[176 7 80] [224 0 80] [128 60 0] [145 40 70]]"
+	if got := fmt.Sprint(recovered); got != want {
+		t.Errorf("recovered %v; want %v", got, want)
+	}
+
+	// after the feedback only channel 0 (changed by the last packet) is in the journal
+	jw.acknowledge(3)
+	if b := jw.marshal(); b == nil || b[0]&0x0F != 0 {
+		t.Errorf("journal after feedback: % X", b)
+	}
+
+	jw.acknowledge(4)
+	if b := jw.marshal(); b != nil {
+		t.Errorf("journal after complete feedback must be nil, but is % X", b)
+	}
+}
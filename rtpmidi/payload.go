@@ -0,0 +1,194 @@
+package rtpmidi
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// payloadType is the dynamic RTP payload type used by AppleMIDI
+const payloadType = 0x61
+
+// maxCommandSection is the largest MIDI command section (12bit length)
+const maxCommandSection = 0x0FFF
+
+// midiCommand is a MIDI command of the MIDI list, with the delta time (in RTP timestamp units)
+// from the previous command
+type midiCommand struct {
+	Delta uint32
+	Data  []byte
+}
+
+// rtpPacket is an RTP-MIDI packet
+type rtpPacket struct {
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	Commands       []midiCommand
+	Journal        []byte // the raw recovery journal, may be nil
+}
+
+func (p rtpPacket) MarshalBinary() ([]byte, error) {
+	var list []byte
+	for i, c := range p.Commands {
+		if i > 0 {
+			list = appendDelta(list, c.Delta)
+		}
+		list = append(list, c.Data...)
+	}
+
+	if len(list) > maxCommandSection {
+		return nil, fmt.Errorf("MIDI command section too large: %v bytes", len(list))
+	}
+
+	b := make([]byte, 12, 14+len(list)+len(p.Journal))
+	b[0] = 0x80 // version 2
+	b[1] = payloadType
+	binary.BigEndian.PutUint16(b[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(b[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(b[8:], p.SSRC)
+
+	var flags byte
+	if p.Journal != nil {
+		flags |= 0x40 // J
+	}
+
+	if len(list) > 0x0F {
+		b = append(b, 0x80|flags|byte(len(list)>>8), byte(len(list)))
+	} else {
+		b = append(b, flags|byte(len(list)))
+	}
+
+	b = append(b, list...)
+	return append(b, p.Journal...), nil
+}
+
+func (p *rtpPacket) UnmarshalBinary(b []byte) error {
+	if len(b) < 13 || b[0]>>6 != 2 {
+		return fmt.Errorf("invalid RTP packet")
+	}
+
+	if b[1]&0x7F != payloadType {
+		return fmt.Errorf("unexpected RTP payload type %X", b[1]&0x7F)
+	}
+
+	p.SequenceNumber = binary.BigEndian.Uint16(b[2:])
+	p.Timestamp = binary.BigEndian.Uint32(b[4:])
+	p.SSRC = binary.BigEndian.Uint32(b[8:])
+
+	b = b[12:]
+	flags := b[0]
+	length := int(flags & 0x0F)
+	b = b[1:]
+
+	if flags&0x80 != 0 {
+		if len(b) < 1 {
+			return fmt.Errorf("invalid MIDI command section header")
+		}
+		length = length<<8 | int(b[0])
+		b = b[1:]
+	}
+
+	if len(b) < length {
+		return fmt.Errorf("MIDI command section too short")
+	}
+
+	cmds, err := parseMIDIList(b[:length], flags&0x20 != 0)
+	if err != nil {
+		return err
+	}
+	p.Commands = cmds
+
+	p.Journal = nil
+	if flags&0x40 != 0 {
+		p.Journal = b[length:]
+	}
+	return nil
+}
+
+// appendDelta appends the delta time as variable length quantity
+func appendDelta(b []byte, delta uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(delta & 0x7F)
+	for delta >>= 7; delta > 0; delta >>= 7 {
+		i--
+		tmp[i] = byte(delta&0x7F) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// readDelta reads a variable length quantity and returns the number of read bytes
+func readDelta(b []byte) (delta uint32, n int, err error) {
+	for n < len(b) && n < 4 {
+		c := b[n]
+		n++
+		delta = delta<<7 | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			return delta, n, nil
+		}
+	}
+	return 0, n, fmt.Errorf("invalid delta time")
+}
+
+// parseMIDIList parses the MIDI list of a command section. Running status is resolved.
+func parseMIDIList(b []byte, firstHasDelta bool) (cmds []midiCommand, err error) {
+	var status byte
+
+	for i := 0; len(b) > 0; i++ {
+		var c midiCommand
+
+		if i > 0 || firstHasDelta {
+			var n int
+			c.Delta, n, err = readDelta(b)
+			if err != nil {
+				return
+			}
+			b = b[n:]
+			if len(b) == 0 {
+				return cmds, fmt.Errorf("delta time without command")
+			}
+		}
+
+		var n int
+		switch st := b[0]; {
+		case st == 0xF0:
+			n = 1
+			for n < len(b) && b[n] < 0x80 {
+				n++
+			}
+			if n == len(b) {
+				return cmds, fmt.Errorf("unterminated system exclusive command")
+			}
+			n++
+			c.Data = b[:n]
+			status = 0
+		case st >= 0x80:
+			n = 1 + midistream.DataLen(st)
+			if len(b) < n {
+				return cmds, fmt.Errorf("incomplete MIDI command")
+			}
+			c.Data = b[:n]
+			if midistream.IsChannelStatus(st) {
+				status = st
+			} else if !midistream.IsRealtime(st) {
+				status = 0
+			}
+		default:
+			if status == 0 {
+				return cmds, fmt.Errorf("data byte without running status")
+			}
+			n = midistream.DataLen(status)
+			if len(b) < n {
+				return cmds, fmt.Errorf("incomplete MIDI command")
+			}
+			c.Data = append([]byte{status}, b[:n]...)
+		}
+
+		b = b[n:]
+		cmds = append(cmds, c)
+	}
+
+	return
+}
@@ -0,0 +1,73 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestPayloadRoundtrip(t *testing.T) {
+	p := rtpPacket{
+		SequenceNumber: 0xFFFF,
+		Timestamp:      123456,
+		SSRC:           42,
+		Commands: []midiCommand{
+			{Data: []byte{0x90, 60, 100}},
+			{Delta: 200, Data: []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+			{Delta: 0, Data: []byte{0xF8}},
+			{Delta: 1, Data: []byte{0xC1, 5}},
+		},
+		Journal: []byte{0x20, 0, 1},
+	}
+
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary returned error: %v", err)
+	}
+
+	if b[12]&0x80 == 0 {
+		t.Errorf("command section of %v bytes must use the long header", len(b))
+	}
+
+	var got rtpPacket
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary returned error: %v", err)
+	}
+
+	if got.SequenceNumber != p.SequenceNumber || got.Timestamp != p.Timestamp || got.SSRC != p.SSRC {
+		t.Errorf("header = %v/%v/%v", got.SequenceNumber, got.Timestamp, got.SSRC)
+	}
+
+	if fmt.Sprint(got.Commands) != fmt.Sprint(p.Commands) {
+		t.Errorf("commands = %v; want %v", got.Commands, p.Commands)
+	}
+
+	if !bytes.Equal(got.Journal, p.Journal) {
+		t.Errorf("journal = % X; want % X", got.Journal, p.Journal)
+	}
+}
+
+func TestParseMIDIListRunningStatus(t *testing.T) {
+	// Z=1: the first command has a delta time, the second uses running status
+	list := []byte{0x00, 0x90, 60, 100, 0x81, 0x00, 62, 100}
+
+	cmds, err := parseMIDIList(list, true)
+	if err != nil {
+		t.Fatalf("parseMIDIList returned error: %v", err)
+	}
+
+	want := "[{0 [144 60 100]} {128 [144 62 100]}]"
+	if got := fmt.Sprint(cmds); got != want {
+		t.Errorf("parseMIDIList = %v; want %v", got, want)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	for _, d := range []uint32{0, 127, 128, 16383, 16384, 0x0FFFFFFF} {
+		b := appendDelta(nil, d)
+		got, n, err := readDelta(b)
+		if err != nil || got != d || n != len(b) {
+			t.Errorf("readDelta(appendDelta(%v)) = %v, %v, %v", d, got, n, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package rtpmidi_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/rtpmidi"
+)
+
+func TestReadFromWriteTo(t *testing.T) {
+	responder, err := rtpmidi.Listen("responder", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+	defer responder.Close()
+
+	initiator, err := rtpmidi.Dial("initiator", responder.ControlAddr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Dial returned error: %v", err)
+	}
+	defer initiator.Close()
+
+	var (
+		mx   sync.Mutex
+		res  bytes.Buffer
+		done = make(chan bool, 3)
+	)
+
+	rd := mid.NewReader(mid.NoLogger())
+	rd.Msg.Channel.NoteOn = func(p *mid.Position, ch, key, vel uint8) {
+		mx.Lock()
+		fmt.Fprintf(&res, "ON%v:%v/%v ", ch, key, vel)
+		mx.Unlock()
+		done <- true
+	}
+	rd.Msg.Channel.NoteOff = func(p *mid.Position, ch, key, vel uint8) {
+		mx.Lock()
+		fmt.Fprintf(&res, "OFF%v:%v ", ch, key)
+		mx.Unlock()
+		done <- true
+	}
+	rd.Msg.Channel.ProgramChange = func(p *mid.Position, ch, prog uint8) {
+		mx.Lock()
+		fmt.Fprintf(&res, "PC%v:%v ", ch, prog)
+		mx.Unlock()
+		done <- true
+	}
+
+	if err := rd.ReadFrom(responder); err != nil {
+		t.Fatalf("ReadFrom returned error: %v", err)
+	}
+
+	if err := responder.WaitConnected(time.Second); err != nil {
+		t.Fatalf("WaitConnected returned error: %v", err)
+	}
+
+	wr := mid.WriteTo(initiator)
+	wr.SetChannel(9)
+	wr.ProgramChange(3)
+	wr.NoteOn(36, 110)
+	wr.NoteOff(36)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for message %v", i+1)
+		}
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if got, want := res.String(), "PC9:3 ON9:36/110 OFF9:36 "; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,634 @@
+// Package rtpmidi implements MIDI over the network with the AppleMIDI session protocol
+// and the RTP-MIDI payload format (RFC 6295), without the need for OS drivers.
+//
+// A Session connects two peers. One side listens (Listen) and the other side invites it (Dial).
+// Each side uses two UDP ports: a control port and a data port with the next higher port number.
+// Clocks are synchronized, the receiver sends feedback about the received packets and
+// every packet carries a recovery journal (chapters P, C, W and N), so that
+// lost packets don't leave hanging notes.
+//
+// A Session implements connect.In and connect.Out, so it can be used with
+// mid.Reader.ReadFrom and mid.WriteTo.
+package rtpmidi
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+var (
+	// SyncInterval is the interval in which the inviting side synchronizes the clocks
+	SyncInterval = 10 * time.Second
+
+	// FeedbackInterval is the interval in which the receiver feedback is sent
+	FeedbackInterval = time.Second
+)
+
+// ErrRejected is returned by Dial, if the invitation was rejected
+var ErrRejected = fmt.Errorf("invitation rejected")
+
+// maxPacketSize is the size of the receive buffer
+const maxPacketSize = 65536
+
+// Session is an AppleMIDI session between two peers. It implements connect.In and connect.Out.
+type Session struct {
+	name    string
+	ssrc    uint32
+	control *net.UDPConn
+	data    *net.UDPConn
+	start   time.Time
+
+	mx          sync.Mutex
+	closed      bool
+	initiator   bool
+	peerName    string
+	peerSSRC    uint32
+	peerControl *net.UDPAddr
+	peerData    *net.UDPAddr
+	connected   chan struct{} // closed when the session is established
+	synced      chan struct{} // closed when the first clock synchronization of the initiator is complete
+	replies     chan exchange // replies to our invitations
+	listener    func(data []byte, deltaMicroseconds int64)
+	latency     time.Duration
+
+	// sending
+	seq     uint16
+	journal journalWriter
+
+	// receiving
+	recv          journalReader
+	receivedAny   bool
+	expectedSeq   uint16
+	feedbackDue   bool
+	lastTimestamp uint32
+
+	// sendFilter allows tests to drop packets
+	sendFilter func(seq uint16) bool
+
+	done chan struct{}
+}
+
+var (
+	_ connect.In  = &Session{}
+	_ connect.Out = &Session{}
+)
+
+func newSession(name string, control, data *net.UDPConn, initiator bool) *Session {
+	var b [4]byte
+	rand.Read(b[:])
+
+	s := &Session{
+		name:      name,
+		ssrc:      binary.BigEndian.Uint32(b[:]),
+		control:   control,
+		data:      data,
+		start:     time.Now(),
+		initiator: initiator,
+		connected: make(chan struct{}),
+		synced:    make(chan struct{}),
+		replies:   make(chan exchange, 4),
+		done:      make(chan struct{}),
+	}
+
+	go s.readLoop(control, true)
+	go s.readLoop(data, false)
+	go s.maintain()
+	return s
+}
+
+// Listen returns a session that waits for the invitation of a peer on the given control address (host:port).
+// The data port is the next higher port. If the port is 0, a free pair of ports is chosen.
+func Listen(name, address string) (*Session, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	for tries := 0; tries < 10; tries++ {
+		control, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+
+		dataAddr := *control.LocalAddr().(*net.UDPAddr)
+		dataAddr.Port++
+
+		data, err := net.ListenUDP("udp", &dataAddr)
+		if err == nil {
+			return newSession(name, control, data, false), nil
+		}
+
+		control.Close()
+		if addr.Port != 0 {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("can't find a free pair of ports")
+}
+
+// Dial invites the peer that listens on the given control address (host:port) and
+// returns the established session. The clocks are synchronized before Dial returns.
+// If the invitation or the clock synchronization does not complete within the timeout, an error is returned.
+func Dial(name, address string, timeout time.Duration) (*Session, error) {
+	remote, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	control, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	remoteData := *remote
+	remoteData.Port++
+
+	s := newSession(name, control, data, true)
+
+	deadline := time.Now().Add(timeout)
+
+	for _, c := range []struct {
+		conn *net.UDPConn
+		addr *net.UDPAddr
+	}{{control, remote}, {data, &remoteData}} {
+		err = s.invite(c.conn, c.addr, deadline)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	s.mx.Lock()
+	s.peerControl = remote
+	s.peerData = &remoteData
+	close(s.connected)
+	s.mx.Unlock()
+
+	s.syncClock()
+
+	select {
+	case <-s.synced:
+		return s, nil
+	case <-time.After(time.Until(deadline)):
+		s.Close()
+		return nil, fmt.Errorf("timeout while synchronizing the clock with %v", remote)
+	}
+}
+
+// invite sends invitations until the peer accepts or rejects or the deadline is reached
+func (s *Session) invite(conn *net.UDPConn, addr *net.UDPAddr, deadline time.Time) error {
+	var b [4]byte
+	rand.Read(b[:])
+	token := binary.BigEndian.Uint32(b[:])
+
+	inv, _ := exchange{Command: cmdInvitation, Token: token, SSRC: s.ssrc, Name: s.name}.MarshalBinary()
+
+	for time.Now().Before(deadline) {
+		_, err := conn.WriteToUDP(inv, addr)
+		if err != nil {
+			return err
+		}
+
+		wait := time.Until(deadline)
+		if wait > 200*time.Millisecond {
+			wait = 200 * time.Millisecond
+		}
+
+		select {
+		case reply := <-s.replies:
+			if reply.Token != token {
+				continue
+			}
+			if reply.Command == cmdInvitationRejected {
+				return ErrRejected
+			}
+			s.mx.Lock()
+			s.peerSSRC = reply.SSRC
+			s.peerName = reply.Name
+			s.mx.Unlock()
+			return nil
+		case <-time.After(wait):
+		}
+	}
+
+	return fmt.Errorf("timeout while inviting %v", addr)
+}
+
+// WaitConnected waits until a peer has joined the session
+func (s *Session) WaitConnected(timeout time.Duration) error {
+	s.mx.Lock()
+	connected := s.connected
+	s.mx.Unlock()
+
+	select {
+	case <-connected:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timeout while waiting for peer")
+	}
+}
+
+// isConnected must be called with the lock held
+func (s *Session) isConnected() bool {
+	select {
+	case <-s.connected:
+		return true
+	default:
+		return false
+	}
+}
+
+// now returns the session time in units of 100 microseconds
+func (s *Session) now() uint64 {
+	return uint64(time.Since(s.start) / (100 * time.Microsecond))
+}
+
+// ControlAddr returns the local control address
+func (s *Session) ControlAddr() *net.UDPAddr {
+	return s.control.LocalAddr().(*net.UDPAddr)
+}
+
+// Peer returns the name of the connected peer
+func (s *Session) Peer() string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.peerName
+}
+
+// Latency returns the network latency, measured by the last clock synchronization
+func (s *Session) Latency() time.Duration {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.latency
+}
+
+// Open does nothing, since the session is opened by Listen or Dial
+func (s *Session) Open() error {
+	return nil
+}
+
+// Close ends the session and closes the ports
+func (s *Session) Close() error {
+	s.mx.Lock()
+	if s.closed {
+		s.mx.Unlock()
+		return nil
+	}
+	s.closed = true
+	peer := s.peerControl
+	s.listener = nil
+	s.mx.Unlock()
+
+	if peer != nil {
+		by, _ := exchange{Command: cmdEndSession, SSRC: s.ssrc}.MarshalBinary()
+		s.control.WriteToUDP(by, peer)
+	}
+
+	close(s.done)
+	s.data.Close()
+	return s.control.Close()
+}
+
+// IsOpen returns wether the session is open
+func (s *Session) IsOpen() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return !s.closed
+}
+
+// Number returns the control port
+func (s *Session) Number() int {
+	return s.ControlAddr().Port
+}
+
+// String returns the name of the session
+func (s *Session) String() string {
+	return s.name
+}
+
+// Underlying returns the *net.UDPConn of the data port
+func (s *Session) Underlying() interface{} {
+	return s.data
+}
+
+// SetListener sets the callback that is called for each received message
+func (s *Session) SetListener(listener func(data []byte, deltaMicroseconds int64)) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.closed {
+		return connect.ErrClosed
+	}
+	s.listener = listener
+	return nil
+}
+
+// StopListening removes the listener
+func (s *Session) StopListening() error {
+	s.mx.Lock()
+	s.listener = nil
+	s.mx.Unlock()
+	return nil
+}
+
+// Send sends the given MIDI messages in a single RTP-MIDI packet
+func (s *Session) Send(b []byte) error {
+	var msgs [][]byte
+	midistream.New(func(msg []byte) {
+		msgs = append(msgs, append([]byte(nil), msg...))
+	}).Write(b)
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.closed {
+		return connect.ErrClosed
+	}
+
+	if !s.isConnected() {
+		return fmt.Errorf("no peer connected")
+	}
+
+	s.seq++
+	p := rtpPacket{
+		SequenceNumber: s.seq,
+		Timestamp:      uint32(s.now()),
+		SSRC:           s.ssrc,
+		Journal:        s.journal.marshal(),
+	}
+
+	for _, msg := range msgs {
+		p.Commands = append(p.Commands, midiCommand{Data: msg})
+		s.journal.track(s.seq, msg)
+	}
+
+	bt, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if s.sendFilter != nil && !s.sendFilter(s.seq) {
+		return nil
+	}
+
+	_, err = s.data.WriteToUDP(bt, s.peerData)
+	return err
+}
+
+func (s *Session) readLoop(conn *net.UDPConn, isControl bool) {
+	for {
+		b := make([]byte, maxPacketSize)
+		n, addr, err := conn.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+
+		b = b[:n]
+		if isCommand(b) {
+			s.handleCommand(conn, addr, b, isControl)
+			continue
+		}
+
+		if !isControl {
+			s.handleRTP(b)
+		}
+	}
+}
+
+func (s *Session) handleCommand(conn *net.UDPConn, addr *net.UDPAddr, b []byte, isControl bool) {
+	switch command(b) {
+	case cmdInvitation:
+		var e exchange
+		if e.UnmarshalBinary(b) != nil {
+			return
+		}
+		s.handleInvitation(conn, addr, e, isControl)
+	case cmdInvitationAccepted, cmdInvitationRejected:
+		var e exchange
+		if e.UnmarshalBinary(b) != nil {
+			return
+		}
+		select {
+		case s.replies <- e:
+		default:
+		}
+	case cmdEndSession:
+		s.mx.Lock()
+		if s.isConnected() {
+			// wait for the next peer
+			s.connected = make(chan struct{})
+		}
+		s.peerControl, s.peerData, s.peerSSRC, s.peerName = nil, nil, 0, ""
+		s.receivedAny = false
+		s.mx.Unlock()
+	case cmdClockSync:
+		var c clockSync
+		if c.UnmarshalBinary(b) != nil {
+			return
+		}
+		s.handleClockSync(conn, addr, c)
+	case cmdReceiverFeedback:
+		var r receiverFeedback
+		if r.UnmarshalBinary(b) != nil {
+			return
+		}
+		s.mx.Lock()
+		s.journal.acknowledge(r.SequenceNumber)
+		s.mx.Unlock()
+	}
+}
+
+func (s *Session) handleInvitation(conn *net.UDPConn, addr *net.UDPAddr, e exchange, isControl bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	reply := exchange{Command: cmdInvitationAccepted, Token: e.Token, SSRC: s.ssrc, Name: s.name}
+
+	switch {
+	case s.closed || s.initiator || (s.peerSSRC != 0 && s.peerSSRC != e.SSRC):
+		reply.Command = cmdInvitationRejected
+	case isControl:
+		s.peerSSRC = e.SSRC
+		s.peerName = e.Name
+		s.peerControl = addr
+	case s.peerSSRC == e.SSRC:
+		s.peerData = addr
+		if !s.isConnected() {
+			s.receivedAny = false
+			close(s.connected)
+		}
+	default:
+		// data port invitation without control port invitation
+		reply.Command = cmdInvitationRejected
+	}
+
+	bt, _ := reply.MarshalBinary()
+	conn.WriteToUDP(bt, addr)
+}
+
+func (s *Session) handleClockSync(conn *net.UDPConn, addr *net.UDPAddr, c clockSync) {
+	now := s.now()
+
+	switch c.Count {
+	case 0:
+		c.Count = 1
+		c.Timestamps[1] = now
+	case 1:
+		c.Count = 2
+		c.Timestamps[2] = now
+		s.mx.Lock()
+		s.latency = time.Duration(now-c.Timestamps[0]) * 100 * time.Microsecond / 2
+		select {
+		case <-s.synced:
+		default:
+			close(s.synced)
+		}
+		s.mx.Unlock()
+	default:
+		s.mx.Lock()
+		s.latency = time.Duration(c.Timestamps[2]-c.Timestamps[0]) * 100 * time.Microsecond / 2
+		s.mx.Unlock()
+		return
+	}
+
+	c.SSRC = s.ssrc
+	bt, _ := c.MarshalBinary()
+	conn.WriteToUDP(bt, addr)
+}
+
+// syncClock starts a clock synchronization
+func (s *Session) syncClock() {
+	s.mx.Lock()
+	peer := s.peerData
+	s.mx.Unlock()
+
+	if peer == nil {
+		return
+	}
+
+	c := clockSync{SSRC: s.ssrc}
+	c.Timestamps[0] = s.now()
+	bt, _ := c.MarshalBinary()
+	s.data.WriteToUDP(bt, peer)
+}
+
+// maintain synchronizes the clocks and sends the receiver feedback
+func (s *Session) maintain() {
+	feedback := time.NewTicker(FeedbackInterval)
+	defer feedback.Stop()
+	clock := time.NewTicker(SyncInterval)
+	defer clock.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-clock.C:
+			if s.initiator {
+				s.syncClock()
+			}
+		case <-feedback.C:
+			s.sendFeedback()
+		}
+	}
+}
+
+func (s *Session) sendFeedback() {
+	s.mx.Lock()
+	if !s.feedbackDue || s.peerControl == nil {
+		s.mx.Unlock()
+		return
+	}
+	s.feedbackDue = false
+	rs := receiverFeedback{SSRC: s.ssrc, SequenceNumber: s.expectedSeq - 1}
+	peer := s.peerControl
+	s.mx.Unlock()
+
+	bt, _ := rs.MarshalBinary()
+	s.control.WriteToUDP(bt, peer)
+}
+
+func (s *Session) handleRTP(b []byte) {
+	var p rtpPacket
+	if p.UnmarshalBinary(b) != nil {
+		return
+	}
+
+	s.mx.Lock()
+
+	if !s.isConnected() || p.SSRC != s.peerSSRC {
+		s.mx.Unlock()
+		return
+	}
+
+	var recovered [][]byte
+	first := !s.receivedAny
+
+	if !first {
+		diff := int16(p.SequenceNumber - s.expectedSeq)
+		if diff < 0 {
+			// duplicate or too late
+			s.mx.Unlock()
+			return
+		}
+		if diff > 0 && p.Journal != nil {
+			recovered, _ = s.recv.recover(p.Journal)
+		}
+	}
+
+	s.receivedAny = true
+	s.expectedSeq = p.SequenceNumber + 1
+	s.feedbackDue = true
+
+	type event struct {
+		msg   []byte
+		delta int64
+	}
+
+	var events []event
+	for _, msg := range recovered {
+		events = append(events, event{msg, 0})
+	}
+
+	ts := p.Timestamp
+	for i, c := range p.Commands {
+		ts += c.Delta
+		var delta int64
+		if !first || i > 0 {
+			if d := int32(ts - s.lastTimestamp); d > 0 {
+				delta = int64(d) * 100
+			}
+		}
+		s.lastTimestamp = ts
+		s.recv.track(c.Data)
+		events = append(events, event{c.Data, delta})
+	}
+
+	listener := s.listener
+	s.mx.Unlock()
+
+	if listener == nil {
+		return
+	}
+
+	for _, ev := range events {
+		listener(ev.msg, ev.delta)
+	}
+}
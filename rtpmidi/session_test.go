@@ -0,0 +1,113 @@
+package rtpmidi
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func connectSessions(t *testing.T) (responder, initiator *Session) {
+	responder, err := Listen("responder", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+
+	initiator, err = Dial("initiator", responder.ControlAddr().String(), time.Second)
+	if err != nil {
+		responder.Close()
+		t.Fatalf("Dial returned error: %v", err)
+	}
+
+	select {
+	case <-initiator.synced:
+	default:
+		t.Fatalf("Dial must return after the clock synchronization")
+	}
+
+	if err := responder.WaitConnected(time.Second); err != nil {
+		t.Fatalf("WaitConnected returned error: %v", err)
+	}
+	return
+}
+
+type received struct {
+	mx   sync.Mutex
+	msgs []string
+	n    chan bool
+}
+
+func (r *received) listen(data []byte, deltaMicroseconds int64) {
+	r.mx.Lock()
+	r.msgs = append(r.msgs, fmt.Sprintf("% X", data))
+	r.mx.Unlock()
+	r.n <- true
+}
+
+func (r *received) wait(t *testing.T, n int) string {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.n:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for message %v", i+1)
+		}
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return fmt.Sprint(r.msgs)
+}
+
+func TestSession(t *testing.T) {
+	responder, initiator := connectSessions(t)
+	defer responder.Close()
+	defer initiator.Close()
+
+	if got := responder.Peer(); got != "initiator" {
+		t.Errorf("responder.Peer() = %q", got)
+	}
+	if got := initiator.Peer(); got != "responder" {
+		t.Errorf("initiator.Peer() = %q", got)
+	}
+
+	rec := &received{n: make(chan bool, 10)}
+	responder.SetListener(rec.listen)
+
+	initiator.Send([]byte{0x90, 60, 100})
+	initiator.Send([]byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7})
+
+	if got, want := rec.wait(t, 2), "[90 3C 64 F0 7E 7F 06 01 F7]"; got != want {
+		t.Errorf("received %v; want %v", got, want)
+	}
+
+	// the other direction
+	rec2 := &received{n: make(chan bool, 10)}
+	initiator.SetListener(rec2.listen)
+	responder.Send([]byte{0xB0, 7, 100})
+
+	if got, want := rec2.wait(t, 1), "[B0 07 64]"; got != want {
+		t.Errorf("received %v; want %v", got, want)
+	}
+}
+
+func TestSessionRecovery(t *testing.T) {
+	responder, initiator := connectSessions(t)
+	defer responder.Close()
+	defer initiator.Close()
+
+	rec := &received{n: make(chan bool, 10)}
+	responder.SetListener(rec.listen)
+
+	// drop the second packet
+	initiator.mx.Lock()
+	initiator.sendFilter = func(seq uint16) bool { return seq != 2 }
+	initiator.mx.Unlock()
+
+	initiator.Send([]byte{0x90, 60, 100})
+	initiator.Send([]byte{0x80, 60, 0})
+	initiator.Send([]byte{0x90, 62, 100})
+
+	// the note off is recovered from the journal before the note on
+	if got, want := rec.wait(t, 3), "[90 3C 64 80 3C 00 90 3E 64]"; got != want {
+		t.Errorf("received %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,356 @@
+package mid
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ScalaScale is a scale as defined by a Scala .scl file
+type ScalaScale struct {
+
+	// Description is the description line of the file
+	Description string
+
+	// Cents are the pitches of the scale degrees 1 to n in cents above the base note.
+	// The last pitch is the period of the scale (usually the octave).
+	Cents []float64
+}
+
+// ScalaKeyboardMapping is a keyboard mapping as defined by a Scala .kbm file
+type ScalaKeyboardMapping struct {
+
+	// Size is the size of the repeating mapping pattern. If it is 0, the mapping is linear.
+	Size int
+
+	// First and Last are the first and the last key that are retuned
+	First, Last uint8
+
+	// Middle is the key where the first mapping entry (scale degree 0) is mapped to
+	Middle uint8
+
+	// Reference is the key with the reference frequency
+	Reference uint8
+
+	// ReferenceFrequency is the frequency of the reference key in Hz
+	ReferenceFrequency float64
+
+	// OctaveDegree is the scale degree of the formal octave
+	OctaveDegree int
+
+	// Mapping maps the keys of a pattern to scale degrees. Unmapped keys have a negative degree.
+	// If it has less than Size entries, the missing entries at the end are unmapped.
+	Mapping []int
+}
+
+// DefaultScalaKeyboardMapping is the linear mapping that is used, if no keyboard mapping is given:
+// the base note is the middle C and A4 has a frequency of 440 Hz.
+var DefaultScalaKeyboardMapping = ScalaKeyboardMapping{
+	First:              0,
+	Last:               127,
+	Middle:             60,
+	Reference:          69,
+	ReferenceFrequency: 440,
+}
+
+// scalaLines returns the lines of a Scala file without comments
+func scalaLines(src io.Reader) (lines []string, err error) {
+	sc := bufio.NewScanner(src)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// firstField returns the first whitespace separated field of the line
+func firstField(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// parseScalaPitch parses a pitch line of a .scl file as cents
+// (if it contains a period) or as ratio
+func parseScalaPitch(s string) (float64, error) {
+	if strings.Contains(s, ".") {
+		return strconv.ParseFloat(s, 64)
+	}
+
+	num, denom := s, "1"
+	if idx := strings.Index(s, "/"); idx >= 0 {
+		num, denom = s[:idx], s[idx+1:]
+	}
+
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseUint(denom, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 || d == 0 {
+		return 0, fmt.Errorf("invalid ratio %q", s)
+	}
+	return 1200 * math.Log2(float64(n)/float64(d)), nil
+}
+
+// ReadScala reads a Scala scale (.scl) from src
+func ReadScala(src io.Reader) (*ScalaScale, error) {
+	lines, err := scalaLines(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("scala file too short")
+	}
+
+	s := &ScalaScale{Description: strings.TrimSpace(lines[0])}
+
+	num, err := strconv.Atoi(firstField(lines[1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of notes: %v", err)
+	}
+
+	lines = lines[2:]
+	for i := 0; i < num; i++ {
+		if i >= len(lines) {
+			return nil, fmt.Errorf("scala file has %v notes, expected %v", i, num)
+		}
+		c, err := parseScalaPitch(firstField(lines[i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pitch in line %q: %v", lines[i], err)
+		}
+		s.Cents = append(s.Cents, c)
+	}
+
+	return s, nil
+}
+
+// ReadScalaFile reads a Scala scale file (.scl)
+func ReadScalaFile(file string) (*ScalaScale, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadScala(f)
+}
+
+// ReadScalaKeyboardMapping reads a Scala keyboard mapping (.kbm) from src
+func ReadScalaKeyboardMapping(src io.Reader) (*ScalaKeyboardMapping, error) {
+	lines, err := scalaLines(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for _, line := range lines {
+		if f := firstField(line); f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("keyboard mapping too short")
+	}
+
+	var ints [7]int
+	for i := range ints {
+		if i == 5 {
+			continue
+		}
+		ints[i], err = strconv.Atoi(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in keyboard mapping: %v", err)
+		}
+	}
+
+	if ints[0] < 0 {
+		return nil, fmt.Errorf("invalid keyboard mapping size: %v", ints[0])
+	}
+
+	for _, i := range []int{1, 2, 3, 4} {
+		if ints[i] < 0 || ints[i] > 127 {
+			return nil, fmt.Errorf("invalid key in keyboard mapping: %v", ints[i])
+		}
+	}
+
+	k := &ScalaKeyboardMapping{
+		Size:         ints[0],
+		First:        uint8(ints[1]),
+		Last:         uint8(ints[2]),
+		Middle:       uint8(ints[3]),
+		Reference:    uint8(ints[4]),
+		OctaveDegree: ints[6],
+	}
+
+	k.ReferenceFrequency, err = strconv.ParseFloat(fields[5], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference frequency: %v", err)
+	}
+
+	fields = fields[7:]
+	for i := 0; i < k.Size; i++ {
+		// missing entries at the end are unmapped
+		if i >= len(fields) || fields[i] == "x" || fields[i] == "X" {
+			k.Mapping = append(k.Mapping, -1)
+			continue
+		}
+		deg, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping entry: %v", err)
+		}
+		k.Mapping = append(k.Mapping, deg)
+	}
+
+	return k, nil
+}
+
+// ReadScalaKeyboardMappingFile reads a Scala keyboard mapping file (.kbm)
+func ReadScalaKeyboardMappingFile(file string) (*ScalaKeyboardMapping, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadScalaKeyboardMapping(f)
+}
+
+// floorDivMod returns the floored division and the non negative remainder
+func floorDivMod(a, b int) (div, mod int) {
+	div, mod = a/b, a%b
+	if mod < 0 {
+		div--
+		mod += b
+	}
+	return
+}
+
+// degree returns the scale degree of the given key, ok is false for unmapped keys
+func (k *ScalaKeyboardMapping) degree(key uint8, scaleSize int) (deg int, ok bool) {
+	if key < k.First || key > k.Last {
+		return 0, false
+	}
+
+	offset := int(key) - int(k.Middle)
+	if k.Size == 0 {
+		return offset, true
+	}
+
+	octave, idx := floorDivMod(offset, k.Size)
+	if idx >= len(k.Mapping) || k.Mapping[idx] < 0 {
+		return 0, false
+	}
+
+	octaveDegree := k.OctaveDegree
+	if octaveDegree == 0 {
+		octaveDegree = scaleSize
+	}
+	return k.Mapping[idx] + octave*octaveDegree, true
+}
+
+// centsOf returns the pitch of the given scale degree in cents above the base note
+func (s *ScalaScale) centsOf(degree int) float64 {
+	n := len(s.Cents)
+	octave, idx := floorDivMod(degree, n)
+	c := float64(octave) * s.Cents[n-1]
+	if idx > 0 {
+		c += s.Cents[idx-1]
+	}
+	return c
+}
+
+// Frequencies returns the frequencies in Hz of all keys for the given keyboard mapping.
+// If kbm is nil, DefaultScalaKeyboardMapping is used. Unmapped keys have a frequency of 0.
+func (s *ScalaScale) Frequencies(kbm *ScalaKeyboardMapping) (freqs [128]float64, err error) {
+	if len(s.Cents) == 0 {
+		return freqs, fmt.Errorf("empty scale")
+	}
+	if kbm == nil {
+		kbm = &DefaultScalaKeyboardMapping
+	}
+	if kbm.Size < 0 {
+		return freqs, fmt.Errorf("invalid keyboard mapping size: %v", kbm.Size)
+	}
+
+	refDegree, ok := kbm.degree(kbm.Reference, len(s.Cents))
+	if !ok {
+		return freqs, fmt.Errorf("reference key %v is not mapped", kbm.Reference)
+	}
+	refCents := s.centsOf(refDegree)
+
+	for key := range freqs {
+		deg, ok := kbm.degree(uint8(key), len(s.Cents))
+		if !ok {
+			continue
+		}
+		freqs[key] = kbm.ReferenceFrequency * math.Pow(2, (s.centsOf(deg)-refCents)/1200)
+	}
+	return
+}
+
+// BulkDump returns a MTS bulk tuning dump for the scale and the given keyboard mapping.
+// If kbm is nil, DefaultScalaKeyboardMapping is used. Unmapped keys are not changed.
+func (s *ScalaScale) BulkDump(kbm *ScalaKeyboardMapping, program uint8, name string) (d MTSBulkDump, err error) {
+	freqs, err := s.Frequencies(kbm)
+	if err != nil {
+		return
+	}
+
+	d.Program = program
+	d.Name = name
+	for key, f := range freqs {
+		d.Pitches[key] = MTSNoChange
+		if f > 0 {
+			d.Pitches[key] = MTSPitchFromFrequency(f)
+		}
+	}
+	return
+}
+
+// NoteChange returns a MTS single note tuning change for all mapped keys of the scale
+// and the given keyboard mapping. If kbm is nil, DefaultScalaKeyboardMapping is used.
+func (s *ScalaScale) NoteChange(kbm *ScalaKeyboardMapping, realtime bool, bank, program uint8) (c MTSNoteChange, err error) {
+	freqs, err := s.Frequencies(kbm)
+	if err != nil {
+		return
+	}
+
+	c.Realtime = realtime
+	c.Bank = bank
+	c.Program = program
+	for key, f := range freqs {
+		if f > 0 {
+			c.Notes = append(c.Notes, MTSNoteTuning{Key: uint8(key), Pitch: MTSPitchFromFrequency(f)})
+		}
+	}
+	return
+}
+
+// ScaleOctave returns a MTS scale/octave tuning for a scale with 12 notes and a period of an octave.
+// The first degree of the scale is mapped to the pitch class of the given base key (e.g. 60 for C).
+func (s *ScalaScale) ScaleOctave(baseKey uint8, channels uint16, realtime, highResolution bool) (t MTSScaleOctave, err error) {
+	if len(s.Cents) != 12 || math.Abs(s.Cents[11]-1200) > 0.001 {
+		return t, fmt.Errorf("scale/octave tuning needs 12 notes per octave")
+	}
+
+	t.Realtime = realtime
+	t.HighResolution = highResolution
+	t.Channels = channels
+	for deg := 0; deg < 12; deg++ {
+		t.Cents[(int(baseKey)+deg)%12] = s.centsOf(deg) - float64(deg*100)
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package mid
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const justScale = `! just.scl
+!
+Just intonation
+ 12
+!
+ 16/15
+ 9/8
+ 6/5
+ 5/4
+ 4/3
+ 45/32
+ 3/2
+ 8/5
+ 5/3
+ 16/9
+ 15/8
+ 2/1
+`
+
+const whiteKeysMapping = `! white keys of a 7 note scale
+12
+0
+127
+60
+69
+440.0
+7
+! mapping
+0
+x
+1
+x
+2
+3
+x
+4
+x
+5
+x
+6
+`
+
+func TestReadScala(t *testing.T) {
+	s, err := ReadScala(strings.NewReader(justScale))
+	if err != nil {
+		t.Fatalf("ReadScala returned error: %v", err)
+	}
+
+	if got, want := s.Description, "Just intonation"; got != want {
+		t.Errorf("Description = %q; want %q", got, want)
+	}
+
+	if got, want := len(s.Cents), 12; got != want {
+		t.Fatalf("len(Cents) = %v; want %v", got, want)
+	}
+
+	if got, want := s.Cents[6], 701.955; math.Abs(got-want) > 0.001 {
+		t.Errorf("Cents[6] = %v; want %v", got, want)
+	}
+
+	freqs, err := s.Frequencies(nil)
+	if err != nil {
+		t.Fatalf("Frequencies returned error: %v", err)
+	}
+
+	// A is a just major sixth (5/3) above C
+	if got, want := freqs[60], 440*3.0/5; math.Abs(got-want) > 0.0001 {
+		t.Errorf("freqs[60] = %v; want %v", got, want)
+	}
+
+	if got, want := freqs[81], 880.0; math.Abs(got-want) > 0.0001 {
+		t.Errorf("freqs[81] = %v; want %v", got, want)
+	}
+
+	oct, err := s.ScaleOctave(60, MTSAllChannels, false, true)
+	if err != nil {
+		t.Fatalf("ScaleOctave returned error: %v", err)
+	}
+
+	// E is a just major third (5/4): 386.3 cents
+	if got, want := oct.Cents[4], -13.686; math.Abs(got-want) > 0.001 {
+		t.Errorf("Cents[4] = %v; want %v", got, want)
+	}
+}
+
+func TestScalaKeyboardMapping(t *testing.T) {
+	scale := &ScalaScale{Cents: []float64{200, 400, 500, 700, 900, 1100, 1200}}
+
+	kbm, err := ReadScalaKeyboardMapping(strings.NewReader(whiteKeysMapping))
+	if err != nil {
+		t.Fatalf("ReadScalaKeyboardMapping returned error: %v", err)
+	}
+
+	if got, want := len(kbm.Mapping), 12; got != want {
+		t.Fatalf("len(Mapping) = %v; want %v", got, want)
+	}
+
+	dump, err := scale.BulkDump(kbm, 0, "major")
+	if err != nil {
+		t.Fatalf("BulkDump returned error: %v", err)
+	}
+
+	tests := []struct {
+		key      uint8
+		expected MTSPitch
+	}{
+		{60, MTSPitch{60, 0}},
+		{61, MTSNoChange},
+		{64, MTSPitch{64, 0}},
+		{69, MTSPitch{69, 0}},
+		{72, MTSPitch{72, 0}},
+		{47, MTSPitch{47, 0}},
+	}
+
+	for _, test := range tests {
+		got := dump.Pitches[test.key]
+		if got != test.expected && math.Abs(got.Float()-test.expected.Float()) > 0.001 {
+			t.Errorf("Pitches[%v] = %v; want %v", test.key, got, test.expected)
+		}
+	}
+}
+
+func TestScalaKeyboardMappingShort(t *testing.T) {
+	scale := &ScalaScale{Cents: []float64{200, 400, 500, 700, 900, 1100, 1200}}
+
+	// a pattern of 12 keys with mappings for the first 5 only
+	kbm := &ScalaKeyboardMapping{
+		Size:               12,
+		Last:               127,
+		Middle:             60,
+		Reference:          60,
+		ReferenceFrequency: 261.6256,
+		OctaveDegree:       7,
+		Mapping:            []int{0, -1, 1, -1, 2},
+	}
+
+	freqs, err := scale.Frequencies(kbm)
+	if err != nil {
+		t.Fatalf("Frequencies returned error: %v", err)
+	}
+	if freqs[60] == 0 || freqs[64] == 0 || freqs[61] != 0 || freqs[65] != 0 || freqs[71] != 0 {
+		t.Errorf("wrong mapped keys: %v", freqs[60:72])
+	}
+
+	kbm.Size = -1
+	if _, err := scale.Frequencies(kbm); err == nil {
+		t.Errorf("Frequencies must return an error for a negative size")
+	}
+}
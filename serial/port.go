@@ -0,0 +1,213 @@
+// Package serial is a MIDI transport for serial (DIN or UART) connections, e.g. tty devices of UART MIDI adapters.
+//
+// A Port implements connect.In and connect.Out on top of an io.ReadWriteCloser.
+// Output is paced to the wire rate of 31250 baud and uses running status.
+// Input is split into complete messages.
+//
+// For active sensing, use Writer.StartActivesense of a mid.Writer for the output and
+// Msg.Realtime.ConnectionLost of a mid.Reader for the input.
+package serial
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gomidi/connect"
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// BaudRate is the MIDI wire rate in bits per second
+const BaudRate = 31250
+
+// ByteDuration is the time it takes to transmit one byte on the wire (start bit, 8 data bits, stop bit)
+const ByteDuration = 10 * time.Second / BaudRate
+
+// Port is a connect.In and connect.Out for a serial MIDI connection.
+// The exported fields must be set before the port is opened.
+type Port struct {
+	rwc  io.ReadWriteCloser
+	name string
+
+	// NoPacing disables pacing the output to the wire rate, e.g. for adapters that buffer the output
+	NoPacing bool
+
+	// NoRunningStatus disables running status for the output
+	NoRunningStatus bool
+
+	mx       sync.Mutex
+	open     bool
+	listener func(data []byte, deltaMicroseconds int64)
+
+	wmx       sync.Mutex // protects the output
+	status    byte       // running status of the output
+	busyUntil time.Time  // the time when the bytes written so far are transmitted
+}
+
+var (
+	_ connect.In  = &Port{}
+	_ connect.Out = &Port{}
+)
+
+// New returns a Port for the given serial connection. The name is returned by String.
+func New(rwc io.ReadWriteCloser, name string) *Port {
+	return &Port{rwc: rwc, name: name}
+}
+
+// Open opens the port and starts reading in a goroutine.
+// Reading stops with the first error of the io.ReadWriteCloser, e.g. when the port is closed.
+func (p *Port) Open() error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.openLocked()
+	return nil
+}
+
+func (p *Port) openLocked() {
+	if p.open {
+		return
+	}
+	p.open = true
+	go p.read()
+}
+
+// Close stops listening and closes the serial connection
+func (p *Port) Close() error {
+	p.mx.Lock()
+	if !p.open {
+		p.mx.Unlock()
+		return nil
+	}
+	p.open = false
+	p.listener = nil
+	p.mx.Unlock()
+
+	return p.rwc.Close()
+}
+
+// IsOpen returns wether the port is open
+func (p *Port) IsOpen() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.open
+}
+
+// Number returns 0
+func (p *Port) Number() int {
+	return 0
+}
+
+// String returns the name of the port
+func (p *Port) String() string {
+	return p.name
+}
+
+// Underlying returns the io.ReadWriteCloser
+func (p *Port) Underlying() interface{} {
+	return p.rwc
+}
+
+// Send writes the given complete MIDI message, using running status if possible.
+// It blocks until the previously written bytes would have been transmitted at 31250 baud.
+func (p *Port) Send(msg []byte) error {
+	if len(msg) == 0 {
+		return nil
+	}
+
+	if !p.IsOpen() {
+		return connect.ErrClosed
+	}
+
+	p.wmx.Lock()
+	defer p.wmx.Unlock()
+	return p.write(msg)
+}
+
+// write must be called with wmx locked
+func (p *Port) write(msg []byte) error {
+	status := msg[0]
+
+	switch {
+	case midistream.IsRealtime(status):
+		// realtime messages do not affect the running status
+	case midistream.IsChannelStatus(status):
+		if status == p.status && !p.NoRunningStatus {
+			msg = msg[1:]
+		}
+		p.status = status
+	default:
+		// system common and system exclusive messages cancel the running status
+		p.status = 0
+	}
+
+	if !p.NoPacing {
+		now := time.Now()
+		if p.busyUntil.After(now) {
+			time.Sleep(p.busyUntil.Sub(now))
+			now = p.busyUntil
+		}
+		p.busyUntil = now.Add(time.Duration(len(msg)) * ByteDuration)
+	}
+
+	_, err := p.rwc.Write(msg)
+	return err
+}
+
+// SetListener opens the port, if needed, and passes the received messages to the listener.
+// The listener is called for every complete message (running status is resolved).
+func (p *Port) SetListener(listener func(data []byte, deltaMicroseconds int64)) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.listener != nil {
+		return fmt.Errorf("already listening")
+	}
+
+	p.openLocked()
+	p.listener = listener
+	return nil
+}
+
+// StopListening stops passing messages to the listener. The port keeps reading (and discarding)
+// the input until it is closed.
+func (p *Port) StopListening() error {
+	p.mx.Lock()
+	p.listener = nil
+	p.mx.Unlock()
+	return nil
+}
+
+func (p *Port) read() {
+	var last time.Time
+
+	split := midistream.New(func(msg []byte) {
+		p.mx.Lock()
+		listener := p.listener
+		p.mx.Unlock()
+
+		now := time.Now()
+		var delta int64
+		if !last.IsZero() {
+			delta = now.Sub(last).Nanoseconds() / 1000
+		}
+		last = now
+
+		if listener != nil {
+			listener(msg, delta)
+		}
+	})
+
+	buf := make([]byte, 256)
+
+	for {
+		n, err := p.rwc.Read(buf)
+		if n > 0 {
+			split.Write(buf[:n])
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
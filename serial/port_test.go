@@ -0,0 +1,174 @@
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// conn is an in-memory serial connection: reads come from a pipe, writes go into a buffer
+type conn struct {
+	*io.PipeReader
+	in *io.PipeWriter
+
+	mx  sync.Mutex
+	out bytes.Buffer
+}
+
+func newConn() *conn {
+	r, w := io.Pipe()
+	return &conn{PipeReader: r, in: w}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.out.Write(b)
+}
+
+func (c *conn) written() string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return fmt.Sprintf("% X", c.out.Bytes())
+}
+
+func TestRunningStatus(t *testing.T) {
+	tests := []struct {
+		noRunningStatus bool
+		msgs            [][]byte
+		expected        string
+	}{
+		{
+			false,
+			[][]byte{{0x90, 60, 100}, {0x90, 62, 100}, {0xF8}, {0x90, 60, 0}, {0x80, 62, 0}},
+			"90 3C 64 3E 64 F8 3C 00 80 3E 00",
+		},
+		{
+			false,
+			[][]byte{{0xB0, 7, 100}, {0xF0, 1, 0xF7}, {0xB0, 7, 90}, {0xF1, 2}, {0xB0, 7, 80}},
+			"B0 07 64 F0 01 F7 B0 07 5A F1 02 B0 07 50",
+		},
+		{
+			true,
+			[][]byte{{0x90, 60, 100}, {0x90, 62, 100}},
+			"90 3C 64 90 3E 64",
+		},
+	}
+
+	for _, test := range tests {
+		c := newConn()
+		p := New(c, "test")
+		p.NoPacing = true
+		p.NoRunningStatus = test.noRunningStatus
+		p.Open()
+
+		for _, msg := range test.msgs {
+			if err := p.Send(msg); err != nil {
+				t.Fatalf("Send returned error: %v", err)
+			}
+		}
+		p.Close()
+
+		if got := c.written(); got != test.expected {
+			t.Errorf("got %v; want %v", got, test.expected)
+		}
+	}
+}
+
+func TestSendClosed(t *testing.T) {
+	p := New(newConn(), "test")
+	if err := p.Send([]byte{0x90, 60, 100}); err == nil {
+		t.Errorf("Send on a closed port must return an error")
+	}
+}
+
+func TestPacing(t *testing.T) {
+	c := newConn()
+	p := New(c, "test")
+	p.Open()
+	defer p.Close()
+
+	start := time.Now()
+
+	// 100 notes, the first with status byte: 201 bytes
+	p.Send([]byte{0x90, 60, 100})
+	for i := 0; i < 99; i++ {
+		p.Send([]byte{0x90, 60, 0})
+	}
+
+	// the last message is written when the previous bytes are transmitted
+	if got, min := time.Since(start), 198*ByteDuration; got < min {
+		t.Errorf("sending 201 bytes took %v; want at least %v", got, min)
+	}
+}
+
+func TestListener(t *testing.T) {
+	c := newConn()
+	p := New(c, "test")
+	defer p.Close()
+
+	received := make(chan string, 10)
+	p.SetListener(func(data []byte, deltaMicroseconds int64) {
+		received <- fmt.Sprintf("% X", data)
+	})
+
+	go c.in.Write([]byte{0x90, 60, 100, 62, 100, 0xF0, 0x7E, 0xF8, 0x01, 0xF7})
+
+	for _, want := range []string{"90 3C 64", "90 3E 64", "F8", "F0 7E 01 F7"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+}
+
+func TestListenAgain(t *testing.T) {
+	c := newConn()
+	p := New(c, "test")
+	defer p.Close()
+
+	received := make(chan string, 10)
+	listener := func(data []byte, deltaMicroseconds int64) {
+		received <- fmt.Sprintf("% X", data)
+	}
+
+	p.SetListener(listener)
+	if err := p.SetListener(listener); err == nil {
+		t.Errorf("SetListener while listening must return an error")
+	}
+
+	expect := func(want string) {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+
+	c.in.Write([]byte{0x90, 60, 100})
+	expect("90 3C 64")
+
+	p.StopListening()
+	p.SetListener(listener)
+
+	// with more than one reader, the bytes would be torn apart between them
+	go func() {
+		for i := 0; i < 10; i++ {
+			c.in.Write([]byte{0x90, 62, 100})
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		expect("90 3E 64")
+	}
+}
@@ -0,0 +1,98 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, e := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); e != 0 {
+		return e
+	}
+	return nil
+}
+
+// openPty returns both ends of a new pseudo terminal in raw mode
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	if err = ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	var n uint32
+	if err = ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	var tio syscall.Termios
+	if err = ioctl(slave, syscall.TCGETS, unsafe.Pointer(&tio)); err == nil {
+		// cfmakeraw
+		tio.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+		tio.Oflag &^= syscall.OPOST
+		tio.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		tio.Cflag &^= syscall.CSIZE | syscall.PARENB
+		tio.Cflag |= syscall.CS8
+		tio.Cc[syscall.VMIN] = 1
+		tio.Cc[syscall.VTIME] = 0
+		err = ioctl(slave, syscall.TCSETS, unsafe.Pointer(&tio))
+	}
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
+
+func TestPty(t *testing.T) {
+	master, slave, err := openPty()
+	if err != nil {
+		t.Skipf("can't open pty: %v", err)
+	}
+
+	out := New(master, "master")
+	in := New(slave, "slave")
+	defer out.Close()
+	defer in.Close()
+
+	received := make(chan string, 10)
+	in.SetListener(func(data []byte, deltaMicroseconds int64) {
+		received <- fmt.Sprintf("% X", data)
+	})
+
+	out.Open()
+	for _, msg := range [][]byte{{0x90, 60, 100}, {0x90, 60, 0}, {0xF0, 0x7E, 0x0A, 0x0D, 0xF7}} {
+		if err := out.Send(msg); err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+	}
+
+	for _, want := range []string{"90 3C 64", "90 3C 00", "F0 7E 0A 0D F7"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+}
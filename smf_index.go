@@ -0,0 +1,626 @@
+package mid
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/gomidi/mid/internal/midistream"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/smf"
+	"github.com/gomidi/midi/smf/smfreader"
+)
+
+// SMFCheckpointInterval is the number of events between two checkpoints of a track in a SMFIndex
+const SMFCheckpointInterval = 256
+
+// SMFIndex is an index of a SMF file for random access, e.g. to jump to a bar of a long multitrack file
+// without dispatching every earlier event.
+//
+// It is built by scanning the chunk structure of the file once (see NewSMFIndex). For every track it records
+// checkpoints (the byte offset, the absolute ticks and the running status) every SMFCheckpointInterval events,
+// together with the values of the channel state that the track changed since the previous checkpoint.
+// So the size of the index grows with the number of events, not with the number of checkpoints times the size
+// of the state.
+//
+// Reader.ReadSMFIndex reads the events starting from any tick, SMFIndex.StateAt returns the state of the channels,
+// the tempo and the time signature at any tick.
+type SMFIndex struct {
+	src      io.ReaderAt
+	header   smf.Header
+	division uint16
+	tracks   []smfTrackIndex
+	tempos   []tempoChange
+	timeSigs []timeSigChange
+	length   uint64
+}
+
+type smfTrackIndex struct {
+	end         int64 // the offset behind the track data
+	checkpoints []smfCheckpoint
+}
+
+// smfCheckpoint is the position in front of an event of a track
+type smfCheckpoint struct {
+	offset int64           // the offset of the event
+	ticks  uint64          // the absolute ticks of the previous event
+	status byte            // the running status
+	state  []smfStateEntry // the values that have been set since the previous checkpoint
+}
+
+type timeSigChange struct {
+	absTicks               uint64
+	numerator, denominator uint8
+}
+
+// smfStateEntry is a value of the channel state that has been set by a track.
+// The key is channel<<9 | param, where param is the controller, 128 + key for notes or one of the stateX constants.
+type smfStateEntry struct {
+	key   uint16
+	value int16
+	ticks uint64
+}
+
+const (
+	stateNotes      = 128
+	stateProgram    = 256
+	stateAftertouch = 257
+	statePitchbend  = 258
+)
+
+// NewSMFIndex scans the SMF file that can be read from src and returns its index.
+// src must stay readable while the index is used.
+func NewSMFIndex(src io.ReaderAt) (*SMFIndex, error) {
+	x := &SMFIndex{src: src}
+
+	var chunks []smfChunk
+	var err error
+	x.header, x.division, chunks, err = readSMFChunks(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range chunks {
+		if err := x.indexTrack(c.start, c.end); err != nil {
+			return nil, fmt.Errorf("can't index track %v: %v", i, err)
+		}
+	}
+
+	sort.SliceStable(x.tempos, func(a, b int) bool { return x.tempos[a].absTicks < x.tempos[b].absTicks })
+	sort.SliceStable(x.timeSigs, func(a, b int) bool { return x.timeSigs[a].absTicks < x.timeSigs[b].absTicks })
+	return x, nil
+}
+
+// smfChunk is the position of the data of a track chunk
+type smfChunk struct {
+	start, end int64
+}
+
+// readSMFChunks reads the header and the positions of the track chunks of a SMF file.
+// NumTracks of the header is the number of the found tracks.
+func readSMFChunks(src io.ReaderAt) (header smf.Header, division uint16, chunks []smfChunk, err error) {
+	var hd [14]byte
+	if _, err = src.ReadAt(hd[:], 0); err != nil {
+		err = fmt.Errorf("can't read SMF header: %v", err)
+		return
+	}
+	if string(hd[:4]) != "MThd" {
+		err = fmt.Errorf("not a SMF file: missing MThd chunk")
+		return
+	}
+	headerLen := binary.BigEndian.Uint32(hd[4:8])
+	if headerLen < 6 {
+		err = fmt.Errorf("invalid SMF header length %v", headerLen)
+		return
+	}
+
+	switch binary.BigEndian.Uint16(hd[8:10]) {
+	case 0:
+		header.Format = smf.SMF0
+	case 1:
+		header.Format = smf.SMF1
+	case 2:
+		header.Format = smf.SMF2
+	default:
+		err = fmt.Errorf("unsupported SMF format %v", binary.BigEndian.Uint16(hd[8:10]))
+		return
+	}
+
+	division = binary.BigEndian.Uint16(hd[12:14])
+	if division&0x8000 == 0 {
+		header.TimeFormat = smf.MetricTicks(division)
+	} else {
+		header.TimeFormat = smf.TimeCode{FramesPerSecond: uint8(-int8(division >> 8)), SubFrames: uint8(division)}
+	}
+
+	offset := 8 + int64(headerLen)
+	for {
+		var chunk [8]byte
+		n, rerr := src.ReadAt(chunk[:], offset)
+		if n == 0 && rerr == io.EOF {
+			break
+		}
+		if n < len(chunk) {
+			err = fmt.Errorf("can't read chunk header at offset %v: %v", offset, rerr)
+			return
+		}
+
+		start := offset + 8
+		offset = start + int64(binary.BigEndian.Uint32(chunk[4:]))
+
+		// unknown chunks are skipped
+		if string(chunk[:4]) == "MTrk" {
+			chunks = append(chunks, smfChunk{start, offset})
+		}
+	}
+
+	header.NumTracks = uint16(len(chunks))
+	return
+}
+
+func (x *SMFIndex) indexTrack(start, end int64) error {
+	t := smfTrackIndex{end: end}
+	s := newSMFTrackScanner(x.src, smfCheckpoint{offset: start}, end)
+	changed := map[uint16]smfStateEntry{}
+
+	for i := 0; ; i++ {
+		if i%SMFCheckpointInterval == 0 && (i == 0 || s.offset < end) {
+			t.checkpoints = append(t.checkpoints, smfCheckpoint{
+				offset: s.offset,
+				ticks:  s.ticks,
+				status: s.status,
+				state:  sortedState(changed),
+			})
+			changed = map[uint16]smfStateEntry{}
+		}
+
+		ev, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		ev.updateState(changed, s.ticks)
+
+		switch {
+		case ev.status == 0xFF && ev.metaType == 0x51 && len(ev.meta) == 3:
+			mpqn := uint32(ev.meta[0])<<16 | uint32(ev.meta[1])<<8 | uint32(ev.meta[2])
+			if mpqn > 0 {
+				x.tempos = append(x.tempos, tempoChange{s.ticks, 60000000 / float64(mpqn)})
+			}
+		case ev.status == 0xFF && ev.metaType == 0x58 && len(ev.meta) >= 2 && ev.meta[1] < 8:
+			// denominators beyond 2^7 don't fit into a byte and are skipped
+			x.timeSigs = append(x.timeSigs, timeSigChange{s.ticks, ev.meta[0], 1 << ev.meta[1]})
+		}
+
+		if s.ticks > x.length {
+			x.length = s.ticks
+		}
+	}
+
+	x.tracks = append(x.tracks, t)
+	return nil
+}
+
+// Header returns the header of the SMF file. NumTracks is the number of the found tracks.
+func (x *SMFIndex) Header() smf.Header {
+	return x.header
+}
+
+// Length returns the absolute ticks of the last event of the longest track
+func (x *SMFIndex) Length() uint64 {
+	return x.length
+}
+
+// checkpoint returns the index of the last checkpoint of the track in front of the events at the given ticks
+func (t *smfTrackIndex) checkpoint(ticks uint64) int {
+	i := sort.Search(len(t.checkpoints), func(i int) bool { return t.checkpoints[i].ticks >= ticks })
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+// state returns the channel state of the track at the checkpoint with the given index,
+// by applying the changes of all checkpoints up to it
+func (t *smfTrackIndex) state(cp int) map[uint16]smfStateEntry {
+	state := map[uint16]smfStateEntry{}
+	for _, c := range t.checkpoints[:cp+1] {
+		for _, e := range c.state {
+			state[e.key] = e
+		}
+	}
+	return state
+}
+
+// ChannelState is the state of a MIDI channel
+type ChannelState struct {
+	// Program is the program, -1 if there was no program change
+	Program int8
+
+	// Controllers are the values of the controllers, -1 for controllers that have not been set
+	Controllers [128]int8
+
+	// Pitchbend is the pitchbend value (0 if it has not been set)
+	Pitchbend int16
+
+	// Aftertouch is the pressure of the channel aftertouch, -1 if it has not been set
+	Aftertouch int8
+
+	// Notes are the velocities of the notes that are sounding (0 for silent notes)
+	Notes [128]uint8
+}
+
+// SMFState is the state at a position of a SMF file
+type SMFState struct {
+	// TempoBPM is the tempo
+	TempoBPM float64
+
+	// Numerator and Denominator are the time signature (4/4 if there was none)
+	Numerator, Denominator uint8
+
+	// Channels are the states of the channels
+	Channels [16]ChannelState
+}
+
+// StateAt returns the state in front of the events at the given absolute ticks, i.e. after all events before.
+// If several tracks set the same value, the last setting wins; for settings at the same ticks, the later track wins.
+func (x *SMFIndex) StateAt(ticks uint64) (*SMFState, error) {
+	st := &SMFState{TempoBPM: 120, Numerator: 4, Denominator: 4}
+
+	for _, t := range x.tempos {
+		if t.absTicks >= ticks {
+			break
+		}
+		st.TempoBPM = t.bpm
+	}
+
+	for _, t := range x.timeSigs {
+		if t.absTicks >= ticks {
+			break
+		}
+		st.Numerator, st.Denominator = t.numerator, t.denominator
+	}
+
+	merged := map[uint16]smfStateEntry{}
+	for i := range x.tracks {
+		t := &x.tracks[i]
+		cp := t.checkpoint(ticks)
+		state := t.state(cp)
+
+		s := newSMFTrackScanner(x.src, t.checkpoints[cp], t.end)
+		for {
+			ev, err := s.next()
+			if err == io.EOF || (err == nil && s.ticks >= ticks) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("can't read track %v: %v", i, err)
+			}
+			ev.updateState(state, s.ticks)
+		}
+
+		for key, e := range state {
+			if prev, has := merged[key]; !has || e.ticks >= prev.ticks {
+				merged[key] = e
+			}
+		}
+	}
+
+	for ch := range st.Channels {
+		c := &st.Channels[ch]
+		c.Program, c.Aftertouch = -1, -1
+		for cc := range c.Controllers {
+			c.Controllers[cc] = -1
+		}
+	}
+
+	for key, e := range merged {
+		c := &st.Channels[key>>9]
+		switch param := key & 0x1FF; {
+		case param < stateNotes:
+			c.Controllers[param] = int8(e.value)
+		case param < stateProgram:
+			c.Notes[param-stateNotes] = uint8(e.value)
+		case param == stateProgram:
+			c.Program = int8(e.value)
+		case param == stateAftertouch:
+			c.Aftertouch = int8(e.value)
+		case param == statePitchbend:
+			c.Pitchbend = e.value
+		}
+	}
+
+	return st, nil
+}
+
+// ReadSMFIndex reads the SMF file of the given index like ReadSMF, but starts with the events at the given absolute ticks.
+// Earlier events are not dispatched; their tempo changes are respected (see Reader.TimeAt).
+// Use SMFIndex.StateAt to get the state of the channels at the start.
+func (r *Reader) ReadSMFIndex(x *SMFIndex, from uint64, options ...smfreader.Option) error {
+	r.errSMF = nil
+	r.pos = &Position{}
+	r.reset()
+	r.setHeader(x.header)
+
+	for _, t := range x.tempos {
+		if t.absTicks >= from {
+			break
+		}
+		r.saveTempoChange(Position{AbsoluteTicks: t.absTicks}, t.bpm)
+	}
+
+	for i := range x.tracks {
+		cp := x.tracks[i].checkpoints[x.tracks[i].checkpoint(from)]
+		rd, err := x.trackReader(i, cp, options...)
+		if err != nil {
+			return err
+		}
+
+		*r.pos = Position{Track: int16(i), AbsoluteTicks: cp.ticks}
+		r.readSMF(&smfSeekReader{Reader: rd, track: int16(i), from: from, pos: r.pos})
+
+		if r.errSMF != nil && r.errSMF != smf.ErrFinished {
+			return r.errSMF
+		}
+		r.errSMF = nil
+	}
+
+	return nil
+}
+
+// trackReader returns a smf.Reader for the given track, starting at the checkpoint.
+func (x *SMFIndex) trackReader(track int, cp smfCheckpoint, options ...smfreader.Option) (smf.Reader, error) {
+	rd, err := newTrackReader(x.src, x.division, cp.offset, x.tracks[track].end, cp.status, options...)
+	if err != nil {
+		return nil, fmt.Errorf("can't read track %v: %v", track, err)
+	}
+	return rd, nil
+}
+
+// newTrackReader returns a smf.Reader for the track data between start and end, where start is the offset of an event
+// and status is the running status in front of it.
+// It reads a single track file that consists of the track data.
+func newTrackReader(src io.ReaderAt, division uint16, start, end int64, status byte, options ...smfreader.Option) (smf.Reader, error) {
+	var first bytes.Buffer
+
+	// the delta of the first event
+	for start < end {
+		var b [1]byte
+		if _, err := src.ReadAt(b[:], start); err != nil {
+			return nil, err
+		}
+		first.WriteByte(b[0])
+		start++
+		if b[0] < 0x80 {
+			break
+		}
+	}
+
+	// restore the running status of the first event
+	if status != 0 && start < end {
+		var b [1]byte
+		if _, err := src.ReadAt(b[:], start); err != nil {
+			return nil, err
+		}
+		if b[0] < 0x80 {
+			first.WriteByte(status)
+		}
+	}
+
+	trackLen := uint32(first.Len()) + uint32(end-start)
+
+	// SMF0 header with a single track
+	hd := []byte{'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, byte(division >> 8), byte(division), 'M', 'T', 'r', 'k', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(hd[18:], trackLen)
+
+	rd := io.MultiReader(bytes.NewReader(hd), &first, io.NewSectionReader(src, start, end-start))
+	return smfreader.New(rd, options...), nil
+}
+
+// smfSeekReader skips the events in front of from and reports the track of the index
+type smfSeekReader struct {
+	smf.Reader
+	track int16
+	from  uint64
+	pos   *Position
+}
+
+func (s *smfSeekReader) Read() (midi.Message, error) {
+	for {
+		m, err := s.Reader.Read()
+		if err != nil || s.pos.AbsoluteTicks+uint64(s.Reader.Delta()) >= s.from {
+			return m, err
+		}
+		s.pos.AbsoluteTicks += uint64(s.Reader.Delta())
+	}
+}
+
+func (s *smfSeekReader) Track() int16 {
+	return s.track
+}
+
+// smfEvent is a raw event of a track
+type smfEvent struct {
+	status   byte
+	data     [2]byte
+	metaType byte
+	meta     []byte
+}
+
+// updateState registers the values of a channel message in the state
+func (ev *smfEvent) updateState(state map[uint16]smfStateEntry, ticks uint64) {
+	if !midistream.IsChannelStatus(ev.status) {
+		return
+	}
+
+	key := uint16(ev.status&0x0F) << 9
+	set := func(param uint16, value int16) {
+		state[key|param] = smfStateEntry{key: key | param, value: value, ticks: ticks}
+	}
+
+	switch ev.status & 0xF0 {
+	case 0x80:
+		set(stateNotes+uint16(ev.data[0]), 0)
+	case 0x90:
+		set(stateNotes+uint16(ev.data[0]), int16(ev.data[1]))
+	case 0xB0:
+		set(uint16(ev.data[0]), int16(ev.data[1]))
+	case 0xC0:
+		set(stateProgram, int16(ev.data[0]))
+	case 0xD0:
+		set(stateAftertouch, int16(ev.data[0]))
+	case 0xE0:
+		set(statePitchbend, int16(uint16(ev.data[1])<<7|uint16(ev.data[0]))-8192)
+	}
+}
+
+func sortedState(state map[uint16]smfStateEntry) []smfStateEntry {
+	entries := make([]smfStateEntry, 0, len(state))
+	for _, e := range state {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].key < entries[b].key })
+	return entries
+}
+
+// smfTrackScanner scans the raw events of a track
+type smfTrackScanner struct {
+	rd     *bufio.Reader
+	offset int64 // the offset of the next byte
+	end    int64
+	ticks  uint64 // the absolute ticks of the last event
+	status byte   // the running status
+	buf    []byte
+}
+
+func newSMFTrackScanner(src io.ReaderAt, cp smfCheckpoint, end int64) *smfTrackScanner {
+	return &smfTrackScanner{
+		rd:     bufio.NewReader(io.NewSectionReader(src, cp.offset, end-cp.offset)),
+		offset: cp.offset,
+		end:    end,
+		ticks:  cp.ticks,
+		status: cp.status,
+	}
+}
+
+func (s *smfTrackScanner) readByte() (byte, error) {
+	b, err := s.rd.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		s.offset++
+	}
+	return b, err
+}
+
+func (s *smfTrackScanner) readVarLen() (v uint32, err error) {
+	for i := 0; i < 4; i++ {
+		var b byte
+		b, err = s.readByte()
+		if err != nil {
+			return
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b < 0x80 {
+			return
+		}
+	}
+	return 0, fmt.Errorf("invalid variable length quantity at offset %v", s.offset)
+}
+
+// next reads the next event. It returns io.EOF at the end of the track.
+// The meta data is only valid until the next call.
+func (s *smfTrackScanner) next() (ev smfEvent, err error) {
+	if s.offset >= s.end {
+		return ev, io.EOF
+	}
+
+	delta, err := s.readVarLen()
+	if err != nil {
+		return
+	}
+
+	b, err := s.readByte()
+	if err != nil {
+		return
+	}
+
+	switch {
+	case b == 0xFF:
+		s.status = 0
+		ev.status = b
+		if ev.metaType, err = s.readByte(); err != nil {
+			return
+		}
+		if ev.meta, err = s.readData(); err != nil {
+			return
+		}
+
+	case b == 0xF0 || b == 0xF7:
+		s.status = 0
+		ev.status = b
+		if _, err = s.readData(); err != nil {
+			return
+		}
+
+	case midistream.IsChannelStatus(b):
+		s.status = b
+		ev.status = b
+		for i := 0; i < midistream.DataLen(b); i++ {
+			if ev.data[i], err = s.readByte(); err != nil {
+				return
+			}
+		}
+
+	case b < 0x80 && s.status != 0:
+		ev.status = s.status
+		ev.data[0] = b
+		if midistream.DataLen(s.status) == 2 {
+			if ev.data[1], err = s.readByte(); err != nil {
+				return
+			}
+		}
+
+	default:
+		return ev, fmt.Errorf("unexpected byte % X at offset %v", b, s.offset-1)
+	}
+
+	s.ticks += uint64(delta)
+	return
+}
+
+// readData reads data that is prefixed by its length
+func (s *smfTrackScanner) readData() ([]byte, error) {
+	l, err := s.readVarLen()
+	if err != nil {
+		return nil, err
+	}
+
+	// only short data (like tempo and time signature) is kept
+	if l > 16 {
+		n, err := io.CopyN(ioutil.Discard, s.rd, int64(l))
+		s.offset += n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	s.buf = s.buf[:0]
+	for i := uint32(0); i < l; i++ {
+		b, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		s.buf = append(s.buf, b)
+	}
+	return s.buf, nil
+}
@@ -0,0 +1,259 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+)
+
+// writeIndexTestSMF writes a SMF file with two tracks and more events than fit between two checkpoints
+func writeIndexTestSMF() []byte {
+	var bf bytes.Buffer
+	wr := NewSMF(&bf, 2)
+
+	wr.TempoBPM(120)
+	wr.Meter(3, 4)
+	wr.SetDelta(4800)
+	wr.TempoBPM(90)
+	wr.SetDelta(2400)
+	wr.Meter(6, 8)
+	wr.EndOfTrack()
+
+	wr.SetChannel(2)
+	wr.ProgramChange(5)
+	for i := 0; i < 1000; i++ {
+		wr.SetDelta(10)
+		wr.ControlChange(7, uint8(i%128))
+		switch {
+		case i%100 == 0:
+			wr.NoteOn(uint8(60+i/100), 100)
+		case i%100 == 50:
+			wr.NoteOff(uint8(60 + i/100))
+		case i%7 == 0:
+			wr.Pitchbend(int16(i*8 - 4000))
+		case i == 333:
+			wr.SysEx(bytes.Repeat([]byte{0x11}, 40))
+			wr.Aftertouch(33)
+			wr.SetChannel(3)
+			wr.ProgramChange(9)
+			wr.SetChannel(2)
+		}
+	}
+	wr.EndOfTrack()
+	return bf.Bytes()
+}
+
+type indexTestEvent struct {
+	track int16
+	ticks uint64
+	key   uint16
+	value int16
+	desc  string
+}
+
+func recordIndexTestEvents(rd *Reader, events *[]indexTestEvent) {
+	add := func(p *Position, key uint16, value int16, desc string) {
+		*events = append(*events, indexTestEvent{p.Track, p.AbsoluteTicks, key, value,
+			fmt.Sprintf("#%v %v d:%v %s", p.Track, p.AbsoluteTicks, p.DeltaTicks, desc)})
+	}
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		add(p, uint16(ch)<<9|stateNotes+uint16(key), int16(vel), fmt.Sprintf("on %v %v %v", ch, key, vel))
+	}
+	rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) {
+		add(p, uint16(ch)<<9|stateNotes+uint16(key), 0, fmt.Sprintf("off %v %v", ch, key))
+	}
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) {
+		add(p, uint16(ch)<<9|uint16(cc), int16(val), fmt.Sprintf("cc %v %v %v", ch, cc, val))
+	}
+	rd.Msg.Channel.ProgramChange = func(p *Position, ch, prog uint8) {
+		add(p, uint16(ch)<<9|stateProgram, int16(prog), fmt.Sprintf("pc %v %v", ch, prog))
+	}
+	rd.Msg.Channel.Aftertouch = func(p *Position, ch, val uint8) {
+		add(p, uint16(ch)<<9|stateAftertouch, int16(val), fmt.Sprintf("at %v %v", ch, val))
+	}
+	rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, val int16) {
+		add(p, uint16(ch)<<9|statePitchbend, val, fmt.Sprintf("pb %v %v", ch, val))
+	}
+	rd.Msg.Meta.TempoBPM = func(p Position, bpm float64) {
+		add(&p, 0xFFFF, 0, fmt.Sprintf("tempo %v", bpm))
+	}
+	rd.Msg.SysEx.Complete = func(p *Position, data []byte) {
+		add(p, 0xFFFF, 0, fmt.Sprintf("sysex %v", len(data)))
+	}
+}
+
+func TestSMFIndexRead(t *testing.T) {
+	data := writeIndexTestSMF()
+
+	var all []indexTestEvent
+	full := NewReader(NoLogger())
+	recordIndexTestEvents(full, &all)
+	if err := full.ReadSMF(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadSMF returned error: %v", err)
+	}
+
+	x, err := NewSMFIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewSMFIndex returned error: %v", err)
+	}
+	if hd := x.Header(); hd.NumTracks != 2 || hd.Format.String() != full.header.Format.String() {
+		t.Errorf("wrong header %v", hd)
+	}
+	if x.Length() != 10000 {
+		t.Errorf("Length() = %v; want 10000", x.Length())
+	}
+	if len(x.tracks[1].checkpoints) < 4 {
+		t.Errorf("expected several checkpoints, got %v", len(x.tracks[1].checkpoints))
+	}
+
+	// the checkpoints store only the values that changed since the previous checkpoint
+	cps := x.tracks[1].checkpoints
+	for i := 1; i < len(cps); i++ {
+		for _, e := range cps[i].state {
+			if e.ticks < cps[i-1].ticks {
+				t.Errorf("checkpoint %v stores the value %v that has been set before checkpoint %v", i, e, i-1)
+			}
+		}
+	}
+
+	for _, from := range []uint64{0, 1, 2560, 2561, 4800, 5005, 9990, 10000, 20000} {
+		var want, got []string
+		for _, ev := range all {
+			if ev.ticks >= from {
+				want = append(want, ev.desc)
+			}
+		}
+
+		var events []indexTestEvent
+		rd := NewReader(NoLogger())
+		recordIndexTestEvents(rd, &events)
+		if err := rd.ReadSMFIndex(x, from); err != nil {
+			t.Fatalf("ReadSMFIndex(%v) returned error: %v", from, err)
+		}
+		for _, ev := range events {
+			got = append(got, ev.desc)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("ReadSMFIndex(%v):\ngot  %v\nwant %v", from, got, want)
+		}
+
+		if got, want := *rd.TimeAt(9000), *full.TimeAt(9000); got != want {
+			t.Errorf("ReadSMFIndex(%v): TimeAt(9000) = %v; want %v", from, got, want)
+		}
+	}
+}
+
+func TestSMFIndexStateAt(t *testing.T) {
+	data := writeIndexTestSMF()
+
+	var all []indexTestEvent
+	rd := NewReader(NoLogger())
+	recordIndexTestEvents(rd, &all)
+	rd.ReadSMF(bytes.NewReader(data))
+	sort.SliceStable(all, func(a, b int) bool { return all[a].ticks < all[b].ticks })
+
+	x, err := NewSMFIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewSMFIndex returned error: %v", err)
+	}
+
+	for _, at := range []uint64{0, 10, 2560, 3340, 3345, 5000, 7200, 7201, 10000, 20000} {
+		var want SMFState
+		for ch := range want.Channels {
+			c := &want.Channels[ch]
+			c.Program, c.Aftertouch = -1, -1
+			for cc := range c.Controllers {
+				c.Controllers[cc] = -1
+			}
+		}
+		for _, ev := range all {
+			if ev.ticks >= at {
+				break
+			}
+			if ev.key == 0xFFFF {
+				continue
+			}
+			c := &want.Channels[ev.key>>9]
+			switch param := ev.key & 0x1FF; {
+			case param < stateNotes:
+				c.Controllers[param] = int8(ev.value)
+			case param < stateProgram:
+				c.Notes[param-stateNotes] = uint8(ev.value)
+			case param == stateProgram:
+				c.Program = int8(ev.value)
+			case param == stateAftertouch:
+				c.Aftertouch = int8(ev.value)
+			case param == statePitchbend:
+				c.Pitchbend = ev.value
+			}
+		}
+
+		got, err := x.StateAt(at)
+		if err != nil {
+			t.Fatalf("StateAt(%v) returned error: %v", at, err)
+		}
+		if got.Channels != want.Channels {
+			t.Errorf("StateAt(%v): wrong channel state\ngot  %+v\nwant %+v", at, got.Channels[2:4], want.Channels[2:4])
+		}
+	}
+
+	tests := []struct {
+		at                     uint64
+		bpm                    float64
+		numerator, denominator uint8
+	}{
+		{0, 120, 4, 4},
+		{1, 120, 3, 4},
+		{4800, 120, 3, 4},
+		{4801, 90, 3, 4},
+		{7201, 90, 6, 8},
+	}
+	for _, test := range tests {
+		st, _ := x.StateAt(test.at)
+		if math.Abs(st.TempoBPM-test.bpm) > 0.001 || st.Numerator != test.numerator || st.Denominator != test.denominator {
+			t.Errorf("StateAt(%v) = %v %v/%v; want %v %v/%v", test.at, st.TempoBPM, st.Numerator, st.Denominator,
+				test.bpm, test.numerator, test.denominator)
+		}
+	}
+}
+
+func TestSMFIndexInvalidTimeSignature(t *testing.T) {
+	data := []byte("MThd\x00\x00\x00\x06\x00\x00\x00\x01\x00\x60" +
+		"MTrk\x00\x00\x00\x14" +
+		"\x00\xFF\x58\x04\x03\x02\x18\x08" + // 3/4
+		"\x0A\xFF\x58\x04\x04\x08\x18\x08" + // 4/256
+		"\x00\xFF\x2F\x00")
+
+	x, err := NewSMFIndex(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewSMFIndex returned error: %v", err)
+	}
+
+	st, err := x.StateAt(20)
+	if err != nil {
+		t.Fatalf("StateAt returned error: %v", err)
+	}
+	if st.Numerator != 3 || st.Denominator != 4 {
+		t.Errorf("StateAt(20) = %v/%v; want 3/4", st.Numerator, st.Denominator)
+	}
+}
+
+func TestSMFIndexInvalid(t *testing.T) {
+	data := writeIndexTestSMF()
+
+	tests := [][]byte{
+		nil,
+		[]byte("MThx\x00\x00\x00\x06\x00\x01\x00\x01\x00\x60"),
+		[]byte("MThd\x00\x00\x00\x06\x00\x05\x00\x01\x00\x60"),
+		data[:len(data)-2],
+		append(append([]byte{}, data[:30]...), 0xF2, 0x01),
+	}
+	for _, test := range tests {
+		if _, err := NewSMFIndex(bytes.NewReader(test)); err == nil {
+			t.Errorf("NewSMFIndex(% X) must return an error", test)
+		}
+	}
+}
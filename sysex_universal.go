@@ -0,0 +1,50 @@
+package mid
+
+const (
+	// AllDevices is the device ID of universal system exclusive messages that addresses every device
+	AllDevices = 0x7F
+
+	// universalNonRealtime is the ID of universal non-realtime system exclusive messages
+	universalNonRealtime = 0x7E
+
+	// universalRealtime is the ID of universal realtime system exclusive messages
+	universalRealtime = 0x7F
+)
+
+// universalSysEx returns the data (without 0xF0 and 0xF7) of a universal system exclusive message
+func universalSysEx(realtime bool, deviceID, subID1, subID2 uint8, data ...byte) []byte {
+	var id byte = universalNonRealtime
+	if realtime {
+		id = universalRealtime
+	}
+	return append([]byte{id, deviceID & 0x7F, subID1, subID2}, data...)
+}
+
+// isUniversalSysEx checks if data is a universal system exclusive message of the given sub IDs.
+// The realtime and the non-realtime variants are both accepted.
+func isUniversalSysEx(data []byte, subID1, subID2 uint8) bool {
+	if len(data) < 4 {
+		return false
+	}
+	if data[0] != universalNonRealtime && data[0] != universalRealtime {
+		return false
+	}
+	return data[2] == subID1 && data[3] == subID2
+}
+
+// dispatchUniversalSysEx dispatches universal system exclusive messages to their dedicated callbacks.
+// It returns false, if the message has not been handled and should be passed to SysEx.Complete.
+func (r *Reader) dispatchUniversalSysEx(data []byte) bool {
+	if len(data) < 4 || (data[0] != universalNonRealtime && data[0] != universalRealtime) {
+		return false
+	}
+
+	if r.Msg.SysEx.IdentityReply != nil {
+		if dev, id, ok := ParseIdentityReply(data); ok {
+			r.Msg.SysEx.IdentityReply(r.pos, dev, id)
+			return true
+		}
+	}
+
+	return r.dispatchTuning(data)
+}
@@ -0,0 +1,309 @@
+package mid
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinBPM and DefaultMaxBPM are the default tempo range of a TempoDetector
+	DefaultMinBPM = 60
+	DefaultMaxBPM = 180
+
+	// DefaultMinConfidence is the default confidence a TempoDetector needs to change the tempo of the Reader
+	DefaultMinConfidence = 0.6
+
+	// TapTimeout is the pause after which tapping starts again
+	TapTimeout = 2 * time.Second
+)
+
+const (
+	chordThreshold = 50 * time.Millisecond // onsets closer together are played as one
+	onsetWindow    = 8 * time.Second       // onsets older than this (relative to the last one) are forgotten
+	maxOnsets      = 32
+	maxTaps        = 8
+	ioiTolerance   = 0.06 // tolerance of inter onset intervals relative to the beat
+	minIntervals   = 4    // the number of intervals needed for full confidence
+)
+
+// TempoDetector is a layer over a Reader that estimates the tempo from the timing of played notes,
+// or from the taps of a dedicated tap key (see SetTapKey).
+//
+// For played notes, all intervals between the recent note onsets (chords count as one onset) are analysed:
+// the beat is the period that explains most intervals as multiples of it, within the tempo range.
+// For taps, the tempo is the mean of the intervals between the recent taps.
+//
+// The confidence (0-1) tells how well the intervals fit the tempo. If it reaches MinConfidence, the tempo
+// of the Reader is changed (see Reader.TempoBPM) and Msg.Meta.TempoBPM is called, like for MIDI clock.
+//
+// Note on messages are passed to the callbacks that had been attached to the Reader before NewTempoDetector was
+// called, except for the tap key.
+type TempoDetector struct {
+	rd *Reader
+
+	mx         sync.Mutex
+	onsets     []time.Time
+	taps       []time.Time
+	tapKey     int // channel*128 + key, -1 for no tap key
+	bpm        float64
+	confidence float64
+
+	// now returns the time of a note on
+	now func() time.Time
+
+	prevNoteOn func(p *Position, channel, key, velocity uint8)
+
+	// MinBPM and MaxBPM are the range of tempi that are detected from played notes.
+	// Faster and slower beats are taken as multiples or divisions. If they are <= 0, DefaultMinBPM and DefaultMaxBPM are used.
+	// If MaxBPM is less than MinBPM, both defaults are used.
+	MinBPM, MaxBPM float64
+
+	// MinConfidence is the confidence that is needed to change the tempo of the Reader.
+	// If it is 0, DefaultMinConfidence is used.
+	MinConfidence float64
+
+	// Tempo is called for every new estimation, if it is not nil
+	Tempo func(bpm, confidence float64)
+}
+
+// NewTempoDetector attaches a TempoDetector to rd.
+func NewTempoDetector(rd *Reader) *TempoDetector {
+	t := &TempoDetector{rd: rd, tapKey: -1, now: time.Now}
+	t.prevNoteOn = rd.Msg.Channel.NoteOn
+	rd.Msg.Channel.NoteOn = t.noteOn
+	return t
+}
+
+// SetTapKey makes the given key the tap key. Then the tempo is detected from its taps only and its notes
+// are not passed to the previous callbacks.
+func (t *TempoDetector) SetTapKey(channel, key uint8) {
+	t.mx.Lock()
+	t.tapKey = int(channel&0x0F)*128 + int(key&0x7F)
+	t.taps = nil
+	t.mx.Unlock()
+}
+
+// UnsetTapKey detects the tempo from the played notes again
+func (t *TempoDetector) UnsetTapKey() {
+	t.mx.Lock()
+	t.tapKey = -1
+	t.mx.Unlock()
+}
+
+// Reset forgets the collected notes and taps and the estimation
+func (t *TempoDetector) Reset() {
+	t.mx.Lock()
+	t.onsets, t.taps = nil, nil
+	t.bpm, t.confidence = 0, 0
+	t.mx.Unlock()
+}
+
+// BPM returns the last estimated tempo and its confidence. The tempo is 0 if there is no estimation yet.
+func (t *TempoDetector) BPM() (bpm, confidence float64) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.bpm, t.confidence
+}
+
+// Tap registers a tap at the given time, e.g. from a button of the user interface.
+// It may be called while the Reader reads from another goroutine. If the tempo of the Reader is changed,
+// Msg.Meta.TempoBPM is called from the goroutine of the caller.
+func (t *TempoDetector) Tap(at time.Time) {
+	t.mx.Lock()
+	if n := len(t.taps); n > 0 && at.Sub(t.taps[n-1]) > TapTimeout {
+		t.taps = nil
+	}
+	t.taps = append(t.taps, at)
+	if len(t.taps) > maxTaps {
+		t.taps = t.taps[len(t.taps)-maxTaps:]
+	}
+	bpm, confidence := estimateTapTempo(t.taps)
+	t.mx.Unlock()
+
+	t.report(bpm, confidence)
+}
+
+// Onset registers a played note at the given time, like Tap.
+func (t *TempoDetector) Onset(at time.Time) {
+	t.mx.Lock()
+	if n := len(t.onsets); n > 0 && at.Sub(t.onsets[n-1]) < chordThreshold {
+		t.mx.Unlock()
+		return
+	}
+	t.onsets = append(t.onsets, at)
+
+	first := 0
+	for first < len(t.onsets) && at.Sub(t.onsets[first]) > onsetWindow {
+		first++
+	}
+	if len(t.onsets)-first > maxOnsets {
+		first = len(t.onsets) - maxOnsets
+	}
+	t.onsets = t.onsets[first:]
+
+	minBPM, maxBPM := t.MinBPM, t.MaxBPM
+	if !(minBPM > 0) {
+		minBPM = DefaultMinBPM
+	}
+	if !(maxBPM > 0) {
+		maxBPM = DefaultMaxBPM
+	}
+	if maxBPM < minBPM {
+		minBPM, maxBPM = DefaultMinBPM, DefaultMaxBPM
+	}
+	bpm, confidence := estimateTempo(t.onsets, minBPM, maxBPM)
+	t.mx.Unlock()
+
+	t.report(bpm, confidence)
+}
+
+func (t *TempoDetector) report(bpm, confidence float64) {
+	if bpm == 0 {
+		return
+	}
+
+	t.mx.Lock()
+	t.bpm, t.confidence = bpm, confidence
+	minConfidence := t.MinConfidence
+	t.mx.Unlock()
+
+	if minConfidence == 0 {
+		minConfidence = DefaultMinConfidence
+	}
+
+	if t.Tempo != nil {
+		t.Tempo(bpm, confidence)
+	}
+
+	if confidence >= minConfidence && math.Abs(bpm-t.rd.TempoBPM()) >= 0.01 {
+		t.rd.liveTempoChange(bpm)
+	}
+}
+
+func (t *TempoDetector) noteOn(p *Position, ch, key, vel uint8) {
+	t.mx.Lock()
+	tapKey := t.tapKey
+	t.mx.Unlock()
+
+	switch {
+	case tapKey == int(ch)*128+int(key):
+		t.Tap(t.now())
+		return
+	case tapKey < 0:
+		t.Onset(t.now())
+	}
+
+	if t.prevNoteOn != nil {
+		t.prevNoteOn(p, ch, key, vel)
+	}
+}
+
+// estimateTapTempo returns the tempo of the mean tap interval. The confidence decreases with the variation of the
+// intervals and is reduced for few taps.
+func estimateTapTempo(taps []time.Time) (bpm, confidence float64) {
+	n := len(taps) - 1
+	if n < 1 {
+		return 0, 0
+	}
+
+	var sum, sumSq float64
+	for i := 1; i < len(taps); i++ {
+		d := taps[i].Sub(taps[i-1]).Seconds()
+		sum += d
+		sumSq += d * d
+	}
+	mean := sum / float64(n)
+	if mean <= 0 {
+		return 0, 0
+	}
+	variation := math.Sqrt(math.Max(0, sumSq/float64(n)-mean*mean)) / mean
+
+	confidence = math.Max(0, 1-variation/(2*ioiTolerance)) * math.Min(1, float64(n)/minIntervals)
+	return 60 / mean, confidence
+}
+
+// estimateTempo estimates the tempo of the onsets within the tempo range by analysing the intervals between
+// all pairs of onsets up to two beats of the lowest tempo.
+func estimateTempo(onsets []time.Time, minBPM, maxBPM float64) (bpm, confidence float64) {
+	if len(onsets) < 3 {
+		return 0, 0
+	}
+
+	maxIOI := 2 * 60 / minBPM
+	var iois []float64
+	for i := range onsets {
+		for j := i + 1; j < len(onsets); j++ {
+			d := onsets[j].Sub(onsets[i]).Seconds()
+			if d > maxIOI {
+				break
+			}
+			iois = append(iois, d)
+		}
+	}
+
+	// every interval, folded into the tempo range, is a candidate for the beat
+	var best, bestScore float64
+	for _, d := range iois {
+		beat := d
+		for 60/beat < minBPM {
+			beat /= 2
+		}
+		for 60/beat > maxBPM {
+			beat *= 2
+		}
+		if 60/beat < minBPM {
+			// the range is too narrow for this interval
+			continue
+		}
+		if score, _, _ := scoreBeat(iois, beat); score > bestScore {
+			best, bestScore = beat, score
+		}
+	}
+	if best == 0 {
+		return 0, 0
+	}
+
+	// refine the beat by the matching intervals
+	score, sumIOI, sumMultiples := scoreBeat(iois, best)
+	beat := sumIOI / sumMultiples
+
+	// intervals on a subdivision of the beat (e.g. eighth notes) neither support nor contradict it
+	var total float64
+	for _, d := range iois {
+		k := math.Round(d / beat)
+		if (k < 1 || math.Abs(d-k*beat) > ioiTolerance*beat) && isSubdivision(d, beat) {
+			continue
+		}
+		total += 1 / math.Max(1, k)
+	}
+
+	confidence = score / total * math.Min(1, float64(len(onsets)-1)/minIntervals)
+	return 60 / beat, confidence
+}
+
+// scoreBeat returns the score of the beat: the sum of 1/k for the intervals that are k-multiples of the beat
+// (so that divisions of the beat score less than the beat), the sum of these intervals and the sum of their k.
+func scoreBeat(iois []float64, beat float64) (score, sumIOI, sumMultiples float64) {
+	for _, d := range iois {
+		k := math.Round(d / beat)
+		if k < 1 || math.Abs(d-k*beat) > ioiTolerance*beat {
+			continue
+		}
+		score += 1 / k
+		sumIOI += d
+		sumMultiples += k
+	}
+	return
+}
+
+// isSubdivision returns true, if the interval is a multiple of a half, a third or a quarter of the beat
+func isSubdivision(d, beat float64) bool {
+	for div := 2.0; div <= 4; div++ {
+		sub := beat / div
+		if k := math.Round(d / sub); k >= 1 && math.Abs(d-k*sub) <= ioiTolerance*sub {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package mid
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// onsetTimes returns the times of the given offsets in seconds
+func onsetTimes(offsets ...float64) []time.Time {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := make([]time.Time, len(offsets))
+	for i, o := range offsets {
+		res[i] = start.Add(time.Duration(o * float64(time.Second)))
+	}
+	return res
+}
+
+func TestEstimateTempo(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	jitter := func(offsets []float64, max float64) []float64 {
+		for i := range offsets {
+			offsets[i] += (rnd.Float64()*2 - 1) * max
+		}
+		return offsets
+	}
+
+	tests := []struct {
+		name          string
+		offsets       []float64
+		bpm           float64
+		minConfidence float64
+		maxConfidence float64
+	}{
+		{"quarters at 120", []float64{0, 0.5, 1, 1.5, 2, 2.5}, 120, 0.99, 1},
+		{"eighths at 120", []float64{0, 0.25, 0.5, 0.75, 1, 1.25, 1.5, 1.75, 2}, 120, 0.99, 1},
+		{"quarters at 240 are 120", []float64{0, 0.25, 0.5, 0.75, 1}, 120, 0.99, 1},
+		{"half notes at 50 are 100", []float64{0, 1.2, 2.4, 3.6, 4.8}, 100, 0.99, 1},
+		{"rhythm at 90", []float64{0, 0.667, 1, 1.333, 2, 2.667, 3.333, 4}, 90, 0.6, 1},
+		{"jitter at 100", jitter([]float64{0, 0.6, 1.2, 1.8, 2.4, 3, 3.6, 4.2}, 0.01), 100, 0.6, 1},
+		{"few notes", []float64{0, 0.5, 1}, 120, 0.4, 0.6},
+		{"random", []float64{0, 0.31, 0.47, 1.13, 1.2, 1.93, 2.41, 2.55, 3.38, 3.6}, 0, 0, 0.59},
+	}
+
+	for _, test := range tests {
+		bpm, confidence := estimateTempo(onsetTimes(test.offsets...), DefaultMinBPM, DefaultMaxBPM)
+		if test.bpm > 0 && math.Abs(bpm-test.bpm) > 1 {
+			t.Errorf("%s: bpm %.2f; want %.2f", test.name, bpm, test.bpm)
+		}
+		if confidence < test.minConfidence || confidence > test.maxConfidence {
+			t.Errorf("%s: confidence %.2f; want %.2f-%.2f", test.name, confidence, test.minConfidence, test.maxConfidence)
+		}
+	}
+
+	if bpm, _ := estimateTempo(onsetTimes(0, 1), DefaultMinBPM, DefaultMaxBPM); bpm != 0 {
+		t.Errorf("2 onsets must not be enough, got %v", bpm)
+	}
+}
+
+func TestEstimateTapTempo(t *testing.T) {
+	tests := []struct {
+		offsets       []float64
+		bpm           float64
+		minConfidence float64
+		maxConfidence float64
+	}{
+		{[]float64{0, 0.4}, 150, 0.2, 0.3},
+		{[]float64{0, 0.4, 0.8, 1.2, 1.6}, 150, 1, 1},
+		// taps are not folded into a tempo range
+		{[]float64{0, 0.25, 0.5, 0.75, 1}, 240, 1, 1},
+		{[]float64{0, 0.5, 0.9, 1.5, 1.9}, 126.3, 0, 0.3},
+	}
+
+	for _, test := range tests {
+		bpm, confidence := estimateTapTempo(onsetTimes(test.offsets...))
+		if math.Abs(bpm-test.bpm) > 0.1 {
+			t.Errorf("%v: bpm %.2f; want %.2f", test.offsets, bpm, test.bpm)
+		}
+		if confidence < test.minConfidence || confidence > test.maxConfidence {
+			t.Errorf("%v: confidence %.2f; want %.2f-%.2f", test.offsets, confidence, test.minConfidence, test.maxConfidence)
+		}
+	}
+}
+
+func TestTempoDetector(t *testing.T) {
+	rd := NewReader(NoLogger())
+
+	var passed []uint8
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		passed = append(passed, key)
+	}
+
+	var tempoChanges []float64
+	rd.Msg.Meta.TempoBPM = func(p Position, bpm float64) {
+		tempoChanges = append(tempoChanges, math.Round(bpm))
+	}
+
+	td := NewTempoDetector(rd)
+
+	var now time.Time
+	td.now = func() time.Time { return now }
+
+	// a chord every 0.6s (100 BPM)
+	var in []byte
+	for i := 0; i < 6; i++ {
+		in = append(in, 0x90, 60, 100, 0x90, 64, 100, 0x80, 60, 0, 0x80, 64, 0)
+	}
+	rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) {
+		if key == 64 {
+			now = now.Add(600 * time.Millisecond)
+		}
+	}
+
+	// Read resets the tempo of the Reader, so check it within the reading
+	var readerTempo float64
+	rd.Msg.Realtime.Stop = func() { readerTempo = rd.TempoBPM() }
+	in = append(in, 0xFC)
+
+	rd.Read(bytes.NewReader(in))
+
+	if math.Abs(readerTempo-100) > 0.01 {
+		t.Errorf("the tempo of the reader must be 100, got %v", readerTempo)
+	}
+	if len(tempoChanges) == 0 || tempoChanges[len(tempoChanges)-1] != 100 {
+		t.Errorf("wrong tempo changes %v", tempoChanges)
+	}
+	if bpm, confidence := td.BPM(); math.Abs(bpm-100) > 0.01 || confidence != 1 {
+		t.Errorf("BPM() = %v, %v; want 100, 1", bpm, confidence)
+	}
+	if len(passed) != 12 {
+		t.Errorf("the notes must be passed, got %v", passed)
+	}
+
+	// tap key
+	passed = nil
+	tempoChanges = nil
+	td.Reset()
+	td.SetTapKey(9, 36)
+
+	var estimations int
+	td.Tempo = func(bpm, confidence float64) { estimations++ }
+
+	in = nil
+	for i := 0; i < 5; i++ {
+		in = append(in, 0x99, 36, 100, 0x90, 64, 100, 0x80, 64, 0)
+	}
+	in = append(in, 0xFC)
+	rd.Read(bytes.NewReader(in))
+
+	if math.Abs(readerTempo-100) > 0.01 {
+		t.Errorf("the tempo of the reader must be 100 after tapping, got %v", readerTempo)
+	}
+	if estimations != 4 {
+		t.Errorf("expected 4 estimations from 5 taps, got %v", estimations)
+	}
+	if len(passed) != 5 {
+		t.Errorf("only the other notes must be passed, got %v", passed)
+	}
+}
+
+func TestTempoDetectorTapWhileReading(t *testing.T) {
+	rd := NewReader(NoLogger())
+	var sum uint64
+	rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) { sum += p.AbsoluteTicks }
+	td := NewTempoDetector(rd)
+	in := newTestInReader(rd)
+
+	done := make(chan bool)
+	go func() {
+		start := time.Now()
+		for i := 0; i < 100; i++ {
+			td.Tap(start.Add(time.Duration(i) * 400 * time.Millisecond))
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 1000; i++ {
+		in.handleMessage([]byte{0xB0, 7, 100}, 1000)
+		rd.TimeAt(uint64(i))
+	}
+	<-done
+
+	if bpm := rd.TempoBPM(); math.Abs(bpm-150) > 0.01 {
+		t.Errorf("the tempo of the reader must be 150, got %v", bpm)
+	}
+}
+
+func TestTempoDetectorInvalidRange(t *testing.T) {
+	tests := []struct {
+		min, max float64
+	}{
+		{-60, 0},
+		{0, -180},
+		{120, 90},
+		{math.NaN(), math.NaN()},
+	}
+
+	for _, test := range tests {
+		rd := NewReader(NoLogger())
+		rd.Read(bytes.NewReader(nil))
+		td := NewTempoDetector(rd)
+		td.MinBPM, td.MaxBPM = test.min, test.max
+
+		done := make(chan bool)
+		go func() {
+			for _, at := range onsetTimes(0, 0.6, 1.2, 1.8, 2.4, 3.0) {
+				td.Onset(at)
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("MinBPM %v, MaxBPM %v: the estimation does not terminate", test.min, test.max)
+		}
+
+		if bpm, _ := td.BPM(); math.Abs(bpm-100) > 0.01 {
+			t.Errorf("MinBPM %v, MaxBPM %v: got %v BPM; want 100", test.min, test.max, bpm)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package mid
+
+import (
+	"io"
+
+	"github.com/gomidi/mid/ump"
+	"github.com/gomidi/midi/midiwriter"
+)
+
+// ReadUMP reads Universal MIDI Packets from src until an error happens (like Read).
+// The packets are translated to MIDI 1.0 messages and dispatched to the attached functions of the Reader.
+// Packets without a MIDI 1.0 equivalent are skipped.
+func (r *Reader) ReadUMP(src io.Reader) error {
+	return r.Read(ump.NewMIDI1Reader(src))
+}
+
+// NewUMPWriter creates a new Writer that writes Universal MIDI Packets of the given group to dest.
+// If midi2 is true, channel voice messages are translated to MIDI 2.0 channel voice messages,
+// otherwise they are written as MIDI 1.0 channel voice packets.
+func NewUMPWriter(dest io.Writer, group uint8, midi2 bool, options ...midiwriter.Option) *Writer {
+	return NewWriter(ump.NewMIDI1Writer(dest, group, midi2), options...)
+}
@@ -0,0 +1,105 @@
+package ump
+
+// status of system exclusive (data) messages
+const (
+	SysExComplete = 0x0
+	SysExStart    = 0x1
+	SysExContinue = 0x2
+	SysExEnd      = 0x3
+)
+
+const (
+	sysex7BytesPerPacket = 6
+	sysex8BytesPerPacket = 13
+)
+
+// sysexStatus returns the status for the i-th of n packets
+func sysexStatus(i, n int) uint8 {
+	switch {
+	case n == 1:
+		return SysExComplete
+	case i == 0:
+		return SysExStart
+	case i == n-1:
+		return SysExEnd
+	default:
+		return SysExContinue
+	}
+}
+
+// SysEx7 returns the Data64 packets for the given system exclusive data (without 0xF0 and 0xF7)
+func SysEx7(group uint8, data []byte) (ps []Packet) {
+	n := (len(data) + sysex7BytesPerPacket - 1) / sysex7BytesPerPacket
+	if n == 0 {
+		n = 1
+	}
+
+	for i := 0; i < n; i++ {
+		chunk := data[i*sysex7BytesPerPacket:]
+		if len(chunk) > sysex7BytesPerPacket {
+			chunk = chunk[:sysex7BytesPerPacket]
+		}
+
+		var b [sysex7BytesPerPacket]byte
+		copy(b[:], chunk)
+
+		ps = append(ps, Packet{
+			word0(Data64, group, sysexStatus(i, n)<<4|uint8(len(chunk)), b[0]&0x7F, b[1]&0x7F),
+			uint32(b[2]&0x7F)<<24 | uint32(b[3]&0x7F)<<16 | uint32(b[4]&0x7F)<<8 | uint32(b[5]&0x7F),
+		})
+	}
+	return
+}
+
+// SysEx7Data returns the data bytes of a Data64 packet
+func (p Packet) SysEx7Data() []byte {
+	n := int(p[0]>>16) & 0x0F
+	if n > sysex7BytesPerPacket {
+		n = sysex7BytesPerPacket
+	}
+	b := []byte{uint8(p[0] >> 8), uint8(p[0]), uint8(p[1] >> 24), uint8(p[1] >> 16), uint8(p[1] >> 8), uint8(p[1])}
+	return b[:n]
+}
+
+// SysEx8 returns the Data128 packets for the given 8bit system exclusive data and stream ID
+func SysEx8(group, streamID uint8, data []byte) (ps []Packet) {
+	n := (len(data) + sysex8BytesPerPacket - 1) / sysex8BytesPerPacket
+	if n == 0 {
+		n = 1
+	}
+
+	for i := 0; i < n; i++ {
+		chunk := data[i*sysex8BytesPerPacket:]
+		if len(chunk) > sysex8BytesPerPacket {
+			chunk = chunk[:sysex8BytesPerPacket]
+		}
+
+		var b [sysex8BytesPerPacket]byte
+		copy(b[:], chunk)
+
+		// the number of bytes includes the stream ID
+		ps = append(ps, Packet{
+			word0(Data128, group, sysexStatus(i, n)<<4|uint8(len(chunk)+1), streamID, b[0]),
+			uint32(b[1])<<24 | uint32(b[2])<<16 | uint32(b[3])<<8 | uint32(b[4]),
+			uint32(b[5])<<24 | uint32(b[6])<<16 | uint32(b[7])<<8 | uint32(b[8]),
+			uint32(b[9])<<24 | uint32(b[10])<<16 | uint32(b[11])<<8 | uint32(b[12]),
+		})
+	}
+	return
+}
+
+// SysEx8Data returns the stream ID and the data bytes of a Data128 system exclusive packet
+func (p Packet) SysEx8Data() (streamID uint8, data []byte) {
+	n := int(p[0]>>16)&0x0F - 1
+	if n < 0 {
+		return uint8(p[0] >> 8), nil
+	}
+	if n > sysex8BytesPerPacket {
+		n = sysex8BytesPerPacket
+	}
+	b := []byte{uint8(p[0])}
+	for _, w := range p[1:] {
+		b = append(b, uint8(w>>24), uint8(w>>16), uint8(w>>8), uint8(w))
+	}
+	return uint8(p[0] >> 8), b[:n]
+}
@@ -0,0 +1,71 @@
+package ump
+
+// status of UMP stream messages
+const (
+	StreamStartOfClip = 0x20
+	StreamEndOfClip   = 0x21
+)
+
+// status of flex data messages (status bank 0)
+const (
+	FlexSetTempo         = 0x00
+	FlexSetTimeSignature = 0x01
+)
+
+// StartOfClip returns the stream message that marks the start of a clip
+func StartOfClip() Packet {
+	return Packet{uint32(Stream)<<28 | StreamStartOfClip<<16}
+}
+
+// EndOfClip returns the stream message that marks the end of a clip
+func EndOfClip() Packet {
+	return Packet{uint32(Stream)<<28 | StreamEndOfClip<<16}
+}
+
+// StreamStatus returns the 10bit status of a stream message
+func (p Packet) StreamStatus() uint16 {
+	return uint16(p[0]>>16) & 0x03FF
+}
+
+// flexGroup builds a complete flex data packet addressed to the whole group
+func flexGroup(group, status uint8, data ...uint32) Packet {
+	// form 0 (complete), address 1 (group)
+	p := Packet{word0(FlexData, group, 0x10, 0, status)}
+	copy(p[1:], data)
+	return p
+}
+
+// SetTempo returns a flex data message that sets the tempo in units of 10 nanoseconds per quarter note
+func SetTempo(group uint8, tenNanosPerQuarter uint32) Packet {
+	return flexGroup(group, FlexSetTempo, tenNanosPerQuarter)
+}
+
+// SetTempoBPM returns a flex data message that sets the tempo in beats per minute
+func SetTempoBPM(group uint8, bpm float64) Packet {
+	return SetTempo(group, uint32(6000000000/bpm+0.5))
+}
+
+// SetTimeSignature returns a flex data message that sets the time signature.
+// The denominator is given as power of 2 (like in SMF meta messages) and
+// thirtySeconds is the number of 1/32 notes in a quarter note (0 means 8).
+func SetTimeSignature(group, numerator, denominatorPower, thirtySeconds uint8) Packet {
+	return flexGroup(group, FlexSetTimeSignature, uint32(numerator)<<24|uint32(denominatorPower)<<16|uint32(thirtySeconds)<<8)
+}
+
+// FlexStatus returns the status bank and the status of a flex data message
+func (p Packet) FlexStatus() (bank, status uint8) {
+	return uint8(p[0] >> 8), uint8(p[0])
+}
+
+// Tempo returns the tempo of a flex data set tempo message in beats per minute
+func (p Packet) Tempo() (bpm float64) {
+	if p[1] == 0 {
+		return 0
+	}
+	return 6000000000 / float64(p[1])
+}
+
+// TimeSignature returns the time signature of a flex data set time signature message
+func (p Packet) TimeSignature() (numerator, denominatorPower, thirtySeconds uint8) {
+	return uint8(p[1] >> 24), uint8(p[1] >> 16), uint8(p[1] >> 8)
+}
@@ -0,0 +1,143 @@
+package ump
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// Reader reads Universal MIDI Packets from a byte stream (big endian words)
+type Reader struct {
+	src io.Reader
+	buf [16]byte
+}
+
+// NewReader returns a Reader that reads packets from src
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src}
+}
+
+// Read reads the next packet. At the end of the stream io.EOF is returned.
+// If the stream ends within a packet, io.ErrUnexpectedEOF is returned.
+func (r *Reader) Read() (p Packet, err error) {
+	_, err = io.ReadFull(r.src, r.buf[:4])
+	if err != nil {
+		return
+	}
+	p[0] = binary.BigEndian.Uint32(r.buf[:4])
+
+	n := p.Size()
+	if n == 1 {
+		return
+	}
+
+	_, err = io.ReadFull(r.src, r.buf[4:n*4])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return
+	}
+
+	for i := 1; i < n; i++ {
+		p[i] = binary.BigEndian.Uint32(r.buf[i*4:])
+	}
+	return
+}
+
+// Writer writes Universal MIDI Packets to a byte stream (big endian words)
+type Writer struct {
+	dest io.Writer
+}
+
+// NewWriter returns a Writer that writes packets to dest
+func NewWriter(dest io.Writer) *Writer {
+	return &Writer{dest: dest}
+}
+
+// Write writes the given packets
+func (w *Writer) Write(ps ...Packet) error {
+	for _, p := range ps {
+		_, err := w.dest.Write(p.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type midi1Reader struct {
+	rd    *Reader
+	trans MIDI2To1
+	buf   []byte
+}
+
+// NewMIDI1Reader returns an io.Reader that reads the UMP stream from src and
+// returns the translated MIDI 1.0 byte stream. It can be passed to the Read method
+// of the mid.Reader to get the callbacks for the packets.
+func NewMIDI1Reader(src io.Reader) io.Reader {
+	return &midi1Reader{rd: NewReader(src)}
+}
+
+func (r *midi1Reader) Read(b []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		var p Packet
+		p, err = r.rd.Read()
+		if err != nil {
+			return 0, err
+		}
+		for _, msg := range r.trans.Translate(p) {
+			r.buf = append(r.buf, msg...)
+		}
+	}
+
+	n = copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return
+}
+
+type midi1Writer struct {
+	wr       *Writer
+	group    uint8
+	trans    *MIDI1To2
+	splitter *midistream.Splitter
+	err      error
+}
+
+// NewMIDI1Writer returns an io.Writer that translates the MIDI 1.0 byte stream written to it
+// into Universal MIDI Packets of the given group and writes them to dest.
+// If midi2 is true, channel voice messages are translated to MIDI 2.0 channel voice messages,
+// otherwise they are written as MIDI 1.0 channel voice packets.
+// It can be passed to mid.NewWriter to use the Writer methods for UMP streams.
+func NewMIDI1Writer(dest io.Writer, group uint8, midi2 bool) io.Writer {
+	w := &midi1Writer{wr: NewWriter(dest), group: group}
+	if midi2 {
+		w.trans = &MIDI1To2{Group: group}
+	}
+	w.splitter = midistream.New(w.writeMessage)
+	return w
+}
+
+func (w *midi1Writer) writeMessage(msg []byte) {
+	if w.err != nil {
+		return
+	}
+
+	var ps []Packet
+	if w.trans != nil {
+		ps = w.trans.Translate(msg)
+	} else {
+		ps = MIDI1Packets(w.group, msg)
+	}
+
+	w.err = w.wr.Write(ps...)
+}
+
+func (w *midi1Writer) Write(b []byte) (int, error) {
+	w.splitter.Write(b)
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(b), nil
+}
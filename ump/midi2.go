@@ -0,0 +1,140 @@
+package ump
+
+// opcodes of MIDI 2.0 channel voice messages
+const (
+	OpRegisteredPerNoteController = 0x0
+	OpAssignablePerNoteController = 0x1
+	OpRPN                         = 0x2
+	OpNRPN                        = 0x3
+	OpRelativeRPN                 = 0x4
+	OpRelativeNRPN                = 0x5
+	OpPerNotePitchBend            = 0x6
+	OpNoteOff                     = 0x8
+	OpNoteOn                      = 0x9
+	OpPolyPressure                = 0xA
+	OpControlChange               = 0xB
+	OpProgramChange               = 0xC
+	OpChannelPressure             = 0xD
+	OpPitchBend                   = 0xE
+	OpPerNoteManagement           = 0xF
+)
+
+// PitchBendCenter is the center (no bend) of the 32bit MIDI 2.0 pitch bend
+const PitchBendCenter = 0x80000000
+
+// midi2 builds a MIDI 2.0 channel voice packet
+func midi2(group, op, channel, b2, b3 uint8, data uint32) Packet {
+	return Packet{word0(MIDI2ChannelVoice, group, op<<4|channel&0x0F, b2, b3), data}
+}
+
+// NoteOff returns a MIDI 2.0 note off message with a 16bit velocity and an attribute
+func NoteOff(group, channel, key uint8, velocity uint16, attrType uint8, attr uint16) Packet {
+	return midi2(group, OpNoteOff, channel, key&0x7F, attrType, uint32(velocity)<<16|uint32(attr))
+}
+
+// NoteOn returns a MIDI 2.0 note on message with a 16bit velocity and an attribute
+func NoteOn(group, channel, key uint8, velocity uint16, attrType uint8, attr uint16) Packet {
+	return midi2(group, OpNoteOn, channel, key&0x7F, attrType, uint32(velocity)<<16|uint32(attr))
+}
+
+// PolyPressure returns a MIDI 2.0 polyphonic key pressure message with a 32bit value
+func PolyPressure(group, channel, key uint8, pressure uint32) Packet {
+	return midi2(group, OpPolyPressure, channel, key&0x7F, 0, pressure)
+}
+
+// ControlChange returns a MIDI 2.0 control change message with a 32bit value
+func ControlChange(group, channel, controller uint8, value uint32) Packet {
+	return midi2(group, OpControlChange, channel, controller&0x7F, 0, value)
+}
+
+// ProgramChange returns a MIDI 2.0 program change message. If bankValid is true, the bank is selected, too.
+func ProgramChange(group, channel, program uint8, bankValid bool, bankMSB, bankLSB uint8) Packet {
+	var flags uint8
+	if bankValid {
+		flags = 1
+	}
+	return midi2(group, OpProgramChange, channel, 0, flags,
+		uint32(program&0x7F)<<24|uint32(bankMSB&0x7F)<<8|uint32(bankLSB&0x7F))
+}
+
+// ChannelPressure returns a MIDI 2.0 channel pressure message with a 32bit value
+func ChannelPressure(group, channel uint8, pressure uint32) Packet {
+	return midi2(group, OpChannelPressure, channel, 0, 0, pressure)
+}
+
+// PitchBend returns a MIDI 2.0 pitch bend message with a 32bit value (center is PitchBendCenter)
+func PitchBend(group, channel uint8, value uint32) Packet {
+	return midi2(group, OpPitchBend, channel, 0, 0, value)
+}
+
+// RPN returns a MIDI 2.0 registered controller message with a 32bit value
+func RPN(group, channel, bank, index uint8, value uint32) Packet {
+	return midi2(group, OpRPN, channel, bank&0x7F, index&0x7F, value)
+}
+
+// NRPN returns a MIDI 2.0 assignable controller message with a 32bit value
+func NRPN(group, channel, bank, index uint8, value uint32) Packet {
+	return midi2(group, OpNRPN, channel, bank&0x7F, index&0x7F, value)
+}
+
+// RelativeRPN returns a MIDI 2.0 relative registered controller message
+func RelativeRPN(group, channel, bank, index uint8, delta int32) Packet {
+	return midi2(group, OpRelativeRPN, channel, bank&0x7F, index&0x7F, uint32(delta))
+}
+
+// RelativeNRPN returns a MIDI 2.0 relative assignable controller message
+func RelativeNRPN(group, channel, bank, index uint8, delta int32) Packet {
+	return midi2(group, OpRelativeNRPN, channel, bank&0x7F, index&0x7F, uint32(delta))
+}
+
+// PerNoteController returns a MIDI 2.0 registered (or assignable, if registered is false) per note controller message
+func PerNoteController(group, channel, key uint8, registered bool, index uint8, value uint32) Packet {
+	op := uint8(OpAssignablePerNoteController)
+	if registered {
+		op = OpRegisteredPerNoteController
+	}
+	return midi2(group, op, channel, key&0x7F, index, value)
+}
+
+// PerNotePitchBend returns a MIDI 2.0 per note pitch bend message (center is PitchBendCenter)
+func PerNotePitchBend(group, channel, key uint8, value uint32) Packet {
+	return midi2(group, OpPerNotePitchBend, channel, key&0x7F, 0, value)
+}
+
+// PerNoteManagement returns a MIDI 2.0 per note management message.
+// Flag bit 0 resets the per note controllers, bit 1 detaches them from the previous note.
+func PerNoteManagement(group, channel, key, flags uint8) Packet {
+	return midi2(group, OpPerNoteManagement, channel, key&0x7F, flags&0x03, 0)
+}
+
+// Key returns the key (note number) of note and per note messages,
+// the controller of control change messages and the bank of (N)RPN messages
+func (p Packet) Key() uint8 {
+	return uint8(p[0]>>8) & 0x7F
+}
+
+// Index returns the index of (N)RPN and per note controller messages
+// and the attribute type of note messages
+func (p Packet) Index() uint8 {
+	return uint8(p[0])
+}
+
+// Velocity returns the 16bit velocity of a MIDI 2.0 note message
+func (p Packet) Velocity() uint16 {
+	return uint16(p[1] >> 16)
+}
+
+// Attribute returns the 16bit attribute data of a MIDI 2.0 note message
+func (p Packet) Attribute() uint16 {
+	return uint16(p[1])
+}
+
+// Value returns the 32bit data of a MIDI 2.0 channel voice message
+func (p Packet) Value() uint32 {
+	return p[1]
+}
+
+// Program returns the program, if the bank is valid and the bank of a MIDI 2.0 program change message
+func (p Packet) Program() (program uint8, bankValid bool, bankMSB, bankLSB uint8) {
+	return uint8(p[1]>>24) & 0x7F, p[0]&1 == 1, uint8(p[1]>>8) & 0x7F, uint8(p[1]) & 0x7F
+}
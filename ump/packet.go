@@ -0,0 +1,115 @@
+// Package ump encodes and decodes MIDI 2.0 Universal MIDI Packets (UMP).
+//
+// A Packet holds 1 to 4 32bit words, depending on its MessageType.
+// The constructors return ready to send packets and the accessor methods decode them.
+//
+// To connect UMP streams with the Reader and Writer of the mid package, use
+// NewMIDI1Reader and NewMIDI1Writer. They translate between MIDI 1.0 byte streams and UMPs,
+// following the default translation rules of the MIDI 2.0 specification.
+package ump
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MessageType is the type of a Universal MIDI Packet (the first 4 bits)
+type MessageType uint8
+
+const (
+	// Utility messages (32 bit): NOOP, jitter reduction and delta clockstamps
+	Utility MessageType = 0x0
+
+	// System messages (32 bit): system realtime and system common messages
+	System MessageType = 0x1
+
+	// MIDI1ChannelVoice messages (32 bit) are MIDI 1.0 channel voice messages
+	MIDI1ChannelVoice MessageType = 0x2
+
+	// Data64 messages (64 bit) carry 7bit system exclusive data
+	Data64 MessageType = 0x3
+
+	// MIDI2ChannelVoice messages (64 bit) are MIDI 2.0 channel voice messages
+	MIDI2ChannelVoice MessageType = 0x4
+
+	// Data128 messages (128 bit) carry 8bit system exclusive data
+	Data128 MessageType = 0x5
+
+	// FlexData messages (128 bit) carry tempo, time signatures etc.
+	FlexData MessageType = 0xD
+
+	// Stream messages (128 bit) are UMP stream messages
+	Stream MessageType = 0xF
+)
+
+// Size returns the number of 32bit words of a packet of the message type
+func (t MessageType) Size() int {
+	switch t {
+	case 0x0, 0x1, 0x2, 0x6, 0x7:
+		return 1
+	case 0x3, 0x4, 0x8, 0x9, 0xA:
+		return 2
+	case 0xB, 0xC:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Packet is a Universal MIDI Packet. Words that are not used by the message type are 0.
+type Packet [4]uint32
+
+// Type returns the message type of the packet
+func (p Packet) Type() MessageType {
+	return MessageType(p[0] >> 28)
+}
+
+// Group returns the group (0-15) of the packet
+func (p Packet) Group() uint8 {
+	return uint8(p[0]>>24) & 0x0F
+}
+
+// Size returns the number of 32bit words of the packet
+func (p Packet) Size() int {
+	return p.Type().Size()
+}
+
+// Words returns the used words of the packet
+func (p Packet) Words() []uint32 {
+	return p[:p.Size()]
+}
+
+// Bytes returns the bytes of the packet (big endian)
+func (p Packet) Bytes() []byte {
+	b := make([]byte, p.Size()*4)
+	for i, w := range p.Words() {
+		binary.BigEndian.PutUint32(b[i*4:], w)
+	}
+	return b
+}
+
+// String represents the packet as a string (for debugging)
+func (p Packet) String() string {
+	return fmt.Sprintf("UMP type %X group %v: %08X", uint8(p.Type()), p.Group(), p.Words())
+}
+
+// status returns the status byte of a 32bit or 64bit message (bits 16-23)
+func (p Packet) status() uint8 {
+	return uint8(p[0] >> 16)
+}
+
+// Status returns the status nibble of a message (bits 20-23), e.g. the opcode of a channel voice message
+// or the status of a utility or data message
+func (p Packet) Status() uint8 {
+	return uint8(p[0]>>20) & 0x0F
+}
+
+// Channel returns the channel of a channel voice message
+func (p Packet) Channel() uint8 {
+	return uint8(p[0]>>16) & 0x0F
+}
+
+// word0 builds the first word of a packet
+func word0(t MessageType, group, b1, b2, b3 uint8) uint32 {
+	return uint32(t)<<28 | uint32(group&0x0F)<<24 | uint32(b1)<<16 | uint32(b2)<<8 | uint32(b3)
+}
@@ -0,0 +1,136 @@
+package ump
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestPacketEncoding(t *testing.T) {
+	tests := []struct {
+		p     Packet
+		words []uint32
+	}{
+		{NOOP(), []uint32{0x00000000}},
+		{JRTimestamp(0x1234), []uint32{0x00201234}},
+		{DeltaClockstamp(0x12345), []uint32{0x00412345}},
+		{Packet{word0(MIDI1ChannelVoice, 1, 0x93, 60, 100)}, []uint32{0x21933C64}},
+		{NoteOn(2, 3, 60, 0xFFFF, 0, 0), []uint32{0x42933C00, 0xFFFF0000}},
+		{ControlChange(0, 1, 7, 0x80000000), []uint32{0x40B10700, 0x80000000}},
+		{ProgramChange(0, 0, 5, true, 1, 2), []uint32{0x40C00001, 0x05000102}},
+		{RPN(0, 0, 0, 1, 0x12345678), []uint32{0x40200001, 0x12345678}},
+		{PerNotePitchBend(0, 0, 60, PitchBendCenter), []uint32{0x40603C00, 0x80000000}},
+		{PerNoteController(0, 0, 60, false, 5, 1), []uint32{0x40103C05, 0x00000001}},
+		{StartOfClip(), []uint32{0xF0200000, 0, 0, 0}},
+		{SetTempo(0, 50000000), []uint32{0xD0100000, 50000000, 0, 0}},
+	}
+
+	for _, test := range tests {
+		if got, want := test.p.Words(), test.words; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v.Words() = %08X; want %08X", test.p, got, want)
+		}
+	}
+}
+
+func TestMIDI1Packets(t *testing.T) {
+	tests := [][]byte{
+		{0x90, 60, 100},
+		{0xC3, 5},
+		{0xF2, 0x10, 0x20},
+		{0xF8},
+	}
+
+	for _, msg := range tests {
+		p, ok := MIDI1(4, msg)
+		if !ok {
+			t.Errorf("MIDI1(% X) not ok", msg)
+			continue
+		}
+		if got := p.Group(); got != 4 {
+			t.Errorf("MIDI1(% X).Group() = %v; want 4", msg, got)
+		}
+		if got := p.MIDI1Bytes(); !bytes.Equal(got, msg) {
+			t.Errorf("MIDI1(% X).MIDI1Bytes() = % X", msg, got)
+		}
+	}
+
+	if _, ok := MIDI1(0, []byte{0xF0, 1, 0xF7}); ok {
+		t.Errorf("MIDI1 must not accept system exclusive messages")
+	}
+}
+
+func TestSysEx(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+
+	ps := SysEx7(0, data)
+	if len(ps) != 3 {
+		t.Fatalf("len(SysEx7(14 bytes)) = %v; want 3", len(ps))
+	}
+
+	var got []byte
+	var status []uint8
+	for _, p := range ps {
+		got = append(got, p.SysEx7Data()...)
+		status = append(status, p.Status())
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("SysEx7 data = % X; want % X", got, data)
+	}
+
+	if want := []uint8{SysExStart, SysExContinue, SysExEnd}; !reflect.DeepEqual(status, want) {
+		t.Errorf("SysEx7 status = %v; want %v", status, want)
+	}
+
+	ps = SysEx8(0, 7, data)
+	if len(ps) != 2 {
+		t.Fatalf("len(SysEx8(14 bytes)) = %v; want 2", len(ps))
+	}
+
+	got = nil
+	for _, p := range ps {
+		id, d := p.SysEx8Data()
+		if id != 7 {
+			t.Errorf("stream ID = %v; want 7", id)
+		}
+		got = append(got, d...)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("SysEx8 data = % X; want % X", got, data)
+	}
+}
+
+func TestReaderWriter(t *testing.T) {
+	ps := []Packet{
+		NOOP(),
+		NoteOn(0, 1, 60, 0x8000, 0, 0),
+		SetTimeSignature(0, 3, 2, 8),
+		Packet{word0(System, 0, 0xF8, 0, 0)},
+	}
+
+	var bf bytes.Buffer
+	if err := NewWriter(&bf).Write(ps...); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+
+	if got, want := bf.Len(), 4+8+16+4; got != want {
+		t.Errorf("written %v bytes; want %v", got, want)
+	}
+
+	rd := NewReader(&bf)
+	for i, want := range ps {
+		got, err := rd.Read()
+		if err != nil {
+			t.Fatalf("[%v] Read returned error: %v", i, err)
+		}
+		if got != want {
+			t.Errorf("[%v] Read() = %v; want %v", i, got, want)
+		}
+	}
+
+	if _, err := rd.Read(); err != io.EOF {
+		t.Errorf("Read() at the end returned %v; want io.EOF", err)
+	}
+}
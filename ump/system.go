@@ -0,0 +1,65 @@
+package ump
+
+import (
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// MIDI1 returns a packet for the given raw MIDI 1.0 message that is not a system exclusive message.
+// Channel voice messages become MIDI1ChannelVoice packets, system messages become System packets.
+// ok is false, if msg can't be represented by a single 32bit packet.
+func MIDI1(group uint8, msg []byte) (p Packet, ok bool) {
+	if len(msg) == 0 || msg[0] < 0x80 || msg[0] == 0xF0 || msg[0] == 0xF7 {
+		return
+	}
+
+	n := midistream.DataLen(msg[0])
+	if len(msg) < 1+n {
+		return
+	}
+
+	var b [3]byte
+	copy(b[:], msg[:1+n])
+
+	t := System
+	if midistream.IsChannelStatus(msg[0]) {
+		t = MIDI1ChannelVoice
+	}
+
+	return Packet{word0(t, group, b[0], b[1], b[2])}, true
+}
+
+// MIDI1Bytes returns the raw MIDI 1.0 message of a MIDI1ChannelVoice or System packet.
+// It returns nil for any other message type.
+func (p Packet) MIDI1Bytes() []byte {
+	if p.Type() != MIDI1ChannelVoice && p.Type() != System {
+		return nil
+	}
+
+	status := p.status()
+	n := midistream.DataLen(status)
+	if n < 0 {
+		return nil
+	}
+
+	b := []byte{status, uint8(p[0]>>8) & 0x7F, uint8(p[0]) & 0x7F}
+	return b[:1+n]
+}
+
+// MIDI1Packets returns the packets for the given complete MIDI 1.0 message without translating it to MIDI 2.0:
+// MIDI1ChannelVoice or System packets for channel and system messages and Data64 packets
+// for system exclusive messages (including 0xF0 and 0xF7).
+func MIDI1Packets(group uint8, msg []byte) []Packet {
+	if len(msg) > 0 && msg[0] == 0xF0 {
+		data := msg[1:]
+		if len(data) > 0 && data[len(data)-1] == 0xF7 {
+			data = data[:len(data)-1]
+		}
+		return SysEx7(group, data)
+	}
+
+	p, ok := MIDI1(group, msg)
+	if !ok {
+		return nil
+	}
+	return []Packet{p}
+}
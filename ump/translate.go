@@ -0,0 +1,281 @@
+package ump
+
+import (
+	"github.com/gomidi/mid/internal/midistream"
+)
+
+// Upscale scales the value v with srcBits bits to dstBits bits,
+// following the min-center-max algorithm of the MIDI 2.0 specification:
+// the minimum, the center and the maximum of the source range map to the
+// minimum, the center and the maximum of the destination range.
+func Upscale(v uint32, srcBits, dstBits uint) uint32 {
+	if srcBits >= dstBits {
+		return Downscale(v, srcBits, dstBits)
+	}
+
+	scaleBits := dstBits - srcBits
+	bitShifted := v << scaleBits
+	srcCenter := uint32(1) << (srcBits - 1)
+
+	if v <= srcCenter {
+		return bitShifted
+	}
+
+	// expand the bit repeat pattern of the bits below the center
+	repeatBits := srcBits - 1
+	repeatMask := uint32(1)<<repeatBits - 1
+	repeatValue := v & repeatMask
+
+	if scaleBits > repeatBits {
+		repeatValue <<= scaleBits - repeatBits
+	} else {
+		repeatValue >>= repeatBits - scaleBits
+	}
+
+	for repeatValue != 0 {
+		bitShifted |= repeatValue
+		repeatValue >>= repeatBits
+	}
+
+	return bitShifted
+}
+
+// Downscale scales the value v with srcBits bits down to dstBits bits by dropping the lower bits
+func Downscale(v uint32, srcBits, dstBits uint) uint32 {
+	if srcBits <= dstBits {
+		return v
+	}
+	return v >> (srcBits - dstBits)
+}
+
+// parameter tracks the selected (N)RPN of a MIDI 1.0 channel
+type parameter struct {
+	selected bool
+	nrpn     bool
+	msb, lsb uint8
+	valueMSB uint8
+}
+
+// MIDI1To2 translates MIDI 1.0 messages to MIDI 2.0 channel voice packets,
+// following the default translation rules of the MIDI 2.0 specification.
+//
+// Since bank select and (N)RPN messages consist of multiple MIDI 1.0 messages,
+// MIDI1To2 keeps track of them per channel. The zero value is ready to use for group 0.
+type MIDI1To2 struct {
+	Group uint8
+
+	bankValid [16]bool
+	bank      [16][2]uint8
+	param     [16]parameter
+}
+
+// Translate translates the given complete MIDI 1.0 message (running status resolved).
+// System exclusive messages must include 0xF0 and 0xF7.
+// Messages that are part of a multi message sequence (bank select, (N)RPN selection) return no packets.
+func (t *MIDI1To2) Translate(msg []byte) []Packet {
+	if len(msg) == 0 {
+		return nil
+	}
+
+	if !midistream.IsChannelStatus(msg[0]) {
+		return MIDI1Packets(t.Group, msg)
+	}
+
+	if len(msg) < 1+midistream.DataLen(msg[0]) {
+		return nil
+	}
+
+	ch := msg[0] & 0x0F
+	g := t.Group
+
+	switch msg[0] & 0xF0 {
+	case 0x80:
+		return []Packet{NoteOff(g, ch, msg[1], uint16(Upscale(uint32(msg[2]), 7, 16)), 0, 0)}
+	case 0x90:
+		if msg[2] == 0 {
+			// note on with velocity 0 is a note off with the default velocity
+			return []Packet{NoteOff(g, ch, msg[1], uint16(Upscale(64, 7, 16)), 0, 0)}
+		}
+		return []Packet{NoteOn(g, ch, msg[1], uint16(Upscale(uint32(msg[2]), 7, 16)), 0, 0)}
+	case 0xA0:
+		return []Packet{PolyPressure(g, ch, msg[1], Upscale(uint32(msg[2]), 7, 32))}
+	case 0xB0:
+		return t.controlChange(ch, msg[1], msg[2])
+	case 0xC0:
+		return []Packet{ProgramChange(g, ch, msg[1], t.bankValid[ch], t.bank[ch][0], t.bank[ch][1])}
+	case 0xD0:
+		return []Packet{ChannelPressure(g, ch, Upscale(uint32(msg[1]), 7, 32))}
+	case 0xE0:
+		return []Packet{PitchBend(g, ch, Upscale(uint32(msg[2])<<7|uint32(msg[1]), 14, 32))}
+	}
+
+	return nil
+}
+
+func (t *MIDI1To2) controlChange(ch, cc, val uint8) []Packet {
+	g := t.Group
+	p := &t.param[ch]
+
+	switch cc {
+	case 0:
+		t.bankValid[ch] = true
+		t.bank[ch][0] = val
+		return nil
+	case 32:
+		t.bankValid[ch] = true
+		t.bank[ch][1] = val
+		return nil
+	case 101, 99:
+		p.selected = true
+		p.nrpn = cc == 99
+		p.msb = val
+		p.valueMSB = 0
+		return nil
+	case 100, 98:
+		p.selected = true
+		p.nrpn = cc == 98
+		p.lsb = val
+		p.valueMSB = 0
+		if !p.nrpn && p.msb == 127 && p.lsb == 127 {
+			// RPN null
+			p.selected = false
+		}
+		return nil
+	case 6, 38:
+		if !p.selected {
+			break
+		}
+		var v14 uint32
+		if cc == 6 {
+			p.valueMSB = val
+			v14 = uint32(val) << 7
+		} else {
+			v14 = uint32(p.valueMSB)<<7 | uint32(val)
+		}
+		if p.nrpn {
+			return []Packet{NRPN(g, ch, p.msb, p.lsb, Upscale(v14, 14, 32))}
+		}
+		return []Packet{RPN(g, ch, p.msb, p.lsb, Upscale(v14, 14, 32))}
+	case 96, 97:
+		if !p.selected {
+			break
+		}
+		delta := int32(1)
+		if cc == 97 {
+			delta = -1
+		}
+		if p.nrpn {
+			return []Packet{RelativeNRPN(g, ch, p.msb, p.lsb, delta)}
+		}
+		return []Packet{RelativeRPN(g, ch, p.msb, p.lsb, delta)}
+	}
+
+	return []Packet{ControlChange(g, ch, cc, Upscale(uint32(val), 7, 32))}
+}
+
+// MIDI2To1 translates Universal MIDI Packets to MIDI 1.0 messages,
+// following the default translation rules of the MIDI 2.0 specification.
+//
+// System exclusive messages that are split across packets are reassembled,
+// so MIDI2To1 must get the packets in order. The zero value is ready to use.
+type MIDI2To1 struct {
+	sysex []byte
+}
+
+// Translate translates the given packet to complete MIDI 1.0 messages.
+// Packets without MIDI 1.0 equivalent (e.g. utility messages and per note controllers) return no messages.
+func (t *MIDI2To1) Translate(p Packet) [][]byte {
+	switch p.Type() {
+	case MIDI1ChannelVoice, System:
+		b := p.MIDI1Bytes()
+		if b == nil {
+			return nil
+		}
+		return [][]byte{b}
+	case Data64:
+		return t.sysex7(p)
+	case MIDI2ChannelVoice:
+		return translateMIDI2(p)
+	}
+	return nil
+}
+
+func (t *MIDI2To1) sysex7(p Packet) [][]byte {
+	data := p.SysEx7Data()
+
+	switch p.Status() {
+	case SysExComplete:
+		t.sysex = nil
+		return [][]byte{sysexBytes(data)}
+	case SysExStart:
+		t.sysex = append([]byte{0xF0}, data...)
+	case SysExContinue:
+		if t.sysex != nil {
+			t.sysex = append(t.sysex, data...)
+		}
+	case SysExEnd:
+		if t.sysex == nil {
+			return nil
+		}
+		b := append(t.sysex, data...)
+		t.sysex = nil
+		return [][]byte{append(b, 0xF7)}
+	}
+	return nil
+}
+
+func sysexBytes(data []byte) []byte {
+	b := make([]byte, 0, len(data)+2)
+	b = append(b, 0xF0)
+	b = append(b, data...)
+	return append(b, 0xF7)
+}
+
+func translateMIDI2(p Packet) [][]byte {
+	ch := p.Channel()
+
+	cc := func(controller uint8, val uint32) []byte {
+		return []byte{0xB0 | ch, controller, uint8(val) & 0x7F}
+	}
+
+	switch p.Status() {
+	case OpNoteOff:
+		return [][]byte{{0x80 | ch, p.Key(), uint8(Downscale(uint32(p.Velocity()), 16, 7))}}
+	case OpNoteOn:
+		vel := uint8(Downscale(uint32(p.Velocity()), 16, 7))
+		if vel == 0 {
+			// a MIDI 1.0 note on with velocity 0 would be a note off
+			vel = 1
+		}
+		return [][]byte{{0x90 | ch, p.Key(), vel}}
+	case OpPolyPressure:
+		return [][]byte{{0xA0 | ch, p.Key(), uint8(Downscale(p.Value(), 32, 7))}}
+	case OpControlChange:
+		return [][]byte{cc(p.Key(), Downscale(p.Value(), 32, 7))}
+	case OpProgramChange:
+		prog, bankValid, msb, lsb := p.Program()
+		if bankValid {
+			return [][]byte{cc(0, uint32(msb)), cc(32, uint32(lsb)), {0xC0 | ch, prog}}
+		}
+		return [][]byte{{0xC0 | ch, prog}}
+	case OpChannelPressure:
+		return [][]byte{{0xD0 | ch, uint8(Downscale(p.Value(), 32, 7))}}
+	case OpPitchBend:
+		v := Downscale(p.Value(), 32, 14)
+		return [][]byte{{0xE0 | ch, uint8(v) & 0x7F, uint8(v>>7) & 0x7F}}
+	case OpRPN, OpNRPN:
+		msbCC, lsbCC := uint8(101), uint8(100)
+		if p.Status() == OpNRPN {
+			msbCC, lsbCC = 99, 98
+		}
+		v := Downscale(p.Value(), 32, 14)
+		return [][]byte{
+			cc(msbCC, uint32(p.Key())),
+			cc(lsbCC, uint32(p.Index())),
+			cc(6, v>>7),
+			cc(38, v),
+		}
+	}
+
+	return nil
+}
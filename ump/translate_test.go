@@ -0,0 +1,97 @@
+package ump
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		v                uint32
+		srcBits, dstBits uint
+		want             uint32
+	}{
+		{0, 7, 16, 0},
+		{64, 7, 16, 0x8000},
+		{127, 7, 16, 0xFFFF},
+		{0, 7, 32, 0},
+		{64, 7, 32, 0x80000000},
+		{127, 7, 32, 0xFFFFFFFF},
+		{0x2000, 14, 32, 0x80000000},
+		{0x3FFF, 14, 32, 0xFFFFFFFF},
+		{0xFFFF, 16, 7, 127},
+		{0x80000000, 32, 14, 0x2000},
+	}
+
+	for _, test := range tests {
+		if got := Upscale(test.v, test.srcBits, test.dstBits); got != test.want {
+			t.Errorf("Upscale(%X, %v, %v) = %X; want %X", test.v, test.srcBits, test.dstBits, got, test.want)
+		}
+	}
+}
+
+func TestMIDI1To2(t *testing.T) {
+	var tr MIDI1To2
+
+	tests := []struct {
+		msg  []byte
+		want []Packet
+	}{
+		{[]byte{0x91, 60, 127}, []Packet{NoteOn(0, 1, 60, 0xFFFF, 0, 0)}},
+		{[]byte{0x91, 60, 0}, []Packet{NoteOff(0, 1, 60, 0x8000, 0, 0)}},
+		{[]byte{0xB0, 0, 1}, nil},
+		{[]byte{0xB0, 32, 2}, nil},
+		{[]byte{0xC0, 5}, []Packet{ProgramChange(0, 0, 5, true, 1, 2)}},
+		{[]byte{0xC1, 5}, []Packet{ProgramChange(0, 1, 5, false, 0, 0)}},
+		{[]byte{0xB2, 101, 0}, nil},
+		{[]byte{0xB2, 100, 0}, nil},
+		{[]byte{0xB2, 6, 64}, []Packet{RPN(0, 2, 0, 0, 0x80000000)}},
+		{[]byte{0xB2, 96, 0}, []Packet{RelativeRPN(0, 2, 0, 0, 1)}},
+		{[]byte{0xB2, 101, 127}, nil},
+		{[]byte{0xB2, 100, 127}, nil},
+		{[]byte{0xB2, 6, 64}, []Packet{ControlChange(0, 2, 6, 0x80000000)}},
+		{[]byte{0xE0, 0, 64}, []Packet{PitchBend(0, 0, PitchBendCenter)}},
+		{[]byte{0xF0, 1, 2, 0xF7}, SysEx7(0, []byte{1, 2})},
+	}
+
+	for i, test := range tests {
+		if got := tr.Translate(test.msg); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("[%v] Translate(% X) = %v; want %v", i, test.msg, got, test.want)
+		}
+	}
+}
+
+func TestMIDI2To1(t *testing.T) {
+	var tr MIDI2To1
+
+	tests := []struct {
+		p    Packet
+		want [][]byte
+	}{
+		{NoteOn(0, 1, 60, 0xFFFF, 0, 0), [][]byte{{0x91, 60, 127}}},
+		{NoteOn(0, 1, 60, 0x0001, 0, 0), [][]byte{{0x91, 60, 1}}},
+		{NoteOff(0, 1, 60, 0, 0, 0), [][]byte{{0x81, 60, 0}}},
+		{PitchBend(0, 3, PitchBendCenter), [][]byte{{0xE3, 0, 64}}},
+		{ProgramChange(0, 0, 5, true, 1, 2), [][]byte{{0xB0, 0, 1}, {0xB0, 32, 2}, {0xC0, 5}}},
+		{NRPN(0, 0, 1, 2, 0xFFFFFFFF), [][]byte{{0xB0, 99, 1}, {0xB0, 98, 2}, {0xB0, 6, 127}, {0xB0, 38, 127}}},
+		{PerNotePitchBend(0, 0, 60, 0), nil},
+		{JRTimestamp(10), nil},
+		{SysEx7(0, []byte{1, 2, 3, 4, 5, 6, 7})[0], nil},
+		{SysEx7(0, []byte{1, 2, 3, 4, 5, 6, 7})[1], [][]byte{{0xF0, 1, 2, 3, 4, 5, 6, 7, 0xF7}}},
+	}
+
+	for i, test := range tests {
+		got := tr.Translate(test.p)
+		if len(got) != len(test.want) {
+			t.Errorf("[%v] Translate(%v) = % X; want % X", i, test.p, got, test.want)
+			continue
+		}
+		for j := range got {
+			if !bytes.Equal(got[j], test.want[j]) {
+				t.Errorf("[%v] Translate(%v) = % X; want % X", i, test.p, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package ump
+
+// status of utility messages
+const (
+	UtilityNOOP            = 0x0
+	UtilityJRClock         = 0x1
+	UtilityJRTimestamp     = 0x2
+	UtilityDCTPQ           = 0x3
+	UtilityDeltaClockstamp = 0x4
+)
+
+// NOOP returns a utility message that does nothing
+func NOOP() Packet {
+	return Packet{word0(Utility, 0, UtilityNOOP<<4, 0, 0)}
+}
+
+// JRClock returns a jitter reduction clock message with the senders clock time
+// in units of 1/31250 seconds
+func JRClock(senderTime uint16) Packet {
+	return Packet{word0(Utility, 0, UtilityJRClock<<4, uint8(senderTime>>8), uint8(senderTime))}
+}
+
+// JRTimestamp returns a jitter reduction timestamp message for the following message
+// in units of 1/31250 seconds
+func JRTimestamp(senderTime uint16) Packet {
+	return Packet{word0(Utility, 0, UtilityJRTimestamp<<4, uint8(senderTime>>8), uint8(senderTime))}
+}
+
+// DeltaClockstampTPQ returns a delta clockstamp ticks per quarter note message
+func DeltaClockstampTPQ(ticksPerQuarter uint16) Packet {
+	return Packet{word0(Utility, 0, UtilityDCTPQ<<4, uint8(ticksPerQuarter>>8), uint8(ticksPerQuarter))}
+}
+
+// DeltaClockstamp returns a delta clockstamp message with the ticks (20bit) since the last event
+func DeltaClockstamp(ticks uint32) Packet {
+	return Packet{word0(Utility, 0, UtilityDeltaClockstamp<<4|uint8(ticks>>16)&0x0F, uint8(ticks>>8), uint8(ticks))}
+}
+
+// MaxDeltaClockstamp is the largest number of ticks a single delta clockstamp can hold
+const MaxDeltaClockstamp = 0xFFFFF
+
+// Time16 returns the 16bit time of jitter reduction and ticks per quarter note messages
+func (p Packet) Time16() uint16 {
+	return uint16(p[0])
+}
+
+// Ticks returns the ticks of a delta clockstamp message
+func (p Packet) Ticks() uint32 {
+	return p[0] & 0xFFFFF
+}
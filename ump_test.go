@@ -0,0 +1,45 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestUMPReadWrite(t *testing.T) {
+	for _, midi2 := range []bool{false, true} {
+		var bf bytes.Buffer
+		wr := NewUMPWriter(&bf, 0, midi2)
+		wr.SetChannel(2)
+		wr.NoteOn(60, 100)
+		wr.Pitchbend(-4096)
+		wr.ControlChange(7, 90)
+		wr.SysEx([]byte{0x41, 0x10, 0x42, 0x12, 0x40, 0x00, 0x7F, 0x00})
+		wr.NoteOff(60)
+
+		var res bytes.Buffer
+		rd := NewReader(NoLogger())
+		rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+			fmt.Fprintf(&res, "ON%v:%v/%v ", ch, key, vel)
+		}
+		rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) {
+			fmt.Fprintf(&res, "OFF%v:%v ", ch, key)
+		}
+		rd.Msg.Channel.Pitchbend = func(p *Position, ch uint8, value int16) {
+			fmt.Fprintf(&res, "PB%v:%v ", ch, value)
+		}
+		rd.Msg.Channel.ControlChange.Each = func(p *Position, ch, cc, val uint8) {
+			fmt.Fprintf(&res, "CC%v/%v:%v ", ch, cc, val)
+		}
+		rd.Msg.SysEx.Complete = func(p *Position, data []byte) {
+			fmt.Fprintf(&res, "SYSEX% X ", data)
+		}
+
+		rd.ReadUMP(&bf)
+
+		expected := "ON2:60/100 PB2:-4096 CC2/7:90 SYSEX41 10 42 12 40 00 7F 00 OFF2:60 "
+		if got := res.String(); got != expected {
+			t.Errorf("midi2: %v\ngot:      %q\nexpected: %q", midi2, got, expected)
+		}
+	}
+}
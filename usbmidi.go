@@ -0,0 +1,43 @@
+package mid
+
+import (
+	"io"
+
+	"github.com/gomidi/mid/usbmidi"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midiwriter"
+)
+
+// portReader is a midi.Reader that knows the port of the last read message,
+// e.g. the cable number of USB-MIDI packets
+type portReader interface {
+	midi.Reader
+	Port() uint8
+}
+
+// Port returns the port of the message that is currently dispatched. When reading USB-MIDI
+// packets via ReadUSBMIDI, it is the cable number of the packet. Otherwise it is 0.
+// It is meant to be called from within the callbacks.
+func (r *Reader) Port() uint8 {
+	if r.port == nil {
+		return 0
+	}
+	return r.port.Port()
+}
+
+// ReadUSBMIDI reads USB-MIDI event packets from src until an error happens (like Read).
+// The messages of all cables are dispatched to the attached functions of the Reader
+// and the cable of the current message is returned by Port.
+func (r *Reader) ReadUSBMIDI(src io.Reader) error {
+	r.pos = nil
+	r.reset()
+	rd := usbmidi.NewReader(src, r.dispatchRealTime, r.midiReaderOptions...)
+	r.port = rd
+	defer func() { r.port = nil }()
+	return r.dispatch(rd)
+}
+
+// NewUSBMIDIWriter creates a new Writer that writes USB-MIDI event packets for the given cable to dest.
+func NewUSBMIDIWriter(dest io.Writer, cable uint8, options ...midiwriter.Option) *Writer {
+	return NewWriter(usbmidi.NewWriter(dest, cable), options...)
+}
@@ -0,0 +1,127 @@
+package usbmidi
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gomidi/mid/internal/midistream"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/realtime"
+	"github.com/gomidi/midi/midireader"
+)
+
+// Reader reads USB-MIDI event packets and returns the contained MIDI messages.
+// It fullfills the midi.Reader interface.
+type Reader struct {
+	src        io.Reader
+	dec        Decoder
+	cable      uint8
+	buf        [4]byte
+	bf         bytes.Buffer
+	midiReader midi.Reader
+}
+
+var _ midi.Reader = &Reader{}
+
+// NewReader returns a Reader that reads packets from src.
+// Realtime messages are passed to rthandler (if it is not nil) and not returned by Read.
+func NewReader(src io.Reader, rthandler func(realtime.Message), options ...midireader.Option) *Reader {
+	rd := &Reader{src: src}
+	rd.midiReader = midireader.New(&rd.bf, rthandler, options...)
+	return rd
+}
+
+// Read reads packets until a MIDI message is complete and returns it.
+// If src returns io.EOF, Read returns io.EOF (io.ErrUnexpectedEOF, if it ends within a packet).
+func (r *Reader) Read() (midi.Message, error) {
+	for {
+		p, err := r.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		b := r.dec.Decode(p)
+		if b == nil {
+			continue
+		}
+
+		r.cable = p.Cable()
+		r.bf.Reset()
+		r.bf.Write(b)
+
+		// for realtime messages the rthandler is called and io.EOF is returned
+		msg, err := r.midiReader.Read()
+		if err != nil {
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// ReadPacket reads the next raw packet.
+// At the end of src it returns io.EOF, for an incomplete packet io.ErrUnexpectedEOF.
+func (r *Reader) ReadPacket() (p Packet, err error) {
+	_, err = io.ReadFull(r.src, r.buf[:])
+	return Packet(r.buf), err
+}
+
+// Cable returns the cable number of the last read message.
+// Within the rthandler it returns the cable of the realtime message.
+func (r *Reader) Cable() uint8 {
+	return r.cable
+}
+
+// Port returns the cable number of the last read message.
+// It allows mid.Reader to report the cable as port.
+func (r *Reader) Port() uint8 {
+	return r.cable
+}
+
+// Writer splits the MIDI byte stream that is written to it into messages and writes
+// them as USB-MIDI event packets. Running status is resolved.
+type Writer struct {
+	dest     io.Writer
+	cable    uint8
+	splitter *midistream.Splitter
+	err      error
+}
+
+// NewWriter returns a Writer that writes the packets for the given cable to dest.
+// It can be passed to mid.NewWriter to use the Writer methods for USB-MIDI.
+func NewWriter(dest io.Writer, cable uint8) *Writer {
+	w := &Writer{dest: dest, cable: cable}
+	w.splitter = midistream.New(w.writeMessage)
+	return w
+}
+
+// SetCable sets the cable for the following messages
+func (w *Writer) SetCable(cable uint8) {
+	w.cable = cable & 0x0F
+}
+
+// WritePacket writes the given raw packets
+func (w *Writer) WritePacket(ps ...Packet) error {
+	for _, p := range ps {
+		_, err := w.dest.Write(p[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeMessage(msg []byte) {
+	if w.err != nil {
+		return
+	}
+	w.err = w.WritePacket(Encode(w.cable, msg)...)
+}
+
+// Write writes the given MIDI bytes. Incomplete messages are buffered until they are complete.
+func (w *Writer) Write(b []byte) (int, error) {
+	w.splitter.Write(b)
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,37 @@
+package usbmidi
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadPacketEOF(t *testing.T) {
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{nil, io.EOF},
+		{[]byte{0x09, 0x90, 60}, io.ErrUnexpectedEOF},
+		{[]byte{0x09, 0x90, 60, 100, 0x08}, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		rd := NewReader(bytes.NewReader(test.data), nil)
+		var err error
+		for err == nil {
+			_, err = rd.ReadPacket()
+		}
+		if err != test.err {
+			t.Errorf("% X: got error %v; want %v", test.data, err, test.err)
+		}
+
+		rd = NewReader(bytes.NewReader(test.data), nil)
+		for err = nil; err == nil; {
+			_, err = rd.Read()
+		}
+		if err != test.err {
+			t.Errorf("% X: Read returned error %v; want %v", test.data, err, test.err)
+		}
+	}
+}
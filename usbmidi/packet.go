@@ -0,0 +1,151 @@
+// Package usbmidi encodes and decodes USB-MIDI event packets as defined by the
+// USB Device Class Definition for MIDI Devices.
+//
+// Every packet has 4 bytes: the cable number and the code index number (CIN)
+// in the first byte, followed by up to 3 MIDI bytes.
+//
+// The Reader reads packets and returns the contained MIDI messages, together with the
+// cable they came from. The Writer splits a MIDI byte stream (e.g. written by mid.Writer)
+// into packets for a cable.
+package usbmidi
+
+import (
+	"fmt"
+)
+
+// code index numbers
+const (
+	CINMisc           = 0x0
+	CINCableEvent     = 0x1
+	CINSysCommon2     = 0x2
+	CINSysCommon3     = 0x3
+	CINSysExStart     = 0x4
+	CINSysExEnd1      = 0x5 // also used for single byte system common messages
+	CINSysExEnd2      = 0x6
+	CINSysExEnd3      = 0x7
+	CINNoteOff        = 0x8
+	CINNoteOn         = 0x9
+	CINPolyAftertouch = 0xA
+	CINControlChange  = 0xB
+	CINProgramChange  = 0xC
+	CINAftertouch     = 0xD
+	CINPitchbend      = 0xE
+	CINSingleByte     = 0xF
+)
+
+// cinLen is the number of MIDI bytes for each code index number
+var cinLen = [16]int{0, 0, 2, 3, 3, 1, 2, 3, 3, 3, 3, 3, 2, 2, 3, 1}
+
+// Packet is a USB-MIDI event packet
+type Packet [4]byte
+
+// NewPacket returns a packet for the given cable, code index number and MIDI bytes
+func NewPacket(cable, cin uint8, data ...byte) (p Packet) {
+	p[0] = cable<<4 | cin&0x0F
+	copy(p[1:], data)
+	return
+}
+
+// Cable returns the cable number (0-15)
+func (p Packet) Cable() uint8 {
+	return p[0] >> 4
+}
+
+// CIN returns the code index number
+func (p Packet) CIN() uint8 {
+	return p[0] & 0x0F
+}
+
+// Data returns the MIDI bytes of the packet. For reserved code index numbers it returns nil.
+func (p Packet) Data() []byte {
+	return p[1 : 1+cinLen[p.CIN()]]
+}
+
+// String represents the packet as a string (for debugging)
+func (p Packet) String() string {
+	return fmt.Sprintf("USB-MIDI cable %v CIN %X: % X", p.Cable(), p.CIN(), p.Data())
+}
+
+// Encode returns the packets for the given complete MIDI message on the given cable.
+// System exclusive messages must include 0xF0 and 0xF7 and are split across packets.
+// It returns nil for messages without status byte.
+func Encode(cable uint8, msg []byte) []Packet {
+	if len(msg) == 0 || msg[0] < 0x80 {
+		return nil
+	}
+
+	status := msg[0]
+
+	switch {
+	case status == 0xF0:
+		return encodeSysEx(cable, msg)
+	case status < 0xF0:
+		return []Packet{NewPacket(cable, status>>4, msg...)}
+	case status >= 0xF8:
+		return []Packet{NewPacket(cable, CINSingleByte, status)}
+	}
+
+	switch len(msg) {
+	case 1:
+		return []Packet{NewPacket(cable, CINSysExEnd1, msg...)}
+	case 2:
+		return []Packet{NewPacket(cable, CINSysCommon2, msg...)}
+	default:
+		return []Packet{NewPacket(cable, CINSysCommon3, msg[:3]...)}
+	}
+}
+
+func encodeSysEx(cable uint8, msg []byte) (ps []Packet) {
+	for len(msg) > 3 {
+		ps = append(ps, NewPacket(cable, CINSysExStart, msg[:3]...))
+		msg = msg[3:]
+	}
+	// the last packet tells how many bytes are left
+	return append(ps, NewPacket(cable, CINSysExEnd1+uint8(len(msg))-1, msg...))
+}
+
+// Decoder reassembles MIDI messages from packets. System exclusive messages are
+// collected separately for each cable. The zero value is ready to use.
+type Decoder struct {
+	sysex [16][]byte
+}
+
+// Decode returns the complete MIDI message that is carried or completed by the given packet.
+// Packets within system exclusive messages and packets with reserved code index numbers return nil.
+// The returned slice is only valid until the next call of Decode.
+func (d *Decoder) Decode(p Packet) []byte {
+	cable := p.Cable()
+	data := p.Data()
+
+	switch p.CIN() {
+	case CINMisc, CINCableEvent:
+		return nil
+	case CINSysExStart:
+		if data[0] == 0xF0 {
+			d.sysex[cable] = append(d.sysex[cable][:0], data...)
+			return nil
+		}
+		if len(d.sysex[cable]) > 0 {
+			d.sysex[cable] = append(d.sysex[cable], data...)
+		}
+		return nil
+	case CINSysExEnd1, CINSysExEnd2, CINSysExEnd3:
+		if data[len(data)-1] != 0xF7 {
+			// single byte system common message
+			return data
+		}
+		if data[0] == 0xF0 {
+			// the complete system exclusive message fits into the packet
+			return data
+		}
+		if len(d.sysex[cable]) == 0 {
+			// end without start
+			return nil
+		}
+		msg := append(d.sysex[cable], data...)
+		d.sysex[cable] = msg[:0]
+		return msg
+	}
+
+	return data
+}
@@ -0,0 +1,56 @@
+package usbmidi
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		cable uint8
+		msg   []byte
+		want  []Packet
+	}{
+		{1, []byte{0x92, 60, 100}, []Packet{{0x19, 0x92, 60, 100}}},
+		{0, []byte{0xC0, 5}, []Packet{{0x0C, 0xC0, 5, 0}}},
+		{0, []byte{0xF8}, []Packet{{0x0F, 0xF8, 0, 0}}},
+		{0, []byte{0xF6}, []Packet{{0x05, 0xF6, 0, 0}}},
+		{0, []byte{0xF3, 1}, []Packet{{0x02, 0xF3, 1, 0}}},
+		{0, []byte{0xF0, 0xF7}, []Packet{{0x06, 0xF0, 0xF7, 0}}},
+		{2, []byte{0xF0, 1, 2, 3, 4, 0xF7}, []Packet{{0x24, 0xF0, 1, 2}, {0x27, 3, 4, 0xF7}}},
+		{2, []byte{0xF0, 1, 2, 3, 4, 5, 0xF7}, []Packet{{0x24, 0xF0, 1, 2}, {0x24, 3, 4, 5}, {0x25, 0xF7, 0, 0}}},
+	}
+
+	for _, test := range tests {
+		if got := Encode(test.cable, test.msg); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Encode(%v, % X) = %v; want %v", test.cable, test.msg, got, test.want)
+		}
+	}
+}
+
+func TestDecodeInterleavedSysEx(t *testing.T) {
+	a := Encode(0, []byte{0xF0, 1, 2, 3, 4, 5, 6, 7, 0xF7})
+	b := Encode(1, []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7})
+
+	ps := []Packet{a[0], b[0], a[1], NewPacket(0, CINSingleByte, 0xF8), b[1], a[2]}
+
+	var d Decoder
+	var got []string
+
+	for _, p := range ps {
+		if msg := d.Decode(p); msg != nil {
+			got = append(got, fmt.Sprintf("%v:% X", p.Cable(), msg))
+		}
+	}
+
+	want := []string{
+		"0:F8",
+		"1:F0 7E 7F 06 01 F7",
+		"0:F0 01 02 03 04 05 06 07 F7",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gomidi/mid/usbmidi"
+)
+
+func TestUSBMIDIReadWrite(t *testing.T) {
+	var bf bytes.Buffer
+	uw := usbmidi.NewWriter(&bf, 0)
+	wr := NewWriter(uw)
+
+	wr.SetChannel(3)
+	wr.NoteOn(60, 100)
+	uw.SetCable(5)
+	wr.SysEx([]byte{0x41, 0x10, 0x42, 0x12, 0x40, 0x00, 0x7F, 0x00})
+	wr.Clock()
+	uw.SetCable(0)
+	wr.NoteOff(60)
+
+	if bf.Len()%4 != 0 {
+		t.Fatalf("written %v bytes, must be a multiple of 4", bf.Len())
+	}
+
+	var res bytes.Buffer
+	rd := NewReader(NoLogger(), IgnoreMIDIClock())
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		fmt.Fprintf(&res, "[%v] ON%v:%v/%v ", rd.Port(), ch, key, vel)
+	}
+	rd.Msg.Channel.NoteOff = func(p *Position, ch, key, vel uint8) {
+		fmt.Fprintf(&res, "[%v] OFF%v:%v ", rd.Port(), ch, key)
+	}
+	rd.Msg.Realtime.Clock = func() {
+		fmt.Fprintf(&res, "[%v] CLOCK ", rd.Port())
+	}
+	rd.Msg.SysEx.Complete = func(p *Position, data []byte) {
+		fmt.Fprintf(&res, "[%v] SYSEX% X ", rd.Port(), data)
+	}
+
+	rd.ReadUSBMIDI(&bf)
+
+	expected := "[0] ON3:60/100 [5] SYSEX41 10 42 12 40 00 7F 00 [5] CLOCK [0] OFF3:60 "
+	if got := res.String(); got != expected {
+		t.Errorf("\ngot:      %q\nexpected: %q", got, expected)
+	}
+}
@@ -0,0 +1,189 @@
+package mid
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gomidi/mid/ump"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/channel"
+	"github.com/gomidi/midi/midimessage/meta"
+	"github.com/gomidi/midi/midimessage/meta/meter"
+)
+
+// clipMagic is the identifier at the beginning of every clip file
+const clipMagic = "SMF2CLIP"
+
+// ClipWriter writes MIDI 2.0 clip files (SMF2CLIP). Its methods must not be called concurrently
+type ClipWriter struct {
+	wr *clipWriter
+	*midiWriter
+}
+
+// NewClipWriter returns a new ClipWriter that writes a clip with the given resolution
+// (delta clockstamp ticks per quarter note) to dest.
+// All messages are written to the given group.
+// If midi2 is true, channel voice messages are translated to MIDI 2.0 channel voice messages,
+// otherwise they are written as MIDI 1.0 channel voice packets.
+//
+// The clip must be finished by calling EndOfClip.
+func NewClipWriter(dest io.Writer, ticksPerQuarter uint16, group uint8, midi2 bool) *ClipWriter {
+	wr := &clipWriter{
+		dest:       dest,
+		wr:         ump.NewWriter(dest),
+		resolution: ticksPerQuarter,
+		group:      group,
+	}
+	if midi2 {
+		wr.trans = &ump.MIDI1To2{Group: group}
+	}
+	return &ClipWriter{
+		wr:         wr,
+		midiWriter: &midiWriter{wr: wr, ch: channel.Channel0},
+	}
+}
+
+// NewClipFile creates a new clip file and allows writer to write to it.
+// The file is guaranteed to be closed when returning.
+// The clip is finished automatically, if needed.
+func NewClipFile(file string, ticksPerQuarter uint16, group uint8, midi2 bool, writer func(*ClipWriter) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	wr := NewClipWriter(f, ticksPerQuarter, group, midi2)
+	if writer != nil {
+		err = writer(wr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !wr.wr.finished {
+		return wr.EndOfClip()
+	}
+	return nil
+}
+
+// SetDelta sets the delta ticks to the next message
+func (w *ClipWriter) SetDelta(deltatime uint32) {
+	w.wr.delta = deltatime
+}
+
+// EndOfClip writes the end of clip message
+func (w *ClipWriter) EndOfClip() error {
+	w.midiWriter.noteState = [16][128]bool{}
+	return w.wr.Write(meta.EndOfTrack)
+}
+
+// TempoBPM writes a set tempo message
+func (w *ClipWriter) TempoBPM(bpm float64) error {
+	return w.wr.Write(meta.FractionalBPM(bpm))
+}
+
+// Meter writes a set time signature message in a more comfortable way.
+// Numerator and Denominator are decimal.
+func (w *ClipWriter) Meter(numerator, denominator uint8) error {
+	return w.wr.Write(meter.Meter(numerator, denominator))
+}
+
+// clipWriter writes the messages as UMPs, each preceded by a delta clockstamp
+type clipWriter struct {
+	dest          io.Writer
+	wr            *ump.Writer
+	trans         *ump.MIDI1To2
+	group         uint8
+	resolution    uint16
+	delta         uint32
+	headerWritten bool
+	finished      bool
+	err           error
+}
+
+var _ midi.Writer = &clipWriter{}
+
+func (w *clipWriter) writeHeader() error {
+	w.headerWritten = true
+	_, err := io.WriteString(w.dest, clipMagic)
+	if err != nil {
+		return err
+	}
+	return w.wr.Write(ump.DeltaClockstampTPQ(w.resolution), ump.DeltaClockstamp(0), ump.StartOfClip())
+}
+
+// writePackets writes the packets of a single event, preceded by the pending delta
+func (w *clipWriter) writePackets(ps ...ump.Packet) error {
+	for w.delta > ump.MaxDeltaClockstamp {
+		if err := w.wr.Write(ump.DeltaClockstamp(ump.MaxDeltaClockstamp)); err != nil {
+			return err
+		}
+		w.delta -= ump.MaxDeltaClockstamp
+	}
+
+	for _, p := range ps {
+		if err := w.wr.Write(ump.DeltaClockstamp(w.delta), p); err != nil {
+			return err
+		}
+		w.delta = 0
+	}
+	return nil
+}
+
+func (w *clipWriter) Write(msg midi.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.finished {
+		return fmt.Errorf("can't write %s: clip is finished", msg)
+	}
+
+	if !w.headerWritten {
+		if w.err = w.writeHeader(); w.err != nil {
+			return w.err
+		}
+	}
+
+	var ps []ump.Packet
+
+	if msg == meta.EndOfTrack {
+		w.finished = true
+		w.err = w.writePackets(ump.EndOfClip())
+		return w.err
+	}
+
+	switch m := msg.(type) {
+	case meta.Tempo:
+		ps = []ump.Packet{ump.SetTempoBPM(w.group, m.FractionalBPM())}
+	case meta.TimeSig:
+		ps = []ump.Packet{ump.SetTimeSignature(w.group, m.Numerator, log2(m.Denominator), m.DemiSemiQuaverPerQuarter)}
+	case meta.Message:
+		// other meta messages have no representation in a clip
+		return nil
+	default:
+		if w.trans != nil {
+			ps = w.trans.Translate(msg.Raw())
+		} else {
+			ps = ump.MIDI1Packets(w.group, msg.Raw())
+		}
+	}
+
+	// messages that are translated to no packets keep their delta for the next message
+	if len(ps) > 0 {
+		w.err = w.writePackets(ps...)
+	}
+	return w.err
+}
+
+// log2 returns the power of 2 of a decimal time signature denominator
+func log2(v uint8) (n uint8) {
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	return
+}
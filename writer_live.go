@@ -13,6 +13,7 @@ import (
 // Writer writes live MIDI data. Its methods must not be called concurrently
 type Writer struct {
 	*midiWriter
+	activesense *activesenseWriter
 }
 
 var _ midi.Writer = &Writer{}
@@ -26,7 +27,7 @@ func NewWriter(dest io.Writer, options ...midiwriter.Option) *Writer {
 		}, options...)
 
 	wr := midiwriter.New(dest, options...)
-	return &Writer{&midiWriter{wr: wr, ch: channel.Channel0}}
+	return &Writer{midiWriter: &midiWriter{wr: wr, ch: channel.Channel0}}
 }
 
 // ActiveSensing writes the active sensing realtime message
@@ -8,6 +8,27 @@ import (
 	"github.com/gomidi/midi/midimessage/sysex"
 )
 
+// ChannelWriter writes channel messages. It is implemented by Writer and SMFWriter.
+type ChannelWriter interface {
+	SetChannel(no uint8)
+	Aftertouch(pressure uint8) error
+	PolyAftertouch(key, pressure uint8) error
+	NoteOn(key, velocity uint8) error
+	NoteOff(key uint8) error
+	NoteOffVelocity(key, velocity uint8) error
+	Pitchbend(value int16) error
+	ProgramChange(program uint8) error
+	ControlChange(controller, value uint8) error
+	RPN(val101, val100, msbVal, lsbVal uint8) error
+	PitchBendSensitivityRPN(msbVal, lsbVal uint8) error
+	Write(msg midi.Message) error
+}
+
+var (
+	_ ChannelWriter = &Writer{}
+	_ ChannelWriter = &SMFWriter{}
+)
+
 type midiWriter struct {
 	wr              midi.Writer
 	ch              channel.Channel
@@ -0,0 +1,500 @@
+// Package wsbridge bridges MIDI and browser clients over WebSocket.
+//
+// A Bridge is an http.Handler. Every connected client receives the MIDI messages that are read by
+// an attached mid.Reader, either as JSON text messages (the default) or as raw MIDI bytes in binary
+// messages (query parameter format=raw). Messages the clients send are written to a mid.ChannelWriter.
+//
+// The JSON events use the same message types and field names as the oscbridge package:
+//
+//	{"type": "noteon", "ch": 0, "key": 60, "vel": 100}
+//	{"type": "cc", "ch": 9, "cc": 7, "val": 127}
+//	{"type": "raw", "data": [240, 126, 127, 6, 1, 247]}
+//
+// The types are noteon, noteoff, cc, program, pitchbend, aftertouch and polyaftertouch. All other messages
+// are of type raw. Events of an SMF have additional fields track and tick (absolute ticks).
+//
+// Clients subscribe to a subset of the messages by passing the query parameters channels (e.g. channels=0,9)
+// and types (e.g. types=noteon,noteoff) or by sending
+//
+//	{"type": "subscribe", "channels": [0, 9], "types": ["noteon", "noteoff"]}
+//
+// An empty list subscribes to everything. Clients that are too slow to receive the messages
+// never block the Reader: their messages are dropped (or they are disconnected, see Bridge.DisconnectSlow).
+//
+// Cross-origin connections from browsers are rejected, unless Bridge.CheckOrigin allows them.
+package wsbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/internal/bridge"
+	"github.com/gomidi/midi"
+	"github.com/gomidi/midi/midimessage/realtime"
+	"github.com/gomidi/midi/midireader"
+)
+
+// DefaultQueueSize is the default number of messages that are buffered for each client
+const DefaultQueueSize = 256
+
+// DefaultWriteTimeout is the default time a client has to receive a message
+const DefaultWriteTimeout = 5 * time.Second
+
+// message types; the channel message types are those of the internal bridge package
+const (
+	msgRaw      = bridge.NumTypes
+	numMsgTypes = msgRaw + 1
+)
+
+var msgTypeNames [numMsgTypes]string
+
+var msgTypes = map[string]int{}
+
+func init() {
+	copy(msgTypeNames[:], bridge.TypeNames[:])
+	msgTypeNames[msgRaw] = "raw"
+
+	for typ, name := range msgTypeNames {
+		msgTypes[name] = typ
+	}
+}
+
+// filter selects the messages a client is subscribed to
+type filter struct {
+	channels [16]bool
+	types    [numMsgTypes]bool
+}
+
+// newFilter returns a filter for the given channels and types. Empty lists select everything.
+func newFilter(channels []uint8, types []string) (f filter, err error) {
+	for i := range f.channels {
+		f.channels[i] = len(channels) == 0
+	}
+	for _, ch := range channels {
+		if ch > 15 {
+			return f, fmt.Errorf("invalid channel %v", ch)
+		}
+		f.channels[ch] = true
+	}
+
+	for i := range f.types {
+		f.types[i] = len(types) == 0
+	}
+	for _, t := range types {
+		typ, ok := msgTypes[t]
+		if !ok {
+			return f, fmt.Errorf("unknown message type %q", t)
+		}
+		f.types[typ] = true
+	}
+	return
+}
+
+// parseFilter returns the filter for the channels and types query parameters
+func parseFilter(channels, types string) (filter, error) {
+	var chs []uint8
+	var ts []string
+
+	for _, s := range strings.Split(channels, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		ch, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return filter{}, fmt.Errorf("invalid channel %q", s)
+		}
+		chs = append(chs, uint8(ch))
+	}
+
+	for _, s := range strings.Split(types, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ts = append(ts, s)
+		}
+	}
+
+	return newFilter(chs, ts)
+}
+
+// event is a MIDI message as it is sent to the clients
+type event struct {
+	typ     int
+	channel uint8
+	json    []byte
+	raw     []byte
+}
+
+func (f filter) match(ev *event) bool {
+	if !f.types[ev.typ] {
+		return false
+	}
+	return ev.typ == msgRaw || f.channels[ev.channel]
+}
+
+// inEvent is a message received from a client
+type inEvent struct {
+	Type     string   `json:"type"`
+	Ch       int      `json:"ch"`
+	Key      int      `json:"key"`
+	Vel      int      `json:"vel"`
+	CC       int      `json:"cc"`
+	Val      int      `json:"val"`
+	Prog     int      `json:"prog"`
+	RawData  []int    `json:"data"`
+	Channels []uint8  `json:"channels"`
+	Types    []string `json:"types"`
+}
+
+type client struct {
+	conn  *wsConn
+	raw   bool
+	queue chan []byte
+	done  chan struct{}
+
+	mx       sync.Mutex
+	filter   filter
+	dropping bool
+}
+
+func (c *client) setFilter(f filter) {
+	c.mx.Lock()
+	c.filter = f
+	c.mx.Unlock()
+}
+
+// Bridge is an http.Handler that connects websocket clients with a mid.Reader and a mid.ChannelWriter
+type Bridge struct {
+	wr   mid.ChannelWriter
+	wrMx sync.Mutex
+
+	mx      sync.Mutex
+	clients map[*client]bool
+	prev    func(*mid.Position, midi.Message)
+
+	// QueueSize is the number of messages that are buffered for each client.
+	// If it is 0, DefaultQueueSize is used.
+	QueueSize int
+
+	// WriteTimeout is the time a client has to receive a message before it is disconnected.
+	// If it is 0, DefaultWriteTimeout is used.
+	WriteTimeout time.Duration
+
+	// DisconnectSlow disconnects clients whose queue is full instead of dropping their messages
+	DisconnectSlow bool
+
+	// CheckOrigin returns true if a client with the Origin header of the request may connect.
+	// If it is nil, only requests without Origin header or with an origin whose host equals the
+	// Host of the request are accepted, so that foreign web pages can't connect.
+	CheckOrigin func(r *http.Request) bool
+
+	// Error is called for errors of clients, if it is not nil
+	Error func(err error)
+}
+
+// New returns a Bridge that writes the messages of the clients to wr.
+// If wr is nil, messages from the clients are ignored.
+func New(wr mid.ChannelWriter) *Bridge {
+	return &Bridge{
+		wr:      wr,
+		clients: map[*client]bool{},
+	}
+}
+
+func (b *Bridge) error(err error) {
+	if b.Error != nil {
+		b.Error(err)
+	}
+}
+
+// Clients returns the number of connected clients
+func (b *Bridge) Clients() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return len(b.clients)
+}
+
+// Close disconnects all clients
+func (b *Bridge) Close() error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for c := range b.clients {
+		c.conn.close(1001)
+		delete(b.clients, c)
+	}
+	return nil
+}
+
+// Attach subscribes the bridge to the messages of the Reader. A Msg.Each callback that
+// had been attached to the Reader before is still called.
+func (b *Bridge) Attach(rd *mid.Reader) {
+	b.prev = rd.Msg.Each
+	rd.Msg.Each = b.each
+}
+
+func (b *Bridge) each(p *mid.Position, msg midi.Message) {
+	if b.prev != nil {
+		b.prev(p, msg)
+	}
+	b.Send(p, msg)
+}
+
+// Send sends the message to all subscribed clients. p may be nil.
+// It is called for every message of an attached Reader.
+func (b *Bridge) Send(p *mid.Position, msg midi.Message) {
+	ev := newEvent(p, msg)
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for c := range b.clients {
+		c.mx.Lock()
+		ok := c.filter.match(ev)
+		c.mx.Unlock()
+		if !ok {
+			continue
+		}
+
+		data := ev.json
+		if c.raw {
+			data = ev.raw
+		}
+
+		select {
+		case c.queue <- data:
+			c.dropping = false
+		default:
+			if b.DisconnectSlow {
+				b.error(fmt.Errorf("disconnecting slow client %s", c.conn.conn.RemoteAddr()))
+				c.conn.conn.Close()
+				delete(b.clients, c)
+				continue
+			}
+			if !c.dropping {
+				b.error(fmt.Errorf("client %s is too slow, dropping messages", c.conn.conn.RemoteAddr()))
+				c.dropping = true
+			}
+		}
+	}
+}
+
+// newEvent encodes the message for the clients
+func newEvent(p *mid.Position, msg midi.Message) *event {
+	ev := &event{raw: msg.Raw()}
+	fields := map[string]interface{}{}
+
+	typ, v, ok := bridge.Decode(msg)
+	if ok {
+		ev.typ, ev.channel = typ, uint8(v[bridge.Ch])
+		for _, f := range bridge.Fields[typ] {
+			fields[bridge.FieldNames[f]] = v[f]
+		}
+	} else {
+		ev.typ = msgRaw
+		data := make([]int, len(ev.raw))
+		for i, b := range ev.raw {
+			data[i] = int(b)
+		}
+		fields["data"] = data
+	}
+
+	fields["type"] = msgTypeNames[ev.typ]
+	if ev.typ != msgRaw {
+		fields["ch"] = ev.channel
+	}
+
+	if p != nil {
+		fields["track"], fields["tick"] = p.Track, p.AbsoluteTicks
+	}
+
+	ev.json, _ = json.Marshal(fields)
+	return ev
+}
+
+// ServeHTTP upgrades the request to a websocket connection and serves the client until it disconnects
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	f, err := parseFilter(q.Get("channels"), q.Get("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "raw" {
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	checkOrigin := b.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	conn, err := upgrade(w, r, checkOrigin)
+	if err != nil {
+		b.error(err)
+		return
+	}
+
+	size := b.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	c := &client{
+		conn:   conn,
+		raw:    format == "raw",
+		queue:  make(chan []byte, size),
+		done:   make(chan struct{}),
+		filter: f,
+	}
+
+	b.mx.Lock()
+	b.clients[c] = true
+	b.mx.Unlock()
+
+	go b.writeLoop(c)
+	err = b.readLoop(c)
+
+	b.mx.Lock()
+	delete(b.clients, c)
+	b.mx.Unlock()
+	close(c.done)
+
+	if err != nil && err != io.EOF {
+		b.error(fmt.Errorf("client %s: %v", conn.conn.RemoteAddr(), err))
+		conn.close(1002)
+		return
+	}
+	conn.conn.Close()
+}
+
+func (b *Bridge) writeLoop(c *client) {
+	timeout := b.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+
+	op := byte(opText)
+	if c.raw {
+		op = opBinary
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.queue:
+			if err := c.conn.writeFrame(op, data, time.Now().Add(timeout)); err != nil {
+				// unblocks the read loop
+				c.conn.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (b *Bridge) readLoop(c *client) error {
+	for {
+		op, msg, err := c.conn.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if op == opBinary {
+			err = b.writeRaw(msg)
+		} else {
+			err = b.receive(c, msg)
+		}
+
+		if err != nil {
+			b.error(fmt.Errorf("client %s: %v", c.conn.conn.RemoteAddr(), err))
+		}
+	}
+}
+
+// receive handles a JSON message of a client
+func (b *Bridge) receive(c *client, msg []byte) error {
+	var ev inEvent
+	if err := json.Unmarshal(msg, &ev); err != nil {
+		return fmt.Errorf("invalid message %q: %v", msg, err)
+	}
+
+	if ev.Type == "subscribe" {
+		f, err := newFilter(ev.Channels, ev.Types)
+		if err != nil {
+			return err
+		}
+		c.setFilter(f)
+		return nil
+	}
+
+	typ, ok := msgTypes[ev.Type]
+	if !ok {
+		return fmt.Errorf("unknown message type %q", ev.Type)
+	}
+
+	if typ == msgRaw {
+		data := make([]byte, len(ev.RawData))
+		for i, v := range ev.RawData {
+			if v < 0 || v > 255 {
+				return fmt.Errorf("invalid byte %v", v)
+			}
+			data[i] = byte(v)
+		}
+		return b.writeRaw(data)
+	}
+
+	if b.wr == nil {
+		return nil
+	}
+
+	b.wrMx.Lock()
+	defer b.wrMx.Unlock()
+	return bridge.Write(b.wr, typ, bridge.Values{
+		bridge.Ch:   ev.Ch,
+		bridge.Key:  ev.Key,
+		bridge.Vel:  ev.Vel,
+		bridge.CC:   ev.CC,
+		bridge.Val:  ev.Val,
+		bridge.Prog: ev.Prog,
+	})
+}
+
+// writeRaw writes the raw MIDI messages in data
+func (b *Bridge) writeRaw(data []byte) error {
+	if b.wr == nil {
+		return nil
+	}
+
+	b.wrMx.Lock()
+	defer b.wrMx.Unlock()
+
+	var err error
+	rd := midireader.New(bytes.NewReader(data), func(m realtime.Message) {
+		if e := b.wr.Write(m); e != nil && err == nil {
+			err = e
+		}
+	})
+
+	for {
+		m, e := rd.Read()
+		if e == io.EOF {
+			return err
+		}
+		if e != nil {
+			return e
+		}
+		if e := b.wr.Write(m); e != nil && err == nil {
+			err = e
+		}
+	}
+}
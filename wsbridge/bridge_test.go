@@ -0,0 +1,319 @@
+package wsbridge
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomidi/mid"
+	"github.com/gomidi/mid/internal/bridge"
+	"github.com/gomidi/midi/midimessage/channel"
+)
+
+// testClient is a minimal websocket client
+type testClient struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func dial(t *testing.T, srv *httptest.Server, query string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /?%s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", query, key)
+
+	rd := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %v", resp.StatusCode)
+	}
+
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Fatalf("Sec-WebSocket-Accept = %q; want %q", got, want)
+	}
+
+	return &testClient{conn: conn, rd: rd}
+}
+
+func (c *testClient) send(op byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	b := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	b = append(b, mask[:]...)
+	for i, p := range payload {
+		b = append(b, p^mask[i%4])
+	}
+	c.conn.Write(b)
+}
+
+func (c *testClient) receive(t *testing.T) (op byte, payload []byte) {
+	t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.rd, hdr[:]); err != nil {
+		t.Fatalf("can't receive: %v", err)
+	}
+
+	l := int(hdr[1] & 0x7F)
+	if l == 126 {
+		var b [2]byte
+		io.ReadFull(c.rd, b[:])
+		l = int(binary.BigEndian.Uint16(b[:]))
+	}
+
+	payload = make([]byte, l)
+	if _, err := io.ReadFull(c.rd, payload); err != nil {
+		t.Fatalf("can't receive: %v", err)
+	}
+	return hdr[0] & 0x0F, payload
+}
+
+func waitClients(t *testing.T, b *Bridge, n int) {
+	t.Helper()
+	for i := 0; b.Clients() != n; i++ {
+		if i > 100 {
+			t.Fatalf("got %v clients; want %v", b.Clients(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		channels, types string
+		ev              event
+		match           bool
+	}{
+		{"", "", event{typ: bridge.ControlChange, channel: 3}, true},
+		{"3", "", event{typ: bridge.ControlChange, channel: 3}, true},
+		{"1,2", "", event{typ: bridge.ControlChange, channel: 3}, false},
+		{"", "noteon,noteoff", event{typ: bridge.ControlChange, channel: 3}, false},
+		{"", "noteon,noteoff", event{typ: bridge.NoteOff, channel: 3}, true},
+		{"1", "raw", event{typ: msgRaw}, true},
+	}
+
+	for _, test := range tests {
+		f, err := parseFilter(test.channels, test.types)
+		if err != nil {
+			t.Fatalf("parseFilter(%q, %q) returned error: %v", test.channels, test.types, err)
+		}
+
+		if got := f.match(&test.ev); got != test.match {
+			t.Errorf("parseFilter(%q, %q).match(%v) = %v; want %v", test.channels, test.types, test.ev, got, test.match)
+		}
+	}
+
+	for _, invalid := range [][2]string{{"16", ""}, {"x", ""}, {"", "foo"}} {
+		if _, err := parseFilter(invalid[0], invalid[1]); err == nil {
+			t.Errorf("parseFilter(%q, %q) must return an error", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestEvent(t *testing.T) {
+	tests := []struct {
+		pos  *mid.Position
+		json string
+	}{
+		{nil, `{"ch":2,"key":60,"type":"noteon","vel":100}`},
+		{&mid.Position{Track: 1, AbsoluteTicks: 960}, `{"ch":2,"key":60,"tick":960,"track":1,"type":"noteon","vel":100}`},
+	}
+
+	for _, test := range tests {
+		ev := newEvent(test.pos, channel.Channel2.NoteOn(60, 100))
+		if got := string(ev.json); got != test.json {
+			t.Errorf("got %s; want %s", got, test.json)
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	var out bytes.Buffer
+	b := New(mid.NewWriter(&out))
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+
+	all := dial(t, srv, "")
+	notes := dial(t, srv, "format=raw&types=noteon,noteoff&channels=2")
+	waitClients(t, b, 2)
+
+	var in bytes.Buffer
+	wr := mid.NewWriter(&in)
+	wr.SetChannel(2)
+	wr.NoteOn(60, 100)
+	wr.ControlChange(7, 127)
+	wr.SetChannel(3)
+	wr.NoteOn(62, 100)
+
+	rd := mid.NewReader(mid.NoLogger())
+	b.Attach(rd)
+	rd.Read(&in)
+
+	for _, want := range []string{
+		`{"ch":2,"key":60,"type":"noteon","vel":100}`,
+		`{"cc":7,"ch":2,"type":"cc","val":127}`,
+		`{"ch":3,"key":62,"type":"noteon","vel":100}`,
+	} {
+		op, got := all.receive(t)
+		if op != opText || string(got) != want {
+			t.Errorf("got %X %s; want %s", op, got, want)
+		}
+	}
+
+	op, got := notes.receive(t)
+	if op != opBinary || fmt.Sprintf("% X", got) != "92 3C 64" {
+		t.Errorf("got %X % X; want binary 92 3C 64", op, got)
+	}
+
+	notes.send(opBinary, []byte{0x90, 0x3C, 0x40})
+	notes.send(opPing, []byte("ping"))
+
+	if op, got := notes.receive(t); op != opPong || string(got) != "ping" {
+		t.Errorf("got %X %s; want pong", op, got)
+	}
+
+	// subscribe to control changes on channel 3 only
+	all.send(opText, []byte(`{"type": "subscribe", "channels": [3], "types": ["cc"]}`))
+	// and write to the Writer
+	all.send(opText, []byte(`{"type": "cc", "ch": 1, "cc": 7, "val": 64}`))
+	all.send(opText, []byte(`{"type": "raw", "data": [248]}`))
+	all.send(opPing, []byte("ping"))
+
+	if op, got := all.receive(t); op != opPong || string(got) != "ping" {
+		t.Errorf("got %X %s; want pong", op, got)
+	}
+
+	b.Send(nil, channel.Channel2.ControlChange(7, 1))
+	b.Send(nil, channel.Channel3.ControlChange(7, 2))
+
+	if _, got := all.receive(t); string(got) != `{"cc":7,"ch":3,"type":"cc","val":2}` {
+		t.Errorf("got %s after subscription", got)
+	}
+
+	if got, want := fmt.Sprintf("% X", out.Bytes()), "90 3C 40 B1 07 40 F8"; got != want {
+		t.Errorf("written: %v; want %v", got, want)
+	}
+
+	all.send(opClose, []byte{0x03, 0xE8})
+	if op, _ := all.receive(t); op != opClose {
+		t.Errorf("got %X; want close", op)
+	}
+	waitClients(t, b, 1)
+}
+
+func TestSlowClient(t *testing.T) {
+	b := New(nil)
+	b.QueueSize = 2
+	b.DisconnectSlow = true
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	slow := dial(t, srv, "")
+	defer slow.conn.Close()
+	waitClients(t, b, 1)
+
+	// the client does not read, so the queue fills up (the kernel buffers are much larger
+	// than a few messages, therefore we send many)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000000 && b.Clients() > 0; i++ {
+			b.Send(nil, channel.Channel0.ControlChange(1, uint8(i%128)))
+		}
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked")
+	}
+
+	if b.Clients() != 0 {
+		t.Errorf("slow client must be disconnected")
+	}
+}
+
+func TestHandshakeError(t *testing.T) {
+	srv := httptest.NewServer(New(nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("status %v; want %v", resp.StatusCode, http.StatusUpgradeRequired)
+	}
+
+	resp, err = http.Get(srv.URL + "?types=foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %v; want %v", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin      string
+		checkOrigin func(*http.Request) bool
+		allowed     bool
+	}{
+		{"", nil, true},
+		{"http://test", nil, true},
+		{"https://TEST", nil, true},
+		{"http://evil.example", nil, false},
+		{"http://test:8080", nil, false},
+		{"://", nil, false},
+		{"http://evil.example", func(*http.Request) bool { return true }, true},
+		{"", func(*http.Request) bool { return false }, false},
+	}
+
+	for _, test := range tests {
+		b := New(nil)
+		b.CheckOrigin = test.checkOrigin
+
+		r := httptest.NewRequest(http.MethodGet, "http://test/", nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		// the recorder can't be hijacked, so an allowed request fails later
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, r)
+
+		if forbidden := w.Code == http.StatusForbidden; forbidden == test.allowed {
+			t.Errorf("origin %q: status %v, allowed must be %v", test.origin, w.Code, test.allowed)
+		}
+	}
+}
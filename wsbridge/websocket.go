@@ -0,0 +1,257 @@
+package wsbridge
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client key to compute the accept key (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxMessageSize is the largest message that is accepted from a client
+const maxMessageSize = 1 << 20
+
+var errMessageTooLarge = errors.New("websocket message too large")
+
+// wsConn is the server side of a websocket connection
+type wsConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+
+	mx sync.Mutex // protects writes
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for the client key
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin returns true if the request has no Origin header or if the host of the origin equals the Host of the request
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// upgrade performs the websocket handshake and takes over the connection, if checkOrigin accepts the request
+func upgrade(w http.ResponseWriter, r *http.Request, checkOrigin func(*http.Request) bool) (*wsConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("no websocket upgrade request")
+	}
+
+	if !checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("origin %q not allowed", r.Header.Get("Origin"))
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("unsupported websocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("http.ResponseWriter does not support hijacking")
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, rd: brw.Reader}, nil
+}
+
+// writeFrame writes a single unmasked, final frame
+func (c *wsConn) writeFrame(op byte, payload []byte, deadline time.Time) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var hdr [10]byte
+	hdr[0] = 0x80 | op
+	n := 2
+
+	switch l := len(payload); {
+	case l < 126:
+		hdr[1] = byte(l)
+	case l <= 0xFFFF:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+
+	c.conn.SetWriteDeadline(deadline)
+	if _, err := c.conn.Write(append(hdr[:n:n], payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads a single (masked) client frame
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.rd, hdr[:]); err != nil {
+		return
+	}
+
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	l := uint64(hdr[1] & 0x7F)
+
+	switch l {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.rd, b[:]); err != nil {
+			return
+		}
+		l = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.rd, b[:]); err != nil {
+			return
+		}
+		l = binary.BigEndian.Uint64(b[:])
+	}
+
+	if l > maxMessageSize {
+		err = errMessageTooLarge
+		return
+	}
+
+	if !masked {
+		err = fmt.Errorf("unmasked websocket frame from client")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rd, mask[:]); err != nil {
+		return
+	}
+
+	payload = make([]byte, l)
+	if _, err = io.ReadFull(c.rd, payload); err != nil {
+		return
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// readMessage returns the next text or binary message, answering pings and reassembling fragments.
+// It returns io.EOF, if the client closed the connection.
+func (c *wsConn) readMessage() (op byte, msg []byte, err error) {
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch fop {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, time.Now().Add(time.Second)); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload, time.Now().Add(time.Second))
+			return 0, nil, io.EOF
+		case opText, opBinary:
+			if op != 0 {
+				return 0, nil, fmt.Errorf("websocket message interrupted by new message")
+			}
+			op = fop
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, fmt.Errorf("unexpected websocket continuation frame")
+			}
+		default:
+			return 0, nil, fmt.Errorf("unknown websocket opcode %X", fop)
+		}
+
+		if len(msg)+len(payload) > maxMessageSize {
+			return 0, nil, errMessageTooLarge
+		}
+		msg = append(msg, payload...)
+
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+// close sends a close frame with the given status code and closes the connection
+func (c *wsConn) close(code uint16) error {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], code)
+	c.writeFrame(opClose, b[:], time.Now().Add(time.Second))
+	return c.conn.Close()
+}
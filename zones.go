@@ -0,0 +1,391 @@
+package mid
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Zone maps a key and velocity range of an input channel to an output channel.
+// Zones with overlapping ranges are layered.
+type Zone struct {
+	// InChannel is the channel of the incoming messages
+	InChannel uint8
+
+	// OutChannel is the channel of the written messages
+	OutChannel uint8
+
+	// MinKey and MaxKey are the key range of the zone (before transposing). If MaxKey is 0, it is 127.
+	MinKey, MaxKey uint8
+
+	// MinVelocity and MaxVelocity are the velocity range of the zone. If MaxVelocity is 0, it is 127.
+	MinVelocity, MaxVelocity uint8
+
+	// Transpose is added to the key (in semitones)
+	Transpose int8
+
+	// VelocityScale is multiplied with the velocity. If it is 0, the velocity is not changed.
+	VelocityScale float64
+
+	// Controllers are the controllers that are forwarded to the zone. If empty, all are forwarded.
+	Controllers []uint8
+}
+
+func (z *Zone) validate() error {
+	if z.InChannel > 15 || z.OutChannel > 15 {
+		return fmt.Errorf("invalid channel in zone %v => %v", z.InChannel, z.OutChannel)
+	}
+	if z.MaxKey == 0 {
+		z.MaxKey = 127
+	}
+	if z.MaxVelocity == 0 {
+		z.MaxVelocity = 127
+	}
+	if z.MinKey > z.MaxKey || z.MinVelocity > z.MaxVelocity {
+		return fmt.Errorf("invalid range in zone %v => %v", z.InChannel, z.OutChannel)
+	}
+	if z.VelocityScale < 0 {
+		return fmt.Errorf("invalid velocity scale %v", z.VelocityScale)
+	}
+	return nil
+}
+
+func (z *Zone) matchNote(ch, key, vel uint8) bool {
+	return z.InChannel == ch && key >= z.MinKey && key <= z.MaxKey && vel >= z.MinVelocity && vel <= z.MaxVelocity
+}
+
+func (z *Zone) forwardsController(cc uint8) bool {
+	if len(z.Controllers) == 0 {
+		return true
+	}
+	for _, c := range z.Controllers {
+		if c == cc {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the transposed key and false, if it is out of range
+func (z *Zone) key(key uint8) (uint8, bool) {
+	k := int(key) + int(z.Transpose)
+	if k < 0 || k > 127 {
+		return 0, false
+	}
+	return uint8(k), true
+}
+
+func (z *Zone) velocity(vel uint8) uint8 {
+	if z.VelocityScale == 0 {
+		return vel
+	}
+	v := int(float64(vel)*z.VelocityScale + 0.5)
+	switch {
+	case v < 1:
+		return 1
+	case v > 127:
+		return 127
+	}
+	return uint8(v)
+}
+
+// zoneNote is a running note of a zone
+type zoneNote struct {
+	channel, key uint8
+}
+
+// Zones is a layer over a Reader that splits and layers the notes of input channels into zones
+// and writes them to the output channels of the zones.
+//
+// Control changes, pitch bend and channel pressure of an input channel are written to the output channels
+// of its zones (once per output channel). Note off and polyphonic pressure messages always reach the
+// output channel and key of the note on, even if the zones have been changed in between.
+// Sustain pedal releases also reach every output channel that received the sustain pedal.
+//
+// Messages on channels that are no input channel of any zone are passed to the callbacks that had been
+// attached to the Reader before NewZones was called.
+type Zones struct {
+	wr ChannelWriter
+
+	mx        sync.Mutex
+	zones     []Zone
+	inputs    [16]bool
+	notes     [16][128][]zoneNote // input channel -> key -> running notes
+	sustained [16][16]bool        // input channel -> output channel -> sustain pedal down
+
+	// the callbacks of the Reader before Zones took over
+	prev struct {
+		noteOn         func(p *Position, channel, key, velocity uint8)
+		noteOff        func(p *Position, channel, key, velocity uint8)
+		pitchbend      func(p *Position, channel uint8, value int16)
+		aftertouch     func(p *Position, channel, pressure uint8)
+		polyAftertouch func(p *Position, channel, key, pressure uint8)
+		cc             func(p *Position, channel, controller, value uint8)
+	}
+
+	// Error is called for errors of the writer, if it is not nil
+	Error func(err error)
+}
+
+// NewZones attaches Zones to rd that write to wr.
+func NewZones(rd *Reader, wr ChannelWriter, zones ...Zone) (*Zones, error) {
+	z := &Zones{wr: wr}
+	if err := z.SetZones(zones...); err != nil {
+		return nil, err
+	}
+
+	z.prev.noteOn = rd.Msg.Channel.NoteOn
+	z.prev.noteOff = rd.Msg.Channel.NoteOff
+	z.prev.pitchbend = rd.Msg.Channel.Pitchbend
+	z.prev.aftertouch = rd.Msg.Channel.Aftertouch
+	z.prev.polyAftertouch = rd.Msg.Channel.PolyAftertouch
+	z.prev.cc = rd.Msg.Channel.ControlChange.Each
+
+	rd.Msg.Channel.NoteOn = z.noteOn
+	rd.Msg.Channel.NoteOff = z.noteOff
+	rd.Msg.Channel.Pitchbend = z.pitchbend
+	rd.Msg.Channel.Aftertouch = z.aftertouch
+	rd.Msg.Channel.PolyAftertouch = z.polyAftertouch
+	rd.Msg.Channel.ControlChange.Each = z.controlChange
+
+	return z, nil
+}
+
+// SetZones replaces the zones. It may be called while reading.
+// The note off and polyphonic aftertouch messages of running notes still reach the zones that started the notes.
+func (z *Zones) SetZones(zones ...Zone) error {
+	zs := make([]Zone, len(zones))
+	var inputs [16]bool
+
+	for i, zone := range zones {
+		if err := zone.validate(); err != nil {
+			return err
+		}
+		zs[i] = zone
+		inputs[zone.InChannel] = true
+	}
+
+	z.mx.Lock()
+	z.zones = zs
+	z.inputs = inputs
+	z.mx.Unlock()
+	return nil
+}
+
+func (z *Zones) isInput(ch uint8) bool {
+	z.mx.Lock()
+	defer z.mx.Unlock()
+	return z.inputs[ch]
+}
+
+func (z *Zones) write(ch uint8, fn func() error) {
+	z.wr.SetChannel(ch)
+	if err := fn(); err != nil && z.Error != nil {
+		z.Error(err)
+	}
+}
+
+// outChannels returns the output channels of the zones of the input channel, each channel once
+func (z *Zones) outChannels(ch uint8, filter func(*Zone) bool) (outs []uint8) {
+	var seen [16]bool
+	for i := range z.zones {
+		zone := &z.zones[i]
+		if zone.InChannel != ch || seen[zone.OutChannel] || (filter != nil && !filter(zone)) {
+			continue
+		}
+		seen[zone.OutChannel] = true
+		outs = append(outs, zone.OutChannel)
+	}
+	return
+}
+
+func (z *Zones) noteOn(p *Position, ch, key, vel uint8) {
+	if !z.isInput(ch) {
+		if z.prev.noteOn != nil {
+			z.prev.noteOn(p, ch, key, vel)
+		}
+		return
+	}
+
+	type start struct {
+		note zoneNote
+		vel  uint8
+	}
+
+	var starts []start
+
+	z.mx.Lock()
+	for i := range z.zones {
+		zone := &z.zones[i]
+		if !zone.matchNote(ch, key, vel) {
+			continue
+		}
+		k, ok := zone.key(key)
+		if !ok {
+			continue
+		}
+		s := start{zoneNote{zone.OutChannel, k}, zone.velocity(vel)}
+
+		// overlapping zones that play the same note start it once, with the highest velocity
+		dup := false
+		for j := range starts {
+			if starts[j].note == s.note {
+				if s.vel > starts[j].vel {
+					starts[j].vel = s.vel
+				}
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			starts = append(starts, s)
+		}
+	}
+
+	// a retriggered key stops its previous notes
+	stops := z.notes[ch][key]
+	notes := make([]zoneNote, len(starts))
+	for i, s := range starts {
+		notes[i] = s.note
+	}
+	z.notes[ch][key] = notes
+	z.mx.Unlock()
+
+	for _, n := range stops {
+		z.write(n.channel, func() error { return z.wr.NoteOff(n.key) })
+	}
+
+	for _, s := range starts {
+		z.write(s.note.channel, func() error { return z.wr.NoteOn(s.note.key, s.vel) })
+	}
+}
+
+func (z *Zones) noteOff(p *Position, ch, key, vel uint8) {
+	z.mx.Lock()
+	notes := z.notes[ch][key]
+	z.notes[ch][key] = nil
+	isInput := z.inputs[ch]
+	z.mx.Unlock()
+
+	// notes are stopped, even if the channel is no input anymore
+	if !isInput && notes == nil {
+		if z.prev.noteOff != nil {
+			z.prev.noteOff(p, ch, key, vel)
+		}
+		return
+	}
+
+	for _, n := range notes {
+		if vel > 0 {
+			z.write(n.channel, func() error { return z.wr.NoteOffVelocity(n.key, vel) })
+		} else {
+			z.write(n.channel, func() error { return z.wr.NoteOff(n.key) })
+		}
+	}
+}
+
+func (z *Zones) polyAftertouch(p *Position, ch, key, pressure uint8) {
+	z.mx.Lock()
+	notes := z.notes[ch][key]
+	isInput := z.inputs[ch]
+	z.mx.Unlock()
+
+	if !isInput && notes == nil {
+		if z.prev.polyAftertouch != nil {
+			z.prev.polyAftertouch(p, ch, key, pressure)
+		}
+		return
+	}
+
+	for _, n := range notes {
+		z.write(n.channel, func() error { return z.wr.PolyAftertouch(n.key, pressure) })
+	}
+}
+
+func (z *Zones) controlChange(p *Position, ch, cc, val uint8) {
+	z.mx.Lock()
+	if !z.inputs[ch] && !(cc == 64 && z.isSustained(ch)) {
+		z.mx.Unlock()
+		if z.prev.cc != nil {
+			z.prev.cc(p, ch, cc, val)
+		}
+		return
+	}
+
+	outs := z.outChannels(ch, func(zone *Zone) bool { return zone.forwardsController(cc) })
+
+	if cc == 64 {
+		down := val >= 64
+		if !down {
+			// the pedal is released on every channel where it is down
+			for out, sustained := range z.sustained[ch] {
+				if sustained && !containsChannel(outs, uint8(out)) {
+					outs = append(outs, uint8(out))
+				}
+			}
+		}
+		for _, out := range outs {
+			z.sustained[ch][out] = down
+		}
+	}
+	z.mx.Unlock()
+
+	for _, out := range outs {
+		z.write(out, func() error { return z.wr.ControlChange(cc, val) })
+	}
+}
+
+// isSustained returns true, if the sustain pedal of the input channel is down on any output channel.
+// z.mx must be locked.
+func (z *Zones) isSustained(ch uint8) bool {
+	for _, s := range z.sustained[ch] {
+		if s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsChannel(chs []uint8, ch uint8) bool {
+	for _, c := range chs {
+		if c == ch {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *Zones) pitchbend(p *Position, ch uint8, value int16) {
+	z.mx.Lock()
+	isInput := z.inputs[ch]
+	outs := z.outChannels(ch, nil)
+	z.mx.Unlock()
+
+	if !isInput {
+		if z.prev.pitchbend != nil {
+			z.prev.pitchbend(p, ch, value)
+		}
+		return
+	}
+
+	for _, out := range outs {
+		z.write(out, func() error { return z.wr.Pitchbend(value) })
+	}
+}
+
+func (z *Zones) aftertouch(p *Position, ch, pressure uint8) {
+	z.mx.Lock()
+	isInput := z.inputs[ch]
+	outs := z.outChannels(ch, nil)
+	z.mx.Unlock()
+
+	if !isInput {
+		if z.prev.aftertouch != nil {
+			z.prev.aftertouch(p, ch, pressure)
+		}
+		return
+	}
+
+	for _, out := range outs {
+		z.write(out, func() error { return z.wr.Aftertouch(pressure) })
+	}
+}
@@ -0,0 +1,136 @@
+package mid
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestZones(t *testing.T) {
+	var bf bytes.Buffer
+	rd := NewReader(NoLogger())
+
+	var passed []string
+	rd.Msg.Channel.NoteOn = func(p *Position, ch, key, vel uint8) {
+		passed = append(passed, fmt.Sprintf("on %v %v", ch, key))
+	}
+
+	zones, err := NewZones(rd, NewWriter(&bf),
+		Zone{InChannel: 0, OutChannel: 1, MaxKey: 59, Transpose: 12, Controllers: []uint8{64}},
+		Zone{InChannel: 0, OutChannel: 2, MinKey: 60},
+		Zone{InChannel: 0, OutChannel: 3, MinKey: 60, MinVelocity: 100, VelocityScale: 0.5},
+	)
+	if err != nil {
+		t.Fatalf("NewZones returned error: %v", err)
+	}
+
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		// lower zone, transposed
+		{[]byte{0x90, 48, 80}, "91 3C 50"},
+		{[]byte{0x80, 48, 0}, "91 3C 00"},
+		// upper zone, soft
+		{[]byte{0x90, 72, 80}, "92 48 50"},
+		// upper zone, hard: layered with half velocity
+		{[]byte{0x90, 74, 120}, "92 4A 78 93 4A 3C"},
+		{[]byte{0xA0, 74, 10}, "A2 4A 0A A3 4A 0A"},
+		{[]byte{0x90, 74, 0}, "92 4A 00 93 4A 00"},
+		// sustain goes to every zone, modulation only to the upper zones
+		{[]byte{0xB0, 64, 127}, "B1 40 7F B2 40 7F B3 40 7F"},
+		{[]byte{0xB0, 1, 20}, "B2 01 14 B3 01 14"},
+		{[]byte{0xE0, 0, 0x40}, "E1 00 40 E2 00 40 E3 00 40"},
+		// other channels are passed
+		{[]byte{0x95, 60, 100}, ""},
+	}
+
+	for _, test := range tests {
+		bf.Reset()
+		rd.Read(bytes.NewReader(test.in))
+
+		if got := fmt.Sprintf("% X", bf.Bytes()); got != test.expected {
+			t.Errorf("% X: got %v; want %v", test.in, got, test.expected)
+		}
+	}
+
+	if fmt.Sprint(passed) != "[on 5 60]" {
+		t.Errorf("passed %v; want [on 5 60]", passed)
+	}
+
+	// change the zones while note 72 and the sustain pedal are down
+	err = zones.SetZones(Zone{InChannel: 0, OutChannel: 4, Controllers: []uint8{7}})
+	if err != nil {
+		t.Fatalf("SetZones returned error: %v", err)
+	}
+
+	tests = []struct {
+		in       []byte
+		expected string
+	}{
+		{[]byte{0x90, 60, 100}, "94 3C 64"},
+		// the note off reaches the old zone
+		{[]byte{0x80, 72, 0}, "92 48 00"},
+		// the pedal is released where it had been pressed
+		{[]byte{0xB0, 64, 0}, "B1 40 00 B2 40 00 B3 40 00"},
+		{[]byte{0xB0, 64, 127}, ""},
+	}
+
+	for _, test := range tests {
+		bf.Reset()
+		rd.Read(bytes.NewReader(test.in))
+
+		if got := fmt.Sprintf("% X", bf.Bytes()); got != test.expected {
+			t.Errorf("after SetZones % X: got %v; want %v", test.in, got, test.expected)
+		}
+	}
+}
+
+func TestZonesInvalid(t *testing.T) {
+	zones := []Zone{
+		{InChannel: 16},
+		{OutChannel: 16},
+		{MinKey: 60, MaxKey: 50},
+		{MinVelocity: 60, MaxVelocity: 50},
+		{VelocityScale: -1},
+	}
+
+	for _, zone := range zones {
+		if _, err := NewZones(NewReader(), NewWriter(&bytes.Buffer{}), zone); err == nil {
+			t.Errorf("NewZones(%v) must return an error", zone)
+		}
+	}
+}
+
+func TestZonesOverlapping(t *testing.T) {
+	var bf bytes.Buffer
+	rd := NewReader(NoLogger())
+
+	// both zones play key 60 on channel 1
+	_, err := NewZones(rd, NewWriter(&bf),
+		Zone{InChannel: 0, OutChannel: 1, VelocityScale: 0.5},
+		Zone{InChannel: 0, OutChannel: 1, MinKey: 48, MaxKey: 72},
+		Zone{InChannel: 0, OutChannel: 2},
+	)
+	if err != nil {
+		t.Fatalf("NewZones returned error: %v", err)
+	}
+
+	tests := []struct {
+		in       []byte
+		expected string
+	}{
+		{[]byte{0x90, 60, 100}, "91 3C 64 92 3C 64"},
+		{[]byte{0x80, 60, 0}, "91 3C 00 92 3C 00"},
+		{[]byte{0x90, 40, 100}, "91 28 32 92 28 64"},
+	}
+
+	for _, test := range tests {
+		bf.Reset()
+		rd.Read(bytes.NewReader(test.in))
+
+		if got := fmt.Sprintf("% X", bf.Bytes()); got != test.expected {
+			t.Errorf("% X: got %v; want %v", test.in, got, test.expected)
+		}
+	}
+}